@@ -27,6 +27,7 @@ import (
 	"github.com/ligjn/aog/internal/logger"
 	"github.com/ligjn/aog/internal/provider"
 	"github.com/ligjn/aog/internal/schedule"
+	"github.com/ligjn/aog/internal/server"
 	"github.com/ligjn/aog/internal/types"
 	"github.com/ligjn/aog/internal/utils"
 	"github.com/ligjn/aog/internal/utils/bcode"
@@ -47,12 +48,14 @@ func NewCommand() *cobra.Command {
 		NewVersionCommand(),
 
 		NewGetCommand(),
+		NewShowCommand(),
 		NewInstallServiceCommand(),
 		NewEditCommand(),
 		NewDeleteCommand(),
 
 		// Models
 		NewInstallModelCommand(),
+		NewModelCommand(),
 
 		// Export/Import
 		NewExportServiceCommand(),
@@ -221,7 +224,11 @@ func Run(ctx context.Context) error {
 		return err
 	}
 
-	go ListenModelEngineHealth()
+	go server.SuperviseModelEngines(ctx)
+	server.ResumeModelDownloads(ctx)
+	if preload, _ := strconv.ParseBool(config.Var("AOG_PRELOAD_CHAT_MODEL")); preload {
+		go server.PreloadChatModel(ctx)
+	}
 
 	// Run the server
 	err = aogServer.Run(ctx, config.GlobalAOGEnvironment.ApiHost)
@@ -295,6 +302,16 @@ func NewGetCommand() *cobra.Command {
 	return getCmd
 }
 
+func NewShowCommand() *cobra.Command {
+	showCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show details of resources",
+	}
+	showCmd.AddCommand(NewShowModelCommand())
+
+	return showCmd
+}
+
 func NewDeleteCommand() *cobra.Command {
 	editCmd := &cobra.Command{
 		Use:   "delete",
@@ -528,6 +545,236 @@ func NewInstallModelCommand() *cobra.Command {
 	return pullModelCmd
 }
 
+func NewModelCommand() *cobra.Command {
+	modelCmd := &cobra.Command{
+		Use:   "model",
+		Short: "Manage local models",
+	}
+	modelCmd.AddCommand(
+		NewImportModelCommand(),
+		NewExportModelCommand(),
+		NewInstallModelBundleCommand(),
+		NewLoadModelCommand(),
+		NewUnloadModelCommand(),
+		NewModelKeepAliveCommand(),
+		NewListLoadedModelsCommand(),
+		NewListModelDownloadsCommand(),
+		NewModelProgressCommand(),
+		NewPruneModelsCommand(),
+	)
+
+	return modelCmd
+}
+
+func NewImportModelCommand() *cobra.Command {
+	var (
+		engineName   string
+		modelName    string
+		serviceName  string
+		providerName string
+	)
+
+	importModelCmd := &cobra.Command{
+		Use:   "import <path>",
+		Short: "Import local model files into a model engine",
+		Long: `Import a GGUF file into ollama, or an OpenVINO IR directory into openvino, without downloading.
+The path must be on the machine the AOG server runs on.`,
+		Args:   cobra.ExactArgs(1),
+		PreRun: CheckAOGServer,
+		Run:    ImportModelHandler,
+	}
+
+	importModelCmd.Flags().StringVarP(&engineName, "engine", "e", "", "Model engine to import the model into, ollama/openvino (required)")
+	importModelCmd.Flags().StringVarP(&modelName, "name", "n", "", "Name of the model (default: the file or directory name)")
+	importModelCmd.Flags().StringVarP(&serviceName, "for", "f", "", "Name of the service the model is for (default: chat for ollama, text-to-image for openvino)")
+	importModelCmd.Flags().StringVarP(&providerName, "provider", "p", "", "Name of the service provider to import the model for, e.g: local_ollama_chat")
+
+	if err := importModelCmd.MarkFlagRequired("engine"); err != nil {
+		slog.Error("Error: --engine is required")
+	}
+
+	return importModelCmd
+}
+
+func NewExportModelCommand() *cobra.Command {
+	var (
+		output       string
+		providerName string
+	)
+
+	exportModelCmd := &cobra.Command{
+		Use:   "export <model_name>",
+		Short: "Export a downloaded model into an offline bundle",
+		Long: `Pack the files of a downloaded model in the storage of its engine, ollama or openvino, into a tar bundle,
+which can be installed by "aog model install-bundle" on the machines without network.`,
+		Args:   cobra.ExactArgs(1),
+		PreRun: CheckAOGServer,
+		Run:    ExportModelHandler,
+	}
+
+	exportModelCmd.Flags().StringVarP(&output, "output", "o", "", "Path of the bundle to write (required)")
+	exportModelCmd.Flags().StringVarP(&providerName, "provider", "p", "", "Name of the service provider of the model, e.g: local_ollama_chat")
+
+	if err := exportModelCmd.MarkFlagRequired("output"); err != nil {
+		slog.Error("Error: --output is required")
+	}
+
+	return exportModelCmd
+}
+
+func NewInstallModelBundleCommand() *cobra.Command {
+	var providerName string
+
+	installBundleCmd := &cobra.Command{
+		Use:    "install-bundle <bundle>",
+		Short:  "Install a model from an offline bundle",
+		Long:   `Install a model from a bundle made by "aog model export", after verifying the checksums of its files.`,
+		Args:   cobra.ExactArgs(1),
+		PreRun: CheckAOGServer,
+		Run:    InstallModelBundleHandler,
+	}
+
+	installBundleCmd.Flags().StringVarP(&providerName, "provider", "p", "", "Name of the service provider to install the model for (default: the local provider of the service of the bundle)")
+
+	return installBundleCmd
+}
+
+func NewLoadModelCommand() *cobra.Command {
+	var (
+		keepAlive    string
+		providerName string
+	)
+
+	loadModelCmd := &cobra.Command{
+		Use:   "load <model_name>",
+		Short: "Load a model into memory ahead of use",
+		Long: `Load a downloaded model into the memory of its local engine, ollama or llamacpp, so the first request
+to it doesn't wait for it to load.`,
+		Args:   cobra.ExactArgs(1),
+		PreRun: CheckAOGServer,
+		Run:    LoadModelHandler,
+	}
+
+	loadModelCmd.Flags().StringVarP(&keepAlive, "keep-alive", "k", "", "How long the model stays loaded after the last request, e.g: 10m, 1h, -1 to keep it loaded (default: the keep alive set for the model)")
+	loadModelCmd.Flags().StringVarP(&providerName, "provider", "p", "", "Name of the service provider of the model, e.g: local_ollama_chat")
+
+	return loadModelCmd
+}
+
+func NewUnloadModelCommand() *cobra.Command {
+	var providerName string
+
+	unloadModelCmd := &cobra.Command{
+		Use:    "unload <model_name>",
+		Short:  "Unload a model from memory",
+		Long:   `Free the memory the model uses in its local engine. It's loaded again by the next request to it.`,
+		Args:   cobra.ExactArgs(1),
+		PreRun: CheckAOGServer,
+		Run:    UnloadModelHandler,
+	}
+
+	unloadModelCmd.Flags().StringVarP(&providerName, "provider", "p", "", "Name of the service provider of the model, e.g: local_ollama_chat")
+
+	return unloadModelCmd
+}
+
+func NewModelKeepAliveCommand() *cobra.Command {
+	var providerName string
+
+	keepAliveCmd := &cobra.Command{
+		Use:   "keep-alive <model_name> [duration]",
+		Short: "Set how long a model stays loaded after use",
+		Long: `Set how long the model stays loaded in its local engine after the last request to it, e.g: 10m, 1h,
+or -1 to keep it loaded. Without the duration, the default of the engine is used again.`,
+		Args:   cobra.RangeArgs(1, 2),
+		PreRun: CheckAOGServer,
+		Run:    ModelKeepAliveHandler,
+	}
+
+	keepAliveCmd.Flags().StringVarP(&providerName, "provider", "p", "", "Name of the service provider of the model, e.g: local_ollama_chat")
+
+	return keepAliveCmd
+}
+
+func NewListLoadedModelsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:    "ps",
+		Short:  "List the models loaded in memory",
+		Args:   cobra.NoArgs,
+		PreRun: CheckAOGServer,
+		Run:    ListLoadedModelsHandler,
+	}
+}
+
+func NewListModelDownloadsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:    "downloads",
+		Short:  "List the models queued or downloading",
+		Args:   cobra.NoArgs,
+		PreRun: CheckAOGServer,
+		Run:    ListModelDownloadsHandler,
+	}
+}
+
+func NewModelProgressCommand() *cobra.Command {
+	var providerName string
+
+	progressCmd := &cobra.Command{
+		Use:   "progress [model_name]",
+		Short: "Follow the download of a model",
+		Long: `Show the progress of the download of the model until it's over, or of all the downloads without the model name.
+The download goes on in the AOG server if it's stopped.`,
+		Args:   cobra.MaximumNArgs(1),
+		PreRun: CheckAOGServer,
+		Run:    ModelProgressHandler,
+	}
+
+	progressCmd.Flags().StringVarP(&providerName, "provider", "p", "", "Name of the service provider of the model, e.g: local_ollama_chat")
+
+	return progressCmd
+}
+
+func NewPruneModelsCommand() *cobra.Command {
+	var (
+		engineName string
+		deleteAll  bool
+	)
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "List or delete the models no service provider uses",
+		Long: `List the models in the local engines no service provider refers to, e.g. left behind when the provider
+was removed, and delete them with --delete to free the disk space. Models pulled with the engine itself,
+e.g. ollama pull, are listed too, check the list before deleting. Engines not running are skipped.`,
+		Args:   cobra.NoArgs,
+		PreRun: CheckAOGServer,
+		Run:    PruneModelsHandler,
+	}
+
+	pruneCmd.Flags().StringVarP(&engineName, "engine", "e", "", "Prune the models of the engine only, e.g: ollama, llamacpp, openvino")
+	pruneCmd.Flags().BoolVar(&deleteAll, "delete", false, "Delete the models listed")
+
+	return pruneCmd
+}
+
+func NewShowModelCommand() *cobra.Command {
+	var providerName string
+
+	showModelCmd := &cobra.Command{
+		Use:   "model <model_name>",
+		Short: "Show details of a model",
+		Long: `Show the size, digest, quantization and context length of a model from the local engine it's downloaded to,
+the service providers and services using it, and when it was last used.`,
+		Args:   cobra.ExactArgs(1),
+		PreRun: CheckAOGServer,
+		Run:    ShowModelHandler,
+	}
+
+	showModelCmd.Flags().StringVarP(&providerName, "provider", "p", "", "Name of the service provider of the model, e.g: local_ollama_chat")
+
+	return showModelCmd
+}
+
 func NewDeleteModelCommand() *cobra.Command {
 	var (
 		serviceName  string
@@ -644,10 +891,14 @@ func NewListModelsCommand() *cobra.Command {
 			fmt.Printf("%-30s %-25s %-10s %-25s\n", "MODEL NAME", "PROVIDER NAME", "STATUS", "CREATE AT") // 表头
 
 			for _, model := range resp.Data {
+				status := model.Status
+				if status == "downloading" && model.Progress > 0 {
+					status = fmt.Sprintf("%s %.0f%%", status, model.Progress)
+				}
 				fmt.Printf("%-30s %-20s %-15s %-25s\n",
 					model.ModelName,
 					model.ProviderName,
-					model.Status,
+					status,
 					model.CreatedAt.Format(time.RFC3339),
 				)
 			}
@@ -847,9 +1098,12 @@ func InstallServiceHandler(cmd *cobra.Command, args []string) {
 		} else {
 			req.ServiceSource = types.ServiceSourceLocal
 			req.ApiFlavor = types.FlavorOllama
-			if serviceName == types.ServiceTextToImage {
+			if serviceName == types.ServiceTextToImage || serviceName == types.ServiceImageToImage {
 				req.ApiFlavor = types.FlavorOpenvino
 			}
+			if serviceName == types.ServiceSpeechToText {
+				req.ApiFlavor = types.FlavorVLLM
+			}
 			req.AuthType = types.AuthTypeNone
 		}
 		skipModelFlag, err := cmd.Flags().GetBool("skip_model")
@@ -956,14 +1210,15 @@ func StartAOGServer(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	err := StartModelEngine("openvino", types.EngineStartModeDaemon)
-	if err != nil {
-		return
-	}
-
-	err = StartModelEngine("ollama", types.EngineStartModeDaemon)
-	if err != nil {
-		return
+	for _, desc := range provider.ModelEngineDescriptors() {
+		// external engines are started outside aog
+		if desc.External {
+			continue
+		}
+		err := StartModelEngine(desc.Name, types.EngineStartModeDaemon)
+		if err != nil {
+			return
+		}
 	}
 
 	fmt.Println("AOG server start successfully.")
@@ -971,10 +1226,14 @@ func StartAOGServer(cmd *cobra.Command, args []string) {
 
 func StartModelEngine(engineName, mode string) error {
 	// Check if the model engine service is started
-	engineProvider := provider.GetModelEngine(engineName)
+	engineProvider, err := provider.GetModelEngine(engineName)
+	if err != nil {
+		slog.Error("Get model engine error: " + err.Error())
+		return err
+	}
 	engineConfig := engineProvider.GetConfig()
 
-	err := engineProvider.HealthCheck()
+	err = engineProvider.HealthCheck()
 	if err != nil {
 		cmd := exec.Command(engineConfig.ExecFile, "-h")
 		err := cmd.Run()
@@ -1114,7 +1373,419 @@ func PullHandler(cmd *cobra.Command, args []string) {
 	}
 
 	fmt.Println("You can use the command `aog get models` to check if the model is downloaded successfully.")
-	fmt.Println("Model is downloading in the background, please wait...")
+	fmt.Printf("Model is downloading in the background, run `aog model progress %s` to follow it.\n", modelName)
+}
+
+func ImportModelHandler(cmd *cobra.Command, args []string) {
+	engineName, _ := cmd.Flags().GetString("engine")
+	modelName, _ := cmd.Flags().GetString("name")
+	serviceName, _ := cmd.Flags().GetString("for")
+	providerName, _ := cmd.Flags().GetString("provider")
+
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		fmt.Printf("Invalid path: %s\n", err.Error())
+		return
+	}
+	if modelName == "" {
+		modelName = strings.TrimSuffix(filepath.Base(path), ".gguf")
+	}
+
+	req := dto.ImportModelRequest{
+		ProviderName: providerName,
+		ModelName:    modelName,
+		ServiceName:  serviceName,
+		Engine:       engineName,
+		Path:         path,
+	}
+	resp := bcode.Bcode{}
+
+	c := config.NewAOGClient()
+	routerPath := fmt.Sprintf("/aog/%s/model/import", version.AOGVersion)
+
+	fmt.Printf("Importing model %s from %s ...\n", modelName, path)
+	err = c.Client.Do(context.Background(), http.MethodPost, routerPath, req, &resp)
+	if err != nil {
+		fmt.Printf("Import model failed: %s\n", err.Error())
+		return
+	}
+
+	if resp.HTTPCode > 200 {
+		fmt.Printf("Import model failed: %s\n", resp.Message)
+		return
+	}
+
+	fmt.Println("Import model successfully.")
+}
+
+func ExportModelHandler(cmd *cobra.Command, args []string) {
+	output, _ := cmd.Flags().GetString("output")
+	providerName, _ := cmd.Flags().GetString("provider")
+
+	path, err := filepath.Abs(output)
+	if err != nil {
+		fmt.Printf("Invalid output path: %s\n", err.Error())
+		return
+	}
+
+	req := dto.ExportModelRequest{
+		ProviderName: providerName,
+		ModelName:    args[0],
+		Path:         path,
+	}
+	resp := dto.ExportModelResponse{}
+
+	c := config.NewAOGClient()
+	routerPath := fmt.Sprintf("/aog/%s/model/export", version.AOGVersion)
+
+	fmt.Printf("Exporting model %s to %s ...\n", args[0], path)
+	err = c.Client.Do(context.Background(), http.MethodPost, routerPath, req, &resp)
+	if err != nil {
+		fmt.Printf("Export model failed: %s\n", err.Error())
+		return
+	}
+
+	if resp.HTTPCode > 200 {
+		fmt.Printf("Export model failed: %s\n", resp.Message)
+		return
+	}
+
+	fmt.Printf("Model %s of %s is exported to %s\n", resp.ModelName, resp.Engine, path)
+}
+
+func InstallModelBundleHandler(cmd *cobra.Command, args []string) {
+	providerName, _ := cmd.Flags().GetString("provider")
+
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		fmt.Printf("Invalid bundle path: %s\n", err.Error())
+		return
+	}
+
+	req := dto.InstallModelBundleRequest{
+		ProviderName: providerName,
+		Path:         path,
+	}
+	resp := dto.InstallModelBundleResponse{}
+
+	c := config.NewAOGClient()
+	routerPath := fmt.Sprintf("/aog/%s/model/bundle", version.AOGVersion)
+
+	fmt.Printf("Installing model bundle %s ...\n", path)
+	err = c.Client.Do(context.Background(), http.MethodPost, routerPath, req, &resp)
+	if err != nil {
+		fmt.Printf("Install model bundle failed: %s\n", err.Error())
+		return
+	}
+
+	if resp.HTTPCode > 200 {
+		fmt.Printf("Install model bundle failed: %s\n", resp.Message)
+		return
+	}
+
+	fmt.Printf("Model %s of %s is installed.\n", resp.ModelName, resp.Engine)
+}
+
+func ShowModelHandler(cmd *cobra.Command, args []string) {
+	providerName, _ := cmd.Flags().GetString("provider")
+
+	req := dto.GetModelDetailRequest{
+		ModelName:    args[0],
+		ProviderName: providerName,
+	}
+	resp := dto.GetModelDetailResponse{}
+
+	c := config.NewAOGClient()
+	routerPath := fmt.Sprintf("/aog/%s/model/detail", version.AOGVersion)
+
+	err := c.Client.Do(context.Background(), http.MethodGet, routerPath, req, &resp)
+	if err != nil {
+		fmt.Printf("Get model detail failed: %s\n", err.Error())
+		return
+	}
+
+	if resp.HTTPCode > 200 {
+		fmt.Printf("Get model detail failed: %s\n", resp.Message)
+		return
+	}
+
+	d := resp.Data
+	fmt.Printf("%-20s %s\n", "Model:", d.ModelName)
+	if d.Engine != "" {
+		fmt.Printf("%-20s %s\n", "Engine:", d.Engine)
+		fmt.Printf("%-20s %s\n", "Size:", progress.HumanBytes(d.Size))
+		for _, field := range [][2]string{
+			{"Digest:", d.Digest},
+			{"Format:", d.Format},
+			{"Family:", d.Family},
+			{"Parameter size:", d.ParameterSize},
+			{"Quantization:", d.QuantizationLevel},
+		} {
+			if field[1] != "" {
+				fmt.Printf("%-20s %s\n", field[0], field[1])
+			}
+		}
+		if d.ContextLength > 0 {
+			fmt.Printf("%-20s %d\n", "Context length:", d.ContextLength)
+		}
+	}
+	services := "-"
+	if len(d.Services) > 0 {
+		services = strings.Join(d.Services, ", ")
+	}
+	fmt.Printf("%-20s %s\n", "Services:", services)
+	lastUsed := "never"
+	if d.LastUsedAt != nil {
+		lastUsed = d.LastUsedAt.Format(time.RFC3339)
+	}
+	fmt.Printf("%-20s %s\n", "Last used:", lastUsed)
+
+	fmt.Printf("\n%-30s %-15s %-10s %-12s %-25s\n", "PROVIDER NAME", "SERVICE NAME", "SOURCE", "STATUS", "LAST USED")
+	for _, p := range d.Providers {
+		lastUsed := "-"
+		if p.LastUsedAt != nil {
+			lastUsed = p.LastUsedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%-30s %-15s %-10s %-12s %-25s\n", p.ProviderName, p.ServiceName, p.ServiceSource, p.Status, lastUsed)
+	}
+}
+
+func LoadModelHandler(cmd *cobra.Command, args []string) {
+	keepAlive, _ := cmd.Flags().GetString("keep-alive")
+	providerName, _ := cmd.Flags().GetString("provider")
+
+	req := dto.LoadModelRequest{
+		ProviderName: providerName,
+		ModelName:    args[0],
+		KeepAlive:    keepAlive,
+	}
+	resp := dto.LoadModelResponse{}
+
+	c := config.NewAOGClient()
+	routerPath := fmt.Sprintf("/aog/%s/model/load", version.AOGVersion)
+
+	fmt.Printf("Loading model %s ...\n", args[0])
+	err := c.Client.Do(context.Background(), http.MethodPost, routerPath, req, &resp)
+	if err != nil {
+		fmt.Printf("Load model failed: %s\n", err.Error())
+		return
+	}
+
+	if resp.HTTPCode > 200 {
+		fmt.Printf("Load model failed: %s\n", resp.Message)
+		return
+	}
+
+	fmt.Printf("Model %s is loaded.\n", args[0])
+}
+
+func UnloadModelHandler(cmd *cobra.Command, args []string) {
+	providerName, _ := cmd.Flags().GetString("provider")
+
+	req := dto.UnloadModelRequest{
+		ProviderName: providerName,
+		ModelName:    args[0],
+	}
+	resp := dto.UnloadModelResponse{}
+
+	c := config.NewAOGClient()
+	routerPath := fmt.Sprintf("/aog/%s/model/unload", version.AOGVersion)
+
+	err := c.Client.Do(context.Background(), http.MethodPost, routerPath, req, &resp)
+	if err != nil {
+		fmt.Printf("Unload model failed: %s\n", err.Error())
+		return
+	}
+
+	if resp.HTTPCode > 200 {
+		fmt.Printf("Unload model failed: %s\n", resp.Message)
+		return
+	}
+
+	fmt.Printf("Model %s is unloaded.\n", args[0])
+}
+
+func ModelKeepAliveHandler(cmd *cobra.Command, args []string) {
+	providerName, _ := cmd.Flags().GetString("provider")
+
+	req := dto.SetModelKeepAliveRequest{
+		ProviderName: providerName,
+		ModelName:    args[0],
+	}
+	if len(args) > 1 {
+		req.KeepAlive = args[1]
+	}
+	resp := dto.SetModelKeepAliveResponse{}
+
+	c := config.NewAOGClient()
+	routerPath := fmt.Sprintf("/aog/%s/model/keep_alive", version.AOGVersion)
+
+	err := c.Client.Do(context.Background(), http.MethodPut, routerPath, req, &resp)
+	if err != nil {
+		fmt.Printf("Set model keep alive failed: %s\n", err.Error())
+		return
+	}
+
+	if resp.HTTPCode > 200 {
+		fmt.Printf("Set model keep alive failed: %s\n", resp.Message)
+		return
+	}
+
+	if req.KeepAlive == "" {
+		fmt.Printf("Keep alive of model %s is reset to the default of the engine.\n", args[0])
+		return
+	}
+	fmt.Printf("Keep alive of model %s is set to %s.\n", args[0], req.KeepAlive)
+}
+
+func ListLoadedModelsHandler(cmd *cobra.Command, args []string) {
+	resp := dto.GetLoadedModelsResponse{}
+
+	c := config.NewAOGClient()
+	routerPath := fmt.Sprintf("/aog/%s/model/loaded", version.AOGVersion)
+
+	err := c.Client.Do(context.Background(), http.MethodGet, routerPath, nil, &resp)
+	if err != nil {
+		fmt.Printf("List loaded models failed: %s\n", err.Error())
+		return
+	}
+
+	if resp.HTTPCode > 200 {
+		fmt.Printf("List loaded models failed: %s\n", resp.Message)
+		return
+	}
+
+	fmt.Printf("%-45s %-10s %-10s %-10s %-12s %-25s\n", "MODEL NAME", "ENGINE", "SIZE", "VRAM", "KEEP ALIVE", "EXPIRES")
+	for _, m := range resp.Data {
+		keepAlive := "default"
+		if m.KeepAlive != "" {
+			keepAlive = m.KeepAlive
+		}
+		expires := "never"
+		if m.ExpiresAt != nil {
+			expires = m.ExpiresAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%-45s %-10s %-10s %-10s %-12s %-25s\n", m.ModelName, m.Engine, progress.HumanBytes(m.Size),
+			progress.HumanBytes(m.SizeVRAM), keepAlive, expires)
+	}
+}
+
+func formatModelDownload(d *types.ModelDownload) string {
+	switch {
+	case d.Total > 0:
+		return fmt.Sprintf("%.1f%% %s/%s", d.Progress, progress.HumanBytes(d.Completed), progress.HumanBytes(d.Total))
+	case d.Detail != "" && !d.Finished():
+		return d.Detail
+	default:
+		return "-"
+	}
+}
+
+func ListModelDownloadsHandler(cmd *cobra.Command, args []string) {
+	resp := dto.GetModelDownloadsResponse{}
+
+	c := config.NewAOGClient()
+	routerPath := fmt.Sprintf("/aog/%s/model/download", version.AOGVersion)
+
+	err := c.Client.Do(context.Background(), http.MethodGet, routerPath, nil, &resp)
+	if err != nil {
+		fmt.Printf("List model downloads failed: %s\n", err.Error())
+		return
+	}
+
+	if resp.HTTPCode > 200 {
+		fmt.Printf("List model downloads failed: %s\n", resp.Message)
+		return
+	}
+
+	fmt.Printf("%-40s %-25s %-12s %-30s %-8s\n", "MODEL NAME", "PROVIDER NAME", "STATUS", "PROGRESS", "ATTEMPT")
+	for _, d := range resp.Data {
+		fmt.Printf("%-40s %-25s %-12s %-30s %-8d\n", d.ModelName, d.ProviderName, d.Status, formatModelDownload(&d), d.Attempt)
+	}
+}
+
+func ModelProgressHandler(cmd *cobra.Command, args []string) {
+	providerName, _ := cmd.Flags().GetString("provider")
+
+	req := dto.GetModelDownloadsRequest{ProviderName: providerName}
+	if len(args) > 0 {
+		req.ModelName = args[0]
+	}
+
+	c := config.NewAOGClient()
+	routerPath := fmt.Sprintf("/aog/%s/model/download/progress", version.AOGVersion)
+
+	// the line of a download is rewritten as it goes, until another download is reported
+	lastKey := ""
+	err := c.Client.Stream(context.Background(), http.MethodGet, routerPath, req, func(bts []byte) error {
+		var d types.ModelDownload
+		if err := json.Unmarshal(bts, &d); err != nil {
+			return err
+		}
+		key := d.ProviderName + "/" + d.ModelName
+		if lastKey != "" && key != lastKey {
+			fmt.Println()
+		}
+		lastKey = key
+		fmt.Printf("\r\033[K%-40s %-12s %s", d.ModelName, d.Status, formatModelDownload(&d))
+		if (d.Status == types.ModelDownloadRetrying || d.Status == types.ModelDownloadFailed) && d.LastError != "" {
+			fmt.Printf(" (%s)", d.LastError)
+		}
+		return nil
+	})
+	if lastKey != "" {
+		fmt.Println()
+	}
+	if err != nil {
+		msg := err.Error()
+		if msg == "" {
+			msg = "model not found"
+		}
+		fmt.Printf("Model download failed: %s\n", msg)
+	}
+}
+
+func PruneModelsHandler(cmd *cobra.Command, args []string) {
+	engineName, _ := cmd.Flags().GetString("engine")
+	deleteAll, _ := cmd.Flags().GetBool("delete")
+
+	req := dto.PruneModelsRequest{Engine: engineName, Delete: deleteAll}
+	resp := dto.PruneModelsResponse{}
+
+	c := config.NewAOGClient()
+	routerPath := fmt.Sprintf("/aog/%s/model/prune", version.AOGVersion)
+
+	err := c.Client.Do(context.Background(), http.MethodPost, routerPath, req, &resp)
+	if err != nil {
+		fmt.Printf("Prune models failed: %s\n", err.Error())
+		return
+	}
+
+	if resp.HTTPCode > 200 {
+		fmt.Printf("Prune models failed: %s\n", resp.Message)
+		return
+	}
+
+	if len(resp.Data.Models) == 0 {
+		fmt.Println("No unused models to prune.")
+		return
+	}
+	fmt.Printf("%-45s %-10s %-10s %-30s\n", "MODEL NAME", "ENGINE", "SIZE", "STATUS")
+	for _, m := range resp.Data.Models {
+		status := "deleted"
+		switch {
+		case m.Error != "":
+			status = "failed: " + m.Error
+		case !deleteAll:
+			status = "to delete"
+		}
+		fmt.Printf("%-45s %-10s %-10s %-30s\n", m.ModelName, m.Engine, progress.HumanBytes(m.Size), status)
+	}
+	if !deleteAll {
+		fmt.Printf("%s would be freed, run with --delete to delete the models.\n", progress.HumanBytes(resp.Data.FreedBytes))
+		return
+	}
+	fmt.Printf("%s freed.\n", progress.HumanBytes(resp.Data.FreedBytes))
 }
 
 func DeleteModelHandler(cmd *cobra.Command, args []string) {
@@ -1334,67 +2005,3 @@ func NewExportServiceToStdoutCommand(service, provider, model string) *cobra.Com
 	}
 	return cmd
 }
-
-func ListenModelEngineHealth() {
-	ds := datastore.GetDefaultDatastore()
-
-	sp := &types.ServiceProvider{
-		ServiceSource: types.ServiceSourceLocal,
-	}
-
-	OpenVINOEngine := provider.GetModelEngine(types.FlavorOpenvino)
-	OllamaEngine := provider.GetModelEngine(types.FlavorOllama)
-
-	for {
-		list, err := ds.List(context.Background(), sp, &datastore.ListOptions{Page: 0, PageSize: 100})
-		if err != nil {
-			logger.EngineLogger.Error("[Engine Listen]List service provider failed: ", err.Error())
-			continue
-		}
-
-		if len(list) == 0 {
-			continue
-		}
-
-		engineList := make([]string, 0)
-		for _, item := range list {
-			sp := item.(*types.ServiceProvider)
-			if utils.Contains(engineList, sp.Flavor) {
-				continue
-			}
-
-			engineList = append(engineList, sp.Flavor)
-		}
-
-		for _, engine := range engineList {
-			if engine == types.FlavorOllama {
-				err := OllamaEngine.HealthCheck()
-				if err != nil {
-					logger.EngineLogger.Error("[Engine Listen]Ollama engine health check failed: ", err.Error())
-					err := OllamaEngine.InitEnv()
-					if err != nil {
-						logger.EngineLogger.Error("[Engine Listen]Ollama engine init env failed: ", err.Error())
-						return
-					}
-					err = OllamaEngine.StartEngine(types.EngineStartModeDaemon)
-					if err != nil {
-						logger.EngineLogger.Error("[Engine Listen]Ollama engine start failed: ", err.Error())
-						continue
-					}
-				}
-			} else if engine == types.FlavorOpenvino {
-				err := OpenVINOEngine.HealthCheck()
-				if err != nil {
-					logger.EngineLogger.Error("[Engine Listen]Openvino engine health check failed: ", err.Error())
-					err := OpenVINOEngine.StartEngine(types.EngineStartModeDaemon)
-					if err != nil {
-						logger.EngineLogger.Error("[Engine Listen]Openvino engine start failed: ", err.Error())
-						continue
-					}
-				}
-			}
-		}
-
-		time.Sleep(60 * time.Second)
-	}
-}
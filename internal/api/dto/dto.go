@@ -3,6 +3,7 @@ package dto
 import (
 	"time"
 
+	"github.com/ligjn/aog/internal/types"
 	"github.com/ligjn/aog/internal/utils/bcode"
 )
 
@@ -113,6 +114,28 @@ type CreateModelRequest struct {
 	ServiceSource string `json:"service_source" validate:"required"`
 }
 
+type ImportModelRequest struct {
+	ProviderName string `json:"provider_name"`
+	ModelName    string `json:"model_name" validate:"required"`
+	ServiceName  string `json:"service_name"`
+	Engine       string `json:"engine" validate:"required"`
+	// Path the model files on the machine AOG runs on
+	Path string `json:"path" validate:"required"`
+}
+
+type ExportModelRequest struct {
+	ProviderName string `json:"provider_name"`
+	ModelName    string `json:"model_name" validate:"required"`
+	// Path the bundle to write on the machine AOG runs on
+	Path string `json:"path" validate:"required"`
+}
+
+type InstallModelBundleRequest struct {
+	ProviderName string `json:"provider_name"`
+	// Path the bundle on the machine AOG runs on
+	Path string `json:"path" validate:"required"`
+}
+
 type CreateModelStreamRequest struct {
 	ProviderName  string `json:"provider_name"`
 	ModelName     string `json:"model_name" validate:"required"`
@@ -120,6 +143,25 @@ type CreateModelStreamRequest struct {
 	ServiceSource string `json:"service_source"`
 }
 
+type LoadModelRequest struct {
+	ProviderName string `json:"provider_name"`
+	ModelName    string `json:"model_name" validate:"required"`
+	// KeepAlive how long the model stays loaded after used, the keep alive set for the model if empty
+	KeepAlive string `json:"keep_alive"`
+}
+
+type UnloadModelRequest struct {
+	ProviderName string `json:"provider_name"`
+	ModelName    string `json:"model_name" validate:"required"`
+}
+
+type SetModelKeepAliveRequest struct {
+	ProviderName string `json:"provider_name"`
+	ModelName    string `json:"model_name" validate:"required"`
+	// KeepAlive e.g. "10m", negative to keep forever, empty to use the default of the engine again
+	KeepAlive string `json:"keep_alive"`
+}
+
 type DeleteModelRequest struct {
 	ProviderName  string `json:"provider_name"`
 	ModelName     string `json:"model_name" validate:"required"`
@@ -133,6 +175,17 @@ type GetModelsRequest struct {
 	ServiceName  string `form:"service_name,omitempty"`
 }
 
+type GetModelDetailRequest struct {
+	ModelName    string `form:"model_name" json:"model_name" validate:"required"`
+	ProviderName string `form:"provider_name,omitempty" json:"provider_name"`
+}
+
+// GetModelDownloadsRequest The downloads of the model, all if ModelName is empty
+type GetModelDownloadsRequest struct {
+	ModelName    string `form:"model_name,omitempty" json:"model_name"`
+	ProviderName string `form:"provider_name,omitempty" json:"provider_name"`
+}
+
 type GetModelListRequest struct {
 	ServiceSource string `form:"service_source" validate:"required"`
 	Flavor        string `form:"flavor" validate:"required"`
@@ -146,6 +199,81 @@ type CreateModelResponse struct {
 	bcode.Bcode
 }
 
+type ImportModelResponse struct {
+	bcode.Bcode
+}
+
+type ExportModelResponse struct {
+	bcode.Bcode
+	ModelName string `json:"model_name"`
+	Engine    string `json:"engine"`
+}
+
+type InstallModelBundleResponse struct {
+	bcode.Bcode
+	ModelName string `json:"model_name"`
+	Engine    string `json:"engine"`
+}
+
+type LoadModelResponse struct {
+	bcode.Bcode
+}
+
+type UnloadModelResponse struct {
+	bcode.Bcode
+}
+
+type SetModelKeepAliveResponse struct {
+	bcode.Bcode
+}
+
+type GetLoadedModelsResponse struct {
+	bcode.Bcode
+	Data []LoadedModel `json:"data"`
+}
+
+type GetModelDownloadsResponse struct {
+	bcode.Bcode
+	Data []types.ModelDownload `json:"data"`
+}
+
+// PruneModelsRequest Engine limits the prune to the models of the engine. The models are only
+// listed unless Delete is set
+type PruneModelsRequest struct {
+	Engine string `json:"engine"`
+	Delete bool   `json:"delete"`
+}
+
+type PruneModelsResponse struct {
+	bcode.Bcode
+	Data PruneModelsData `json:"data"`
+}
+
+type PruneModelsData struct {
+	Models []PrunedModel `json:"models"`
+	// FreedBytes the size of the models deleted, or to delete if they are only listed
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+// PrunedModel A model of a local engine no service provider refers to. Error is set if it failed to delete
+type PrunedModel struct {
+	ModelName string `json:"model_name"`
+	Engine    string `json:"engine"`
+	Size      int64  `json:"size"`
+	Error     string `json:"error,omitempty"`
+}
+
+// LoadedModel A model loaded by a local engine
+type LoadedModel struct {
+	ModelName string `json:"model_name"`
+	Engine    string `json:"engine"`
+	// Size bytes of the memory the model uses, SizeVRAM of them in the video memory
+	Size      int64      `json:"size"`
+	SizeVRAM  int64      `json:"size_vram"`
+	KeepAlive string     `json:"keep_alive,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
 type DeleteModelResponse struct {
 	bcode.Bcode
 }
@@ -155,6 +283,11 @@ type GetModelsResponse struct {
 	Data []Model `json:"data"`
 }
 
+type GetModelDetailResponse struct {
+	bcode.Bcode
+	Data ModelDetail `json:"data"`
+}
+
 type RecommendModelResponse struct {
 	bcode.Bcode
 	Data map[string][]RecommendModelData `json:"data"`
@@ -170,6 +303,41 @@ type Model struct {
 	Status       string    `json:"status"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
+	// Progress percent of the download, of TotalBytes with CompletedBytes downloaded
+	Progress       float64 `json:"progress"`
+	CompletedBytes int64   `json:"completed_bytes,omitempty"`
+	TotalBytes     int64   `json:"total_bytes,omitempty"`
+}
+
+// ModelDetail The model in the datastore, with its metadata from the local engine it's downloaded to
+type ModelDetail struct {
+	ModelName string `json:"model_name"`
+	// Engine the local engine the metadata below is taken from, empty if none can tell
+	Engine            string     `json:"engine,omitempty"`
+	Size              int64      `json:"size"`
+	Digest            string     `json:"digest,omitempty"`
+	Format            string     `json:"format,omitempty"`
+	Family            string     `json:"family,omitempty"`
+	ParameterSize     string     `json:"parameter_size,omitempty"`
+	QuantizationLevel string     `json:"quantization_level,omitempty"`
+	ContextLength     int64      `json:"context_length,omitempty"`
+	ModifiedAt        *time.Time `json:"modified_at,omitempty"`
+	// Services the services using the providers of the model
+	Services   []string        `json:"services"`
+	Providers  []ModelProvider `json:"providers"`
+	LastUsedAt *time.Time      `json:"last_used_at,omitempty"`
+}
+
+// ModelProvider A service provider the model is added to
+type ModelProvider struct {
+	ProviderName  string     `json:"provider_name"`
+	ServiceName   string     `json:"service_name"`
+	ServiceSource string     `json:"service_source"`
+	Flavor        string     `json:"flavor"`
+	Status        string     `json:"status"`
+	LastUsedAt    *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
 }
 
 type LocalSupportModelData struct {
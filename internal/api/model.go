@@ -43,6 +43,72 @@ func (t *AOGCoreServer) CreateModel(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+func (t *AOGCoreServer) ImportModel(c *gin.Context) {
+	request := new(dto.ImportModelRequest)
+	if err := c.ShouldBindJSON(request); err != nil {
+		bcode.ReturnError(c, bcode.ErrModelBadRequest)
+		return
+	}
+	logger.ApiLogger.Debug("[API] ImportModel request", "model", request.ModelName, "engine", request.Engine, "path", request.Path)
+
+	if err := validate.Struct(request); err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	resp, err := t.Model.ImportModel(ctx, request)
+	if err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+
+	logger.ApiLogger.Debug("[API] ImportModel response", "message", resp.Message)
+	c.JSON(http.StatusOK, resp)
+}
+
+func (t *AOGCoreServer) ExportModel(c *gin.Context) {
+	request := new(dto.ExportModelRequest)
+	if err := c.ShouldBindJSON(request); err != nil {
+		bcode.ReturnError(c, bcode.ErrModelBadRequest)
+		return
+	}
+	logger.ApiLogger.Debug("[API] ExportModel request", "model", request.ModelName, "provider", request.ProviderName, "path", request.Path)
+
+	if err := validate.Struct(request); err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+
+	resp, err := server.ExportModelBundle(c.Request.Context(), request)
+	if err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (t *AOGCoreServer) InstallModelBundle(c *gin.Context) {
+	request := new(dto.InstallModelBundleRequest)
+	if err := c.ShouldBindJSON(request); err != nil {
+		bcode.ReturnError(c, bcode.ErrModelBadRequest)
+		return
+	}
+	logger.ApiLogger.Debug("[API] InstallModelBundle request", "provider", request.ProviderName, "path", request.Path)
+
+	if err := validate.Struct(request); err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+
+	resp, err := server.InstallModelBundle(c.Request.Context(), request)
+	if err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
 func (t *AOGCoreServer) CreateModelStream(c *gin.Context) {
 	request := new(dto.CreateModelRequest)
 	if err := c.Bind(request); err != nil {
@@ -177,6 +243,186 @@ func (t *AOGCoreServer) GetModels(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+func (t *AOGCoreServer) GetModelDetail(c *gin.Context) {
+	request := new(dto.GetModelDetailRequest)
+	if err := c.ShouldBindQuery(request); err != nil {
+		bcode.ReturnError(c, bcode.ErrModelBadRequest)
+		return
+	}
+	// the aog client sends it in the body
+	if request.ModelName == "" {
+		if err := c.ShouldBindJSON(request); err != nil && !errors.Is(err, io.EOF) {
+			bcode.ReturnError(c, bcode.ErrModelBadRequest)
+			return
+		}
+	}
+	logger.ApiLogger.Debug("[API] GetModelDetail request", "model", request.ModelName, "provider", request.ProviderName)
+
+	if err := validate.Struct(request); err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+
+	resp, err := t.Model.GetModelDetail(c.Request.Context(), request)
+	if err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (t *AOGCoreServer) LoadModel(c *gin.Context) {
+	request := new(dto.LoadModelRequest)
+	if err := c.ShouldBindJSON(request); err != nil {
+		bcode.ReturnError(c, bcode.ErrModelBadRequest)
+		return
+	}
+	logger.ApiLogger.Debug("[API] LoadModel request", "model", request.ModelName, "provider", request.ProviderName, "keep_alive", request.KeepAlive)
+
+	if err := validate.Struct(request); err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+
+	resp, err := server.LoadModel(c.Request.Context(), request)
+	if err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (t *AOGCoreServer) UnloadModel(c *gin.Context) {
+	request := new(dto.UnloadModelRequest)
+	if err := c.ShouldBindJSON(request); err != nil {
+		bcode.ReturnError(c, bcode.ErrModelBadRequest)
+		return
+	}
+	logger.ApiLogger.Debug("[API] UnloadModel request", "model", request.ModelName, "provider", request.ProviderName)
+
+	if err := validate.Struct(request); err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+
+	resp, err := server.UnloadModel(c.Request.Context(), request)
+	if err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (t *AOGCoreServer) SetModelKeepAlive(c *gin.Context) {
+	request := new(dto.SetModelKeepAliveRequest)
+	if err := c.ShouldBindJSON(request); err != nil {
+		bcode.ReturnError(c, bcode.ErrModelBadRequest)
+		return
+	}
+	logger.ApiLogger.Debug("[API] SetModelKeepAlive request", "model", request.ModelName, "provider", request.ProviderName, "keep_alive", request.KeepAlive)
+
+	if err := validate.Struct(request); err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+
+	resp, err := server.SetModelKeepAlive(c.Request.Context(), request)
+	if err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func bindModelDownloadsRequest(c *gin.Context) (*dto.GetModelDownloadsRequest, error) {
+	request := new(dto.GetModelDownloadsRequest)
+	if err := c.ShouldBindQuery(request); err != nil {
+		return nil, err
+	}
+	// the aog client sends it in the body
+	if request.ModelName == "" && request.ProviderName == "" {
+		if err := c.ShouldBindJSON(request); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	}
+	return request, nil
+}
+
+func (t *AOGCoreServer) GetModelDownloads(c *gin.Context) {
+	request, err := bindModelDownloadsRequest(c)
+	if err != nil {
+		bcode.ReturnError(c, bcode.ErrModelBadRequest)
+		return
+	}
+	logger.ApiLogger.Debug("[API] GetModelDownloads request", "model", request.ModelName, "provider", request.ProviderName)
+
+	c.JSON(http.StatusOK, &dto.GetModelDownloadsResponse{
+		Bcode: *bcode.ModelCode,
+		Data:  server.GetModelDownloads(request.ModelName, request.ProviderName),
+	})
+}
+
+// SubscribeModelDownloads The states of the downloads as JSON lines, until the downloads are over
+func (t *AOGCoreServer) SubscribeModelDownloads(c *gin.Context) {
+	request, err := bindModelDownloadsRequest(c)
+	if err != nil {
+		bcode.ReturnError(c, bcode.ErrModelBadRequest)
+		return
+	}
+	logger.ApiLogger.Debug("[API] SubscribeModelDownloads request", "model", request.ModelName, "provider", request.ProviderName)
+
+	w := c.Writer
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.NotFound(w, c.Request)
+		return
+	}
+	ch, err := server.SubscribeModelDownloads(c.Request.Context(), request.ModelName, request.ProviderName)
+	if err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for d := range ch {
+		line, err := json.Marshal(d)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "%s\n", line)
+		flusher.Flush()
+	}
+}
+
+func (t *AOGCoreServer) GetLoadedModels(c *gin.Context) {
+	logger.ApiLogger.Debug("[API] GetLoadedModels request")
+
+	resp, err := server.GetLoadedModels(c.Request.Context())
+	if err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (t *AOGCoreServer) PruneModels(c *gin.Context) {
+	request := new(dto.PruneModelsRequest)
+	if err := c.ShouldBindJSON(request); err != nil && !errors.Is(err, io.EOF) {
+		bcode.ReturnError(c, bcode.ErrModelBadRequest)
+		return
+	}
+	logger.ApiLogger.Debug("[API] PruneModels request", "engine", request.Engine, "delete", request.Delete)
+
+	resp, err := server.PruneModels(c.Request.Context(), request)
+	if err != nil {
+		bcode.ReturnError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
 func (t *AOGCoreServer) CancelModelStream(c *gin.Context) {
 	logger.ApiLogger.Error("[API] CancelModelStream request params:", c.Request.Body)
 	request := new(dto.ModelStreamCancelRequest)
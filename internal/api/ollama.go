@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ligjn/aog/internal/api/dto"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/schedule"
+	"github.com/ligjn/aog/internal/server"
+	"github.com/ligjn/aog/internal/types"
+)
+
+// registerOllamaHandlers Handlers of the management endpoints of the ollama flavor. They answer in
+// the format of Ollama API, e.g. {"error": "..."} on failure, instead of the AOG bcode
+func registerOllamaHandlers(e *AOGCoreServer) {
+	schedule.RegisterManagementHandler(types.FlavorOllama, "tags", e.OllamaListModels)
+	schedule.RegisterManagementHandler(types.FlavorOllama, "show", e.OllamaShowModel)
+	schedule.RegisterManagementHandler(types.FlavorOllama, "ps", e.OllamaListRunningModels)
+	schedule.RegisterManagementHandler(types.FlavorOllama, "pull", e.OllamaPullModel)
+	schedule.RegisterManagementHandler(types.FlavorOllama, "version", e.OllamaVersion)
+}
+
+func ollamaError(c *gin.Context, status int, err error) {
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+func (t *AOGCoreServer) OllamaListModels(c *gin.Context) {
+	resp, err := server.ListOllamaModels(c.Request.Context())
+	if err != nil {
+		ollamaError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (t *AOGCoreServer) OllamaShowModel(c *gin.Context) {
+	request := new(types.ShowRequest)
+	if err := c.ShouldBindJSON(request); err != nil {
+		ollamaError(c, http.StatusBadRequest, err)
+		return
+	}
+	if request.Model == "" {
+		request.Model = request.Name
+	}
+	if request.Model == "" {
+		ollamaError(c, http.StatusBadRequest, errors.New("model is required"))
+		return
+	}
+
+	resp, err := server.ShowOllamaModel(c.Request.Context(), request.Model)
+	if errors.Is(err, server.ErrOllamaModelNotFound) {
+		ollamaError(c, http.StatusNotFound, fmt.Errorf("model '%s' not found", request.Model))
+		return
+	} else if err != nil {
+		ollamaError(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (t *AOGCoreServer) OllamaListRunningModels(c *gin.Context) {
+	c.JSON(http.StatusOK, server.ListOllamaRunningModels(c.Request.Context()))
+}
+
+func (t *AOGCoreServer) OllamaVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, server.GetOllamaVersion(c.Request.Context()))
+}
+
+// OllamaPullModel Pull the model by the local provider of the service given as model_type, or of the
+// service the model is known for in the model table, chat by default. The progress is streamed as
+// ndjson unless stream is false
+func (t *AOGCoreServer) OllamaPullModel(c *gin.Context) {
+	request := new(types.PullModelRequest)
+	if err := c.ShouldBindJSON(request); err != nil {
+		ollamaError(c, http.StatusBadRequest, err)
+		return
+	}
+	if request.Model == "" {
+		request.Model = request.Name
+	}
+	if request.Model == "" {
+		ollamaError(c, http.StatusBadRequest, errors.New("model is required"))
+		return
+	}
+	serviceName, err := server.OllamaPullServiceName(c.Request.Context(), request.Model, request.ModelType)
+	if errors.Is(err, server.ErrOllamaPullServiceNotSupported) {
+		ollamaError(c, http.StatusBadRequest, fmt.Errorf("service '%s' does not support pulling models", request.ModelType))
+		return
+	} else if err != nil {
+		ollamaError(c, http.StatusInternalServerError, err)
+		return
+	}
+	stream := request.Stream == nil || *request.Stream
+	logger.ApiLogger.Debug("[API] OllamaPullModel request", "model", request.Model, "service", serviceName, "stream", stream)
+
+	ctx := c.Request.Context()
+	dataCh, errCh := server.CreateModelStream(ctx, dto.CreateModelRequest{
+		ModelName:     request.Model,
+		ServiceName:   serviceName,
+		ServiceSource: types.ServiceSourceLocal,
+	})
+
+	w := c.Writer
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.NotFound(w, c.Request)
+		return
+	}
+	if stream {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+
+	var last []byte
+	fail := func(err error) {
+		// errors of the engine are already like {"error": "..."}
+		var engineErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal([]byte(err.Error()), &engineErr) == nil && engineErr.Error != "" {
+			err = errors.New(engineErr.Error)
+		}
+		if stream {
+			line, _ := json.Marshal(gin.H{"error": err.Error()})
+			fmt.Fprintf(w, "%s\n", line)
+			flusher.Flush()
+			return
+		}
+		ollamaError(c, http.StatusInternalServerError, err)
+	}
+	for {
+		select {
+		case data, ok := <-dataCh:
+			if !ok {
+				if errCh != nil {
+					if err := <-errCh; err != nil {
+						fail(err)
+						return
+					}
+				}
+				if !stream {
+					if last == nil {
+						last = []byte(`{"status":"success"}`)
+					}
+					c.Data(http.StatusOK, "application/json; charset=utf-8", last)
+				}
+				return
+			}
+			last = data
+			if stream {
+				fmt.Fprintf(w, "%s\n", data)
+				flusher.Flush()
+			}
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			if err != nil {
+				fail(err)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
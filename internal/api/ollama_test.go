@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ligjn/aog/config"
+	"github.com/ligjn/aog/internal/datastore"
+	"github.com/ligjn/aog/internal/datastore/sqlite"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/server"
+	"github.com/ligjn/aog/internal/types"
+)
+
+func TestMain(m *testing.M) {
+	rootDir, err := os.MkdirTemp("", "aog-api-test")
+	if err != nil {
+		panic(err)
+	}
+	config.GlobalAOGEnvironment = &config.AOGEnvironment{RootDir: rootDir}
+	logger.InitLogger(logger.LogConfig{LogLevel: "error", LogPath: rootDir})
+	ds, err := sqlite.New(filepath.Join(rootDir, "aog.db"))
+	if err != nil {
+		panic(err)
+	}
+	if err := ds.Init(); err != nil {
+		panic(err)
+	}
+	datastore.SetDefaultDatastore(ds)
+	gin.SetMode(gin.TestMode)
+	code := m.Run()
+	os.RemoveAll(rootDir)
+	os.Exit(code)
+}
+
+// addTestModels Add the downloaded models served by a remote provider of the service
+func addTestModels(t *testing.T, providerName, service string, models ...string) {
+	t.Helper()
+	ctx := context.Background()
+	ds := datastore.GetDefaultDatastore()
+	sp := &types.ServiceProvider{
+		ProviderName:  providerName,
+		ServiceName:   service,
+		ServiceSource: types.ServiceSourceRemote,
+		Method:        http.MethodPost,
+		URL:           "http://127.0.0.1:1/" + service,
+		AuthType:      types.AuthTypeNone,
+		Flavor:        types.FlavorOpenAI,
+		ExtraHeaders:  "{}",
+		ExtraJSONBody: "{}",
+		Properties:    "{}",
+		Status:        1,
+	}
+	if err := ds.Add(ctx, sp); err != nil {
+		t.Fatal(err)
+	}
+	for _, model := range models {
+		if err := ds.Add(ctx, &types.Model{ModelName: model, ProviderName: providerName, Status: "downloaded"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func ollamaRequest(t *testing.T, handler gin.HandlerFunc, method, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := gin.New()
+	router.Handle(method, "/", handler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, "/", strings.NewReader(body)))
+	return w
+}
+
+func TestOllamaHandlers(t *testing.T) {
+	s := &AOGCoreServer{}
+	// more than a page of the model table
+	var models []string
+	for i := 0; i < 120; i++ {
+		models = append(models, fmt.Sprintf("tags-model-%d", i))
+	}
+	addTestModels(t, "openai_chat_tags_test", types.ServiceChat, models...)
+	addTestModels(t, "openai_embed_tags_test", types.ServiceEmbed, "text-vectors")
+
+	t.Run("tags", func(t *testing.T) {
+		w := ollamaRequest(t, s.OllamaListModels, http.MethodGet, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		var resp types.ListResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		listed := make(map[string]bool)
+		for _, m := range resp.Models {
+			listed[m.Name] = true
+		}
+		for _, m := range append(models, "text-vectors") {
+			if !listed[m] {
+				t.Errorf("model %s is not listed", m)
+			}
+		}
+	})
+
+	t.Run("show", func(t *testing.T) {
+		w := ollamaRequest(t, s.OllamaShowModel, http.MethodPost, `{"model": "text-vectors"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		var resp types.ShowResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Capabilities) != 1 || resp.Capabilities[0] != "embedding" {
+			t.Errorf("capabilities %v, want [embedding]", resp.Capabilities)
+		}
+	})
+
+	t.Run("show not found", func(t *testing.T) {
+		w := ollamaRequest(t, s.OllamaShowModel, http.MethodPost, `{"name": "missing-model"}`)
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("status %d, want %d", w.Code, http.StatusNotFound)
+		}
+		if !strings.Contains(w.Body.String(), `"error":"model 'missing-model' not found"`) {
+			t.Errorf("body %s", w.Body)
+		}
+	})
+
+	t.Run("pull service not supported", func(t *testing.T) {
+		w := ollamaRequest(t, s.OllamaPullModel, http.MethodPost, `{"model": "m1", "model_type": "text_to_image"}`)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+		}
+	})
+
+	t.Run("pull not streamed", func(t *testing.T) {
+		// the service has no local provider, the error comes as one JSON response instead of ndjson
+		w := ollamaRequest(t, s.OllamaPullModel, http.MethodPost, `{"model": "text-vectors", "stream": false}`)
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("status %d, want %d: %s", w.Code, http.StatusInternalServerError, w.Body)
+		}
+		if ct := w.Header().Get("Content-Type"); strings.Contains(ct, "ndjson") {
+			t.Errorf("content type %s, want JSON", ct)
+		}
+		var resp map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp["error"] == "" {
+			t.Errorf("body %s, want an error object", w.Body)
+		}
+	})
+}
+
+func TestOllamaPullServiceName(t *testing.T) {
+	addTestModels(t, "openai_embed_pull_test", types.ServiceEmbed, "nomic-text:v1.5")
+	ctx := context.Background()
+	cases := []struct {
+		model   string
+		service string
+		want    string
+	}{
+		{model: "qwen2:0.5b", want: types.ServiceChat},
+		// no guess from the name
+		{model: "some-embed-model", want: types.ServiceChat},
+		{model: "nomic-text:v1.5", want: types.ServiceEmbed},
+		{model: "nomic-text:v1.5", service: types.ServiceGenerate, want: types.ServiceGenerate},
+	}
+	for _, c := range cases {
+		got, err := server.OllamaPullServiceName(ctx, c.model, c.service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("service of %s (%q) is %s, want %s", c.model, c.service, got, c.want)
+		}
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/ligjn/aog/config"
 	"github.com/ligjn/aog/internal/datastore"
 	"github.com/ligjn/aog/internal/provider"
+	"github.com/ligjn/aog/internal/server"
 	"github.com/ligjn/aog/internal/types"
 	"github.com/ligjn/aog/internal/utils"
 	"github.com/ligjn/aog/version"
@@ -27,6 +28,7 @@ func InjectRouter(e *AOGCoreServer) {
 	e.Router.Handle(http.MethodGet, "/engine/health", engineHealthHandler)
 	e.Router.Handle(http.MethodGet, "/version", getVersion)
 	e.Router.Handle(http.MethodGet, "/engine/version", getEngineVersion)
+	e.Router.Handle(http.MethodGet, "/engine/status", engineStatusHandler)
 	e.Router.Handle(http.MethodGet, "/update/status", updateAvailableHandler)
 	e.Router.Handle(http.MethodPost, "/update", updateHandler)
 
@@ -47,13 +49,39 @@ func InjectRouter(e *AOGCoreServer) {
 	r.Handle(http.MethodDelete, "/service_provider", e.DeleteServiceProvider)
 
 	r.Handle(http.MethodGet, "/model", e.GetModels)
+	r.Handle(http.MethodGet, "/model/detail", e.GetModelDetail)
 	r.Handle(http.MethodPost, "/model", e.CreateModel)
+	r.Handle(http.MethodPost, "/model/import", e.ImportModel)
+	r.Handle(http.MethodPost, "/model/export", e.ExportModel)
+	r.Handle(http.MethodPost, "/model/bundle", e.InstallModelBundle)
+	r.Handle(http.MethodPost, "/model/load", e.LoadModel)
+	r.Handle(http.MethodPost, "/model/unload", e.UnloadModel)
+	r.Handle(http.MethodPut, "/model/keep_alive", e.SetModelKeepAlive)
+	r.Handle(http.MethodGet, "/model/loaded", e.GetLoadedModels)
 	r.Handle(http.MethodDelete, "/model", e.DeleteModel)
+	r.Handle(http.MethodPost, "/model/prune", e.PruneModels)
 	r.Handle(http.MethodPost, "/model/stream", e.CreateModelStream)
 	r.Handle(http.MethodPost, "/model/stream/cancel", e.CancelModelStream)
+	r.Handle(http.MethodGet, "/model/download", e.GetModelDownloads)
+	r.Handle(http.MethodGet, "/model/download/progress", e.SubscribeModelDownloads)
 	r.Handle(http.MethodGet, "/model/recommend", e.GetRecommendModels)
 	r.Handle(http.MethodGet, "/model/support", e.GetModelList)
 
+	// management endpoints installed with the routes of the flavors
+	registerOllamaHandlers(e)
+
+	// engines serving http themselves, e.g. llamacpp dispatching to its llama-server processes
+	for _, desc := range provider.ModelEngineDescriptors() {
+		engine, err := provider.GetModelEngine(desc.Name)
+		if err != nil {
+			continue
+		}
+		if handler, ok := engine.(http.Handler); ok {
+			prefix := "/aog/" + version.AOGVersion + "/engine/" + desc.Name
+			r.Any("/engine/"+desc.Name+"/*path", gin.WrapH(http.StripPrefix(prefix, handler)))
+		}
+	}
+
 	slog.Info("Gateway started", "host", config.GlobalAOGEnvironment.ApiHost)
 }
 
@@ -67,9 +95,12 @@ func healthHeader(c *gin.Context) {
 
 func engineHealthHandler(c *gin.Context) {
 	var data = make(map[string]string)
-	for _, modelEngineName := range types.SupportModelEngine {
-		engine := provider.GetModelEngine(modelEngineName)
-		err := engine.HealthCheck()
+	for _, desc := range provider.ModelEngineDescriptors() {
+		modelEngineName := desc.Name
+		engine, err := provider.GetModelEngine(modelEngineName)
+		if err == nil {
+			err = engine.HealthCheck()
+		}
 		if err != nil {
 			data[modelEngineName] = "DOWN"
 			continue
@@ -79,6 +110,10 @@ func engineHealthHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, data)
 }
 
+func engineStatusHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, server.GetEngineStatus())
+}
+
 func getVersion(c *gin.Context) {
 	c.JSON(http.StatusOK, map[string]string{"version": version.AOGVersion})
 }
@@ -86,10 +121,14 @@ func getVersion(c *gin.Context) {
 func getEngineVersion(c *gin.Context) {
 	ctx := c.Request.Context()
 	var data = make(map[string]string)
-	for _, modelEngineName := range types.SupportModelEngine {
-		engine := provider.GetModelEngine(modelEngineName)
+	for _, desc := range provider.ModelEngineDescriptors() {
+		modelEngineName := desc.Name
+		engine, err := provider.GetModelEngine(modelEngineName)
 		var respData types.EngineVersionResponse
-		resp, err := engine.GetVersion(ctx, &respData)
+		var resp *types.EngineVersionResponse
+		if err == nil {
+			resp, err = engine.GetVersion(ctx, &respData)
+		}
 		if err != nil {
 			data[modelEngineName] = "get version failed"
 			continue
@@ -72,7 +72,7 @@ func (c *GRPCClient) ModelInfer(inferInputs []*grpc_client.ModelInferRequest_Inf
 
 	// Set different Outputs according to different serviceType.
 	inferOutputs := make([]*grpc_client.ModelInferRequest_InferRequestedOutputTensor, 0)
-	if serviceType == types.ServiceTextToImage {
+	if serviceType == types.ServiceTextToImage || serviceType == types.ServiceImageToImage {
 		inferOutputs = append(inferOutputs, &grpc_client.ModelInferRequest_InferRequestedOutputTensor{
 			Name: "image",
 		})
@@ -10,6 +10,8 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 
 	"github.com/ligjn/aog/internal/utils/bcode"
 	"github.com/ligjn/aog/internal/utils/progress"
@@ -20,7 +22,54 @@ type Client struct {
 	http *http.Client
 }
 
-var ModelClientMap = make(map[string][]context.CancelFunc)
+// ModelCancelMap The cancel funcs of the pulls of each model, safe for concurrent use
+type ModelCancelMap struct {
+	mu      sync.Mutex
+	cancels map[string][]*pullCancel
+}
+
+// pullCancel A pointer per pull, so that the entry of the pull can be found when it returns
+type pullCancel struct {
+	cancel context.CancelFunc
+}
+
+var ModelClientMap = &ModelCancelMap{cancels: make(map[string][]*pullCancel)}
+
+// Add Keep the cancel func of a pull of the model. remove must be called once the pull returns
+func (c *ModelCancelMap) Add(model string, cancel context.CancelFunc) (remove func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	model = strings.ToLower(model)
+	entry := &pullCancel{cancel: cancel}
+	c.cancels[model] = append(c.cancels[model], entry)
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		entries := c.cancels[model]
+		for i, e := range entries {
+			if e == entry {
+				entries = append(entries[:i:i], entries[i+1:]...)
+				break
+			}
+		}
+		if len(entries) == 0 {
+			delete(c.cancels, model)
+			return
+		}
+		c.cancels[model] = entries
+	}
+}
+
+// Cancel Cancel the pulls of the model
+func (c *ModelCancelMap) Cancel(model string) {
+	c.mu.Lock()
+	entries := c.cancels[strings.ToLower(model)]
+	delete(c.cancels, strings.ToLower(model))
+	c.mu.Unlock()
+	for _, e := range entries {
+		e.cancel()
+	}
+}
 
 func checkError(resp *http.Response, body []byte) error {
 	if resp.StatusCode < http.StatusBadRequest {
@@ -6,15 +6,24 @@ package convert
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	jsonata "github.com/blues/jsonata-go"
+	"github.com/blues/jsonata-go/jtypes"
 	"github.com/ligjn/aog/internal/logger"
 	"github.com/ligjn/aog/internal/types"
+	"github.com/ligjn/aog/internal/utils"
 )
 
 type ConvertContext map[string]any
@@ -41,10 +50,52 @@ func CreateConverter(name string, config any) (Converter, error) {
 	return factory(config)
 }
 
+// jsonataExts functions not provided by jsonata-go but needed by flavor conversions. They must be
+// registered before any expression is compiled
+var jsonataExts = map[string]jsonata.Extension{
+	// $parseJson(str) parses a JSON string, e.g. arguments of tool calls. The string itself is
+	// returned if it is not a valid JSON
+	"parseJson": {Func: func(s string) interface{} {
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return s
+		}
+		return v
+	}},
+	// $imageDataUrl(image) turns raw base64 image (e.g. images of ollama) into a data url.
+	// Urls and data urls are returned as is
+	"imageDataUrl": {Func: func(s string) string {
+		if strings.HasPrefix(s, "data:") || strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+			return s
+		}
+		// the media type can be detected from the first bytes of the image
+		head := s
+		if len(head) > 64 {
+			head = head[:64]
+		}
+		data, _ := base64.StdEncoding.DecodeString(head)
+		return fmt.Sprintf("data:%s;base64,%s", http.DetectContentType(data), s)
+	}},
+	// $imageData(url) returns {"media_type", "data"} of a data url, or undefined for other urls
+	"imageData": {Func: func(s string) (interface{}, error) {
+		mediaType, data, ok := utils.ParseImageDataURL(s)
+		if !ok {
+			return nil, jtypes.ErrUndefined
+		}
+		return map[string]interface{}{"media_type": mediaType, "data": data}, nil
+	}},
+}
+
 func InitConverters() error {
+	if err := jsonata.RegisterExts(jsonataExts); err != nil {
+		return fmt.Errorf("[Converter] Failed to register jsonata extensions: %s", err.Error())
+	}
 	RegisterConverter("jsonata", NewJsonataConverter)
 	RegisterConverter("header", NewHeaderConverter)
 	RegisterConverter("action_if", NewActionBasedOnPattern)
+	RegisterConverter("sse_event", NewSSEEventConverter)
+	RegisterConverter("binary_body", NewBinaryBodyConverter)
+	RegisterConverter("multipart", NewMultipartConverter)
 	return nil
 }
 
@@ -77,9 +128,12 @@ func NewConverterPipeline(config []types.ConversionStepDef) (*ConverterPipeline,
 }
 
 func (p *ConverterPipeline) Convert(content types.HTTPContent, ctx ConvertContext) (types.HTTPContent, error) {
-	logger.LogicLogger.Debug("[Flavor] Convert Start: ", content)
-	if !p.IsReusable() { // need to replace the step which is not reusable
-		steps := make([]Converter, len(p.steps))
+	logger.LogicLogger.Debug("[Flavor] Convert Start", "content", content)
+	// NOTE: pipelines are shared by concurrent tasks, so p.steps must never be modified here.
+	// Non-reusable steps are recreated into a local slice for this call only.
+	steps := p.steps
+	if !p.IsReusable() {
+		steps = make([]Converter, len(p.steps))
 		for i, step := range p.steps {
 			if !step.IsReusable() {
 				c, err := CreateConverter(p.config[i].Converter, p.config[i].Config)
@@ -91,9 +145,8 @@ func (p *ConverterPipeline) Convert(content types.HTTPContent, ctx ConvertContex
 				steps[i] = step
 			}
 		}
-		p.steps = steps
 	}
-	for _, step := range p.steps {
+	for _, step := range steps {
 		// NOTE: we cannot use := below, otherwise content will be redeclared and outside content will not be updated
 		var err error
 		content, err = step.Convert(content, ctx)
@@ -102,7 +155,7 @@ func (p *ConverterPipeline) Convert(content types.HTTPContent, ctx ConvertContex
 		}
 	}
 
-	logger.LogicLogger.Debug("[Flavor] Convert Finish: ", content)
+	logger.LogicLogger.Debug("[Flavor] Convert Finish", "content", content)
 	return content, nil
 }
 
@@ -175,9 +228,79 @@ func (a *ActionBasedOnPattern) Convert(content types.HTTPContent, ctx ConvertCon
 
 //------------------------------------------------------------
 
+// ErrUndefined the jsonata expression yields nothing for the content. Stream conversions drop such
+// chunks, e.g. events of no interest, other conversions fail with it
+var ErrUndefined = jsonata.ErrUndefined
+
+// compiledJsonata A jsonata expression shared by all converters using the same source. RegisterVars
+// writes into the registry of a compiled expression, so each evaluation borrows a compiled copy of
+// its own from the pool of the var names it sets. The vars of a task never leak into another, and
+// concurrent tasks evaluate the expression at the same time
+type compiledJsonata struct {
+	source string
+	// pools sorted var names joined by "," -> *sync.Pool of *jsonata.Expr
+	pools sync.Map
+}
+
+// jsonataCache caches compiled expressions by their source, so each expression is compiled only once
+// no matter how many flavors, services or pipeline rebuilds refer to it
+var jsonataCache sync.Map
+
+func compileJsonata(expression string) (*compiledJsonata, error) {
+	if cached, ok := jsonataCache.Load(expression); ok {
+		return cached.(*compiledJsonata), nil
+	}
+	expr, err := jsonata.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	c := &compiledJsonata{source: expression}
+	c.pool(nil).Put(expr)
+	cached, _ := jsonataCache.LoadOrStore(expression, c)
+	return cached.(*compiledJsonata), nil
+}
+
+// pool The compiled copies of the expression with exactly the vars registered, whose values are
+// overwritten by each evaluation
+func (c *compiledJsonata) pool(vars map[string]any) *sync.Pool {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	p, _ := c.pools.LoadOrStore(strings.Join(names, ","), &sync.Pool{})
+	return p.(*sync.Pool)
+}
+
+func (c *compiledJsonata) eval(data []byte, vars map[string]any) ([]byte, error) {
+	pool := c.pool(vars)
+	expr, _ := pool.Get().(*jsonata.Expr)
+	if expr == nil {
+		var err error
+		// compiled once by compileJsonata already, so it doesn't fail
+		if expr, err = jsonata.Compile(c.source); err != nil {
+			return nil, fmt.Errorf("[Jsonata Converter] Failed to compile expression: %s", err.Error())
+		}
+	}
+	defer pool.Put(expr)
+	if len(vars) > 0 {
+		if err := expr.RegisterVars(vars); err != nil {
+			return nil, fmt.Errorf("[Jsonata Converter] Failed to register vars: %s", err.Error())
+		}
+	}
+	res, err := expr.EvalBytes(data)
+	if errors.Is(err, jsonata.ErrUndefined) {
+		return nil, fmt.Errorf("[Jsonata Converter] Expression yields nothing for the content: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[Jsonata Converter] Failed to evaluate expression: %s", err.Error())
+	}
+	return res, nil
+}
+
 type JsonataConverter struct {
 	Expression string
-	compiled   *jsonata.Expr
+	compiled   *compiledJsonata
 }
 
 func NewJsonataConverter(config any) (Converter, error) {
@@ -185,7 +308,7 @@ func NewJsonataConverter(config any) (Converter, error) {
 	if !ok {
 		return nil, fmt.Errorf("[Jsonata Converter] Expect string to create converter but got: %#v", config)
 	}
-	compiled, err := jsonata.Compile(expression)
+	compiled, err := compileJsonata(expression)
 	if err != nil {
 		return nil, fmt.Errorf("[Jsonata Converter] Failed to compile expression: %s with error: %s", expression, err.Error())
 	}
@@ -198,15 +321,9 @@ func (c *JsonataConverter) IsReusable() bool {
 }
 
 func (c *JsonataConverter) Convert(content types.HTTPContent, ctx ConvertContext) (types.HTTPContent, error) {
-	if ctx != nil {
-		err := c.compiled.RegisterVars(ctx)
-		if err != nil {
-			return types.HTTPContent{}, fmt.Errorf("[Jsonata Converter] Failed to register vars: %s", err.Error())
-		}
-	}
-	res, err := c.compiled.EvalBytes(content.Body)
+	res, err := c.compiled.eval(content.Body, ctx)
 	if err != nil {
-		return types.HTTPContent{}, fmt.Errorf("[Jsonata Converter] Failed to evaluate expression: %s", err.Error())
+		return types.HTTPContent{}, err
 	}
 	return types.HTTPContent{Body: res, Header: content.Header}, nil
 }
@@ -261,3 +378,151 @@ func (c *HeaderConverter) Convert(content types.HTTPContent, ctx ConvertContext)
 	}
 	return types.HTTPContent{Body: content.Body, Header: header}, nil
 }
+
+//------------------------------------------------------------
+
+// SSEEventConverter Format the JSON body as named events of event-stream. Some APIs (e.g. anthropic)
+// send several events with different names for one chunk, so the body can be either an object
+// or an array of objects, and the name of each event is picked from the field event_field
+type SSEEventConverter struct {
+	EventField string `json:"event_field"`
+}
+
+func NewSSEEventConverter(config any) (Converter, error) {
+	j, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("[SSE Event Converter] Failed to marshal config: %s", err.Error())
+	}
+	var c SSEEventConverter
+	err = json.Unmarshal(j, &c)
+	if err != nil {
+		return nil, fmt.Errorf("[SSE Event Converter] Failed to unmarshal config: %s", err.Error())
+	}
+	if c.EventField == "" {
+		c.EventField = "type"
+	}
+	return &c, nil
+}
+
+func (c *SSEEventConverter) IsReusable() bool {
+	return true
+}
+
+func (c *SSEEventConverter) Convert(content types.HTTPContent, ctx ConvertContext) (types.HTTPContent, error) {
+	var events []json.RawMessage
+	body := bytes.TrimSpace(content.Body)
+	if bytes.HasPrefix(body, []byte("[")) {
+		if err := json.Unmarshal(body, &events); err != nil {
+			return types.HTTPContent{}, fmt.Errorf("[SSE Event Converter] Failed to unmarshal events: %s", err.Error())
+		}
+	} else {
+		events = []json.RawMessage{body}
+	}
+	var buf bytes.Buffer
+	for _, data := range events {
+		var fields map[string]any
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return types.HTTPContent{}, fmt.Errorf("[SSE Event Converter] Failed to unmarshal event: %s", err.Error())
+		}
+		name, _ := fields[c.EventField].(string)
+		ev := types.SSEEvent{Event: name, Data: data, HasData: true}
+		buf.Write(ev.Bytes())
+	}
+	if buf.Len() == 0 {
+		return types.HTTPContent{}, &types.DropAction{}
+	}
+	return types.HTTPContent{Body: buf.Bytes(), Header: content.Header}, nil
+}
+
+//------------------------------------------------------------
+
+// BinaryBodyConverter Replace the JSON body with the binary content it refers to. Some APIs (e.g.
+// text-to-speech of aliyun) return the audio as a URL or base64 data in JSON instead of the
+// audio itself. The body is expected to be shaped as {"url": ..., "data": ..., "content_type": ...}
+// by the previous converters, data (base64) takes precedence over url
+type BinaryBodyConverter struct {
+	ContentType string `json:"content_type"` // used if not given in the body
+	// MaxSize the most bytes downloaded from the url, defaultBinaryBodyMaxSize if not set
+	MaxSize int64 `json:"max_size"`
+}
+
+// defaultBinaryBodyMaxSize large enough for the audio of a long text
+const defaultBinaryBodyMaxSize = 64 << 20
+
+var binaryBodyHTTPClient = &http.Client{Timeout: 60 * time.Second}
+
+func NewBinaryBodyConverter(config any) (Converter, error) {
+	j, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("[Binary Body Converter] Failed to marshal config: %s", err.Error())
+	}
+	var c BinaryBodyConverter
+	err = json.Unmarshal(j, &c)
+	if err != nil {
+		return nil, fmt.Errorf("[Binary Body Converter] Failed to unmarshal config: %s", err.Error())
+	}
+	if c.ContentType == "" {
+		c.ContentType = "application/octet-stream"
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = defaultBinaryBodyMaxSize
+	}
+	return &c, nil
+}
+
+func (c *BinaryBodyConverter) IsReusable() bool {
+	return true
+}
+
+func (c *BinaryBodyConverter) Convert(content types.HTTPContent, ctx ConvertContext) (types.HTTPContent, error) {
+	var ref struct {
+		URL         string `json:"url"`
+		Data        string `json:"data"`
+		ContentType string `json:"content_type"`
+	}
+	if err := json.Unmarshal(content.Body, &ref); err != nil {
+		return types.HTTPContent{}, fmt.Errorf("[Binary Body Converter] Failed to unmarshal body: %s", err.Error())
+	}
+	contentType := ref.ContentType
+	var body []byte
+	switch {
+	case ref.Data != "":
+		data, err := base64.StdEncoding.DecodeString(ref.Data)
+		if err != nil {
+			return types.HTTPContent{}, fmt.Errorf("[Binary Body Converter] Failed to decode data: %s", err.Error())
+		}
+		body = data
+	case ref.URL != "":
+		resp, err := binaryBodyHTTPClient.Get(ref.URL)
+		if err != nil {
+			return types.HTTPContent{}, fmt.Errorf("[Binary Body Converter] Failed to download %s: %s", ref.URL, err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return types.HTTPContent{}, fmt.Errorf("[Binary Body Converter] Failed to download %s: status %d", ref.URL, resp.StatusCode)
+		}
+		// one more byte than allowed is read to tell a body of MaxSize from a larger one
+		body, err = io.ReadAll(io.LimitReader(resp.Body, c.MaxSize+1))
+		if err != nil {
+			return types.HTTPContent{}, fmt.Errorf("[Binary Body Converter] Failed to download %s: %s", ref.URL, err.Error())
+		}
+		if int64(len(body)) > c.MaxSize {
+			return types.HTTPContent{}, fmt.Errorf("[Binary Body Converter] Failed to download %s: larger than %d bytes", ref.URL, c.MaxSize)
+		}
+		if contentType == "" {
+			contentType = resp.Header.Get("Content-Type")
+		}
+	default:
+		return types.HTTPContent{}, fmt.Errorf("[Binary Body Converter] Neither url nor data is found in body")
+	}
+	if contentType == "" {
+		contentType = c.ContentType
+	}
+	header := content.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Del("Content-Length")
+	header.Set("Content-Type", contentType)
+	return types.HTTPContent{Body: body, Header: header}, nil
+}
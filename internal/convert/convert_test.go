@@ -0,0 +1,283 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/types"
+)
+
+func TestMain(m *testing.M) {
+	logDir, err := os.MkdirTemp("", "aog-convert-test")
+	if err != nil {
+		panic(err)
+	}
+	logger.InitLogger(logger.LogConfig{LogLevel: "error", LogPath: logDir})
+	if err := InitConverters(); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(logDir)
+	os.Exit(code)
+}
+
+// openaiStreamToAOG the stream_response_to_aog conversion of the openai chat service
+var openaiStreamToAOG = []types.ConversionStepDef{
+	{Converter: "action_if", Config: map[string]any{"trim": true, "pattern": "[DONE]", "action": "drop"}},
+	{Converter: "jsonata", Config: `{
+		"id": id,
+		"model": model,
+		"created_at": created,
+		"message": choices[0].delta,
+		"finished": choices[0].finish_reason ? true : false,
+		"finish_reason": choices[0].finish_reason
+	}`},
+}
+
+func openaiChunk(i int) []byte {
+	return []byte(fmt.Sprintf(`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o",`+
+		`"choices":[{"index":0,"delta":{"role":"assistant","content":"token %d "},"finish_reason":null}]}`, i))
+}
+
+func TestJsonataVarsDontLeak(t *testing.T) {
+	c, err := NewJsonataConverter(`{"model": $exists($model) ? $model : model}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"model": "from-body"}`)
+
+	res, err := c.Convert(types.HTTPContent{Body: body}, ConvertContext{"model": "from-task"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Body) != `{"model":"from-task"}` {
+		t.Fatalf("unexpected result with $model: %s", res.Body)
+	}
+
+	// a task not setting $model must not see the one of the task before
+	res, err = c.Convert(types.HTTPContent{Body: body}, ConvertContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Body) != `{"model":"from-body"}` {
+		t.Fatalf("$model leaked into a later evaluation: %s", res.Body)
+	}
+}
+
+func TestJsonataConcurrentVars(t *testing.T) {
+	c, err := NewJsonataConverter(`{"n": $n, "content": content}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				res, err := c.Convert(types.HTTPContent{Body: []byte(`{"content": "x"}`)}, ConvertContext{"n": n})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var out struct {
+					N int `json:"n"`
+				}
+				if err := json.Unmarshal(res.Body, &out); err != nil || out.N != n {
+					t.Errorf("evaluation with $n=%d got %s", n, res.Body)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkStreamPipeline(b *testing.B) {
+	p, err := NewConverterPipeline(openaiStreamToAOG)
+	if err != nil {
+		b.Fatal(err)
+	}
+	chunk := openaiChunk(1)
+	b.SetBytes(int64(len(chunk)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Convert(types.HTTPContent{Body: chunk}, ConvertContext{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkStreamPipelineParallel chunks of concurrent tasks converted by the same shared pipeline
+func BenchmarkStreamPipelineParallel(b *testing.B) {
+	p, err := NewConverterPipeline(openaiStreamToAOG)
+	if err != nil {
+		b.Fatal(err)
+	}
+	chunk := openaiChunk(1)
+	b.SetBytes(int64(len(chunk)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := p.Convert(types.HTTPContent{Body: chunk}, ConvertContext{}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkStreamPipelineWithVars as BenchmarkStreamPipelineParallel, with context vars of the task
+func BenchmarkStreamPipelineWithVars(b *testing.B) {
+	p, err := NewConverterPipeline(openaiStreamToAOG)
+	if err != nil {
+		b.Fatal(err)
+	}
+	chunk := openaiChunk(1)
+	b.SetBytes(int64(len(chunk)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := p.Convert(types.HTTPContent{Body: chunk}, ConvertContext{"model": "gpt-4o", "event": "message"}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkStreamDoneChunk the [DONE] chunk dropped before any expression is evaluated
+func BenchmarkStreamDoneChunk(b *testing.B) {
+	p, err := NewConverterPipeline(openaiStreamToAOG)
+	if err != nil {
+		b.Fatal(err)
+	}
+	done := []byte("[DONE]")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Convert(types.HTTPContent{Body: done}, ConvertContext{}); err == nil {
+			b.Fatal("[DONE] is not dropped")
+		}
+	}
+}
+
+func TestJsonataUndefinedFails(t *testing.T) {
+	c, err := NewJsonataConverter(`missing.field`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Convert(types.HTTPContent{Body: []byte(`{"content": "x"}`)}, ConvertContext{})
+	if err == nil || types.IsDropAction(err) || !errors.Is(err, ErrUndefined) {
+		t.Fatalf("expect ErrUndefined for an expression yielding nothing, got %v", err)
+	}
+}
+
+func TestBinaryBodyConverter(t *testing.T) {
+	audio := []byte("ID3 fake mp3 audio")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/audio.mp3":
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Write(audio)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name            string
+		config          map[string]any
+		body            string
+		want            []byte
+		wantContentType string
+		wantErr         string
+	}{
+		{
+			name:            "url",
+			body:            `{"url": "` + server.URL + `/audio.mp3"}`,
+			want:            audio,
+			wantContentType: "audio/mpeg",
+		},
+		{
+			name:            "content type of the body",
+			body:            `{"url": "` + server.URL + `/audio.mp3", "content_type": "audio/mp3"}`,
+			want:            audio,
+			wantContentType: "audio/mp3",
+		},
+		{
+			name:            "data",
+			config:          map[string]any{"content_type": "audio/wav"},
+			body:            `{"data": "` + base64.StdEncoding.EncodeToString(audio) + `", "url": "` + server.URL + `/missing"}`,
+			want:            audio,
+			wantContentType: "audio/wav",
+		},
+		{
+			name:            "exactly the max size",
+			config:          map[string]any{"max_size": len(audio)},
+			body:            `{"url": "` + server.URL + `/audio.mp3"}`,
+			want:            audio,
+			wantContentType: "audio/mpeg",
+		},
+		{
+			name:    "larger than the max size",
+			config:  map[string]any{"max_size": len(audio) - 1},
+			body:    `{"url": "` + server.URL + `/audio.mp3"}`,
+			wantErr: "larger than",
+		},
+		{
+			name:    "download failed",
+			body:    `{"url": "` + server.URL + `/missing"}`,
+			wantErr: "status 404",
+		},
+		{
+			name:    "no url nor data",
+			body:    `{}`,
+			wantErr: "Neither url nor data",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			converter, err := NewBinaryBodyConverter(c.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := converter.Convert(types.HTTPContent{
+				Body:   []byte(c.body),
+				Header: http.Header{"Content-Type": {"application/json"}, "Content-Length": {"100"}},
+			}, ConvertContext{})
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("got error %v, want %q", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(res.Body, c.want) {
+				t.Errorf("body %q, want %q", res.Body, c.want)
+			}
+			if ct := res.Header.Get("Content-Type"); ct != c.wantContentType {
+				t.Errorf("content type %q, want %q", ct, c.wantContentType)
+			}
+			if res.Header.Get("Content-Length") != "" {
+				t.Errorf("content length of the JSON body is kept")
+			}
+		})
+	}
+}
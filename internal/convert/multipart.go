@@ -0,0 +1,179 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/ligjn/aog/internal/types"
+)
+
+// Multipart requests (e.g. audio uploads of speech-to-text) are represented as JSON objects
+// during conversion so that they can be handled by jsonata like the others. Each form field
+// becomes a string, and each file becomes an object:
+//
+//	{"filename": "a.mp3", "content_type": "audio/mpeg", "data": "<base64 of the file>"}
+//
+// Fields or files appearing more than once become arrays.
+
+// IsMultipart Whether the content is multipart/form-data
+func IsMultipart(header http.Header) bool {
+	return strings.HasPrefix(strings.ToLower(header.Get("Content-Type")), "multipart/form-data")
+}
+
+// MultipartToJSON Parse a multipart/form-data body into its JSON representation
+func MultipartToJSON(contentType string, body []byte) ([]byte, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("[Multipart] Failed to parse content type: %s", err.Error())
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, fmt.Errorf("[Multipart] Boundary is not found in content type")
+	}
+
+	values := make(map[string][]any)
+	var names []string
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("[Multipart] Failed to read part: %s", err.Error())
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("[Multipart] Failed to read part: %s", err.Error())
+		}
+		name := part.FormName()
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+		if part.FileName() == "" {
+			values[name] = append(values[name], string(data))
+			continue
+		}
+		values[name] = append(values[name], map[string]any{
+			"filename":     part.FileName(),
+			"content_type": part.Header.Get("Content-Type"),
+			"data":         base64.StdEncoding.EncodeToString(data),
+		})
+	}
+
+	m := make(map[string]any, len(values))
+	for _, name := range names {
+		if len(values[name]) == 1 {
+			m[name] = values[name][0]
+		} else {
+			m[name] = values[name]
+		}
+	}
+	return json.Marshal(m)
+}
+
+// MultipartConverter Convert the JSON representation back to a multipart/form-data body.
+// It should be the last step of request_from_aog as it sets the Content-Type with the boundary
+type MultipartConverter struct{}
+
+func NewMultipartConverter(config any) (Converter, error) {
+	return &MultipartConverter{}, nil
+}
+
+func (c *MultipartConverter) IsReusable() bool {
+	return true
+}
+
+func (c *MultipartConverter) Convert(content types.HTTPContent, ctx ConvertContext) (types.HTTPContent, error) {
+	var m map[string]any
+	if err := json.Unmarshal(content.Body, &m); err != nil {
+		return types.HTTPContent{}, fmt.Errorf("[Multipart Converter] Failed to unmarshal body: %s", err.Error())
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names) // keep the output stable
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for _, name := range names {
+		values, ok := m[name].([]any)
+		if !ok {
+			values = []any{m[name]}
+		}
+		for _, v := range values {
+			if err := writeMultipartValue(writer, name, v); err != nil {
+				return types.HTTPContent{}, err
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return types.HTTPContent{}, fmt.Errorf("[Multipart Converter] Failed to write body: %s", err.Error())
+	}
+
+	header := content.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Del("Content-Length")
+	header.Set("Content-Type", writer.FormDataContentType())
+	return types.HTTPContent{Body: buf.Bytes(), Header: header}, nil
+}
+
+func writeMultipartValue(writer *multipart.Writer, name string, v any) error {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return writer.WriteField(name, v)
+	case map[string]any:
+		data, isFile := v["data"].(string)
+		if !isFile {
+			break
+		}
+		fileData, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return fmt.Errorf("[Multipart Converter] Failed to decode file %s: %s", name, err.Error())
+		}
+		filename, _ := v["filename"].(string)
+		if filename == "" {
+			filename = name
+		}
+		contentType, _ := v["content_type"].(string)
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			escapeQuotes(name), escapeQuotes(filename)))
+		h.Set("Content-Type", contentType)
+		w, err := writer.CreatePart(h)
+		if err != nil {
+			return fmt.Errorf("[Multipart Converter] Failed to write file %s: %s", name, err.Error())
+		}
+		_, err = w.Write(fileData)
+		return err
+	}
+	// numbers, booleans and other objects are sent as their JSON text
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("[Multipart Converter] Failed to marshal field %s: %s", name, err.Error())
+	}
+	return writer.WriteField(name, string(b))
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -48,6 +48,8 @@ func (ds *SQLite) Init() error {
 		&types.ServiceProvider{},
 		&types.Service{},
 		&types.Model{},
+		&types.ModelUsage{},
+		&types.ModelKeepAlive{},
 	); err != nil {
 		return fmt.Errorf("failed to initialize database tables: %v", err)
 	}
@@ -60,37 +62,26 @@ func (ds *SQLite) Init() error {
 }
 
 // insertInitialData 插入初始化数据
+// Services added in later versions are inserted into existing databases too
 func (ds *SQLite) insertInitialData() error {
-	var count int64
-	if err := ds.db.Model(&types.Service{}).Count(&count).Error; err != nil {
-		return fmt.Errorf("failed to count initial data: %v", err)
-	}
-
-	if count == 0 {
-		initService := make([]*types.Service, 0)
+	initService := make([]*types.Service, 0)
+	for _, name := range types.SupportService {
 		initService = append(initService, &types.Service{
-			Name:         "chat",
-			HybridPolicy: "default",
-			Status:       1,
-		}, &types.Service{
-			Name:         "models",
-			HybridPolicy: "default",
-			Status:       1,
-		}, &types.Service{
-			Name:         "embed",
-			HybridPolicy: "default",
-			Status:       1,
-		}, &types.Service{
-			Name:         "generate",
-			HybridPolicy: "default",
-			Status:       1,
-		}, &types.Service{
-			Name:         "text-to-image",
+			Name:         name,
 			HybridPolicy: "default",
 			Status:       1,
 		})
+	}
 
-		if err := ds.db.CreateInBatches(initService, len(initService)).Error; err != nil {
+	for _, service := range initService {
+		var count int64
+		if err := ds.db.Model(&types.Service{}).Where("name = ?", service.Name).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count initial data: %v", err)
+		}
+		if count > 0 {
+			continue
+		}
+		if err := ds.db.Create(service).Error; err != nil {
 			return fmt.Errorf("failed to create initial service: %v", err)
 		}
 	}
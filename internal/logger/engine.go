@@ -0,0 +1,82 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	EngineLogMaxSize    = 50 // MB
+	EngineLogMaxBackups = 3
+)
+
+// EngineLogFile The file the output of the processes of an engine is written to, <LogDir>/engines/<name>.log
+func EngineLogFile(name string) string {
+	return filepath.Join(logPath, "engines", name+".log")
+}
+
+// OpenEngineLog Open the log file of the engine for its processes to write their output to. The
+// file is handed to the processes directly, so they keep logging after the process started them exits
+func OpenEngineLog(name string) (*os.File, error) {
+	if logPath == "" {
+		return nil, errors.New("logger is not initialized")
+	}
+	file := EngineLogFile(name)
+	if err := os.MkdirAll(filepath.Dir(file), 0o750); err != nil {
+		return nil, err
+	}
+	if err := RotateEngineLog(name); err != nil {
+		EngineLogger.Warn("[Engine] Failed to rotate engine log", "engine", name, "error", err)
+	}
+	return os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+}
+
+// RotateEngineLog Rotate the log file of the engine if it is larger than EngineLogMaxSize. The engine
+// processes hold the file, so it's copied to <name>.log.1 and truncated instead of renamed. They
+// write with O_APPEND, so they go on at the start of the truncated file
+func RotateEngineLog(name string) error {
+	file := EngineLogFile(name)
+	info, err := os.Stat(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Size() < EngineLogMaxSize*1024*1024 {
+		return nil
+	}
+
+	for i := EngineLogMaxBackups - 1; i > 0; i-- {
+		backup := fmt.Sprintf("%s.%d", file, i)
+		if _, err := os.Stat(backup); err == nil {
+			if err := os.Rename(backup, fmt.Sprintf("%s.%d", file, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := copyFile(file, file+".1"); err != nil {
+		return err
+	}
+	return os.Truncate(file, 0)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
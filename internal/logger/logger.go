@@ -16,6 +16,9 @@ const (
 
 var loggerNameArray = []string{"logic", "api", "engine"}
 
+// logPath the logs dir set by InitLogger
+var logPath string
+
 var (
 	LogicLogger  *slog.Logger
 	ApiLogger    *slog.Logger
@@ -83,6 +86,7 @@ func (lm *LogManager) GetLogger(name string) *slog.Logger {
 }
 
 func InitLogger(c LogConfig) {
+	logPath = c.LogPath
 	lm := NewLogManager(c)
 	LogicLogger = lm.GetLogger("logic")
 	ApiLogger = lm.GetLogger("api")
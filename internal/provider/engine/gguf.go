@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ggufMagic "GGUF" in little endian
+const ggufMagic = 0x46554747
+
+// gguf metadata value types
+const (
+	ggufUint8 uint32 = iota
+	ggufInt8
+	ggufUint16
+	ggufInt16
+	ggufUint32
+	ggufInt32
+	ggufFloat32
+	ggufBool
+	ggufString
+	ggufArray
+	ggufUint64
+	ggufInt64
+	ggufFloat64
+)
+
+// ggufFileTypes names of general.file_type, the quantization of the most of the tensors
+var ggufFileTypes = map[uint64]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 7: "Q8_0", 8: "Q5_0", 9: "Q5_1",
+	10: "Q2_K", 11: "Q3_K_S", 12: "Q3_K_M", 13: "Q3_K_L", 14: "Q4_K_S", 15: "Q4_K_M",
+	16: "Q5_K_S", 17: "Q5_K_M", 18: "Q6_K", 19: "IQ2_XXS", 20: "IQ2_XS", 21: "Q2_K_S",
+	22: "IQ3_XS", 23: "IQ3_XXS", 24: "IQ1_S", 25: "IQ4_NL", 26: "IQ3_S", 27: "IQ3_M",
+	28: "IQ2_S", 29: "IQ2_M", 30: "IQ4_XS", 31: "IQ1_M", 32: "BF16",
+}
+
+// ggufInfo the metadata of a GGUF file shown as the model details
+type ggufInfo struct {
+	Architecture  string
+	SizeLabel     string
+	FileType      string
+	ContextLength int64
+}
+
+// readGGUFInfo Read the metadata in the header of the GGUF file. The tensors are not read
+func readGGUFInfo(path string) (*ggufInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var header struct {
+		Magic   uint32
+		Version uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Magic != ggufMagic {
+		return nil, errors.New("not a gguf file")
+	}
+	if header.Version < 2 {
+		return nil, fmt.Errorf("gguf version %d is not supported", header.Version)
+	}
+	var counts struct {
+		Tensors uint64
+		KVs     uint64
+	}
+	if err := binary.Read(r, binary.LittleEndian, &counts); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]any)
+	for i := uint64(0); i < counts.KVs; i++ {
+		key, err := readGGUFString(r)
+		if err != nil {
+			return nil, err
+		}
+		var typ uint32
+		if err := binary.Read(r, binary.LittleEndian, &typ); err != nil {
+			return nil, err
+		}
+		v, err := readGGUFValue(r, typ)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %v", key, err)
+		}
+		if v != nil {
+			values[key] = v
+		}
+	}
+
+	info := &ggufInfo{}
+	info.Architecture, _ = values["general.architecture"].(string)
+	info.SizeLabel, _ = values["general.size_label"].(string)
+	if n, ok := ggufUint(values["general.file_type"]); ok {
+		info.FileType = ggufFileTypes[n]
+	}
+	if n, ok := ggufUint(values[info.Architecture+".context_length"]); ok {
+		info.ContextLength = int64(n)
+	}
+	return info, nil
+}
+
+func readGGUFString(r *bufio.Reader) (string, error) {
+	var n uint64
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", err
+	}
+	if n > 1<<20 {
+		// long strings are of the tokenizer etc., not wanted
+		return "", discardN(r, n)
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+// readGGUFValue Read the value of the type. Arrays are skipped and nil is returned for them
+func readGGUFValue(r *bufio.Reader, typ uint32) (any, error) {
+	var err error
+	switch typ {
+	case ggufUint8, ggufInt8, ggufBool:
+		var v uint8
+		err = binary.Read(r, binary.LittleEndian, &v)
+		return uint64(v), err
+	case ggufUint16, ggufInt16:
+		var v uint16
+		err = binary.Read(r, binary.LittleEndian, &v)
+		return uint64(v), err
+	case ggufUint32, ggufInt32:
+		var v uint32
+		err = binary.Read(r, binary.LittleEndian, &v)
+		return uint64(v), err
+	case ggufUint64, ggufInt64:
+		var v uint64
+		err = binary.Read(r, binary.LittleEndian, &v)
+		return v, err
+	case ggufFloat32:
+		_, err = r.Discard(4)
+		return nil, err
+	case ggufFloat64:
+		_, err = r.Discard(8)
+		return nil, err
+	case ggufString:
+		return readGGUFString(r)
+	case ggufArray:
+		var array struct {
+			Type uint32
+			Len  uint64
+		}
+		if err := binary.Read(r, binary.LittleEndian, &array); err != nil {
+			return nil, err
+		}
+		if size, ok := ggufValueSizes[array.Type]; ok {
+			return nil, discardN(r, array.Len*size)
+		}
+		for i := uint64(0); i < array.Len; i++ {
+			if _, err := readGGUFValue(r, array.Type); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown value type %d", typ)
+}
+
+// ggufValueSizes bytes of the fixed size value types
+var ggufValueSizes = map[uint32]uint64{
+	ggufUint8: 1, ggufInt8: 1, ggufBool: 1, ggufUint16: 2, ggufInt16: 2, ggufUint32: 4, ggufInt32: 4,
+	ggufFloat32: 4, ggufUint64: 8, ggufInt64: 8, ggufFloat64: 8,
+}
+
+func discardN(r *bufio.Reader, n uint64) error {
+	_, err := io.CopyN(io.Discard, r, int64(n))
+	return err
+}
+
+func ggufUint(v any) (uint64, bool) {
+	n, ok := v.(uint64)
+	return n, ok
+}
@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ggufWriter Write the header of a GGUF file, with the metadata added by its methods
+type ggufWriter struct {
+	kvs bytes.Buffer
+	n   uint64
+}
+
+func (w *ggufWriter) write(v any) {
+	binary.Write(&w.kvs, binary.LittleEndian, v)
+}
+
+func (w *ggufWriter) writeString(s string) {
+	w.write(uint64(len(s)))
+	w.kvs.WriteString(s)
+}
+
+// add Add the key with the value of the type, v is written as it is unless it's a string
+func (w *ggufWriter) add(key string, typ uint32, v any) *ggufWriter {
+	w.n++
+	w.writeString(key)
+	w.write(typ)
+	if s, ok := v.(string); ok {
+		w.writeString(s)
+	} else {
+		w.write(v)
+	}
+	return w
+}
+
+func (w *ggufWriter) addStringArray(key string, items ...string) *ggufWriter {
+	w.n++
+	w.writeString(key)
+	w.write(ggufArray)
+	w.write(ggufString)
+	w.write(uint64(len(items)))
+	for _, s := range items {
+		w.writeString(s)
+	}
+	return w
+}
+
+func (w *ggufWriter) addFloatArray(key string, items ...float32) *ggufWriter {
+	w.n++
+	w.writeString(key)
+	w.write(ggufArray)
+	w.write(ggufFloat32)
+	w.write(uint64(len(items)))
+	w.write(items)
+	return w
+}
+
+func (w *ggufWriter) file(t *testing.T, version uint32) string {
+	t.Helper()
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint32{ggufMagic, version})
+	binary.Write(&buf, binary.LittleEndian, []uint64{3, w.n})
+	buf.Write(w.kvs.Bytes())
+	// tensor infos, never read
+	buf.WriteString("tensors")
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadGGUFInfo(t *testing.T) {
+	full := (&ggufWriter{}).
+		add("general.architecture", ggufString, "qwen2").
+		add("general.name", ggufString, strings.Repeat("x", 1<<20+1)).
+		add("general.size_label", ggufString, "0.5B").
+		add("general.quantization_version", ggufUint32, uint32(2)).
+		add("qwen2.rope.freq_base", ggufFloat32, float32(1e6)).
+		add("qwen2.attention.layer_norm_rms_epsilon", ggufFloat64, float64(1e-6)).
+		add("tokenizer.ggml.add_bos_token", ggufBool, uint8(0)).
+		addStringArray("tokenizer.ggml.tokens", "<s>", "</s>", "hello").
+		addFloatArray("tokenizer.ggml.scores", 0, 0.5, 1).
+		add("qwen2.context_length", ggufUint32, uint32(32768)).
+		add("general.file_type", ggufUint32, uint32(15))
+	cases := []struct {
+		name    string
+		path    func(t *testing.T) string
+		want    *ggufInfo
+		wantErr string
+	}{
+		{
+			name: "metadata",
+			path: func(t *testing.T) string { return full.file(t, 3) },
+			want: &ggufInfo{Architecture: "qwen2", SizeLabel: "0.5B", FileType: "Q4_K_M", ContextLength: 32768},
+		},
+		{
+			name: "64 bits context length",
+			path: func(t *testing.T) string {
+				return (&ggufWriter{}).
+					add("general.architecture", ggufString, "llama").
+					add("llama.context_length", ggufUint64, uint64(131072)).
+					add("general.file_type", ggufUint32, uint32(99)).
+					file(t, 2)
+			},
+			want: &ggufInfo{Architecture: "llama", ContextLength: 131072},
+		},
+		{
+			name: "no metadata",
+			path: func(t *testing.T) string { return (&ggufWriter{}).file(t, 3) },
+			want: &ggufInfo{},
+		},
+		{
+			name: "not gguf",
+			path: func(t *testing.T) string {
+				path := filepath.Join(t.TempDir(), "model.bin")
+				os.WriteFile(path, []byte("PK\x03\x04 not a model"), 0o644)
+				return path
+			},
+			wantErr: "not a gguf file",
+		},
+		{
+			name:    "version 1",
+			path:    func(t *testing.T) string { return full.file(t, 1) },
+			wantErr: "gguf version 1 is not supported",
+		},
+		{
+			name: "unknown value type",
+			path: func(t *testing.T) string {
+				return (&ggufWriter{}).add("general.architecture", 99, uint32(0)).file(t, 3)
+			},
+			wantErr: "read general.architecture: unknown value type 99",
+		},
+		{
+			name: "truncated",
+			path: func(t *testing.T) string {
+				path := full.file(t, 3)
+				data, _ := os.ReadFile(path)
+				os.WriteFile(path, data[:len(data)-200], 0o644)
+				return path
+			},
+			wantErr: "EOF",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := readGGUFInfo(c.path(t))
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("got %+v, %v, want error %q", got, err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
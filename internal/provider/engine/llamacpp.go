@@ -0,0 +1,1144 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ligjn/aog/config"
+	"github.com/ligjn/aog/internal/client"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/types"
+	"github.com/ligjn/aog/internal/utils"
+	"github.com/ligjn/aog/version"
+	"github.com/shirou/gopsutil/process"
+)
+
+const (
+	// llamaCppBuild the llama.cpp release installed by AOG
+	llamaCppBuild = "b5200"
+	// llamaCppDefaultMirror the Hugging Face mirror where GGUF models are pulled from
+	llamaCppDefaultMirror = "https://hf-mirror.com"
+	// llamaCppDefaultQuant the quantization pulled if the model name doesn't tell one
+	llamaCppDefaultQuant = "Q4_K_M"
+	// llamaCppBasePort the first port of llama-server processes, one for each loaded model
+	llamaCppBasePort   = 16700
+	llamaCppMaxServers = 100
+	// llamaCppLoadTimeout how long a llama-server may take to load its model
+	llamaCppLoadTimeout = 2 * time.Minute
+	// llamaCppDefaultKeepAlive llama-server processes are kept until the engine stops if no keep_alive is asked
+	llamaCppDefaultKeepAlive = time.Duration(-1)
+)
+
+// LlamaCppProvider llama.cpp engine managed by AOG. GGUF models are pulled from a Hugging Face
+// mirror (AOG_LLAMACPP_MIRROR, https://hf-mirror.com by default) into the models dir of the engine,
+// and a llama-server process is launched on a managed port for each model when it is first asked.
+// Requests reach these processes through the engine routes of AOG (/aog/<ver>/engine/llamacpp),
+// which pick the process by the model in the request, see ServeHTTP.
+//
+// Models are named <owner>/<repo>:<quant>, e.g. Qwen/Qwen2.5-0.5B-Instruct-GGUF:Q4_K_M, or
+// <owner>/<repo>/<file>.gguf to pick the file directly.
+type LlamaCppProvider struct {
+	EngineConfig *types.EngineRecommendConfig
+
+	mu      sync.Mutex
+	servers map[string]*llamaServer
+	// pulling the locks of the GGUF files being downloaded, keyed by their local files, so that
+	// concurrent pulls of a model don't write the same part
+	pulling map[string]chan struct{}
+}
+
+// llamaServer a llama-server process serving one model
+type llamaServer struct {
+	model     string
+	embedding bool
+	port      int
+	cmd       *exec.Cmd
+	// done is closed when the process exits
+	done chan struct{}
+	// keepAlive how long the server is kept after its last request, forever if negative
+	keepAlive time.Duration
+	// active the requests being served, the server isn't stopped for idle while there are some
+	active    int
+	expiresAt time.Time
+	idleTimer *time.Timer
+}
+
+// llamaCppModel an entry of the model manifest, which maps the model names to the GGUF files
+type llamaCppModel struct {
+	File       string    `json:"file"`
+	Size       int64     `json:"size"`
+	Digest     string    `json:"digest"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+func NewLlamaCppProvider(config *types.EngineRecommendConfig) *LlamaCppProvider {
+	if config != nil {
+		return &LlamaCppProvider{
+			EngineConfig: config,
+			servers:      make(map[string]*llamaServer),
+			pulling:      make(map[string]chan struct{}),
+		}
+	}
+
+	AOGDir, err := utils.GetAOGDataDir()
+	if err != nil {
+		logger.EngineLogger.Error("[LlamaCpp] Get AOG data dir failed: " + err.Error())
+		return nil
+	}
+
+	modelsPath := filepath.Join(AOGDir, "engine", "llamacpp", "models")
+	if err := os.MkdirAll(modelsPath, 0o750); err != nil {
+		logger.EngineLogger.Error("[LlamaCpp] Create models dir failed: " + err.Error())
+		return nil
+	}
+
+	llamaCppProvider := &LlamaCppProvider{
+		servers: make(map[string]*llamaServer),
+		pulling: make(map[string]chan struct{}),
+	}
+	llamaCppProvider.EngineConfig = llamaCppProvider.GetConfig()
+
+	return llamaCppProvider
+}
+
+func (l *LlamaCppProvider) GetConfig() *types.EngineRecommendConfig {
+	if l.EngineConfig != nil {
+		return l.EngineConfig
+	}
+
+	AOGDir, err := utils.GetAOGDataDir()
+	if err != nil {
+		logger.EngineLogger.Error("[LlamaCpp] Get AOG data dir failed: " + err.Error())
+		return nil
+	}
+	enginePath := filepath.Join(AOGDir, "engine", "llamacpp")
+
+	execFile := "llama-server"
+	platform := ""
+	switch runtime.GOOS {
+	case "windows":
+		execFile = "llama-server.exe"
+		platform = "win-cpu-x64"
+	case "linux":
+		platform = "ubuntu-x64"
+	case "darwin":
+		platform = "macos-arm64"
+		if runtime.GOARCH == "amd64" {
+			platform = "macos-x64"
+		}
+	default:
+		logger.EngineLogger.Error("[LlamaCpp] unsupported operating system: " + runtime.GOOS)
+		return nil
+	}
+	downloadUrl := config.Var("AOG_LLAMACPP_DOWNLOAD_URL")
+	if downloadUrl == "" {
+		downloadUrl = fmt.Sprintf("https://github.com/ggml-org/llama.cpp/releases/download/%s/llama-%s-bin-%s.zip",
+			llamaCppBuild, llamaCppBuild, platform)
+	}
+
+	return &types.EngineRecommendConfig{
+		// llama-server processes are reached through the engine routes of aog
+		Host:         config.Host().Host,
+		Origin:       "127.0.0.1",
+		Scheme:       "http",
+		DownloadUrl:  downloadUrl,
+		DownloadPath: filepath.Join(enginePath, "download"),
+		EnginePath:   enginePath,
+		ExecPath:     findExecPath(enginePath, execFile),
+		ExecFile:     execFile,
+	}
+}
+
+// findExecPath The release zips put the binaries in build/bin or directly in the root
+func findExecPath(enginePath, execFile string) string {
+	execPath := filepath.Join(enginePath, "bin")
+	_ = filepath.WalkDir(enginePath, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && d.Name() == execFile {
+			execPath = filepath.Dir(path)
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return execPath
+}
+
+func (l *LlamaCppProvider) GetDefaultClient() *client.Client {
+	scheme := "http"
+	if l.EngineConfig.Scheme == "https" {
+		scheme = "https"
+	}
+
+	return client.NewClient(&url.URL{
+		Scheme: scheme,
+		Host:   l.EngineConfig.Host,
+		Path:   "/aog/" + version.AOGVersion + "/engine/" + types.FlavorLlamaCpp,
+	}, http.DefaultClient)
+}
+
+func (l *LlamaCppProvider) execFilePath() string {
+	return filepath.Join(l.EngineConfig.ExecPath, l.EngineConfig.ExecFile)
+}
+
+func (l *LlamaCppProvider) modelsPath() string {
+	return filepath.Join(l.EngineConfig.EnginePath, "models")
+}
+
+func (l *LlamaCppProvider) isInstalled() bool {
+	_, err := os.Stat(l.execFilePath())
+	return err == nil
+}
+
+func (l *LlamaCppProvider) InstallEngine() error {
+	if err := os.MkdirAll(l.EngineConfig.DownloadPath, 0o750); err != nil {
+		return fmt.Errorf("failed to create download dir: %v", err)
+	}
+	file, err := utils.DownloadFile(l.EngineConfig.DownloadUrl, l.EngineConfig.DownloadPath)
+	if err != nil {
+		return fmt.Errorf("failed to download llama.cpp: %v, url: %v", err, l.EngineConfig.DownloadUrl)
+	}
+
+	logger.EngineLogger.Info("[LlamaCpp] start install...")
+	if err := utils.UnzipFile(file, l.EngineConfig.EnginePath); err != nil {
+		return fmt.Errorf("failed to unzip llama.cpp: %v", err)
+	}
+	l.EngineConfig.ExecPath = findExecPath(l.EngineConfig.EnginePath, l.EngineConfig.ExecFile)
+	if !l.isInstalled() {
+		return fmt.Errorf("%s not found after install", l.EngineConfig.ExecFile)
+	}
+	if runtime.GOOS != "windows" {
+		if err := os.Chmod(l.execFilePath(), 0o755); err != nil {
+			return fmt.Errorf("failed to make llama-server executable: %v", err)
+		}
+	}
+	logger.EngineLogger.Info("[LlamaCpp] llama.cpp install completed")
+	return nil
+}
+
+func (l *LlamaCppProvider) InitEnv() error {
+	return nil
+}
+
+// StartEngine llama-server processes are launched when their models are asked, so there is nothing
+// to start but to check the engine is installed
+func (l *LlamaCppProvider) StartEngine(mode string) error {
+	logger.EngineLogger.Info("[LlamaCpp] Start engine mode: " + mode)
+	if !l.isInstalled() {
+		return fmt.Errorf("llama-server not found in %s", l.EngineConfig.ExecPath)
+	}
+	return nil
+}
+
+// StopEngine Stop all the llama-server processes
+func (l *LlamaCppProvider) StopEngine() error {
+	l.mu.Lock()
+	servers := make([]*llamaServer, 0, len(l.servers))
+	for _, s := range l.servers {
+		servers = append(servers, s)
+	}
+	l.mu.Unlock()
+
+	var errs []error
+	for _, s := range servers {
+		if err := l.stopServer(s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (l *LlamaCppProvider) HealthCheck() error {
+	c := l.GetDefaultClient()
+	if err := c.Do(context.Background(), http.MethodGet, "/health", nil, nil); err != nil {
+		logger.EngineLogger.Error("[LlamaCpp] Health check failed: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetVersion The build of llama-server, e.g. "5200 (2f54e348)"
+func (l *LlamaCppProvider) GetVersion(ctx context.Context, resp *types.EngineVersionResponse) (*types.EngineVersionResponse, error) {
+	out, err := exec.CommandContext(ctx, l.execFilePath(), "--version").CombinedOutput()
+	if err != nil {
+		logger.EngineLogger.Error("[LlamaCpp] Get version failed: " + err.Error())
+		return nil, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "version:"); ok {
+			resp.Version = strings.TrimSpace(v)
+			return resp, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown llama-server version output: %s", strings.TrimSpace(string(out)))
+}
+
+// ------------------------------------------------------------
+// models
+
+func (l *LlamaCppProvider) manifestPath() string {
+	return filepath.Join(l.modelsPath(), "manifest.json")
+}
+
+func (l *LlamaCppProvider) loadManifest() (map[string]llamaCppModel, error) {
+	manifest := make(map[string]llamaCppModel)
+	data, err := os.ReadFile(l.manifestPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid model manifest: %v", err)
+	}
+	return manifest, nil
+}
+
+// updateManifest Change the manifest by fn, which returns false if nothing is changed
+func (l *LlamaCppProvider) updateManifest(fn func(manifest map[string]llamaCppModel) bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	manifest, err := l.loadManifest()
+	if err != nil {
+		return err
+	}
+	if !fn(manifest) {
+		return nil
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(l.manifestPath(), data, 0o644)
+}
+
+// findModel Model names are matched case-insensitively, as AOG keeps them in lower case
+func findModel(manifest map[string]llamaCppModel, name string) (string, llamaCppModel, bool) {
+	if m, ok := manifest[name]; ok {
+		return name, m, true
+	}
+	for k, m := range manifest {
+		if strings.EqualFold(k, name) {
+			return k, m, true
+		}
+	}
+	return "", llamaCppModel{}, false
+}
+
+func (l *LlamaCppProvider) getModel(name string) (string, llamaCppModel, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	manifest, err := l.loadManifest()
+	if err != nil {
+		return "", llamaCppModel{}, false
+	}
+	return findModel(manifest, name)
+}
+
+func (l *LlamaCppProvider) ListModels(ctx context.Context) (*types.ListResponse, error) {
+	l.mu.Lock()
+	manifest, err := l.loadManifest()
+	l.mu.Unlock()
+	if err != nil {
+		logger.EngineLogger.Error("[LlamaCpp] Get model list failed: " + err.Error())
+		return nil, err
+	}
+
+	models := make([]types.ListModelResponse, 0, len(manifest))
+	for name, m := range manifest {
+		models = append(models, types.ListModelResponse{
+			Name:       name,
+			Model:      name,
+			ModifiedAt: m.ModifiedAt,
+			Size:       m.Size,
+			Digest:     m.Digest,
+			Details:    types.ModelDetails{Format: "gguf"},
+		})
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Name < models[j].Name
+	})
+	return &types.ListResponse{Models: models}, nil
+}
+
+// ModelInfo The model in the manifest, with the quantization and context length read from the
+// header of its GGUF file
+func (l *LlamaCppProvider) ModelInfo(ctx context.Context, model string) (*types.EngineModelInfo, error) {
+	_, m, ok := l.getModel(model)
+	if !ok {
+		return nil, fmt.Errorf("model %s not found", model)
+	}
+	info := &types.EngineModelInfo{
+		Size:       m.Size,
+		Digest:     m.Digest,
+		Details:    types.ModelDetails{Format: "gguf"},
+		ModifiedAt: m.ModifiedAt,
+	}
+	gguf, err := readGGUFInfo(filepath.Join(l.modelsPath(), m.File))
+	if err != nil {
+		logger.EngineLogger.Warn("[LlamaCpp] Failed to read gguf metadata", "model", model, "error", err)
+		return info, nil
+	}
+	info.ContextLength = gguf.ContextLength
+	info.Details.Family = gguf.Architecture
+	if gguf.Architecture != "" {
+		info.Details.Families = []string{gguf.Architecture}
+	}
+	info.Details.ParameterSize = gguf.SizeLabel
+	info.Details.QuantizationLevel = gguf.FileType
+	return info, nil
+}
+
+func (l *LlamaCppProvider) DeleteModel(ctx context.Context, req *types.DeleteRequest) error {
+	logger.EngineLogger.Info("[LlamaCpp] Delete model: " + req.Model)
+	name, m, ok := l.getModel(req.Model)
+	if !ok {
+		return fmt.Errorf("model %s not found", req.Model)
+	}
+
+	if err := l.stopModelServers(name); err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(l.modelsPath(), m.File)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.EngineLogger.Error("[LlamaCpp] Delete model failed: " + err.Error())
+		return err
+	}
+	return l.updateManifest(func(manifest map[string]llamaCppModel) bool {
+		delete(manifest, name)
+		return true
+	})
+}
+
+func llamaCppMirror() string {
+	mirror := config.Var("AOG_LLAMACPP_MIRROR")
+	if mirror == "" {
+		mirror = llamaCppDefaultMirror
+	}
+	return strings.TrimSuffix(mirror, "/")
+}
+
+// llamaCppRemoteFile the GGUF file of a model in a Hugging Face repo, with the sha256 and size the
+// repo tells for it, which are empty if it doesn't
+type llamaCppRemoteFile struct {
+	Repo   string
+	File   string
+	SHA256 string
+	Size   int64
+}
+
+// hfRepoFile a file listed by the Hugging Face API, LFS is set for the files kept in LFS as models are
+type hfRepoFile struct {
+	Filename string `json:"rfilename"`
+	Size     int64  `json:"size"`
+	LFS      *struct {
+		SHA256 string `json:"sha256"`
+		Size   int64  `json:"size"`
+	} `json:"lfs"`
+}
+
+// listRepoFiles The files of the Hugging Face repo, with their sizes and digests
+func listRepoFiles(ctx context.Context, repo string) ([]hfRepoFile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, llamaCppMirror()+"/api/models/"+repo+"?blobs=true", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := modelInfoClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model info: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get model info of %s: HTTP status %s", repo, resp.Status)
+	}
+	var info struct {
+		Siblings []hfRepoFile `json:"siblings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("invalid model info of %s: %v", repo, err)
+	}
+	return info.Siblings, nil
+}
+
+// localFile The name the file is saved with in the models dir
+func (f *llamaCppRemoteFile) localFile() string {
+	return strings.ReplaceAll(f.Repo, "/", "--") + "--" + filepath.Base(f.File)
+}
+
+func newRemoteFile(repo string, f hfRepoFile) *llamaCppRemoteFile {
+	rf := &llamaCppRemoteFile{Repo: repo, File: f.Filename, Size: f.Size}
+	if f.LFS != nil {
+		rf.SHA256 = f.LFS.SHA256
+		rf.Size = f.LFS.Size
+	}
+	return rf
+}
+
+// resolveModelFile Find out the repo and the GGUF file of the model name
+func resolveModelFile(ctx context.Context, name string) (*llamaCppRemoteFile, error) {
+	if strings.HasSuffix(strings.ToLower(name), ".gguf") {
+		parts := strings.SplitN(name, "/", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid model name %s, expect <owner>/<repo>/<file>.gguf", name)
+		}
+		repo := parts[0] + "/" + parts[1]
+		// the file can be downloaded without its digest, if the mirror can't list the repo
+		if files, err := listRepoFiles(ctx, repo); err == nil {
+			for _, f := range files {
+				if f.Filename == parts[2] {
+					return newRemoteFile(repo, f), nil
+				}
+			}
+		}
+		return &llamaCppRemoteFile{Repo: repo, File: parts[2]}, nil
+	}
+
+	repo, quant, _ := strings.Cut(name, ":")
+	if quant == "" {
+		quant = llamaCppDefaultQuant
+	}
+	if strings.Count(repo, "/") != 1 {
+		return nil, fmt.Errorf("invalid model name %s, expect <owner>/<repo>:<quant>", name)
+	}
+
+	files, err := listRepoFiles(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	var ggufs []string
+	for _, f := range files {
+		lower := strings.ToLower(f.Filename)
+		// multimodal projectors are not models by themselves
+		if !strings.HasSuffix(lower, ".gguf") || strings.Contains(lower, "mmproj") {
+			continue
+		}
+		ggufs = append(ggufs, f.Filename)
+		if strings.Contains(lower, strings.ToLower(quant)) {
+			return newRemoteFile(repo, f), nil
+		}
+	}
+	return nil, fmt.Errorf("no %s GGUF file in %s, available: %s", quant, repo, strings.Join(ggufs, ", "))
+}
+
+// pull Download the GGUF file of the model, reporting the progress by fn
+func (l *LlamaCppProvider) pull(ctx context.Context, name string, fn types.PullProgressFunc) error {
+	if _, _, ok := l.getModel(name); ok {
+		return fn(types.ProgressResponse{Status: "success"})
+	}
+	if err := fn(types.ProgressResponse{Status: "pulling manifest"}); err != nil {
+		return err
+	}
+	remote, err := resolveModelFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	file := remote.File
+
+	localFile := remote.localFile()
+	unlock, err := l.lockPull(ctx, localFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// pulled by another request while this one waited
+	if _, _, ok := l.getModel(name); ok {
+		return fn(types.ProgressResponse{Status: "success"})
+	}
+	savePath := filepath.Join(l.modelsPath(), localFile)
+	partPath := savePath + ".part"
+
+	// the part left by an interrupted pull is resumed from its end
+	hash := sha256.New()
+	var offset int64
+	if part, err := os.Open(partPath); err == nil {
+		offset, err = io.Copy(hash, part)
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	downloadUrl := llamaCppMirror() + "/" + remote.Repo + "/resolve/main/" + file
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadUrl, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download model: %v", err)
+	}
+	defer resp.Body.Close()
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		logger.EngineLogger.Info(fmt.Sprintf("[LlamaCpp] Resume downloading %s from %d bytes", file, offset))
+	case resp.StatusCode == http.StatusOK:
+		// the mirror can't resume, start over
+		offset = 0
+		hash.Reset()
+		flag = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// the part is no longer of the file, it's downloaded again by the retry
+		_ = os.Remove(partPath)
+		return fmt.Errorf("failed to download model: partial file of %s is invalid", file)
+	default:
+		return fmt.Errorf("failed to download model: HTTP status %s, url: %s", resp.Status, downloadUrl)
+	}
+
+	out, err := os.OpenFile(partPath, flag, 0o644)
+	if err != nil {
+		return err
+	}
+
+	progress := types.ProgressResponse{Status: "pulling " + file, Digest: file, Total: remote.Size, Completed: offset}
+	if resp.ContentLength >= 0 {
+		progress.Total = offset + resp.ContentLength
+	}
+	buf := make([]byte, 1<<20)
+	lastReport := time.Time{}
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := out.Write(buf[:n]); err != nil {
+				out.Close()
+				return err
+			}
+			hash.Write(buf[:n])
+			progress.Completed += int64(n)
+			if time.Since(lastReport) > 500*time.Millisecond {
+				lastReport = time.Now()
+				if err := fn(progress); err != nil {
+					out.Close()
+					return err
+				}
+			}
+		}
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			out.Close()
+			return fmt.Errorf("failed to download model: %v", readErr)
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := fn(progress); err != nil {
+		return err
+	}
+
+	if err := fn(types.ProgressResponse{Status: "verifying sha256 digest"}); err != nil {
+		return err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if remote.SHA256 != "" && !strings.EqualFold(digest, remote.SHA256) {
+		_ = os.Remove(partPath)
+		return fmt.Errorf("digest mismatch of %s, expected sha256:%s, got sha256:%s", file, remote.SHA256, digest)
+	}
+	if err := os.Rename(partPath, savePath); err != nil {
+		return err
+	}
+
+	if err := fn(types.ProgressResponse{Status: "writing manifest"}); err != nil {
+		return err
+	}
+	err = l.updateManifest(func(manifest map[string]llamaCppModel) bool {
+		manifest[name] = llamaCppModel{
+			File:       localFile,
+			Size:       progress.Completed,
+			Digest:     "sha256:" + digest,
+			ModifiedAt: time.Now(),
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return fn(types.ProgressResponse{Status: "success"})
+}
+
+// lockPull Wait for the other pulls of the local file to end, the returned func releases the lock
+func (l *LlamaCppProvider) lockPull(ctx context.Context, localFile string) (func(), error) {
+	l.mu.Lock()
+	lock, ok := l.pulling[localFile]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		l.pulling[localFile] = lock
+	}
+	l.mu.Unlock()
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *LlamaCppProvider) ModelStoragePath() string {
+	return l.modelsPath()
+}
+
+// PullSize The size of the GGUF file of the model in the repo, less the part downloaded already
+func (l *LlamaCppProvider) PullSize(ctx context.Context, model string) (int64, error) {
+	if _, _, ok := l.getModel(model); ok {
+		return 0, nil
+	}
+	remote, err := resolveModelFile(ctx, model)
+	if err != nil {
+		return 0, err
+	}
+	if remote.Size <= 0 {
+		return 0, fmt.Errorf("size of model %s is unknown", model)
+	}
+	size := remote.Size
+	if info, err := os.Stat(filepath.Join(l.modelsPath(), remote.localFile()+".part")); err == nil {
+		size -= info.Size()
+	}
+	if size < 0 {
+		size = 0
+	}
+	return size, nil
+}
+
+func (l *LlamaCppProvider) PullModel(ctx context.Context, req *types.PullModelRequest, fn types.PullProgressFunc) (*types.ProgressResponse, error) {
+	logger.EngineLogger.Info("[LlamaCpp] Pull model: " + req.Model)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	remove := client.ModelClientMap.Add(req.Model, cancel)
+	defer remove()
+
+	var last types.ProgressResponse
+	err := l.pull(ctx, req.Model, func(p types.ProgressResponse) error {
+		last = p
+		if fn != nil {
+			return fn(p)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.EngineLogger.Error("[LlamaCpp] Pull model failed: " + err.Error())
+		return &last, err
+	}
+	logger.EngineLogger.Info("[LlamaCpp] Pull model success: " + req.Model)
+	return &last, nil
+}
+
+// PullModelStream The progress is sent as JSON lines like Ollama, and the error as {"error": "..."}
+func (l *LlamaCppProvider) PullModelStream(ctx context.Context, req *types.PullModelRequest) (chan []byte, chan error) {
+	logger.EngineLogger.Info("[LlamaCpp] Pull model: " + req.Model + " , mode: stream")
+
+	ctx, cancel := context.WithCancel(ctx)
+	remove := client.ModelClientMap.Add(req.Model, cancel)
+
+	dataCh := make(chan []byte, 100)
+	errCh := make(chan error, 1)
+	go func() {
+		defer remove()
+		defer cancel()
+		defer close(dataCh)
+		defer close(errCh)
+		err := l.pull(ctx, req.Model, func(p types.ProgressResponse) error {
+			data, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			select {
+			case dataCh <- data:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err == nil {
+			return
+		}
+		logger.EngineLogger.Error("[LlamaCpp] Pull model failed: " + err.Error())
+		if ctx.Err() != nil {
+			errCh <- ctx.Err()
+			return
+		}
+		data, _ := json.Marshal(map[string]string{"error": err.Error()})
+		dataCh <- data
+	}()
+	return dataCh, errCh
+}
+
+// ------------------------------------------------------------
+// llama-server processes
+
+func serverKey(model string, embedding bool) string {
+	if embedding {
+		return model + "#embedding"
+	}
+	return model
+}
+
+func isPortFree(port int) bool {
+	ln, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		return false
+	}
+	ln.Close()
+	return true
+}
+
+// loadModel The llama-server process serving the model, launched if it's not running yet
+func (l *LlamaCppProvider) loadModel(ctx context.Context, name string, embedding bool) (*llamaServer, error) {
+	l.mu.Lock()
+	manifest, err := l.loadManifest()
+	if err != nil {
+		l.mu.Unlock()
+		return nil, err
+	}
+	model, m, ok := findModel(manifest, name)
+	if !ok {
+		l.mu.Unlock()
+		return nil, fmt.Errorf("model %s not found, pull it first", name)
+	}
+	if s, ok := l.servers[serverKey(model, embedding)]; ok {
+		l.acquire(s)
+		l.mu.Unlock()
+		return s, l.waitLoaded(ctx, s)
+	}
+	used := make(map[int]bool)
+	for _, s := range l.servers {
+		used[s.port] = true
+	}
+	port := 0
+	for p := llamaCppBasePort; p < llamaCppBasePort+llamaCppMaxServers; p++ {
+		if !used[p] && isPortFree(p) {
+			port = p
+			break
+		}
+	}
+	if port == 0 {
+		l.mu.Unlock()
+		return nil, fmt.Errorf("no free port for llama-server from %d", llamaCppBasePort)
+	}
+
+	args := []string{
+		"--model", filepath.Join(l.modelsPath(), m.File),
+		"--alias", model,
+		"--host", "127.0.0.1",
+		"--port", strconv.Itoa(port),
+		"--jinja",
+	}
+	if embedding {
+		args = append(args, "--embeddings")
+	}
+	cmd := exec.Command(l.execFilePath(), args...)
+	cmd.Dir = l.EngineConfig.ExecPath
+	s := &llamaServer{
+		model:     model,
+		embedding: embedding,
+		port:      port,
+		cmd:       cmd,
+		done:      make(chan struct{}),
+		keepAlive: llamaCppDefaultKeepAlive,
+	}
+	err = startProcess(types.FlavorLlamaCpp, cmd, func(err error) {
+		logger.EngineLogger.Info(fmt.Sprintf("[LlamaCpp] llama-server of %s exited: %v", model, err))
+		l.mu.Lock()
+		if l.servers[serverKey(model, embedding)] == s {
+			delete(l.servers, serverKey(model, embedding))
+		}
+		if s.idleTimer != nil {
+			s.idleTimer.Stop()
+		}
+		l.mu.Unlock()
+		close(s.done)
+	})
+	if err != nil {
+		l.mu.Unlock()
+		logger.EngineLogger.Error("[LlamaCpp] Failed to start llama-server: " + err.Error())
+		return nil, err
+	}
+	l.servers[serverKey(model, embedding)] = s
+	l.acquire(s)
+	l.mu.Unlock()
+	logger.EngineLogger.Info(fmt.Sprintf("[LlamaCpp] llama-server of %s started on port %d, pid %d", model, port, cmd.Process.Pid))
+
+	return s, l.waitLoaded(ctx, s)
+}
+
+// waitLoaded waitReady for loadModel, the server is released if it's not ready. A server which
+// fails to load, or no other request waits for, is stopped and removed, so that it's launched
+// again by the next request rather than left running without keep alive
+func (l *LlamaCppProvider) waitLoaded(ctx context.Context, s *llamaServer) error {
+	err := l.waitReady(ctx, s)
+	if err == nil {
+		return nil
+	}
+	l.mu.Lock()
+	s.active--
+	stop := ctx.Err() == nil || s.active == 0
+	if stop && l.servers[serverKey(s.model, s.embedding)] == s {
+		delete(l.servers, serverKey(s.model, s.embedding))
+	}
+	l.mu.Unlock()
+	if stop {
+		select {
+		case <-s.done:
+		default:
+			logger.EngineLogger.Warn("[LlamaCpp] Stop llama-server of " + s.model + " not loaded: " + err.Error())
+			_ = l.stopServer(s)
+		}
+	}
+	return err
+}
+
+// acquire Keep the server from being stopped for idle while a request is served. Called with l.mu held
+func (l *LlamaCppProvider) acquire(s *llamaServer) {
+	s.active++
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+	s.expiresAt = time.Time{}
+}
+
+// release The request got by loadModel is served. The server is stopped after its keep alive if no
+// other request comes, which is changed to keepAlive if it's set
+func (l *LlamaCppProvider) release(s *llamaServer, keepAlive *time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s.active--
+	if keepAlive != nil {
+		s.keepAlive = *keepAlive
+	}
+	if s.active > 0 || s.keepAlive < 0 {
+		return
+	}
+	s.expiresAt = time.Now().Add(s.keepAlive)
+	var timer *time.Timer
+	timer = time.AfterFunc(s.keepAlive, func() {
+		l.mu.Lock()
+		idle := s.idleTimer == timer && l.servers[serverKey(s.model, s.embedding)] == s
+		if idle {
+			// requests from now on start another server
+			delete(l.servers, serverKey(s.model, s.embedding))
+		}
+		l.mu.Unlock()
+		if idle {
+			logger.EngineLogger.Info("[LlamaCpp] Stop idle llama-server of " + s.model)
+			_ = l.stopServer(s)
+		}
+	})
+	s.idleTimer = timer
+}
+
+// ParseKeepAlive The keep_alive of Ollama requests, a duration like "10m" or seconds as a number.
+// Negative is forever
+func ParseKeepAlive(v any) (time.Duration, error) {
+	switch v := v.(type) {
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	case string:
+		if d, err := time.ParseDuration(v); err == nil {
+			return d, nil
+		}
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return time.Duration(n * float64(time.Second)), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid keep_alive %v", v)
+}
+
+// LoadModel Launch the llama-server of the model, which is kept for req.KeepAlive, until the engine
+// stops by default
+func (l *LlamaCppProvider) LoadModel(ctx context.Context, req *types.LoadModelRequest) error {
+	var keepAlive *time.Duration
+	if req.KeepAlive != "" {
+		d, err := ParseKeepAlive(req.KeepAlive)
+		if err != nil {
+			return err
+		}
+		keepAlive = &d
+	}
+	s, err := l.loadModel(ctx, req.Model, req.Embedding)
+	if err != nil {
+		return err
+	}
+	l.release(s, keepAlive)
+	return nil
+}
+
+// UnloadModel Stop the llama-server processes of the model
+func (l *LlamaCppProvider) UnloadModel(ctx context.Context, model string) error {
+	name, _, ok := l.getModel(model)
+	if !ok {
+		return fmt.Errorf("model %s not found", model)
+	}
+	return l.stopModelServers(name)
+}
+
+func (l *LlamaCppProvider) stopModelServers(name string) error {
+	l.mu.Lock()
+	var servers []*llamaServer
+	for _, s := range l.servers {
+		if s.model == name {
+			servers = append(servers, s)
+		}
+	}
+	l.mu.Unlock()
+	for _, s := range servers {
+		if err := l.stopServer(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListRunningModels The models of the llama-server processes, with the resident memory of the processes
+func (l *LlamaCppProvider) ListRunningModels(ctx context.Context) (*types.ProcessResponse, error) {
+	l.mu.Lock()
+	models := make([]types.ProcessModelResponse, 0, len(l.servers))
+	pids := make([]int, 0, len(l.servers))
+	for _, s := range l.servers {
+		models = append(models, types.ProcessModelResponse{
+			Name:      s.model,
+			Model:     s.model,
+			Details:   types.ModelDetails{Format: "gguf"},
+			ExpiresAt: s.expiresAt,
+		})
+		pids = append(pids, s.cmd.Process.Pid)
+	}
+	l.mu.Unlock()
+
+	for i := range models {
+		if _, m, ok := l.getModel(models[i].Name); ok {
+			models[i].Digest = m.Digest
+		}
+		if p, err := process.NewProcess(int32(pids[i])); err == nil {
+			if mem, err := p.MemoryInfo(); err == nil {
+				models[i].Size = int64(mem.RSS)
+			}
+		}
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Name < models[j].Name
+	})
+	return &types.ProcessResponse{Models: models}, nil
+}
+
+// waitReady Wait until the llama-server has loaded its model
+func (l *LlamaCppProvider) waitReady(ctx context.Context, s *llamaServer) error {
+	healthUrl := fmt.Sprintf("http://127.0.0.1:%d/health", s.port)
+	deadline := time.Now().Add(llamaCppLoadTimeout)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(healthUrl)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		select {
+		case <-s.done:
+			return fmt.Errorf("llama-server of %s exited while loading", s.model)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("llama-server of %s is not ready in %v", s.model, llamaCppLoadTimeout)
+}
+
+func (l *LlamaCppProvider) stopServer(s *llamaServer) error {
+	if err := killProcess(s.cmd.Process); err != nil {
+		logger.EngineLogger.Error("[LlamaCpp] failed to kill llama-server: " + err.Error())
+		return err
+	}
+	<-s.done
+	return nil
+}
+
+// ServeHTTP The engine routes of AOG. /health and GET /v1/models are answered by the engine,
+// the other requests are forwarded to the llama-server of the model in the request body
+func (l *LlamaCppProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/health":
+		if !l.isInstalled() {
+			http.Error(w, `{"error": "llama-server is not installed"}`, http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status": "ok"}`))
+		return
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/models":
+		lr, err := l.ListModels(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data := make([]map[string]any, 0, len(lr.Models))
+		for _, m := range lr.Models {
+			data = append(data, map[string]any{"id": m.Name, "object": "model", "created": m.ModifiedAt.Unix(), "owned_by": "llamacpp"})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": data})
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request struct {
+		Model string `json:"model"`
+		// KeepAlive as Ollama, how long the llama-server is kept after the request
+		KeepAlive any `json:"keep_alive"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil || request.Model == "" {
+		http.Error(w, `{"error": "model is required"}`, http.StatusBadRequest)
+		return
+	}
+	var keepAlive *time.Duration
+	if request.KeepAlive != nil {
+		d, err := ParseKeepAlive(request.KeepAlive)
+		if err != nil {
+			errBody, _ := json.Marshal(map[string]string{"error": err.Error()})
+			http.Error(w, string(errBody), http.StatusBadRequest)
+			return
+		}
+		keepAlive = &d
+	}
+	s, err := l.loadModel(r.Context(), request.Model, strings.HasSuffix(r.URL.Path, "/embeddings"))
+	if err != nil {
+		logger.EngineLogger.Error("[LlamaCpp] Failed to load model: " + err.Error())
+		errBody, _ := json.Marshal(map[string]string{"error": err.Error()})
+		http.Error(w, string(errBody), http.StatusServiceUnavailable)
+		return
+	}
+	defer l.release(s, keepAlive)
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(s.port)})
+	// stream responses are sent as they arrive
+	proxy.FlushInterval = -1
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	proxy.ServeHTTP(w, r)
+}
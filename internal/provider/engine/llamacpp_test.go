@@ -0,0 +1,283 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/types"
+)
+
+func TestMain(m *testing.M) {
+	logDir, err := os.MkdirTemp("", "aog-engine-test")
+	if err != nil {
+		panic(err)
+	}
+	logger.InitLogger(logger.LogConfig{LogLevel: "error", LogPath: logDir})
+	code := m.Run()
+	os.RemoveAll(logDir)
+	os.Exit(code)
+}
+
+func newTestLlamaCpp(t *testing.T) *LlamaCppProvider {
+	t.Helper()
+	l := NewLlamaCppProvider(&types.EngineRecommendConfig{EnginePath: t.TempDir()})
+	if err := os.MkdirAll(l.modelsPath(), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// fakeHFMirror A Hugging Face mirror with one GGUF file in the repo test/Tiny-GGUF. The Range
+// header of each download is sent to ranges, and honored if resume is true
+type fakeHFMirror struct {
+	*httptest.Server
+	data   string
+	sha256 string
+	resume bool
+	ranges []string
+}
+
+func newFakeHFMirror(t *testing.T, data string) *fakeHFMirror {
+	t.Helper()
+	m := &fakeHFMirror{data: data, sha256: fmt.Sprintf("%x", sha256.Sum256([]byte(data))), resume: true}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/models/test/Tiny-GGUF":
+			json.NewEncoder(w).Encode(map[string]any{"siblings": []any{
+				map[string]any{"rfilename": "README.md", "size": 10},
+				map[string]any{"rfilename": "tiny-mmproj-Q4_K_M.gguf", "size": 10},
+				map[string]any{"rfilename": "tiny-Q4_K_M.gguf", "size": len(m.data),
+					"lfs": map[string]any{"sha256": m.sha256, "size": len(m.data)}},
+			}})
+		case "/test/Tiny-GGUF/resolve/main/tiny-Q4_K_M.gguf":
+			rng := r.Header.Get("Range")
+			m.ranges = append(m.ranges, rng)
+			if rng == "" || !m.resume {
+				w.Write([]byte(m.data))
+				return
+			}
+			offset, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			if err != nil || offset >= len(m.data) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(m.data)-1, len(m.data)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(m.data[offset:]))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Setenv("AOG_LLAMACPP_MIRROR", m.URL+"/")
+	t.Cleanup(m.Close)
+	return m
+}
+
+func TestLlamaCppManifest(t *testing.T) {
+	l := newTestLlamaCpp(t)
+	if list, err := l.ListModels(context.Background()); err != nil || len(list.Models) != 0 {
+		t.Fatalf("got %v, %v, want no models without a manifest", list, err)
+	}
+
+	for _, name := range []string{"test/B-GGUF:Q8_0", "test/A-GGUF:Q4_K_M"} {
+		file := strings.NewReplacer("/", "--", ":", "-").Replace(name) + ".gguf"
+		if err := os.WriteFile(filepath.Join(l.modelsPath(), file), []byte("GGUF"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		err := l.updateManifest(func(manifest map[string]llamaCppModel) bool {
+			manifest[name] = llamaCppModel{File: file, Size: 4, Digest: "sha256:" + name}
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := l.ListModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Models) != 2 || list.Models[0].Name != "test/A-GGUF:Q4_K_M" || list.Models[1].Name != "test/B-GGUF:Q8_0" {
+		t.Fatalf("models %+v, want the two in the manifest sorted by name", list.Models)
+	}
+	// AOG keeps the names in lower case
+	name, m, ok := l.getModel("test/a-gguf:q4_k_m")
+	if !ok || name != "test/A-GGUF:Q4_K_M" || m.Digest != "sha256:test/A-GGUF:Q4_K_M" {
+		t.Errorf("got %s %+v %v, want the model found case-insensitively", name, m, ok)
+	}
+
+	if err := l.DeleteModel(context.Background(), &types.DeleteRequest{Model: "test/a-gguf:q4_k_m"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := l.getModel("test/A-GGUF:Q4_K_M"); ok {
+		t.Errorf("deleted model is still in the manifest")
+	}
+	if _, err := os.Stat(filepath.Join(l.modelsPath(), m.File)); !os.IsNotExist(err) {
+		t.Errorf("file of the deleted model is kept: %v", err)
+	}
+	if _, _, ok := l.getModel("test/B-GGUF:Q8_0"); !ok {
+		t.Errorf("other model is deleted from the manifest")
+	}
+	if err := l.DeleteModel(context.Background(), &types.DeleteRequest{Model: "test/A-GGUF:Q4_K_M"}); err == nil {
+		t.Errorf("deleting a model not in the manifest doesn't fail")
+	}
+}
+
+func TestLlamaCppPull(t *testing.T) {
+	data := strings.Repeat("GGUF weights ", 1000)
+	localFile := "test--Tiny-GGUF--tiny-Q4_K_M.gguf"
+	cases := []struct {
+		name string
+		// part the part left by an interrupted pull, none if empty
+		part   string
+		resume bool
+		// wantRange the Range header of the download
+		wantRange string
+		wantErr   string
+	}{
+		{name: "new"},
+		{name: "resumed", part: data[:5000], resume: true, wantRange: "bytes=5000-"},
+		{name: "not resumed by the mirror", part: data[:5000], wantRange: "bytes=5000-"},
+		{name: "part of another file", part: "not the weights", resume: true, wantRange: "bytes=15-", wantErr: "digest mismatch"},
+		{name: "part longer than the file", part: data + "more", resume: true, wantRange: fmt.Sprintf("bytes=%d-", len(data)+4), wantErr: "partial file"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mirror := newFakeHFMirror(t, data)
+			mirror.resume = c.resume
+			l := newTestLlamaCpp(t)
+			partPath := filepath.Join(l.modelsPath(), localFile+".part")
+			if c.part != "" {
+				if err := os.WriteFile(partPath, []byte(c.part), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				wantSize := int64(max(len(data)-len(c.part), 0))
+				if size, err := l.PullSize(context.Background(), "test/Tiny-GGUF"); err != nil || size != wantSize {
+					t.Errorf("pull size %d, %v, want %d", size, err, wantSize)
+				}
+			}
+
+			var statuses []string
+			_, err := l.PullModel(context.Background(), &types.PullModelRequest{Model: "test/Tiny-GGUF"}, func(p types.ProgressResponse) error {
+				statuses = append(statuses, p.Status)
+				return nil
+			})
+			if len(mirror.ranges) != 1 || mirror.ranges[0] != c.wantRange {
+				t.Errorf("download ranges %q, want %q", mirror.ranges, c.wantRange)
+			}
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("got error %v, want %q", err, c.wantErr)
+				}
+				if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+					t.Errorf("invalid part is kept: %v", err)
+				}
+				if _, _, ok := l.getModel("test/Tiny-GGUF"); ok {
+					t.Errorf("model of the failed pull is in the manifest")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if statuses[0] != "pulling manifest" || statuses[len(statuses)-1] != "success" {
+				t.Errorf("progress %q", statuses)
+			}
+			got, err := os.ReadFile(filepath.Join(l.modelsPath(), localFile))
+			if err != nil || string(got) != data {
+				t.Fatalf("model file is not the one in the repo: %v", err)
+			}
+			if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+				t.Errorf("part is kept after the pull: %v", err)
+			}
+			_, m, ok := l.getModel("test/Tiny-GGUF")
+			if !ok || m.File != localFile || m.Size != int64(len(data)) || m.Digest != "sha256:"+mirror.sha256 {
+				t.Errorf("manifest entry %+v", m)
+			}
+
+			// pulled already, not downloaded again
+			if _, err := l.PullModel(context.Background(), &types.PullModelRequest{Model: "test/Tiny-GGUF"}, nil); err != nil {
+				t.Fatal(err)
+			}
+			if len(mirror.ranges) != 1 {
+				t.Errorf("model pulled already is downloaded again")
+			}
+			if size, err := l.PullSize(context.Background(), "test/Tiny-GGUF"); err != nil || size != 0 {
+				t.Errorf("pull size %d, %v of the model pulled already", size, err)
+			}
+		})
+	}
+}
+
+func TestResolveModelFile(t *testing.T) {
+	newFakeHFMirror(t, "GGUF")
+	cases := []struct {
+		name     string
+		wantFile string
+		wantErr  string
+	}{
+		{name: "test/Tiny-GGUF", wantFile: "tiny-Q4_K_M.gguf"},
+		{name: "test/Tiny-GGUF:q4_k_m", wantFile: "tiny-Q4_K_M.gguf"},
+		{name: "test/Tiny-GGUF:Q8_0", wantErr: "no Q8_0 GGUF file in test/Tiny-GGUF, available: tiny-Q4_K_M.gguf"},
+		{name: "test/Tiny-GGUF/tiny-Q4_K_M.gguf", wantFile: "tiny-Q4_K_M.gguf"},
+		// not listed, downloaded without the digest
+		{name: "test/Other-GGUF/other.gguf", wantFile: "other.gguf"},
+		{name: "tiny.gguf", wantErr: "invalid model name"},
+		{name: "Tiny-GGUF", wantErr: "invalid model name"},
+	}
+	for _, c := range cases {
+		remote, err := resolveModelFile(context.Background(), c.name)
+		if c.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("%s: got error %v, want %q", c.name, err, c.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if remote.File != c.wantFile {
+			t.Errorf("%s: got file %s, want %s", c.name, remote.File, c.wantFile)
+		}
+		if c.wantFile == "tiny-Q4_K_M.gguf" && (remote.SHA256 == "" || remote.Size != 4) {
+			t.Errorf("%s: digest and size of the repo are not taken: %+v", c.name, remote)
+		}
+	}
+}
+
+func TestParseKeepAlive(t *testing.T) {
+	cases := []struct {
+		v       any
+		want    time.Duration
+		wantErr bool
+	}{
+		{v: "10m", want: 10 * time.Minute},
+		{v: "-1", want: -time.Second},
+		{v: "90", want: 90 * time.Second},
+		{v: "1.5", want: 1500 * time.Millisecond},
+		{v: float64(0), want: 0},
+		{v: float64(-1), want: -time.Second},
+		{v: "forever", wantErr: true},
+		{v: true, wantErr: true},
+		{v: nil, wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := ParseKeepAlive(c.v)
+		if (err != nil) != c.wantErr || got != c.want {
+			t.Errorf("ParseKeepAlive(%#v) = %v, %v, want %v", c.v, got, err, c.want)
+		}
+	}
+}
@@ -2,7 +2,10 @@ package engine
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -11,6 +14,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ligjn/aog/internal/client"
@@ -94,7 +98,7 @@ func (o *OllamaProvider) StartEngine(mode string) error {
 
 	if mode == types.EngineStartModeDaemon {
 		cmd := exec.Command(execFile, "serve")
-		err := cmd.Start()
+		err := startProcess(types.FlavorOllama, cmd, nil)
 		if err != nil {
 			logger.EngineLogger.Error("[Ollama] failed to start ollama: " + err.Error())
 			return fmt.Errorf("failed to start ollama: %v", err)
@@ -111,10 +115,6 @@ func (o *OllamaProvider) StartEngine(mode string) error {
 			logger.EngineLogger.Error("[Ollama] failed to write pid file: " + err.Error())
 			return fmt.Errorf("failed to write pid file: %v", err)
 		}
-
-		go func() {
-			cmd.Wait()
-		}()
 	} else {
 		if utils.IpexOllamaSupportGPUStatus() {
 			cmd := exec.Command(o.EngineConfig.ExecPath + "/ollama-serve.bat")
@@ -162,7 +162,7 @@ func (o *OllamaProvider) StopEngine() error {
 		return fmt.Errorf("failed to find process: %v", err)
 	}
 
-	if err := process.Kill(); err != nil {
+	if err := killProcess(process); err != nil {
 		logger.EngineLogger.Error("[Ollama] failed to kill process: " + err.Error())
 		return fmt.Errorf("failed to kill process: %v", err)
 	}
@@ -362,10 +362,31 @@ func (o *OllamaProvider) PullModel(ctx context.Context, req *types.PullModelRequ
 
 	c := o.GetDefaultClient()
 	ctx, cancel := context.WithCancel(ctx)
-	modelArray := append(client.ModelClientMap[req.Model], cancel)
-	client.ModelClientMap[req.Model] = modelArray
+	defer cancel()
+	remove := client.ModelClientMap.Add(req.Model, cancel)
+	defer remove()
 
 	var resp types.ProgressResponse
+	if fn != nil {
+		// the progress is streamed to fn
+		stream := true
+		streamReq := *req
+		streamReq.Stream = &stream
+		err := c.Stream(ctx, http.MethodPost, "/api/pull", &streamReq, func(bts []byte) error {
+			var p types.ProgressResponse
+			if err := json.Unmarshal(bts, &p); err != nil {
+				return err
+			}
+			resp = p
+			return fn(p)
+		})
+		if err != nil {
+			logger.EngineLogger.Error("[Ollama] Pull model failed : " + err.Error())
+			return &resp, err
+		}
+		logger.EngineLogger.Info("[Ollama] Pull model success: " + req.Name)
+		return &resp, nil
+	}
 	if err := c.Do(ctx, http.MethodPost, "/api/pull", req, &resp); err != nil {
 		logger.EngineLogger.Error("[Ollama] Pull model failed : " + err.Error())
 		return &resp, err
@@ -380,10 +401,26 @@ func (o *OllamaProvider) PullModelStream(ctx context.Context, req *types.PullMod
 
 	c := o.GetDefaultClient()
 	ctx, cancel := context.WithCancel(ctx)
-	modelArray := append(client.ModelClientMap[req.Model], cancel)
-	client.ModelClientMap[req.Model] = modelArray
-	dataCh, errCh := c.StreamResponse(ctx, http.MethodPost, "/api/pull", req)
-	logger.EngineLogger.Info("[Ollama] Pull model success: " + req.Name + " , mode: stream")
+	remove := client.ModelClientMap.Add(req.Model, cancel)
+	pullDataCh, pullErrCh := c.StreamResponse(ctx, http.MethodPost, "/api/pull", req)
+
+	// relayed, so that the pull is removed from the cancel map once it returns
+	dataCh := make(chan []byte)
+	errCh := make(chan error, 1)
+	go func() {
+		defer remove()
+		defer cancel()
+		defer close(dataCh)
+		defer close(errCh)
+		for data := range pullDataCh {
+			dataCh <- data
+		}
+		if err, ok := <-pullErrCh; ok && err != nil {
+			errCh <- err
+			return
+		}
+		logger.EngineLogger.Info("[Ollama] Pull model success: " + req.Name + " , mode: stream")
+	}()
 
 	return dataCh, errCh
 }
@@ -411,3 +448,324 @@ func (o *OllamaProvider) ListModels(ctx context.Context) (*types.ListResponse, e
 
 	return &lr, nil
 }
+
+func (o *OllamaProvider) ShowModel(ctx context.Context, req *types.ShowRequest) (*types.ShowResponse, error) {
+	c := o.GetDefaultClient()
+	var resp types.ShowResponse
+	if err := c.Do(ctx, http.MethodPost, "/api/show", req, &resp); err != nil {
+		logger.EngineLogger.Error("[Ollama] Show model failed :" + err.Error())
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (o *OllamaProvider) ListRunningModels(ctx context.Context) (*types.ProcessResponse, error) {
+	c := o.GetDefaultClient()
+	var pr types.ProcessResponse
+	if err := c.Do(ctx, http.MethodGet, "/api/ps", nil, &pr); err != nil {
+		logger.EngineLogger.Error("[Ollama] Get running model list failed :" + err.Error())
+		return nil, err
+	}
+
+	return &pr, nil
+}
+
+// LoadModel Ollama loads the model for a request without prompt or input, and keeps it for keep_alive
+func (o *OllamaProvider) LoadModel(ctx context.Context, req *types.LoadModelRequest) error {
+	logger.EngineLogger.Info("[Ollama] Load model: "+req.Model, "keep_alive", req.KeepAlive)
+	body := map[string]any{"model": req.Model, "stream": false}
+	if req.KeepAlive != "" {
+		body["keep_alive"] = req.KeepAlive
+	}
+	path := "/api/generate"
+	if req.Embedding {
+		path = "/api/embed"
+		body["input"] = []string{}
+	}
+	if err := o.GetDefaultClient().Do(ctx, http.MethodPost, path, body, nil); err != nil {
+		logger.EngineLogger.Error("[Ollama] Load model failed :" + err.Error())
+		return err
+	}
+	return nil
+}
+
+// UnloadModel Load the model with keep_alive 0. Embedding models can't be asked by /api/generate
+// so /api/embed is tried for them
+func (o *OllamaProvider) UnloadModel(ctx context.Context, model string) error {
+	logger.EngineLogger.Info("[Ollama] Unload model: " + model)
+	c := o.GetDefaultClient()
+	body := map[string]any{"model": model, "stream": false, "keep_alive": 0}
+	err := c.Do(ctx, http.MethodPost, "/api/generate", body, nil)
+	if err != nil {
+		body["input"] = []string{}
+		if embedErr := c.Do(ctx, http.MethodPost, "/api/embed", body, nil); embedErr == nil {
+			return nil
+		}
+		logger.EngineLogger.Error("[Ollama] Unload model failed :" + err.Error())
+		return err
+	}
+	return nil
+}
+
+// ModelInfo The size, digest and details of the model listed by Ollama, and its context length
+// in the model info of /api/show
+func (o *OllamaProvider) ModelInfo(ctx context.Context, model string) (*types.EngineModelInfo, error) {
+	lr, err := o.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var info *types.EngineModelInfo
+	for _, m := range lr.Models {
+		// "qwen2" is "qwen2:latest" for Ollama
+		if manifestPath(strings.ToLower(m.Name)) == manifestPath(strings.ToLower(model)) {
+			info = &types.EngineModelInfo{
+				Size:       m.Size,
+				Digest:     m.Digest,
+				Details:    m.Details,
+				ModifiedAt: m.ModifiedAt,
+			}
+			break
+		}
+	}
+	if info == nil {
+		return nil, fmt.Errorf("model %s not found", model)
+	}
+
+	resp, err := o.ShowModel(ctx, &types.ShowRequest{Model: model})
+	if err != nil {
+		// the rest of the info is still worth returning
+		return info, nil
+	}
+	if arch, ok := resp.ModelInfo["general.architecture"].(string); ok {
+		if n, ok := resp.ModelInfo[arch+".context_length"].(float64); ok {
+			info.ContextLength = int64(n)
+		}
+	}
+	return info, nil
+}
+
+// ImportModel Create the model from a local GGUF file. The file is pushed as a blob and referred to
+// by the Modelfile, or by the files of the request for the Ollama which no longer takes Modelfiles
+func (o *OllamaProvider) ImportModel(ctx context.Context, req *types.ImportModelRequest) error {
+	logger.EngineLogger.Info("[Ollama] Import model: " + req.Model + " from " + req.Path)
+
+	digest, err := fileDigest(req.Path)
+	if err != nil {
+		logger.EngineLogger.Error("[Ollama] Failed to read model file: " + err.Error())
+		return err
+	}
+
+	c := o.GetDefaultClient()
+	blobPath := "/api/blobs/" + digest
+	if err := c.Do(ctx, http.MethodHead, blobPath, nil, nil); err != nil {
+		f, err := os.Open(req.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := c.Do(ctx, http.MethodPost, blobPath, f, nil); err != nil {
+			logger.EngineLogger.Error("[Ollama] Push model blob failed: " + err.Error())
+			return err
+		}
+	}
+
+	stream := false
+	createReq := &types.CreateRequest{
+		Model:     req.Model,
+		Modelfile: "FROM @" + digest,
+		Stream:    &stream,
+	}
+	if err := c.Do(ctx, http.MethodPost, "/api/create", createReq, nil); err != nil {
+		logger.EngineLogger.Warn("[Ollama] Create model by Modelfile failed, try by files: " + err.Error())
+		createReq.Modelfile = ""
+		createReq.Files = map[string]string{filepath.Base(req.Path): digest}
+		if err := c.Do(ctx, http.MethodPost, "/api/create", createReq, nil); err != nil {
+			logger.EngineLogger.Error("[Ollama] Create model failed: " + err.Error())
+			return err
+		}
+	}
+	logger.EngineLogger.Info("[Ollama] Import model success: " + req.Model)
+
+	return nil
+}
+
+// fileDigest The digest of the file in the form of sha256:<hex>
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+// modelsDir Where Ollama stores the models, OLLAMA_MODELS or ~/.ollama/models
+func (o *OllamaProvider) modelsDir() (string, error) {
+	if dir := os.Getenv("OLLAMA_MODELS"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ollama", "models"), nil
+}
+
+// parseModelName Split the model name into [host/][namespace/]model[:tag], with the defaults of Ollama
+func parseModelName(name string) (host, namespace, model, tag string) {
+	host, namespace, tag = "registry.ollama.ai", "library", "latest"
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	parts := strings.Split(name, "/")
+	switch len(parts) {
+	case 2:
+		namespace = parts[0]
+	case 3:
+		host, namespace = parts[0], parts[1]
+	}
+	return host, namespace, parts[len(parts)-1], tag
+}
+
+// manifestPath The manifest of the model under the models dir, manifests/<host>/<namespace>/<model>/<tag>
+func manifestPath(name string) string {
+	host, namespace, model, tag := parseModelName(name)
+	return filepath.Join("manifests", host, namespace, model, tag)
+}
+
+// ModelFiles The manifest of the model and the blobs of its layers and config
+func (o *OllamaProvider) ModelFiles(ctx context.Context, model string) (map[string]string, error) {
+	modelsDir, err := o.modelsDir()
+	if err != nil {
+		return nil, err
+	}
+	manifest := manifestPath(model)
+	data, err := os.ReadFile(filepath.Join(modelsDir, manifest))
+	if err != nil {
+		logger.EngineLogger.Error("[Ollama] Failed to read model manifest: " + err.Error())
+		return nil, fmt.Errorf("manifest of model %s not found: %v", model, err)
+	}
+	var m struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest of model %s: %v", model, err)
+	}
+
+	files := map[string]string{
+		filepath.ToSlash(manifest): filepath.Join(modelsDir, manifest),
+	}
+	digests := []string{m.Config.Digest}
+	for _, l := range m.Layers {
+		digests = append(digests, l.Digest)
+	}
+	for _, digest := range digests {
+		if digest == "" {
+			continue
+		}
+		blob := "blobs/" + strings.Replace(digest, ":", "-", 1)
+		files[blob] = filepath.Join(modelsDir, filepath.FromSlash(blob))
+	}
+	return files, nil
+}
+
+// InstallModelFiles Copy the blobs and the manifest into the models dir. Ollama reads the manifests
+// from the disk, so the model is listed without a restart
+func (o *OllamaProvider) InstallModelFiles(ctx context.Context, req *types.ImportModelRequest) error {
+	modelsDir, err := o.modelsDir()
+	if err != nil {
+		return err
+	}
+	// the manifest goes last, so that the model is not seen before its blobs are in place
+	blobs, err := os.ReadDir(filepath.Join(req.Path, "blobs"))
+	if err != nil {
+		return err
+	}
+	for _, b := range blobs {
+		dst := filepath.Join(modelsDir, "blobs", b.Name())
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if err := copyFile(filepath.Join(req.Path, "blobs", b.Name()), dst); err != nil {
+			logger.EngineLogger.Error("[Ollama] Failed to install model blob: " + err.Error())
+			return err
+		}
+	}
+	manifest := manifestPath(req.Model)
+	if err := copyFile(filepath.Join(req.Path, manifest), filepath.Join(modelsDir, manifest)); err != nil {
+		logger.EngineLogger.Error("[Ollama] Failed to install model manifest: " + err.Error())
+		return err
+	}
+	logger.EngineLogger.Info("[Ollama] Install model files success: " + req.Model)
+	return nil
+}
+
+func (o *OllamaProvider) ModelStoragePath() string {
+	modelsDir, err := o.modelsDir()
+	if err != nil {
+		return ""
+	}
+	return modelsDir
+}
+
+// modelInfoClient The client getting the metadata of the models from the registries and hubs, with a
+// timeout so that a hub not answering doesn't hold the pull. Not for the files of the models
+var modelInfoClient = &http.Client{Timeout: 30 * time.Second}
+
+// PullSize The size of the layers and config of the model in the manifest of the registry, less
+// the blobs Ollama has already, which are shared by the models
+func (o *OllamaProvider) PullSize(ctx context.Context, model string) (int64, error) {
+	modelsDir, err := o.modelsDir()
+	if err != nil {
+		return 0, err
+	}
+	host, namespace, name, tag := parseModelName(model)
+	manifestUrl := fmt.Sprintf("https://%s/v2/%s/%s/manifests/%s", host, namespace, name, tag)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestUrl, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	resp, err := modelInfoClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get model manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to get manifest of model %s: HTTP status %s", model, resp.Status)
+	}
+	type blob struct {
+		Digest string `json:"digest"`
+		Size   int64  `json:"size"`
+	}
+	var m struct {
+		Config blob   `json:"config"`
+		Layers []blob `json:"layers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return 0, fmt.Errorf("invalid manifest of model %s: %v", model, err)
+	}
+
+	var size int64
+	for _, b := range append(m.Layers, m.Config) {
+		if b.Digest == "" {
+			continue
+		}
+		blobPath := filepath.Join(modelsDir, "blobs", strings.Replace(b.Digest, ":", "-", 1))
+		if _, err := os.Stat(blobPath); err == nil {
+			continue
+		}
+		size += b.Size
+	}
+	return size, nil
+}
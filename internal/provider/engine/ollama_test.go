@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ligjn/aog/internal/types"
+)
+
+func TestOllamaImportModel(t *testing.T) {
+	weights := "GGUF weights"
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(weights)))
+	path := filepath.Join(t.TempDir(), "tiny.gguf")
+	if err := os.WriteFile(path, []byte(weights), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		// blobExists the engine has the blob already, it isn't pushed again
+		blobExists bool
+		// modelfile the engine takes Modelfiles, only the files field otherwise
+		modelfile   bool
+		wantCreates int
+	}{
+		{name: "by Modelfile", modelfile: true, wantCreates: 1},
+		{name: "by files", wantCreates: 2},
+		{name: "blob exists", blobExists: true, modelfile: true, wantCreates: 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var pushed string
+			var creates []types.CreateRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/api/blobs/"+digest && r.Method == http.MethodHead:
+					if !c.blobExists {
+						w.WriteHeader(http.StatusNotFound)
+					}
+				case r.URL.Path == "/api/blobs/"+digest && r.Method == http.MethodPost:
+					data, _ := io.ReadAll(r.Body)
+					pushed = string(data)
+					w.WriteHeader(http.StatusCreated)
+				case r.URL.Path == "/api/create":
+					var req types.CreateRequest
+					json.NewDecoder(r.Body).Decode(&req)
+					creates = append(creates, req)
+					if req.Modelfile != "" && !c.modelfile {
+						w.WriteHeader(http.StatusBadRequest)
+						w.Write([]byte(`{"error": "neither 'from' or 'files' was specified"}`))
+						return
+					}
+					w.Write([]byte(`{"status": "success"}`))
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+			u, _ := url.Parse(server.URL)
+			o := NewOllamaProvider(&types.EngineRecommendConfig{Host: u.Host, Scheme: "http"})
+
+			if err := o.ImportModel(context.Background(), &types.ImportModelRequest{Model: "tiny:latest", Path: path}); err != nil {
+				t.Fatal(err)
+			}
+			wantPushed := weights
+			if c.blobExists {
+				wantPushed = ""
+			}
+			if pushed != wantPushed {
+				t.Errorf("pushed %q, want %q", pushed, wantPushed)
+			}
+			if len(creates) != c.wantCreates {
+				t.Fatalf("got %d creates, want %d", len(creates), c.wantCreates)
+			}
+			if creates[0].Model != "tiny:latest" || creates[0].Modelfile != "FROM @"+digest {
+				t.Errorf("create by Modelfile %+v", creates[0])
+			}
+			if c.wantCreates == 2 {
+				if last := creates[1]; last.Modelfile != "" || last.Files["tiny.gguf"] != digest {
+					t.Errorf("create by files %+v", last)
+				}
+			}
+		})
+	}
+
+	t.Run("file not found", func(t *testing.T) {
+		o := NewOllamaProvider(&types.EngineRecommendConfig{Host: "127.0.0.1:1"})
+		if err := o.ImportModel(context.Background(), &types.ImportModelRequest{Model: "tiny", Path: path + ".missing"}); err == nil {
+			t.Errorf("model of a missing file is imported")
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ligjn/aog/internal/client"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/types"
+)
+
+// OpenAICompatProvider local OpenAI-compatible servers, e.g. llama.cpp server and vLLM,
+// which are installed and started outside AOG. The models are loaded by the server itself
+// when it is launched, so AOG can only list them but can't pull or delete them.
+type OpenAICompatProvider struct {
+	Name         string
+	EngineConfig *types.EngineRecommendConfig
+}
+
+func NewOpenAICompatProvider(name string, config *types.EngineRecommendConfig) *OpenAICompatProvider {
+	p := &OpenAICompatProvider{Name: name, EngineConfig: config}
+	if config == nil {
+		p.EngineConfig = p.GetConfig()
+	}
+	return p
+}
+
+func (o *OpenAICompatProvider) GetDefaultClient() *client.Client {
+	scheme := "http"
+	if o.EngineConfig.Scheme == "https" {
+		scheme = "https"
+	}
+
+	return client.NewClient(&url.URL{
+		Scheme: scheme,
+		Host:   o.EngineConfig.Host,
+	}, http.DefaultClient)
+}
+
+func (o *OpenAICompatProvider) GetConfig() *types.EngineRecommendConfig {
+	if o.EngineConfig != nil {
+		return o.EngineConfig
+	}
+
+	// default ports of llama-server and vllm serve
+	host := "127.0.0.1:8080"
+	if o.Name == types.FlavorVLLM {
+		host = "127.0.0.1:8000"
+	}
+	return &types.EngineRecommendConfig{
+		Host:   host,
+		Origin: "127.0.0.1",
+		Scheme: "http",
+	}
+}
+
+func (o *OpenAICompatProvider) notManaged(action string) error {
+	return fmt.Errorf("%s is managed outside AOG, %s is not supported", o.Name, action)
+}
+
+func (o *OpenAICompatProvider) InstallEngine() error {
+	return o.notManaged("install engine")
+}
+
+func (o *OpenAICompatProvider) StartEngine(mode string) error {
+	return o.notManaged("start engine")
+}
+
+func (o *OpenAICompatProvider) StopEngine() error {
+	return o.notManaged("stop engine")
+}
+
+func (o *OpenAICompatProvider) InitEnv() error {
+	return nil
+}
+
+func (o *OpenAICompatProvider) HealthCheck() error {
+	c := o.GetDefaultClient()
+	if err := c.Do(context.Background(), http.MethodGet, "/health", nil, nil); err != nil {
+		logger.EngineLogger.Error(fmt.Sprintf("[%s] Health check failed: %s", o.Name, err.Error()))
+		return err
+	}
+	logger.EngineLogger.Info(fmt.Sprintf("[%s] server health", o.Name))
+
+	return nil
+}
+
+func (o *OpenAICompatProvider) GetVersion(ctx context.Context, resp *types.EngineVersionResponse) (*types.EngineVersionResponse, error) {
+	c := o.GetDefaultClient()
+	if o.Name == types.FlavorVLLM {
+		if err := c.Do(ctx, http.MethodGet, "/version", nil, resp); err != nil {
+			logger.EngineLogger.Error(fmt.Sprintf("[%s] Get version failed: %s", o.Name, err.Error()))
+			return nil, err
+		}
+		return resp, nil
+	}
+
+	// llama.cpp server reports its build in the server properties
+	var props struct {
+		BuildInfo string `json:"build_info"`
+	}
+	if err := c.Do(ctx, http.MethodGet, "/props", nil, &props); err != nil {
+		logger.EngineLogger.Error(fmt.Sprintf("[%s] Get version failed: %s", o.Name, err.Error()))
+		return nil, err
+	}
+	resp.Version = props.BuildInfo
+	return resp, nil
+}
+
+func (o *OpenAICompatProvider) PullModel(ctx context.Context, req *types.PullModelRequest, fn types.PullProgressFunc) (*types.ProgressResponse, error) {
+	return nil, o.notManaged("pull model")
+}
+
+func (o *OpenAICompatProvider) PullModelStream(ctx context.Context, req *types.PullModelRequest) (chan []byte, chan error) {
+	dataCh := make(chan []byte)
+	errCh := make(chan error, 1)
+	close(dataCh)
+	errCh <- o.notManaged("pull model")
+	close(errCh)
+	return dataCh, errCh
+}
+
+// DeleteModel the model files belong to the server, only the records in AOG are removed
+func (o *OpenAICompatProvider) DeleteModel(ctx context.Context, req *types.DeleteRequest) error {
+	logger.EngineLogger.Info(fmt.Sprintf("[%s] Skip deleting model %s served outside AOG", o.Name, req.Model))
+	return nil
+}
+
+func (o *OpenAICompatProvider) ListModels(ctx context.Context) (*types.ListResponse, error) {
+	c := o.GetDefaultClient()
+	var lr struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Created int64  `json:"created"`
+		} `json:"data"`
+	}
+	if err := c.Do(ctx, http.MethodGet, "/v1/models", nil, &lr); err != nil {
+		logger.EngineLogger.Error(fmt.Sprintf("[%s] Get model list failed: %s", o.Name, err.Error()))
+		return nil, err
+	}
+
+	models := make([]types.ListModelResponse, 0, len(lr.Data))
+	for _, m := range lr.Data {
+		models = append(models, types.ListModelResponse{
+			Name:       m.ID,
+			Model:      m.ID,
+			ModifiedAt: time.Unix(m.Created, 0),
+		})
+	}
+	return &types.ListResponse{Models: models}, nil
+}
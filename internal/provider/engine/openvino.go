@@ -3,28 +3,78 @@ package engine
 import (
 	"bytes"
 	"context"
+	_ "embed"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ligjn/aog/config"
 	"github.com/ligjn/aog/internal/client"
 	"github.com/ligjn/aog/internal/logger"
 	"github.com/ligjn/aog/internal/types"
 	"github.com/ligjn/aog/internal/utils"
 )
 
+// imageToImageScript the python node of the image-to-image graph, which is not in scripts.zip
+//
+//go:embed scripts/image-to-image/model.py
+var imageToImageScript []byte
+
+const (
+	// openvinoDefaultHFEndpoint the Hugging Face mirror the python nodes of ovms download from,
+	// changed by AOG_OVMS_HF_ENDPOINT
+	openvinoDefaultHFEndpoint = "https://hf-mirror.com"
+	// openvinoDefaultPipIndex the index the python requirements of ovms are installed from,
+	// changed by AOG_OVMS_PIP_INDEX_URL
+	openvinoDefaultPipIndex = "https://mirrors.aliyun.com/pypi/simple/"
+)
+
+// openvinoModelNamePattern ModelScope names like OpenVINO/stable-diffusion-v1-5-fp16-ov, which are
+// used in the paths of the models dir and in the scripts run to pull them
+var openvinoModelNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)*$`)
+
 type OpenvinoProvider struct {
 	EngineConfig *types.EngineRecommendConfig
 }
 
+func openvinoHFEndpoint() string {
+	if endpoint := config.Var("AOG_OVMS_HF_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	return openvinoDefaultHFEndpoint
+}
+
+func openvinoPipIndex() string {
+	if index := config.Var("AOG_OVMS_PIP_INDEX_URL"); index != "" {
+		return index
+	}
+	return openvinoDefaultPipIndex
+}
+
+// modelDir The dir of the model in the models dir. The name must not get out of it, nor have
+// characters special to the shell
+func (o *OpenvinoProvider) modelDir(model string) (string, error) {
+	if !openvinoModelNamePattern.MatchString(model) {
+		return "", fmt.Errorf("invalid model name %q", model)
+	}
+	for _, part := range strings.Split(model, "/") {
+		if part == "." || part == ".." {
+			return "", fmt.Errorf("invalid model name %q", model)
+		}
+	}
+	return filepath.Join(o.EngineConfig.EnginePath, "models", model), nil
+}
+
 func NewOpenvinoProvider(config *types.EngineRecommendConfig) *OpenvinoProvider {
 	if config != nil {
 		return &OpenvinoProvider{
@@ -65,11 +115,6 @@ func (o *OpenvinoProvider) GetDefaultClient() *client.GRPCClient {
 
 func (o *OpenvinoProvider) StartEngine(mode string) error {
 	logger.EngineLogger.Info("[OpenVINO] Start engine mode: " + mode)
-	// 当前暂仅支持windows
-	if runtime.GOOS != "windows" {
-		logger.EngineLogger.Error("[OpenVINO] Unsupported OS: " + runtime.GOOS)
-		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
-	}
 	rootPath, err := utils.GetAOGDataDir()
 	if err != nil {
 		logger.EngineLogger.Error("[OpenVINO] Get AOG data dir failed: " + err.Error())
@@ -78,72 +123,178 @@ func (o *OpenvinoProvider) StartEngine(mode string) error {
 
 	modelDir := fmt.Sprintf("%s/models", o.EngineConfig.EnginePath)
 	pidFile := fmt.Sprintf("%s/ovms.pid", rootPath)
+	// ovms fails to start without its config
+	if _, err := os.Stat(o.getConfigPath()); os.IsNotExist(err) {
+		if err := o.initConfig(); err != nil {
+			logger.EngineLogger.Error("[OpenVINO] Failed to create config.json: " + err.Error())
+			return fmt.Errorf("failed to create config.json: %v", err)
+		}
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd, err = o.windowsStartCmd(mode, modelDir)
+	case "linux":
+		cmd, err = o.linuxStartCmd(modelDir)
+	default:
+		logger.EngineLogger.Error("[OpenVINO] Unsupported OS: " + runtime.GOOS)
+		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
+	}
+	if err != nil {
+		return err
+	}
+	cmd.Dir = o.EngineConfig.EnginePath
 
+	if runtime.GOOS == "windows" && mode == types.EngineStartModeStandard {
+		// the server runs in a console window of its own, and "start" returns at once
+		if err = cmd.Start(); err == nil {
+			go cmd.Wait()
+		}
+	} else {
+		err = startProcess(types.FlavorOpenvino, cmd, nil)
+	}
+	if err != nil {
+		logger.EngineLogger.Error("[OpenVINO] Failed to start OpenVINO Model Server: " + err.Error())
+		return err
+	}
+	time.Sleep(500 * time.Microsecond)
+
+	pid := cmd.Process.Pid
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(pid)), 0o644); err != nil {
+		logger.EngineLogger.Error("[OpenVINO] Failed to write PID to file: " + err.Error())
+		if killErr := cmd.Process.Kill(); killErr != nil {
+			logger.EngineLogger.Error("[OpenVINO] Failed to kill process after PID write error: " + killErr.Error())
+		}
+		return err
+	}
+
+	logger.EngineLogger.Info("[OpenVINO] OpenVINO Model Server started successfully")
+	return nil
+}
+
+func (o *OpenvinoProvider) windowsStartCmd(mode, modelDir string) (*exec.Cmd, error) {
 	batchContent := fmt.Sprintf(`
 	@echo on
 	call "%s\\setupvars.bat"
 	set PATH=%s\\python\\Scripts;%%PATH%%
 	set HF_HOME=%s\\.cache
-	set HF_ENDPOINT=https://hf-mirror.com
+	set HF_ENDPOINT=%s
 	%s --port 9000 --rest_port 16666 --config_path %s\\config.json
 	`,
 		o.EngineConfig.ExecPath,
 		o.EngineConfig.ExecPath,
 		o.EngineConfig.EnginePath,
+		openvinoHFEndpoint(),
 		o.EngineConfig.ExecFile,
 		modelDir,
 	)
 
 	logger.EngineLogger.Debug("[OpenVINO] Batch content: " + batchContent)
 	BatchFile := filepath.Join(o.EngineConfig.ExecPath, "start_ovms.bat")
-	if _, err = os.Stat(BatchFile); err != nil {
+	if _, err := os.Stat(BatchFile); err != nil {
 		if err = os.WriteFile(BatchFile, []byte(batchContent), 0o644); err != nil {
 			logger.EngineLogger.Error("[OpenVINO] Failed to create batch file: " + err.Error())
-			return fmt.Errorf("failed to create temp batch file: %v", err)
+			return nil, fmt.Errorf("failed to create temp batch file: %v", err)
 		}
 	}
 
-	cmd := exec.Command("cmd", "/C", BatchFile)
 	if mode == types.EngineStartModeStandard {
-		cmd = exec.Command("cmd", "/C", "start", BatchFile)
+		return exec.Command("cmd", "/C", "start", BatchFile), nil
 	}
-	cmd.Dir = o.EngineConfig.EnginePath
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	return exec.Command("cmd", "/C", BatchFile), nil
+}
 
-	err = cmd.Start()
-	if err != nil {
-		logger.EngineLogger.Error("[OpenVINO] Failed to start OpenVINO Model Server: " + err.Error())
-		return err
+// linuxStartCmd The script execs ovms, so the pid of the script is the pid of ovms
+func (o *OpenvinoProvider) linuxStartCmd(modelDir string) (*exec.Cmd, error) {
+	scriptContent := fmt.Sprintf(`#!/bin/bash
+%s
+exec "%s/%s" --port 9000 --rest_port 16666 --config_path "%s/config.json"
+`,
+		o.linuxEnv(),
+		o.EngineConfig.ExecPath,
+		o.EngineConfig.ExecFile,
+		modelDir,
+	)
+
+	logger.EngineLogger.Debug("[OpenVINO] Script content: " + scriptContent)
+	scriptFile := filepath.Join(o.EngineConfig.ExecPath, "start_ovms.sh")
+	// rewritten every time as the paths in it are only known at runtime
+	if err := os.WriteFile(scriptFile, []byte(scriptContent), 0o755); err != nil {
+		logger.EngineLogger.Error("[OpenVINO] Failed to create script file: " + err.Error())
+		return nil, fmt.Errorf("failed to create script file: %v", err)
 	}
-	time.Sleep(500 * time.Microsecond)
+	return exec.Command("/bin/bash", scriptFile), nil
+}
 
-	pid := cmd.Process.Pid
-	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(pid)), 0o644); err != nil {
-		logger.EngineLogger.Error("[OpenVINO] Failed to write PID to file: " + err.Error())
-		if killErr := cmd.Process.Kill(); killErr != nil {
-			logger.EngineLogger.Error("[OpenVINO] Failed to kill process after PID write error: " + killErr.Error())
-		}
-		return err
+// linuxEnvVars The environment of ovms on Linux, what setupvars.bat does on Windows. ovms is in the
+// bin dir of the release package, next to the lib dir of its libraries and python bindings. The
+// values may refer to the variables of the current environment like $PATH
+func (o *OpenvinoProvider) linuxEnvVars() [][2]string {
+	ovmsDir := filepath.Dir(o.EngineConfig.ExecPath)
+	return [][2]string{
+		{"LD_LIBRARY_PATH", ovmsDir + "/lib:$LD_LIBRARY_PATH"},
+		{"PYTHONPATH", ovmsDir + "/lib/python:$PYTHONPATH"},
+		{"PATH", ovmsDir + "/bin:$HOME/.local/bin:$PATH"},
+		{"HF_HOME", o.EngineConfig.EnginePath + "/.cache"},
+		{"HF_ENDPOINT", openvinoHFEndpoint()},
 	}
+}
 
-	go func() {
-		cmd.Wait()
-	}()
+// linuxEnv The environment of ovms as the exports of a shell script
+func (o *OpenvinoProvider) linuxEnv() string {
+	var lines []string
+	for _, v := range o.linuxEnvVars() {
+		lines = append(lines, fmt.Sprintf("export %s=\"%s\"", v[0], v[1]))
+	}
+	return strings.Join(lines, "\n")
+}
 
-	logger.EngineLogger.Info("[OpenVINO] OpenVINO Model Server started successfully")
+// linuxCmdEnv The environment of ovms for the commands run without a shell
+func (o *OpenvinoProvider) linuxCmdEnv() []string {
+	env := os.Environ()
+	for _, v := range o.linuxEnvVars() {
+		env = append(env, v[0]+"="+os.ExpandEnv(v[1]))
+	}
+	return env
+}
+
+// runLinuxScript Run the shell script in dir, its output is printed and returned in the error on failure
+func runLinuxScript(name, content, dir string) error {
+	scriptFile := filepath.Join(os.TempDir(), name)
+	if err := os.WriteFile(scriptFile, []byte(content), 0o755); err != nil {
+		logger.EngineLogger.Error("[OpenVINO] Failed to create temp script file: " + err.Error())
+		return fmt.Errorf("failed to create temp script file: %v", err)
+	}
+	defer os.Remove(scriptFile)
+
+	cmd := exec.Command("/bin/bash", scriptFile)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = io.MultiWriter(os.Stdout, &stdout)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	if err := cmd.Run(); err != nil {
+		logger.EngineLogger.Error("[OpenVINO] Failed to run script " + name + ": " + err.Error())
+		return fmt.Errorf("failed to run script %s: %v\nStdout: %s\nStderr: %s",
+			name, err, stdout.String(), stderr.String())
+	}
 	return nil
 }
 
 func (o *OpenvinoProvider) StopEngine() error {
-	pidFile := "ovms.pid"
+	rootPath, err := utils.GetAOGDataDir()
+	if err != nil {
+		logger.EngineLogger.Error("[OpenVINO] Get AOG data dir failed: " + err.Error())
+		return fmt.Errorf("failed get aog dir: %v", err)
+	}
+	pidFile := fmt.Sprintf("%s/ovms.pid", rootPath)
 	data, err := os.ReadFile(pidFile)
 	if err != nil {
 		slog.Error("Failed to read PID file", "error", err)
 		logger.EngineLogger.Error("[OpenVINO] Failed to read PID file: " + err.Error())
 		return err
 	}
-	pid, err := strconv.Atoi(string(data))
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
 	if err != nil {
 		slog.Error("Failed to parse PID", "error", err)
 		logger.EngineLogger.Error("[OpenVINO] Invalid PID format: " + err.Error())
@@ -156,7 +307,7 @@ func (o *OpenvinoProvider) StopEngine() error {
 		logger.EngineLogger.Error("[OpenVINO] Failed to find process: " + err.Error())
 		return err
 	}
-	err = process.Kill()
+	err = killProcess(process)
 	if err != nil {
 		slog.Error("Failed to kill process", "error", err)
 		logger.EngineLogger.Error("[OpenVINO] Failed to kill process: " + err.Error())
@@ -204,8 +355,11 @@ func (o *OpenvinoProvider) GetConfig() *types.EngineRecommendConfig {
 	case "linux":
 		// todo 这里需要区分 centos 和 ubuntu(22/24) 的版本 后续实现
 		execFile = "ovms"
-		execPath = fmt.Sprintf("%s/%s", AOGDir, "engine/openvino/ovms")
-		downloadUrl = ""
+		execPath = fmt.Sprintf("%s/%s", AOGDir, "engine/openvino/ovms/bin")
+		downloadUrl = "https://github.com/openvinotoolkit/model_server/releases/download/v2025.0/ovms_ubuntu22_python_on.tar.gz"
+		if url := config.Var("AOG_OVMS_DOWNLOAD_URL"); url != "" {
+			downloadUrl = url
+		}
 		enginePath = fmt.Sprintf("%s/%s", AOGDir, "engine/openvino")
 	default:
 		slog.Error("Unsupported OS: " + runtime.GOOS)
@@ -263,7 +417,11 @@ func (o *OpenvinoProvider) InstallEngine() error {
 	}
 
 	// 解压ovms文件
-	err = utils.UnzipFile(file, o.EngineConfig.EnginePath)
+	if runtime.GOOS == "linux" {
+		err = exec.Command("tar", "-xzf", file, "-C", o.EngineConfig.EnginePath).Run()
+	} else {
+		err = utils.UnzipFile(file, o.EngineConfig.EnginePath)
+	}
 	if err != nil {
 		slog.Error("Failed to unzip OpenVINO Model Server", "error", err)
 		logger.EngineLogger.Error("[OpenVINO] Failed to unzip OpenVINO Model Server: " + err.Error())
@@ -288,25 +446,34 @@ func (o *OpenvinoProvider) InstallEngine() error {
 		return fmt.Errorf("failed to unzip scripts.zip: %v", err)
 	}
 
-	// 新建 config.json 空 文件
-	configFile := fmt.Sprintf("%s/config.json", modelDir)
-	_, err = os.Create(configFile)
-	if err != nil {
-		slog.Error("Failed to create config.json", "error", err)
-		logger.EngineLogger.Error("[OpenVINO] Failed to create config.json: " + err.Error())
-		return fmt.Errorf("failed to create config.json: %v", err)
+	if err := o.installImageToImageScript(); err != nil {
+		return err
 	}
+
 	// 写入默认config配置
-	defaultConfig := OpenvinoModelServerConfig{
-		MediapipeConfigList: []ModelConfig{},
-		ModelConfigList:     []interface{}{},
-	}
-	err = o.saveConfig(&defaultConfig)
+	err = o.initConfig()
 	if err != nil {
 		slog.Error("Failed to save config.json", "error", err)
 		logger.EngineLogger.Error("[OpenVINO] Failed to save config.json: " + err.Error())
 		return fmt.Errorf("failed to save config.json: %v", err)
 	}
+
+	if runtime.GOOS == "linux" {
+		// ovms runs the python nodes by the python3 of the system
+		scriptContent := fmt.Sprintf(`#!/bin/bash
+set -e
+%s
+python3 -m pip install -r "%s/scripts/requirements.txt" -i "%s"
+`, o.linuxEnv(), o.EngineConfig.EnginePath, openvinoPipIndex())
+		logger.EngineLogger.Debug("[OpenVINO] Script content: " + scriptContent)
+		if err := runLinuxScript("run_install.sh", scriptContent, o.EngineConfig.EnginePath); err != nil {
+			return err
+		}
+		slog.Info("[Install Engine] openvino model engine install completed")
+		logger.EngineLogger.Info("[OpenVINO] OpenVINO Model Server install completed")
+		return nil
+	}
+
 	execPath := strings.Replace(o.EngineConfig.ExecPath, "/", "\\", -1)
 	enginePath := strings.Replace(o.EngineConfig.EnginePath, "/", "\\", -1)
 
@@ -315,8 +482,8 @@ func (o *OpenvinoProvider) InstallEngine() error {
 	@echo on
 	call "%s\\setupvars.bat"
 	set PATH=%s\\python\\Scripts;%%PATH%%
-	python -m pip install -r "%s\\scripts\\requirements.txt" -i https://mirrors.aliyun.com/pypi/simple/
-	`, execPath, execPath, enginePath)
+	python -m pip install -r "%s\\scripts\\requirements.txt" -i %s
+	`, execPath, execPath, enginePath, openvinoPipIndex())
 
 	logger.EngineLogger.Debug("[OpenVINO] Batch content: " + batchContent)
 
@@ -372,6 +539,18 @@ func (o *OpenvinoProvider) getConfigPath() string {
 	return fmt.Sprintf("%s/models/config.json", o.EngineConfig.EnginePath)
 }
 
+// initConfig Write the config.json without models
+func (o *OpenvinoProvider) initConfig() error {
+	if err := os.MkdirAll(filepath.Dir(o.getConfigPath()), 0o750); err != nil {
+		return err
+	}
+	defaultConfig := OpenvinoModelServerConfig{
+		MediapipeConfigList: []ModelConfig{},
+		ModelConfigList:     []interface{}{},
+	}
+	return o.saveConfig(&defaultConfig)
+}
+
 func (o *OpenvinoProvider) loadConfig() (*OpenvinoModelServerConfig, error) {
 	configPath := o.getConfigPath()
 	data, err := os.ReadFile(configPath)
@@ -429,6 +608,10 @@ func (o *OpenvinoProvider) PullModelStream(ctx context.Context, req *types.PullM
 }
 
 func (o *OpenvinoProvider) DeleteModel(ctx context.Context, req *types.DeleteRequest) error {
+	modelDir, err := o.modelDir(req.Model)
+	if err != nil {
+		return err
+	}
 	config, err := o.loadConfig()
 	if err != nil {
 		slog.Error("Failed to load config", "error", err)
@@ -446,7 +629,6 @@ func (o *OpenvinoProvider) DeleteModel(ctx context.Context, req *types.DeleteReq
 				return err
 			}
 
-			modelDir := fmt.Sprintf("%s/models/%s", o.EngineConfig.EnginePath, req.Model)
 			if err := os.RemoveAll(modelDir); err != nil {
 				slog.Error("Failed to remove model directory", "error", err)
 				logger.EngineLogger.Error("[OpenVINO] Failed to remove model directory: " + err.Error())
@@ -484,8 +666,26 @@ func (o *OpenvinoProvider) addModelToConfig(modelName string) error {
 	return o.saveConfig(config)
 }
 
+// installImageToImageScript Put the python node of the image-to-image graph shipped with aog into
+// the scripts dir of the engine
+func (o *OpenvinoProvider) installImageToImageScript() error {
+	scriptDir := filepath.Join(o.EngineConfig.EnginePath, "scripts", "image-to-image")
+	if err := os.MkdirAll(scriptDir, 0o750); err != nil {
+		logger.EngineLogger.Error("[OpenVINO] Failed to create image-to-image script dir: " + err.Error())
+		return fmt.Errorf("failed to create image-to-image script dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(scriptDir, "model.py"), imageToImageScript, 0o644); err != nil {
+		logger.EngineLogger.Error("[OpenVINO] Failed to write image-to-image script: " + err.Error())
+		return fmt.Errorf("failed to write image-to-image script: %v", err)
+	}
+	return nil
+}
+
 func (o *OpenvinoProvider) generateGraphPbtxt(modelName, modelType string) error {
-	modelDir := fmt.Sprintf("%s/models/%s", o.EngineConfig.EnginePath, modelName)
+	modelDir, err := o.modelDir(modelName)
+	if err != nil {
+		return err
+	}
 	if err := os.MkdirAll(modelDir, 0o750); err != nil {
 		slog.Error("Failed to create model directory", "error", err)
 		logger.EngineLogger.Error("[OpenVINO] Failed to create model directory: " + err.Error())
@@ -518,6 +718,39 @@ node {
       handler_path: "%s/scripts/text-to-image/model.py"
     }
   }
+}`, modelName, enginePath)
+	case "image-to-image":
+		// engines installed before the script is shipped get it here
+		if err := o.installImageToImageScript(); err != nil {
+			return err
+		}
+		template = fmt.Sprintf(`input_stream: "OVMS_PY_TENSOR:prompt"
+input_stream: "OVMS_PY_TENSOR_IMAGE:source_image"
+input_stream: "OVMS_PY_TENSOR_MASK:mask_image"
+input_stream: "OVMS_PY_TENSOR_STRENGTH:strength"
+input_stream: "OVMS_PY_TENSOR_BATCH:batch"
+input_stream: "OVMS_PY_TENSOR_HEIGHT:height"
+input_stream: "OVMS_PY_TENSOR_WIDTH:width"
+output_stream: "OVMS_PY_TENSOR:image"
+
+node {
+  name: "%s"
+  calculator: "PythonExecutorCalculator"
+  input_side_packet: "PYTHON_NODE_RESOURCES:py"
+
+  input_stream: "INPUT:prompt"
+  input_stream: "IMAGE:source_image"
+  input_stream: "MASK:mask_image"
+  input_stream: "STRENGTH:strength"
+  input_stream: "BATCH:batch"
+  input_stream: "HEIGHT:height"
+  input_stream: "WIDTH:width"
+  output_stream: "OUTPUT:image"
+  node_options: {
+    [type.googleapis.com/mediapipe.PythonExecutorCalculatorOptions]: {
+      handler_path: "%s/scripts/image-to-image/model.py"
+    }
+  }
 }`, modelName, enginePath)
 	default:
 		slog.Error("Unsupported model type: " + modelType)
@@ -532,7 +765,10 @@ node {
 func (o *OpenvinoProvider) PullModel(ctx context.Context, req *types.PullModelRequest, fn types.PullProgressFunc) (*types.ProgressResponse, error) {
 	// 当前暂时使用 modelscope 拉取模型
 	// 后续使用 python 脚本拉取（区分 huggingface 和 modelscope）
-	localModelPath := fmt.Sprintf("%s/models/%s", o.EngineConfig.EnginePath, req.Model)
+	localModelPath, err := o.modelDir(req.Model)
+	if err != nil {
+		return nil, err
+	}
 	scriptPath := fmt.Sprintf("%s/scripts/model.py", o.EngineConfig.EnginePath)
 
 	logger.EngineLogger.Info("[OpenVINO] Pulling model: " + req.Model)
@@ -546,15 +782,31 @@ func (o *OpenvinoProvider) PullModel(ctx context.Context, req *types.PullModelRe
 		}
 	}
 
+	if runtime.GOOS == "linux" {
+		// the names are passed as arguments, not through a shell
+		cmd := exec.CommandContext(ctx, "python3", scriptPath, "--model_name", req.Model, "--local_dir", localModelPath)
+		cmd.Dir = o.EngineConfig.EnginePath
+		cmd.Env = o.linuxCmdEnv()
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = io.MultiWriter(os.Stdout, &stdout)
+		cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+		if err := cmd.Run(); err != nil {
+			logger.EngineLogger.Error("[OpenVINO] Failed to pull model: " + err.Error())
+			return nil, fmt.Errorf("failed to pull model %s: %v\nStdout: %s\nStderr: %s",
+				req.Model, err, stdout.String(), stderr.String())
+		}
+		return o.registerModel(req)
+	}
+
 	batchContent := fmt.Sprintf(`
 		@echo on
 		call "%s\\setupvars.bat"
 		set PATH=%s\\python\\Scripts;%%PATH%%
 		set HF_HOME=%s\\.cache
-		set HF_ENDPOINT=https://hf-mirror.com
+		set HF_ENDPOINT=%s
 		python  %s --model_name %s --local_dir %s
 		`,
-		o.EngineConfig.ExecPath, o.EngineConfig.ExecPath, o.EngineConfig.EnginePath, scriptPath, req.Model, localModelPath)
+		o.EngineConfig.ExecPath, o.EngineConfig.ExecPath, o.EngineConfig.EnginePath, openvinoHFEndpoint(), scriptPath, req.Model, localModelPath)
 
 	logger.EngineLogger.Debug("[OpenVINO] Batch content: " + batchContent)
 
@@ -574,13 +826,18 @@ func (o *OpenvinoProvider) PullModel(ctx context.Context, req *types.PullModelRe
 	cmd.Stdout = io.MultiWriter(os.Stdout)
 	cmd.Stderr = io.MultiWriter(os.Stderr)
 
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
 		slog.Error("Failed to pull model", "error", err)
 		logger.EngineLogger.Error("[OpenVINO] Failed to pull model: " + err.Error())
 		return nil, err
 	}
 
+	return o.registerModel(req)
+}
+
+// registerModel Make the pulled model served by ovms
+func (o *OpenvinoProvider) registerModel(req *types.PullModelRequest) (*types.ProgressResponse, error) {
 	// 生成对应的graph.pbtxt文件
 	logger.EngineLogger.Debug("[OpenVINO] Generating graph.pbtxt for model: " + req.Model)
 	if err := o.generateGraphPbtxt(req.Model, req.ModelType); err != nil {
@@ -601,3 +858,214 @@ func (o *OpenvinoProvider) PullModel(ctx context.Context, req *types.PullModelRe
 
 	return nil, nil
 }
+
+// ImportModel Copy the OpenVINO IR directory into the models dir, and serve it by ovms like a pulled model
+func (o *OpenvinoProvider) ImportModel(ctx context.Context, req *types.ImportModelRequest) error {
+	logger.EngineLogger.Info("[OpenVINO] Import model: " + req.Model + " from " + req.Path)
+
+	info, err := os.Stat(req.Path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not an OpenVINO IR directory", req.Path)
+	}
+
+	localModelPath, err := o.modelDir(req.Model)
+	if err != nil {
+		return err
+	}
+	src, _ := filepath.Abs(req.Path)
+	dst, _ := filepath.Abs(localModelPath)
+	if src != dst {
+		if _, err := os.Stat(localModelPath); err == nil {
+			return fmt.Errorf("model directory %s already exists", localModelPath)
+		}
+		if err := copyDir(src, dst); err != nil {
+			logger.EngineLogger.Error("[OpenVINO] Failed to copy model directory: " + err.Error())
+			os.RemoveAll(dst)
+			return err
+		}
+	}
+
+	if _, err := o.registerModel(&types.PullModelRequest{Model: req.Model, ModelType: req.ModelType}); err != nil {
+		return err
+	}
+	logger.EngineLogger.Info("[OpenVINO] Import model completed: " + req.Model)
+	return nil
+}
+
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o750)
+		}
+		return copyFile(path, target)
+	})
+}
+
+// copyFile Copy to a temp file then rename it, so that dst is never seen incomplete
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o750); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// modelScopeEndpoint where the models are pulled from
+const modelScopeEndpoint = "https://www.modelscope.cn"
+
+func (o *OpenvinoProvider) ModelStoragePath() string {
+	return filepath.Join(o.EngineConfig.EnginePath, "models")
+}
+
+// PullSize The size of the files of the model repo in ModelScope, less the files of the same size
+// in the model dir already
+func (o *OpenvinoProvider) PullSize(ctx context.Context, model string) (int64, error) {
+	modelDir, err := o.modelDir(model)
+	if err != nil {
+		return 0, err
+	}
+	filesUrl := fmt.Sprintf("%s/api/v1/models/%s/repo/files?Recursive=true", modelScopeEndpoint, model)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, filesUrl, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := modelInfoClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get model files: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to get files of model %s: HTTP status %s", model, resp.Status)
+	}
+	var info struct {
+		Data struct {
+			Files []struct {
+				Path string `json:"Path"`
+				Type string `json:"Type"`
+				Size int64  `json:"Size"`
+			} `json:"Files"`
+		} `json:"Data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return 0, fmt.Errorf("invalid files of model %s: %v", model, err)
+	}
+
+	var size int64
+	for _, f := range info.Data.Files {
+		if f.Type != "blob" {
+			continue
+		}
+		if fi, err := os.Stat(filepath.Join(modelDir, filepath.FromSlash(f.Path))); err == nil && fi.Size() == f.Size {
+			continue
+		}
+		size += f.Size
+	}
+	return size, nil
+}
+
+// ModelFiles The files of the model dir, graph.pbtxt excluded as it's generated for the machine
+func (o *OpenvinoProvider) ModelFiles(ctx context.Context, model string) (map[string]string, error) {
+	modelDir, err := o.modelDir(model)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(modelDir); err != nil {
+		return nil, fmt.Errorf("model %s not found: %v", model, err)
+	}
+	files := make(map[string]string)
+	err = filepath.WalkDir(modelDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(modelDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "graph.pbtxt" {
+			return nil
+		}
+		files["models/"+model+"/"+filepath.ToSlash(rel)] = path
+		return nil
+	})
+	return files, err
+}
+
+// InstallModelFiles Install the model dir unpacked like a model imported
+func (o *OpenvinoProvider) InstallModelFiles(ctx context.Context, req *types.ImportModelRequest) error {
+	return o.ImportModel(ctx, &types.ImportModelRequest{
+		Model:     req.Model,
+		Path:      filepath.Join(req.Path, "models", req.Model),
+		ModelType: req.ModelType,
+	})
+}
+
+// ModelInfo The size of the model dir, and the quantization in openvino_config.json if the model
+// is exported with it
+func (o *OpenvinoProvider) ModelInfo(ctx context.Context, model string) (*types.EngineModelInfo, error) {
+	modelDir, err := o.modelDir(model)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(modelDir); err != nil {
+		return nil, fmt.Errorf("model %s not found: %v", model, err)
+	}
+	info := &types.EngineModelInfo{Details: types.ModelDetails{Format: "openvino"}}
+	err = filepath.WalkDir(modelDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		info.Size += fi.Size()
+		if fi.ModTime().After(info.ModifiedAt) {
+			info.ModifiedAt = fi.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		QuantizationConfig struct {
+			Bits int `json:"bits"`
+		} `json:"quantization_config"`
+	}
+	if data, err := os.ReadFile(filepath.Join(modelDir, "openvino_config.json")); err == nil {
+		if json.Unmarshal(data, &config) == nil && config.QuantizationConfig.Bits > 0 {
+			info.Details.QuantizationLevel = fmt.Sprintf("INT%d", config.QuantizationConfig.Bits)
+		}
+	}
+	return info, nil
+}
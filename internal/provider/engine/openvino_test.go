@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ligjn/aog/internal/types"
+)
+
+func TestOpenvinoModelDir(t *testing.T) {
+	enginePath := t.TempDir()
+	o := NewOpenvinoProvider(&types.EngineRecommendConfig{EnginePath: enginePath})
+	cases := []struct {
+		model string
+		valid bool
+	}{
+		{model: "OpenVINO/stable-diffusion-v1-5-fp16-ov", valid: true},
+		{model: "stable-diffusion-v-1-5-ov-fp16", valid: true},
+		{model: "Qwen/Qwen2.5_0.5B.int4", valid: true},
+		{model: "../evil"},
+		{model: "OpenVINO/../../evil"},
+		{model: "OpenVINO/./sd"},
+		{model: "/etc/passwd"},
+		{model: "OpenVINO/sd/"},
+		{model: `sd"; rm -rf ~; echo "`},
+		{model: "sd$(reboot)"},
+		{model: "sd & del *"},
+		{model: ""},
+	}
+	for _, c := range cases {
+		dir, err := o.modelDir(c.model)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("invalid model name %q is taken as %s", c.model, dir)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.model, err)
+			continue
+		}
+		if want := filepath.Join(enginePath, "models", c.model); dir != want {
+			t.Errorf("dir of %s is %s, want %s", c.model, dir, want)
+		}
+	}
+
+	// nothing is pulled or removed by an invalid name
+	if _, err := o.PullModel(context.Background(), &types.PullModelRequest{Model: "../evil"}, nil); err == nil {
+		t.Errorf("model with an invalid name is pulled")
+	}
+	if err := o.DeleteModel(context.Background(), &types.DeleteRequest{Model: "../evil"}); err == nil || !strings.Contains(err.Error(), "invalid model name") {
+		t.Errorf("got error %v deleting a model with an invalid name", err)
+	}
+}
+
+func TestOpenvinoLinuxEnv(t *testing.T) {
+	o := NewOpenvinoProvider(&types.EngineRecommendConfig{EnginePath: "/aog/openvino", ExecPath: "/aog/openvino/ovms/bin"})
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("HOME", "/home/aog")
+
+	env := strings.Join(o.linuxCmdEnv(), "\n") + "\n"
+	for _, want := range []string{
+		"PATH=/aog/openvino/ovms/bin:/home/aog/.local/bin:/usr/bin",
+		"HF_HOME=/aog/openvino/.cache",
+		"HF_ENDPOINT=" + openvinoDefaultHFEndpoint,
+	} {
+		if !strings.Contains(env, want+"\n") {
+			t.Errorf("environment doesn't have %s", want)
+		}
+	}
+
+	t.Setenv("AOG_OVMS_HF_ENDPOINT", "https://huggingface.co")
+	t.Setenv("AOG_OVMS_PIP_INDEX_URL", " 'https://pypi.org/simple/' ")
+	if script := o.linuxEnv(); !strings.Contains(script, `export HF_ENDPOINT="https://huggingface.co"`) {
+		t.Errorf("script environment %s doesn't take AOG_OVMS_HF_ENDPOINT", script)
+	}
+	if index := openvinoPipIndex(); index != "https://pypi.org/simple/" {
+		t.Errorf("pip index %s, want the one of AOG_OVMS_PIP_INDEX_URL", index)
+	}
+}
+
+func TestOpenvinoImportModel(t *testing.T) {
+	o := NewOpenvinoProvider(&types.EngineRecommendConfig{EnginePath: t.TempDir()})
+	if err := o.initConfig(); err != nil {
+		t.Fatal(err)
+	}
+	src := t.TempDir()
+	for name, data := range map[string]string{"model_index.json": "{}", "unet/openvino_model.bin": "IR weights"} {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := &types.ImportModelRequest{Model: "openvino/sd-tiny", Path: src, ModelType: types.ServiceTextToImage}
+	if err := o.ImportModel(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := o.modelDir("openvino/sd-tiny")
+	if data, err := os.ReadFile(filepath.Join(dir, "unet", "openvino_model.bin")); err != nil || string(data) != "IR weights" {
+		t.Errorf("model files are not copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "graph.pbtxt")); err != nil {
+		t.Errorf("graph of the model is not generated: %v", err)
+	}
+	list, err := o.ListModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Models) != 1 || list.Models[0].Name != "openvino/sd-tiny" {
+		t.Errorf("models %+v, want the one imported", list.Models)
+	}
+
+	if err := o.ImportModel(context.Background(), req); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("got error %v importing the model again, want it refused", err)
+	}
+	file := &types.ImportModelRequest{Model: "openvino/sd-file", Path: filepath.Join(src, "model_index.json")}
+	if err := o.ImportModel(context.Background(), file); err == nil || !strings.Contains(err.Error(), "not an OpenVINO IR directory") {
+		t.Errorf("got error %v importing a file, want it refused", err)
+	}
+}
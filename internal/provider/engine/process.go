@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/ligjn/aog/internal/logger"
+)
+
+// ProcessWatcher Told about the engine processes started by AOG, i.e. the engine supervisor
+type ProcessWatcher interface {
+	ProcessStarted(engineName string, pid int)
+	// ProcessExited stopped is true if the process is killed by StopEngine etc., not crashed
+	ProcessExited(engineName string, pid int, err error, stopped bool)
+}
+
+var (
+	processMu      sync.Mutex
+	processWatcher ProcessWatcher
+	// stoppingPids processes being killed by AOG
+	stoppingPids = make(map[int]bool)
+)
+
+func SetProcessWatcher(w ProcessWatcher) {
+	processMu.Lock()
+	defer processMu.Unlock()
+	processWatcher = w
+}
+
+func getProcessWatcher() ProcessWatcher {
+	processMu.Lock()
+	defer processMu.Unlock()
+	return processWatcher
+}
+
+// startProcess Start the engine process with its output written to the log file of the engine,
+// and wait for it in background. onExit is called when it exits, before the watcher is told
+func startProcess(engineName string, cmd *exec.Cmd, onExit func(err error)) error {
+	if cmd.Stdout == nil && cmd.Stderr == nil {
+		if f, err := logger.OpenEngineLog(engineName); err == nil {
+			cmd.Stdout, cmd.Stderr = f, f
+			// the process has its own copy of the file after started
+			defer f.Close()
+		} else {
+			logger.EngineLogger.Warn("[Engine] Failed to open engine log, the output is dropped", "engine", engineName, "error", err)
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	if w := getProcessWatcher(); w != nil {
+		w.ProcessStarted(engineName, pid)
+	}
+
+	go func() {
+		err := cmd.Wait()
+		processMu.Lock()
+		stopped := stoppingPids[pid]
+		delete(stoppingPids, pid)
+		processMu.Unlock()
+		if onExit != nil {
+			onExit(err)
+		}
+		if w := getProcessWatcher(); w != nil {
+			w.ProcessExited(engineName, pid, err, stopped)
+		}
+	}()
+	return nil
+}
+
+// killProcess Kill the engine process, which is not taken as a crash
+func killProcess(p *os.Process) error {
+	processMu.Lock()
+	stoppingPids[p.Pid] = true
+	processMu.Unlock()
+	err := p.Kill()
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		processMu.Lock()
+		delete(stoppingPids, p.Pid)
+		processMu.Unlock()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+# Python node of OpenVINO Model Server serving the image-to-image service of AOG. AOG ships it
+# and installs it into <engine>/scripts/image-to-image, next to the scripts of the other services.
+#
+# Inputs (all bytes): prompt, source_image, mask_image (empty to edit the whole image), strength,
+# batch, height, width. The output "image" packs the images the way AOG reads them: the count,
+# then the length and the PNG data of each image, the numbers being 4-byte little endian.
+import io
+import struct
+
+from PIL import Image
+from pyovms import Tensor
+from optimum.intel import OVPipelineForImage2Image, OVPipelineForInpainting
+
+
+def _text(values, name, default=""):
+    value = values.get(name, b"").decode("utf-8").strip()
+    return value if value else default
+
+
+class OvmsPythonModel:
+    def initialize(self, kwargs):
+        # the model is downloaded into the dir of the graph
+        self.model_dir = kwargs["base_path"]
+        self.image2image = OVPipelineForImage2Image.from_pretrained(self.model_dir, device="CPU")
+        self.inpainting = None
+
+    def _inpainting_pipeline(self):
+        # loaded on the first request with a mask only
+        if self.inpainting is None:
+            self.inpainting = OVPipelineForInpainting.from_pretrained(self.model_dir, device="CPU")
+        return self.inpainting
+
+    def execute(self, inputs):
+        values = {tensor.name: bytes(tensor) for tensor in inputs}
+        prompt = _text(values, "prompt")
+        strength = float(_text(values, "strength", "0.75"))
+        batch = int(_text(values, "batch", "1"))
+        width = int(_text(values, "width", "0"))
+        height = int(_text(values, "height", "0"))
+
+        image = Image.open(io.BytesIO(values["source_image"])).convert("RGB")
+        if width > 0 and height > 0:
+            image = image.resize((width, height))
+
+        mask = values.get("mask_image", b"")
+        if mask:
+            mask_image = Image.open(io.BytesIO(mask)).convert("L").resize(image.size)
+            result = self._inpainting_pipeline()(
+                prompt=prompt,
+                image=image,
+                mask_image=mask_image,
+                strength=strength,
+                num_images_per_prompt=batch,
+                width=image.width,
+                height=image.height,
+            )
+        else:
+            result = self.image2image(
+                prompt=prompt,
+                image=image,
+                strength=strength,
+                num_images_per_prompt=batch,
+            )
+
+        packed = [struct.pack("<I", len(result.images))]
+        for generated in result.images:
+            buf = io.BytesIO()
+            generated.save(buf, format="PNG")
+            data = buf.getvalue()
+            packed.append(struct.pack("<I", len(data)))
+            packed.append(data)
+        return [Tensor("image", b"".join(packed))]
@@ -2,9 +2,14 @@ package provider
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/ligjn/aog/internal/provider/engine"
 	"github.com/ligjn/aog/internal/types"
+	"github.com/ligjn/aog/internal/utils"
 )
 
 // ModelServiceProvider local model engine
@@ -22,14 +27,186 @@ type ModelServiceProvider interface {
 	GetVersion(ctx context.Context, resp *types.EngineVersionResponse) (*types.EngineVersionResponse, error)
 }
 
-func GetModelEngine(engineName string) ModelServiceProvider {
-	switch engineName {
-	case "ollama":
-		return engine.NewOllamaProvider(nil)
-	case "openvino":
-		// todo
-		return engine.NewOpenvinoProvider(nil)
-	default:
-		return engine.NewOllamaProvider(nil)
+// ModelImporter An engine able to register local model files instead of pulling them
+type ModelImporter interface {
+	ImportModel(ctx context.Context, req *types.ImportModelRequest) error
+}
+
+// ModelBundler An engine whose downloaded models can be packed into offline bundles and installed from them
+type ModelBundler interface {
+	// ModelFiles The files of the model in the storage of the engine, keyed by their paths in the bundle
+	ModelFiles(ctx context.Context, model string) (map[string]string, error)
+	// InstallModelFiles Put the files of the model unpacked from a bundle into the storage of the
+	// engine. req.Path is the dir they are unpacked to, by their paths in the bundle
+	InstallModelFiles(ctx context.Context, req *types.ImportModelRequest) error
+}
+
+// ModelInspector An engine able to tell the size, digest, quantization etc. of a model it has
+type ModelInspector interface {
+	ModelInfo(ctx context.Context, model string) (*types.EngineModelInfo, error)
+}
+
+// ModelLoader An engine whose models can be loaded into memory before they're used, and unloaded
+type ModelLoader interface {
+	LoadModel(ctx context.Context, req *types.LoadModelRequest) error
+	UnloadModel(ctx context.Context, model string) error
+	// ListRunningModels The models loaded, with the memory they use
+	ListRunningModels(ctx context.Context) (*types.ProcessResponse, error)
+}
+
+// ModelStorage An engine keeping the models it pulls on the local disk
+type ModelStorage interface {
+	// ModelStoragePath The dir the models are stored in
+	ModelStoragePath() string
+	// PullSize The bytes the pull of the model still writes to the disk, i.e. without the parts
+	// downloaded already, by the metadata of the registry it's pulled from
+	PullSize(ctx context.Context, model string) (int64, error)
+}
+
+// ErrModelEngineNotFound no model engine is registered with the name
+var ErrModelEngineNotFound = errors.New("model engine not registered")
+
+// EngineDescriptor describes a model engine and how to create it
+type EngineDescriptor struct {
+	Name string
+	// Services the AOG services the engine can serve, e.g. chat, embed
+	Services []string
+	// External the engine is installed and started outside AOG and serves the models it is
+	// launched with, so AOG neither installs, starts nor pulls models for it
+	External bool
+	// New creates the engine, with its default config if config is nil
+	New func(config *types.EngineRecommendConfig) ModelServiceProvider
+}
+
+// ServesService whether the engine can serve the AOG service
+func (d EngineDescriptor) ServesService(service string) bool {
+	return utils.Contains(d.Services, service)
+}
+
+type registeredEngine struct {
+	desc     EngineDescriptor
+	instance ModelServiceProvider
+}
+
+var (
+	enginesMu sync.Mutex
+	engines   = make(map[string]*registeredEngine)
+)
+
+func init() {
+	RegisterModelEngine(EngineDescriptor{
+		Name:     types.FlavorOllama,
+		Services: []string{types.ServiceModels, types.ServiceChat, types.ServiceGenerate, types.ServiceEmbed},
+		New: func(config *types.EngineRecommendConfig) ModelServiceProvider {
+			// the constructor returns nil if the engine dir can't be made
+			if p := engine.NewOllamaProvider(config); p != nil {
+				return p
+			}
+			return nil
+		},
+	})
+	RegisterModelEngine(EngineDescriptor{
+		Name:     types.FlavorOpenvino,
+		Services: []string{types.ServiceTextToImage, types.ServiceImageToImage},
+		New: func(config *types.EngineRecommendConfig) ModelServiceProvider {
+			if p := engine.NewOpenvinoProvider(config); p != nil {
+				return p
+			}
+			return nil
+		},
+	})
+	RegisterModelEngine(EngineDescriptor{
+		Name:     types.FlavorLlamaCpp,
+		Services: []string{types.ServiceModels, types.ServiceChat, types.ServiceGenerate, types.ServiceEmbed},
+		New: func(config *types.EngineRecommendConfig) ModelServiceProvider {
+			if p := engine.NewLlamaCppProvider(config); p != nil {
+				return p
+			}
+			return nil
+		},
+	})
+	RegisterModelEngine(EngineDescriptor{
+		Name:     types.FlavorVLLM,
+		Services: []string{types.ServiceModels, types.ServiceChat, types.ServiceGenerate, types.ServiceEmbed, types.ServiceSpeechToText},
+		External: true,
+		New: func(config *types.EngineRecommendConfig) ModelServiceProvider {
+			return engine.NewOpenAICompatProvider(types.FlavorVLLM, config)
+		},
+	})
+}
+
+// RegisterModelEngine Add a model engine, so that service providers of the flavor with the same
+// name are served by it. It panics if the name is registered already
+func RegisterModelEngine(desc EngineDescriptor) {
+	if desc.Name == "" || desc.New == nil {
+		panic("[Engine] Model engine must have a name and a constructor")
+	}
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	if _, exists := engines[desc.Name]; exists {
+		panic("[Engine] Model engine registered twice: " + desc.Name)
+	}
+	engines[desc.Name] = &registeredEngine{desc: desc}
+}
+
+// GetModelEngine The engine registered with the name. It is created on first use and shared afterward
+func GetModelEngine(engineName string) (ModelServiceProvider, error) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	e, ok := engines[engineName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrModelEngineNotFound, engineName)
+	}
+	if e.instance == nil {
+		instance := e.desc.New(nil)
+		if instance == nil {
+			return nil, fmt.Errorf("failed to create model engine %s", engineName)
+		}
+		e.instance = instance
+	}
+	return e.instance, nil
+}
+
+// NewModelEngine A new engine registered with the name using the config, e.g. to reach an engine
+// running at another host. Use GetModelEngine for the shared one instead
+func NewModelEngine(engineName string, config *types.EngineRecommendConfig) (ModelServiceProvider, error) {
+	desc, ok := GetModelEngineDescriptor(engineName)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrModelEngineNotFound, engineName)
 	}
+	instance := desc.New(config)
+	if instance == nil {
+		return nil, fmt.Errorf("failed to create model engine %s", engineName)
+	}
+	return instance, nil
+}
+
+func GetModelEngineDescriptor(engineName string) (EngineDescriptor, bool) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	e, ok := engines[engineName]
+	if !ok {
+		return EngineDescriptor{}, false
+	}
+	return e.desc, true
+}
+
+// ModelEngineDescriptors All registered engines sorted by name
+func ModelEngineDescriptors() []EngineDescriptor {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	res := make([]EngineDescriptor, 0, len(engines))
+	for _, e := range engines {
+		res = append(res, e.desc)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// IsExternalModelEngine whether the engine is registered and started outside AOG
+func IsExternalModelEngine(engineName string) bool {
+	desc, ok := GetModelEngineDescriptor(engineName)
+	return ok && desc.External
 }
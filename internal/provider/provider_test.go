@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/types"
+)
+
+func TestMain(m *testing.M) {
+	logDir, err := os.MkdirTemp("", "aog-provider-test")
+	if err != nil {
+		panic(err)
+	}
+	logger.InitLogger(logger.LogConfig{LogLevel: "error", LogPath: logDir})
+	code := m.Run()
+	os.RemoveAll(logDir)
+	os.Exit(code)
+}
+
+// fakeEngine an engine doing nothing, told apart by its config
+type fakeEngine struct {
+	config *types.EngineRecommendConfig
+}
+
+func (e *fakeEngine) InstallEngine() error          { return nil }
+func (e *fakeEngine) StartEngine(mode string) error { return nil }
+func (e *fakeEngine) StopEngine() error             { return nil }
+func (e *fakeEngine) HealthCheck() error            { return nil }
+func (e *fakeEngine) InitEnv() error                { return nil }
+
+func (e *fakeEngine) PullModel(ctx context.Context, req *types.PullModelRequest, fn types.PullProgressFunc) (*types.ProgressResponse, error) {
+	return &types.ProgressResponse{Status: "success"}, nil
+}
+
+func (e *fakeEngine) PullModelStream(ctx context.Context, req *types.PullModelRequest) (chan []byte, chan error) {
+	return nil, nil
+}
+
+func (e *fakeEngine) DeleteModel(ctx context.Context, req *types.DeleteRequest) error { return nil }
+
+func (e *fakeEngine) ListModels(ctx context.Context) (*types.ListResponse, error) {
+	return &types.ListResponse{}, nil
+}
+
+func (e *fakeEngine) GetConfig() *types.EngineRecommendConfig { return e.config }
+
+func (e *fakeEngine) GetVersion(ctx context.Context, resp *types.EngineVersionResponse) (*types.EngineVersionResponse, error) {
+	return resp, nil
+}
+
+func TestGetModelEngineNotRegistered(t *testing.T) {
+	if _, err := GetModelEngine("no-such-engine"); !errors.Is(err, ErrModelEngineNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrModelEngineNotFound)
+	}
+	if _, err := NewModelEngine("no-such-engine", nil); !errors.Is(err, ErrModelEngineNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrModelEngineNotFound)
+	}
+	if IsExternalModelEngine("no-such-engine") {
+		t.Errorf("engine not registered is taken as external")
+	}
+}
+
+func TestGetModelEngineShared(t *testing.T) {
+	created := 0
+	RegisterModelEngine(EngineDescriptor{
+		Name:     "fake-shared",
+		Services: []string{types.ServiceChat},
+		New: func(config *types.EngineRecommendConfig) ModelServiceProvider {
+			created++
+			return &fakeEngine{config: config}
+		},
+	})
+
+	first, err := GetModelEngine("fake-shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := GetModelEngine("fake-shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || created != 1 {
+		t.Errorf("engine is created %d times, want it created once and shared", created)
+	}
+
+	config := &types.EngineRecommendConfig{Host: "192.168.1.2:11434"}
+	other, err := NewModelEngine("fake-shared", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first || other.GetConfig() != config {
+		t.Errorf("NewModelEngine doesn't create a new engine with the config")
+	}
+	if again, _ := GetModelEngine("fake-shared"); again != first {
+		t.Errorf("NewModelEngine replaced the shared engine")
+	}
+}
+
+func TestGetModelEngineCreateFailed(t *testing.T) {
+	fail := true
+	RegisterModelEngine(EngineDescriptor{
+		Name: "fake-failing",
+		New: func(config *types.EngineRecommendConfig) ModelServiceProvider {
+			if fail {
+				return nil
+			}
+			return &fakeEngine{}
+		},
+	})
+	if _, err := GetModelEngine("fake-failing"); err == nil || errors.Is(err, ErrModelEngineNotFound) {
+		t.Fatalf("got error %v, want the engine failed to be created", err)
+	}
+	// not kept as failed, it's created once it can be
+	fail = false
+	if e, err := GetModelEngine("fake-failing"); err != nil || e == nil {
+		t.Errorf("got %v, %v, want the engine", e, err)
+	}
+}
+
+func TestRegisterModelEngine(t *testing.T) {
+	desc := EngineDescriptor{
+		Name:     "fake-registered",
+		Services: []string{types.ServiceEmbed},
+		External: true,
+		New:      func(config *types.EngineRecommendConfig) ModelServiceProvider { return &fakeEngine{} },
+	}
+	RegisterModelEngine(desc)
+
+	got, ok := GetModelEngineDescriptor("fake-registered")
+	if !ok || !got.ServesService(types.ServiceEmbed) || got.ServesService(types.ServiceChat) {
+		t.Errorf("descriptor %+v, want the one registered", got)
+	}
+	if !IsExternalModelEngine("fake-registered") {
+		t.Errorf("engine is not external")
+	}
+	found := false
+	descs := ModelEngineDescriptors()
+	for i, d := range descs {
+		found = found || d.Name == "fake-registered"
+		if i > 0 && descs[i-1].Name >= d.Name {
+			t.Errorf("descriptors are not sorted by name: %s before %s", descs[i-1].Name, d.Name)
+		}
+	}
+	if !found {
+		t.Errorf("engine is not in the descriptors")
+	}
+
+	for _, bad := range []EngineDescriptor{desc, {Name: "fake-no-constructor"}, {New: desc.New}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %q doesn't panic", bad.Name)
+				}
+			}()
+			RegisterModelEngine(bad)
+		}()
+	}
+}
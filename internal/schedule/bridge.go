@@ -0,0 +1,424 @@
+package schedule
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/ligjn/aog/internal/convert"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/types"
+)
+
+// streamAggregator Merges the stream chunks in AOG format into one AOG response
+// - chat: message.content and message.reasoning_content are concatenated, tool call deltas
+// are merged by their index (arguments concatenated), complete tool calls are appended
+// - generate: response is concatenated
+// - other fields: the last non-null value wins, e.g. finish_reason
+type streamAggregator struct {
+	service   string
+	result    map[string]any
+	content   bytes.Buffer
+	reasoning bytes.Buffer
+	response  bytes.Buffer
+	role      string
+	toolCalls []map[string]any
+	// position in toolCalls of the tool call deltas with the index
+	toolCallIndex map[int]int
+}
+
+func newStreamAggregator(service string) *streamAggregator {
+	return &streamAggregator{
+		service:       service,
+		result:        map[string]any{},
+		toolCallIndex: map[int]int{},
+	}
+}
+
+func (a *streamAggregator) Add(chunk []byte) error {
+	var m map[string]any
+	if err := json.Unmarshal(chunk, &m); err != nil {
+		return fmt.Errorf("[Bridge] Failed to unmarshal stream chunk: %s", err.Error())
+	}
+	for k, v := range m {
+		switch {
+		case v == nil:
+			continue
+		case k == "message" && a.service == types.ServiceChat:
+			if msg, ok := v.(map[string]any); ok {
+				a.addMessage(msg)
+			}
+		case k == "response" && a.service == types.ServiceGenerate:
+			if s, ok := v.(string); ok {
+				a.response.WriteString(s)
+			}
+		default:
+			a.result[k] = v
+		}
+	}
+	return nil
+}
+
+func (a *streamAggregator) addMessage(msg map[string]any) {
+	if role, ok := msg["role"].(string); ok && role != "" && a.role == "" {
+		a.role = role
+	}
+	if s, ok := msg["content"].(string); ok {
+		a.content.WriteString(s)
+	}
+	if s, ok := msg["reasoning_content"].(string); ok {
+		a.reasoning.WriteString(s)
+	}
+	calls, _ := msg["tool_calls"].([]any)
+	for _, c := range calls {
+		call, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		index, hasIndex := call["index"].(float64)
+		if !hasIndex {
+			a.toolCalls = append(a.toolCalls, call)
+			continue
+		}
+		pos, exists := a.toolCallIndex[int(index)]
+		if !exists {
+			a.toolCallIndex[int(index)] = len(a.toolCalls)
+			a.toolCalls = append(a.toolCalls, call)
+			continue
+		}
+		mergeToolCallDelta(a.toolCalls[pos], call)
+	}
+}
+
+func mergeToolCallDelta(call, delta map[string]any) {
+	for k, v := range delta {
+		if k != "function" {
+			if s, ok := v.(string); !ok || s != "" {
+				call[k] = v
+			}
+			continue
+		}
+		deltaFunc, ok := v.(map[string]any)
+		if !ok {
+			continue
+		}
+		callFunc, ok := call["function"].(map[string]any)
+		if !ok {
+			call["function"] = deltaFunc
+			continue
+		}
+		for fk, fv := range deltaFunc {
+			if fk == "arguments" {
+				prev, _ := callFunc["arguments"].(string)
+				if s, ok := fv.(string); ok {
+					callFunc["arguments"] = prev + s
+					continue
+				}
+			}
+			if s, ok := fv.(string); !ok || s != "" {
+				callFunc[fk] = fv
+			}
+		}
+	}
+}
+
+func (a *streamAggregator) Result() ([]byte, error) {
+	switch a.service {
+	case types.ServiceChat:
+		role := a.role
+		if role == "" {
+			role = "assistant"
+		}
+		msg := map[string]any{"role": role, "content": a.content.String()}
+		if a.reasoning.Len() > 0 {
+			msg["reasoning_content"] = a.reasoning.String()
+		}
+		if len(a.toolCalls) > 0 {
+			msg["tool_calls"] = a.toolCalls
+		}
+		a.result["message"] = msg
+	case types.ServiceGenerate:
+		a.result["response"] = a.response.String()
+	}
+	a.result["finished"] = true
+	return json.Marshal(a.result)
+}
+
+// toolCallMerger Holds back the tool call deltas of stream chunks in AOG format, and puts the
+// merged tool calls into the last chunk, for flavors taking complete tool calls only. The content
+// streamed so far is kept too, for flavors repeating the whole text at the end (e.g. openai-responses)
+type toolCallMerger struct {
+	aggregator *streamAggregator
+}
+
+func newToolCallMerger() *toolCallMerger {
+	return &toolCallMerger{aggregator: newStreamAggregator(types.ServiceChat)}
+}
+
+// Merge Returns the chunk without the tool call deltas, or with all the merged tool calls if it
+// is the last one. A DropAction is returned if nothing is left in the chunk
+func (m *toolCallMerger) Merge(chunk []byte) ([]byte, error) {
+	var c map[string]any
+	if err := json.Unmarshal(chunk, &c); err != nil {
+		return nil, fmt.Errorf("[Bridge] Failed to unmarshal stream chunk: %s", err.Error())
+	}
+	msg, _ := c["message"].(map[string]any)
+	if content, ok := msg["content"].(string); ok {
+		m.aggregator.content.WriteString(content)
+	}
+	held := false
+	if calls, ok := msg["tool_calls"].([]any); ok && len(calls) > 0 {
+		m.aggregator.addMessage(map[string]any{"tool_calls": calls})
+		delete(msg, "tool_calls")
+		held = true
+	}
+	if finished, _ := c["finished"].(bool); finished && len(m.aggregator.toolCalls) > 0 {
+		if msg == nil {
+			msg = map[string]any{"role": "assistant", "content": ""}
+			c["message"] = msg
+		}
+		msg["tool_calls"] = m.aggregator.toolCalls
+		m.aggregator.toolCalls = nil
+		m.aggregator.toolCallIndex = map[int]int{}
+	} else if held {
+		content, _ := msg["content"].(string)
+		reasoning, _ := msg["reasoning_content"].(string)
+		if content == "" && reasoning == "" {
+			return nil, &types.DropAction{}
+		}
+	}
+	return json.Marshal(c)
+}
+
+// convertStreamMergingToolCalls Convert a stream chunk to the request flavor, merging the tool calls on the way
+func convertStreamMergingToolCalls(from, to APIFlavor, service string, content types.HTTPContent,
+	ctx convert.ConvertContext, merger *toolCallMerger,
+) (types.HTTPContent, error) {
+	aogFlavor, err := GetAPIFlavor(types.FlavorAOG)
+	if err != nil {
+		return types.HTTPContent{}, err
+	}
+	content, err = ConvertBetweenFlavors(from, aogFlavor, service, "stream_response", content, ctx)
+	if err != nil {
+		return content, err
+	}
+	content.Body, err = merger.Merge(content.Body)
+	if err != nil {
+		return content, err
+	}
+	ctx["content"] = merger.aggregator.content.String()
+	return ConvertBetweenFlavors(aogFlavor, to, service, "stream_response", content, ctx)
+}
+
+// synthesizeStreamChunks Split one AOG response into stream chunks in AOG format. The first
+// chunk carries the whole content, and the last one only marks the end with the finish_reason
+func synthesizeStreamChunks(service string, body []byte) ([][]byte, error) {
+	var m map[string]any
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("[Bridge] Failed to unmarshal response: %s", err.Error())
+	}
+	first := make(map[string]any, len(m))
+	last := make(map[string]any, len(m))
+	for k, v := range m {
+		first[k] = v
+		last[k] = v
+	}
+	first["finished"] = false
+	first["finish_reason"] = nil
+	last["finished"] = true
+	switch service {
+	case types.ServiceChat:
+		role := "assistant"
+		if msg, ok := m["message"].(map[string]any); ok {
+			if r, ok := msg["role"].(string); ok && r != "" {
+				role = r
+			}
+		}
+		last["message"] = map[string]any{"role": role, "content": ""}
+	case types.ServiceGenerate:
+		last["response"] = ""
+	}
+	chunks := make([][]byte, 0, 2)
+	for _, c := range []map[string]any{first, last} {
+		b, err := json.Marshal(c)
+		if err != nil {
+			return nil, fmt.Errorf("[Bridge] Failed to marshal stream chunk: %s", err.Error())
+		}
+		chunks = append(chunks, b)
+	}
+	return chunks, nil
+}
+
+// setRequestStreamMode Set the stream field of a JSON request body, keep the body unchanged if it is not a JSON object
+func setRequestStreamMode(body []byte, stream bool) []byte {
+	var m map[string]any
+	if err := json.Unmarshal(body, &m); err != nil {
+		return body
+	}
+	m["stream"] = stream
+	newBody, err := json.Marshal(m)
+	if err != nil {
+		return body
+	}
+	return newBody
+}
+
+// setRequestKeepAlive Ask the local engine to keep the model for keepAlive, unless the request asks itself
+func setRequestKeepAlive(body []byte, keepAlive string) []byte {
+	var m map[string]any
+	if err := json.Unmarshal(body, &m); err != nil {
+		return body
+	}
+	if _, ok := m["keep_alive"]; ok {
+		return body
+	}
+	m["keep_alive"] = keepAlive
+	newBody, err := json.Marshal(m)
+	if err != nil {
+		return body
+	}
+	return newBody
+}
+
+// sendAggregatedStream Read all chunks of the stream response, merge them in AOG format and send
+// back one response in the request flavor
+func (st *ServiceTask) sendAggregatedStream(resp *http.Response, respStreamMode *types.StreamMode,
+	targetFlavor, requestFlavor APIFlavor, ctx convert.ConvertContext, structured *structuredOutput,
+) error {
+	aogFlavor, err := GetAPIFlavor(types.FlavorAOG)
+	if err != nil {
+		return err
+	}
+	logger.LogicLogger.Info("[Service] Stream: Aggregate stream response", "taskid", st.Schedule.Id, "from flavor", targetFlavor.Name(), "to flavor", requestFlavor.Name())
+	aggregator := newStreamAggregator(st.Request.Service)
+	reader := bufio.NewReader(resp.Body)
+	for {
+		chunk, readChunkErr := respStreamMode.ReadChunk(reader)
+		if readChunkErr != nil && readChunkErr != io.EOF { // real error
+			logger.LogicLogger.Error("[Service] Stream: Failed to read chunk", "taskid", st.Schedule.Id, "error", readChunkErr.Error())
+			return readChunkErr
+		}
+		ev := respStreamMode.UnwrapEvent(chunk)
+		if ev.HasData && len(bytes.TrimSpace(ev.Data)) > 0 {
+			ctx["event"] = ev.Event
+			content := types.HTTPContent{Body: ev.Data, Header: resp.Header.Clone()}
+			content, err = ConvertBetweenFlavors(targetFlavor, aogFlavor, st.Request.Service, "stream_response", content, ctx)
+			if err != nil && !types.IsDropAction(err) {
+				return fmt.Errorf("[Service] Failed to convert response: %s", err.Error())
+			}
+			if err == nil {
+				if err = aggregator.Add(content.Body); err != nil {
+					return err
+				}
+			}
+		}
+		if readChunkErr == io.EOF {
+			break
+		}
+	}
+
+	body, err := aggregator.Result()
+	if err != nil {
+		return err
+	}
+	header := resp.Header.Clone()
+	header.Del("Content-Length")
+	header.Set("Content-Type", "application/json")
+	if structured != nil {
+		// validated only, the provider can't be asked again without streaming
+		status := types.StructuredOutputStatusValidated
+		output, violations := structured.validate(body)
+		if len(violations) > 0 {
+			logger.LogicLogger.Warn("[Service] Output doesn't match the JSON schema", "taskid", st.Schedule.Id, "violations", violations)
+			status = types.StructuredOutputStatusInvalid
+		} else {
+			body = setChatOutput(body, output)
+		}
+		header.Set(types.HeaderStructuredOutput, status)
+	}
+	content, err := ConvertBetweenFlavors(aogFlavor, requestFlavor, st.Request.Service, "response", types.HTTPContent{Body: body, Header: header}, ctx)
+	if err != nil {
+		return fmt.Errorf("[Service] Failed to convert response: %s", err.Error())
+	}
+	st.Ch <- &types.ServiceResult{
+		Type: types.ServiceResultDone, TaskId: st.Schedule.Id,
+		StatusCode: resp.StatusCode,
+		HTTP:       content,
+	}
+	return nil
+}
+
+// sendSynthesizedStream Send back the non-stream response of the provider as a stream in the request flavor
+func (st *ServiceTask) sendSynthesizedStream(statusCode int, content types.HTTPContent,
+	targetFlavor, requestFlavor APIFlavor, ctx convert.ConvertContext,
+) error {
+	aogFlavor, err := GetAPIFlavor(types.FlavorAOG)
+	if err != nil {
+		return err
+	}
+	logger.LogicLogger.Info("[Service] Stream: Synthesize stream response", "taskid", st.Schedule.Id, "from flavor", targetFlavor.Name(), "to flavor", requestFlavor.Name())
+	content, err = ConvertBetweenFlavors(targetFlavor, aogFlavor, st.Request.Service, "response", content, ctx)
+	if err != nil {
+		return fmt.Errorf("[Service] Failed to convert response: %s", err.Error())
+	}
+	chunks, err := synthesizeStreamChunks(st.Request.Service, content.Body)
+	if err != nil {
+		return err
+	}
+
+	header := content.Header.Clone()
+	header.Del("Content-Length")
+	header.Set("Content-Type", "text/event-stream")
+	var sendBackStreamMode *types.StreamMode
+	sendChunk := func(body []byte) {
+		st.Ch <- &types.ServiceResult{
+			Type: types.ServiceResultChunk, TaskId: st.Schedule.Id,
+			StatusCode: statusCode,
+			HTTP: types.HTTPContent{
+				Body:   sendBackStreamMode.WrapChunk(body),
+				Header: sendBackStreamMode.Header,
+			},
+		}
+	}
+	var merger *toolCallMerger
+	if requestFlavor.IsStreamToolCallsMerged(st.Request.Service) {
+		merger = newToolCallMerger()
+	}
+	for _, chunk := range chunks {
+		var converted types.HTTPContent
+		if merger != nil {
+			converted, err = convertStreamMergingToolCalls(aogFlavor, requestFlavor, st.Request.Service,
+				types.HTTPContent{Body: chunk, Header: header.Clone()}, ctx, merger)
+		} else {
+			converted, err = ConvertBetweenFlavors(aogFlavor, requestFlavor, st.Request.Service, "stream_response",
+				types.HTTPContent{Body: chunk, Header: header.Clone()}, ctx)
+		}
+		if types.IsDropAction(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("[Service] Failed to convert response: %s", err.Error())
+		}
+		if sendBackStreamMode == nil {
+			sendBackStreamMode = NewStreamMode(converted.Header)
+			for _, prolog := range requestFlavor.GetStreamResponseProlog(st.Request.Service) {
+				sendChunk([]byte(prolog))
+			}
+		}
+		sendChunk(converted.Body)
+	}
+	if sendBackStreamMode != nil {
+		for _, epilog := range requestFlavor.GetStreamResponseEpilog(st.Request.Service) {
+			sendChunk([]byte(epilog))
+		}
+	}
+	st.Ch <- &types.ServiceResult{
+		Type: types.ServiceResultDone, TaskId: st.Schedule.Id,
+		Error:      &types.DropAction{}, // all have been sent as chunks
+		StatusCode: statusCode,
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package schedule
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ligjn/aog/internal/datastore"
+	"github.com/ligjn/aog/internal/types"
+	"github.com/ligjn/aog/version"
+)
+
+func TestStreamAggregator(t *testing.T) {
+	cases := []struct {
+		name    string
+		service string
+		chunks  []string
+		want    string
+	}{
+		{
+			name:    "chat",
+			service: types.ServiceChat,
+			chunks: []string{
+				`{"id": "c1", "model": "m1", "message": {"role": "assistant", "content": "Hel", "reasoning_content": "th"}, "finished": false, "finish_reason": null}`,
+				`{"id": "c1", "model": "m1", "message": {"content": "lo", "reasoning_content": "ink", "tool_calls": [
+					{"index": 0, "id": "call_a", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":"}}]}}`,
+				`{"id": "c1", "model": "m1", "message": {"content": "", "tool_calls": [{"index": 0, "function": {"arguments": "\"Paris\"}"}}]}}`,
+				`{"id": "c1", "model": "m1", "message": {"content": "", "tool_calls": [{"id": "call_b", "function": {"name": "now", "arguments": "{}"}}]},
+					"finished": true, "finish_reason": "tool_calls"}`,
+			},
+			want: `{"id": "c1", "model": "m1", "finished": true, "finish_reason": "tool_calls",
+				"message": {"role": "assistant", "content": "Hello", "reasoning_content": "think", "tool_calls": [
+					{"index": 0, "id": "call_a", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}},
+					{"id": "call_b", "function": {"name": "now", "arguments": "{}"}}
+				]}}`,
+		},
+		{
+			name:    "generate",
+			service: types.ServiceGenerate,
+			chunks: []string{
+				`{"model": "m1", "response": "Once", "finished": false}`,
+				`{"model": "m1", "response": " upon", "finished": true, "finish_reason": "stop"}`,
+			},
+			want: `{"model": "m1", "response": "Once upon", "finished": true, "finish_reason": "stop"}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := newStreamAggregator(c.service)
+			for _, chunk := range c.chunks {
+				if err := a.Add([]byte(chunk)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := a.Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertJSONEqual(t, c.name, got, []byte(c.want))
+		})
+	}
+}
+
+func TestSynthesizeStreamChunks(t *testing.T) {
+	cases := []struct {
+		name    string
+		service string
+		body    string
+		want    []string
+	}{
+		{
+			name:    "chat",
+			service: types.ServiceChat,
+			body:    `{"id": "c1", "message": {"role": "assistant", "content": "Hello"}, "finished": true, "finish_reason": "stop"}`,
+			want: []string{
+				`{"id": "c1", "message": {"role": "assistant", "content": "Hello"}, "finished": false, "finish_reason": null}`,
+				`{"id": "c1", "message": {"role": "assistant", "content": ""}, "finished": true, "finish_reason": "stop"}`,
+			},
+		},
+		{
+			name:    "generate",
+			service: types.ServiceGenerate,
+			body:    `{"response": "Hello", "finished": true, "finish_reason": "length"}`,
+			want: []string{
+				`{"response": "Hello", "finished": false, "finish_reason": null}`,
+				`{"response": "", "finished": true, "finish_reason": "length"}`,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chunks, err := synthesizeStreamChunks(c.service, []byte(c.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chunks) != len(c.want) {
+				t.Fatalf("got %d chunks, want %d", len(chunks), len(c.want))
+			}
+			for i := range chunks {
+				assertJSONEqual(t, c.name, chunks[i], []byte(c.want[i]))
+			}
+		})
+	}
+}
+
+func TestToolCallMerger(t *testing.T) {
+	m := newToolCallMerger()
+	steps := []struct {
+		chunk string
+		// want the chunk left, empty if it is dropped
+		want string
+	}{
+		{
+			chunk: `{"message": {"role": "assistant", "content": "Checking."}, "finished": false}`,
+			want:  `{"message": {"role": "assistant", "content": "Checking."}, "finished": false}`,
+		},
+		{
+			chunk: `{"message": {"content": "", "tool_calls": [{"index": 0, "id": "call_a", "function": {"name": "get_weather", "arguments": "{\"city\""}}]}, "finished": false}`,
+		},
+		{
+			chunk: `{"message": {"content": " Done", "tool_calls": [{"index": 0, "function": {"arguments": ":\"Paris\"}"}}]}, "finished": false}`,
+			want:  `{"message": {"content": " Done"}, "finished": false}`,
+		},
+		{
+			chunk: `{"finished": true, "finish_reason": "tool_calls"}`,
+			want: `{"finished": true, "finish_reason": "tool_calls", "message": {"role": "assistant", "content": "", "tool_calls": [
+				{"index": 0, "id": "call_a", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]}}`,
+		},
+	}
+	for i, step := range steps {
+		got, err := m.Merge([]byte(step.chunk))
+		if step.want == "" {
+			if !types.IsDropAction(err) {
+				t.Fatalf("chunk %d: got %s, %v, want it dropped", i, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("chunk %d: %v", i, err)
+		}
+		assertJSONEqual(t, "merged chunk", got, []byte(step.want))
+	}
+	if content := m.aggregator.content.String(); content != "Checking. Done" {
+		t.Errorf("content kept %q, want %q", content, "Checking. Done")
+	}
+}
+
+// setProviderProperties Replace the properties of the service provider, e.g. the response modes it supports
+func setProviderProperties(t *testing.T, name, properties string) {
+	t.Helper()
+	ds := datastore.GetDefaultDatastore()
+	sp := &types.ServiceProvider{ProviderName: name}
+	if err := ds.Get(context.Background(), sp); err != nil {
+		t.Fatal(err)
+	}
+	sp.Properties = properties
+	if err := ds.Put(context.Background(), sp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamBridgeRoutes(t *testing.T) {
+	setupServiceTest(t)
+	var respond func(w http.ResponseWriter)
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		respond(w)
+	}))
+	defer provider.Close()
+	addRemoteProvider(t, types.ServiceChat, types.FlavorOllama, provider.URL+"/api/chat", "bridge-model")
+	openaiGateway := flavorGateway(t, types.FlavorOpenAI)
+	defer openaiGateway.Close()
+	openaiURL := openaiGateway.URL + "/aog/" + version.AOGVersion + "/api_flavors/openai/v1/chat/completions"
+	anthropicGateway := flavorGateway(t, types.FlavorAnthropic)
+	defer anthropicGateway.Close()
+	anthropicURL := anthropicGateway.URL + "/aog/" + version.AOGVersion + "/api_flavors/anthropic/v1/messages"
+
+	cases := []struct {
+		name       string
+		url        string
+		properties string
+		stream     bool
+		respond    func(w http.ResponseWriter)
+		// want the JSON response, or the lines of the stream response in order
+		want      string
+		wantLines []string
+		lastLine  string
+	}{
+		{
+			name:       "stream to sync",
+			url:        openaiURL,
+			properties: `{"supported_response_mode": ["stream"]}`,
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				io.WriteString(w, `{"model":"bridge-model","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"Hel"},"done":false}`+"\n")
+				io.WriteString(w, `{"model":"bridge-model","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"lo"},"done":false}`+"\n")
+				io.WriteString(w, `{"model":"bridge-model","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`+"\n")
+			},
+			want: `"content":"Hello"`,
+		},
+		{
+			name:       "sync to stream",
+			url:        openaiURL,
+			properties: `{"supported_response_mode": ["sync"]}`,
+			stream:     true,
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, `{"model":"bridge-model","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"Hello"},"done":true,"done_reason":"stop"}`)
+			},
+			wantLines: []string{`"content":"Hello"`, `"finish_reason":"stop"`, "data: [DONE]"},
+			lastLine:  "data: [DONE]",
+		},
+		{
+			// nothing is sent but the epilog, which must not go without the prolog, nor panic
+			name:       "all chunks dropped",
+			url:        openaiURL,
+			properties: `{}`,
+			stream:     true,
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				io.WriteString(w, ": keep-alive\n\n: keep-alive\n\n")
+			},
+			wantLines: []string{"data: [DONE]"},
+			lastLine:  "data: [DONE]",
+		},
+		{
+			// the first chunk is held back by the tool call merger, the prolog goes before the next one
+			name:       "first chunk dropped",
+			url:        anthropicURL,
+			properties: `{}`,
+			stream:     true,
+			respond: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				io.WriteString(w, `{"model":"bridge-model","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"",`+
+					`"tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Paris"}}}]},"done":false}`+"\n")
+				io.WriteString(w, `{"model":"bridge-model","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`+"\n")
+			},
+			wantLines: []string{"event: message_start", "event: content_block_start", `"get_weather"`, "event: message_stop"},
+			lastLine:  `data: {"type": "message_stop"}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setProviderProperties(t, types.FlavorOllama+"_"+types.ServiceChat+"_test", c.properties)
+			respond = c.respond
+			stream := "false"
+			if c.stream {
+				stream = "true"
+			}
+			status, body := postJSON(t, c.url, `{"model": "bridge-model", "stream": `+stream+`, "messages": [{"role": "user", "content": "Hi"}]}`)
+			if status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			if c.want != "" {
+				if !strings.Contains(strings.ReplaceAll(string(body), " ", ""), c.want) {
+					t.Errorf("response %s doesn't contain %s", body, c.want)
+				}
+				return
+			}
+			var lines []string
+			for _, line := range strings.Split(string(body), "\n") {
+				if strings.TrimSpace(line) != "" {
+					lines = append(lines, line)
+				}
+			}
+			rest := string(body)
+			for _, want := range c.wantLines {
+				i := strings.Index(rest, want)
+				if i < 0 {
+					t.Fatalf("stream %q doesn't contain %s in order", lines, want)
+				}
+				rest = rest[i+len(want):]
+			}
+			if last := lines[len(lines)-1]; last != c.lastLine {
+				t.Errorf("stream ends with %q, want %q", last, c.lastLine)
+			}
+		})
+	}
+}
+
+func TestSetRequestKeepAlive(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want string
+		// raw the body is not JSON, kept as it is
+		raw bool
+	}{
+		{name: "set", body: `{"model": "m1", "stream": false}`, want: `{"model": "m1", "stream": false, "keep_alive": "30m0s"}`},
+		{name: "asked by the request", body: `{"model": "m1", "keep_alive": 0}`, want: `{"model": "m1", "keep_alive": 0}`},
+		{name: "not JSON", body: `model=m1`, want: `model=m1`, raw: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := setRequestKeepAlive([]byte(c.body), "30m0s")
+			if c.raw {
+				if string(got) != c.want {
+					t.Errorf("got %s, want the body kept", got)
+				}
+				return
+			}
+			assertJSONEqual(t, c.name, got, []byte(c.want))
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package schedule
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ligjn/aog/config"
+	"github.com/ligjn/aog/internal/convert"
+	"github.com/ligjn/aog/internal/event"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/types"
+)
+
+func TestMain(m *testing.M) {
+	rootDir, err := os.MkdirTemp("", "aog-schedule-test")
+	if err != nil {
+		panic(err)
+	}
+	config.GlobalAOGEnvironment = &config.AOGEnvironment{RootDir: rootDir}
+	logger.InitLogger(logger.LogConfig{LogLevel: "error", LogPath: rootDir})
+	event.InitSysEvents()
+	if err := InitAPIFlavors(); err != nil {
+		panic(err)
+	}
+	for _, flavor := range AllAPIFlavors() {
+		InitProviderDefaultModelTemplate(flavor)
+	}
+	code := m.Run()
+	os.RemoveAll(rootDir)
+	os.Exit(code)
+}
+
+// streamEvent A chunk of a stream in the fixtures, Event is set for event-stream only. Data given as
+// a JSON string is the raw chunk, e.g. "[DONE]"
+type streamEvent struct {
+	Event string          `json:"event,omitempty"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// conversionFixture A conversion of the fixtures in testdata/conversions. Input is converted from
+// the From flavor to the To flavor and compared with Want. With RoundTrip, To must be aog, and Want
+// converted back to the From flavor must give Input again. Chunks are converted as a stream
+// response the way the service task does, and compared with WantChunks
+type conversionFixture struct {
+	From       string          `json:"from"`
+	To         string          `json:"to"`
+	Service    string          `json:"service"`
+	Conversion string          `json:"conversion"`
+	Vars       map[string]any  `json:"vars"`
+	RoundTrip  bool            `json:"round_trip"`
+	Input      json.RawMessage `json:"input"`
+	Want       json.RawMessage `json:"want"`
+	// StreamType content type of the chunks of the From flavor, text/event-stream by default
+	StreamType string        `json:"stream_type"`
+	Chunks     []streamEvent `json:"chunks"`
+	WantChunks []streamEvent `json:"want_chunks"`
+}
+
+func assertJSONEqual(t *testing.T, what string, got, want []byte) {
+	t.Helper()
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("%s: invalid JSON %s: %v", what, got, err)
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		t.Fatalf("%s: invalid JSON in fixture %s: %v", what, want, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("%s:\n got: %s\nwant: %s", what, got, want)
+	}
+}
+
+func convertFixture(t *testing.T, from, to APIFlavor, f *conversionFixture, body []byte) []byte {
+	t.Helper()
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	ctx := convert.ConvertContext{"id": "aog-test"}
+	for k, v := range f.Vars {
+		ctx[k] = v
+	}
+	content, err := ConvertBetweenFlavors(from, to, f.Service, f.Conversion, types.HTTPContent{Body: body, Header: header}, ctx)
+	if err != nil {
+		t.Fatalf("convert %s from %s to %s: %v", f.Conversion, from.Name(), to.Name(), err)
+	}
+	return content.Body
+}
+
+// convertStreamFixture Convert the chunks the way ServiceTask.Run does, and read back what is sent
+func convertStreamFixture(t *testing.T, from, to APIFlavor, f *conversionFixture) []streamEvent {
+	t.Helper()
+	streamType := f.StreamType
+	if streamType == "" {
+		streamType = "text/event-stream"
+	}
+	header := http.Header{"Content-Type": []string{streamType}}
+	ctx := convert.ConvertContext{"id": "aog-test"}
+	var merger *toolCallMerger
+	if to.IsStreamToolCallsMerged(f.Service) {
+		merger = newToolCallMerger()
+	}
+
+	var sent bytes.Buffer
+	var sendMode *types.StreamMode
+	for _, chunk := range f.Chunks {
+		ctx["event"] = chunk.Event
+		body := []byte(chunk.Data)
+		var raw string
+		if json.Unmarshal(chunk.Data, &raw) == nil {
+			body = []byte(raw)
+		}
+		content := types.HTTPContent{Body: body, Header: header.Clone()}
+		var err error
+		if merger != nil {
+			content, err = convertStreamMergingToolCalls(from, to, f.Service, content, ctx, merger)
+		} else {
+			content, err = ConvertBetweenFlavors(from, to, f.Service, "stream_response", content, ctx)
+		}
+		if types.IsDropAction(err) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("convert stream chunk %s: %v", chunk.Data, err)
+		}
+		if sendMode == nil {
+			sendMode = NewStreamMode(content.Header)
+			for _, prolog := range to.GetStreamResponseProlog(f.Service) {
+				sent.Write(sendMode.WrapChunk([]byte(prolog)))
+			}
+		}
+		sent.Write(sendMode.WrapChunk(content.Body))
+	}
+	if sendMode == nil {
+		t.Fatal("all the stream chunks are dropped")
+	}
+	for _, epilog := range to.GetStreamResponseEpilog(f.Service) {
+		sent.Write(sendMode.WrapChunk([]byte(epilog)))
+	}
+
+	readMode := NewStreamMode(sendMode.Header)
+	reader := bufio.NewReader(&sent)
+	var events []streamEvent
+	for {
+		chunk, err := readMode.ReadChunk(reader)
+		if len(bytes.TrimSpace(chunk)) > 0 {
+			ev := readMode.UnwrapEvent(chunk)
+			events = append(events, streamEvent{Event: ev.Event, Data: bytes.TrimSpace(ev.Data)})
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return events
+}
+
+func TestConversionFixtures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "conversions", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no conversion fixtures")
+	}
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var f conversionFixture
+			if err := json.Unmarshal(data, &f); err != nil {
+				t.Fatalf("invalid fixture: %v", err)
+			}
+			runConversionFixture(t, &f)
+		})
+	}
+}
+
+func runConversionFixture(t *testing.T, f *conversionFixture) {
+	t.Helper()
+	from, err := GetAPIFlavor(f.From)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := GetAPIFlavor(f.To)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Chunks) > 0 {
+		got := convertStreamFixture(t, from, to, f)
+		if len(got) != len(f.WantChunks) {
+			// printed one by one, as raw chunks like [DONE] are not JSON
+			var gotChunks []string
+			for _, ev := range got {
+				gotChunks = append(gotChunks, fmt.Sprintf("%s %s", ev.Event, ev.Data))
+			}
+			t.Fatalf("got %d chunks, want %d:\n%s", len(got), len(f.WantChunks), strings.Join(gotChunks, "\n"))
+		}
+		for i := range got {
+			if got[i].Event != f.WantChunks[i].Event {
+				t.Errorf("chunk %d: got event %q, want %q", i, got[i].Event, f.WantChunks[i].Event)
+			}
+			var raw string
+			if json.Unmarshal(f.WantChunks[i].Data, &raw) == nil {
+				if string(got[i].Data) != raw {
+					t.Errorf("chunk %d: got %s, want %s", i, got[i].Data, raw)
+				}
+				continue
+			}
+			assertJSONEqual(t, fmt.Sprintf("chunk %d", i), got[i].Data, f.WantChunks[i].Data)
+		}
+		return
+	}
+
+	got := convertFixture(t, from, to, f, f.Input)
+	assertJSONEqual(t, f.Conversion+" from "+f.From+" to "+f.To, got, f.Want)
+	if f.RoundTrip {
+		if f.To != types.FlavorAOG {
+			t.Fatal("round trip goes through the aog flavor only")
+		}
+		back := convertFixture(t, to, from, f, got)
+		assertJSONEqual(t, f.Conversion+" back to "+f.From, back, f.Input)
+	}
+}
@@ -7,6 +7,7 @@ package schedule
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -37,6 +38,12 @@ type APIFlavor interface {
 	// needs to send an additional "data: [DONE]" after everything is done.
 	GetStreamResponseProlog(service string) []string
 	GetStreamResponseEpilog(service string) []string
+	// IsStreamToolCallsMerged Some flavors (e.g. ollama) send every tool call completely in one chunk
+	// in stream mode, rather than deltas of it, so the deltas need to be merged before sent back
+	IsStreamToolCallsMerged(service string) bool
+	// GetStructuredOutputSupport How response_format is supported by the provider of this flavor, one of
+	// types.StructuredOutputJSONSchema, types.StructuredOutputJSONObject or "" if not supported at all
+	GetStructuredOutputSupport(service string) string
 
 	// Convert This should cover the 6 conversion methods below
 	Convert(service string, conversion string, content types.HTTPContent, ctx convert.ConvertContext) (types.HTTPContent, error)
@@ -67,12 +74,23 @@ func GetAPIFlavor(name string) (APIFlavor, error) {
 	return flavor, nil
 }
 
+var managementHandlers = make(map[string]gin.HandlerFunc)
+
+// RegisterManagementHandler Register the handler of the management endpoints named so in the
+// flavor. Must be called before the routes of the flavor are installed
+func RegisterManagementHandler(flavor, name string, handler gin.HandlerFunc) {
+	managementHandlers[flavor+"/"+name] = handler
+}
+
 //------------------------------------------------------------
 
 type FlavorConversionDef struct {
-	Prologue   []string                  `yaml:"prologue"`
-	Epilogue   []string                  `yaml:"epilogue"`
-	Conversion []types.ConversionStepDef `yaml:"conversion"`
+	Prologue []string `yaml:"prologue"`
+	Epilogue []string `yaml:"epilogue"`
+	// MergeToolCalls only for stream_response_from_aog, see IsStreamToolCallsMerged. The content
+	// streamed so far is given to the conversion as $content then
+	MergeToolCalls bool                      `yaml:"merge_tool_calls"`
+	Conversion     []types.ConversionStepDef `yaml:"conversion"`
 }
 
 type ModelSelector struct {
@@ -85,12 +103,15 @@ type FlavorServiceDef struct {
 	InstallRawRoutes      bool                `yaml:"install_raw_routes"`
 	DefaultModel          string              `yaml:"default_model"`
 	RequestUrl            string              `yaml:"url"`
+	RequestStreamUrl      string              `yaml:"stream_url"`
 	RequestExtraUrl       string              `yaml:"extra_url"`
 	AuthType              string              `yaml:"auth_type"`
 	AuthApplyUrl          string              `yaml:"auth_apply_url"`
+	AuthKeyName           string              `yaml:"auth_key_name"`
 	RequestSegments       int                 `yaml:"request_segments"`
 	ExtraHeaders          string              `yaml:"extra_headers"`
 	SupportModels         []string            `yaml:"support_models"`
+	StructuredOutput      string              `yaml:"structured_output"`
 	ModelSelector         ModelSelector       `yaml:"model_selector"`
 	RequestToAOG          FlavorConversionDef `yaml:"request_to_aog"`
 	RequestFromAOG        FlavorConversionDef `yaml:"request_from_aog"`
@@ -100,10 +121,19 @@ type FlavorServiceDef struct {
 	StreamResponseFromAOG FlavorConversionDef `yaml:"stream_response_from_aog"`
 }
 
+// FlavorManagementDef Management endpoints (e.g. listing or pulling models) of the flavor. They are
+// not forwarded to service providers but answered by the handlers AOG registered by name, see
+// RegisterManagementHandler
+type FlavorManagementDef struct {
+	InstallRawRoutes bool                `yaml:"install_raw_routes"`
+	Endpoints        map[string][]string `yaml:"endpoints"`
+}
+
 type FlavorDef struct {
-	Version  string                      `yaml:"version"`
-	Name     string                      `yaml:"name"`
-	Services map[string]FlavorServiceDef `yaml:"services"`
+	Version    string                      `yaml:"version"`
+	Name       string                      `yaml:"name"`
+	Services   map[string]FlavorServiceDef `yaml:"services"`
+	Management FlavorManagementDef         `yaml:"management"`
 }
 
 var allConversions = []string{
@@ -268,19 +298,7 @@ func (f *ConfigBasedAPIFlavor) InstallRoutes(gateway *gin.Engine) {
 		}
 
 		for _, endpoint := range serviceDef.Endpoints {
-			parts := strings.SplitN(endpoint, " ", 2)
-			endpoint = strings.TrimSpace(endpoint)
-			if len(parts) != 2 {
-				logger.LogicLogger.Error("[Flavor] Invalid endpoint format", "endpoint", endpoint)
-				panic("[Flavor] Invalid endpoint format: " + endpoint)
-			}
-			method := parts[0]
-			path := parts[1]
-			method = strings.TrimSpace(method)
-			path = strings.TrimSpace(path)
-			if !strings.HasPrefix(path, "/") {
-				path = "/" + path
-			}
+			method, path := parseEndpoint(endpoint)
 			handler := makeServiceRequestHandler(f, service)
 
 			// raw routes which doesn't have any aog prefix
@@ -301,6 +319,39 @@ func (f *ConfigBasedAPIFlavor) InstallRoutes(gateway *gin.Engine) {
 		}
 		logger.LogicLogger.Info("[Flavor] Installed routes", "flavor", f.Name(), "service", service)
 	}
+
+	for name, endpoints := range f.Config.Management.Endpoints {
+		handler, ok := managementHandlers[f.Name()+"/"+name]
+		if !ok {
+			logger.LogicLogger.Warn("[Flavor] No handler registered for management endpoints", "flavor", f.Name(), "name", name)
+			continue
+		}
+		for _, endpoint := range endpoints {
+			method, path := parseEndpoint(endpoint)
+			if f.Config.Management.InstallRawRoutes {
+				gateway.Handle(method, path, handler)
+				logger.LogicLogger.Debug("[Flavor] Installed raw management route", "flavor", f.Name(), "name", name, "route", method+" "+path)
+			}
+			aogPath := "/aog/" + vSpec + "/api_flavors/" + f.Name() + path
+			gateway.Handle(method, aogPath, handler)
+			logger.LogicLogger.Debug("[Flavor] Installed management route", "flavor", f.Name(), "name", name, "route", method+" "+aogPath)
+		}
+	}
+}
+
+// parseEndpoint Split the endpoint like "POST /api/chat" into method and path
+func parseEndpoint(endpoint string) (string, string) {
+	endpoint = strings.TrimSpace(endpoint)
+	parts := strings.SplitN(endpoint, " ", 2)
+	if len(parts) != 2 {
+		logger.LogicLogger.Error("[Flavor] Invalid endpoint format", "endpoint", endpoint)
+		panic("[Flavor] Invalid endpoint format: " + endpoint)
+	}
+	path := strings.TrimSpace(parts[1])
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return strings.TrimSpace(parts[0]), path
 }
 
 func (f *ConfigBasedAPIFlavor) GetStreamResponseProlog(service string) []string {
@@ -311,10 +362,24 @@ func (f *ConfigBasedAPIFlavor) GetStreamResponseEpilog(service string) []string
 	return f.Config.getConversionDef(service, "stream_response_from_aog").Epilogue
 }
 
+func (f *ConfigBasedAPIFlavor) IsStreamToolCallsMerged(service string) bool {
+	def := f.Config.getConversionDef(service, "stream_response_from_aog")
+	return def != nil && def.MergeToolCalls
+}
+
+func (f *ConfigBasedAPIFlavor) GetStructuredOutputSupport(service string) string {
+	return f.Config.Services[service].StructuredOutput
+}
+
 func (f *ConfigBasedAPIFlavor) Convert(service, conversion string, content types.HTTPContent, ctx convert.ConvertContext) (types.HTTPContent, error) {
 	pipeline := f.GetConverterPipeline(service, conversion)
 	logger.LogicLogger.Debug("[Flavor] Converting", "flavor", f.Name(), "service", service, "conversion", conversion, "content", content)
-	return pipeline.Convert(content, ctx)
+	res, err := pipeline.Convert(content, ctx)
+	if strings.HasPrefix(conversion, "stream_response") && errors.Is(err, convert.ErrUndefined) {
+		// a chunk the flavor converts into nothing, e.g. an event of no interest in the stream
+		return types.HTTPContent{}, &types.DropAction{}
+	}
+	return res, err
 }
 
 func (f *ConfigBasedAPIFlavor) ConvertRequestToAOG(service string, content types.HTTPContent, ctx convert.ConvertContext) (types.HTTPContent, error) {
@@ -348,9 +413,14 @@ func makeServiceRequestHandler(flavor APIFlavor, service string) func(c *gin.Con
 
 		w := c.Writer
 
-		taskid, ch, err := InvokeService(flavor.Name(), service, c.Request)
+		taskid, ch, err := InvokeService(flavor.Name(), service, c.Request, c.Param("model_action"))
 		if err != nil {
 			logger.LogicLogger.Error("[Handler] Failed to invoke service", "flavor", flavor.Name(), "service", service, "error", err)
+			var httpError *types.HTTPErrorResponse
+			if errors.As(err, &httpError) {
+				http.Error(w, string(httpError.Body), httpError.StatusCode)
+				return
+			}
 			http.NotFound(w, c.Request)
 			return
 		}
@@ -405,6 +475,17 @@ func ConvertBetweenFlavors(from, to APIFlavor, service string, conv string, cont
 	// need conversion, content-length may change
 	content.Header.Del("Content-Length")
 
+	if convert.IsMultipart(content.Header) {
+		// converters work on JSON, flavors turn it back with the multipart converter if needed
+		body, err := convert.MultipartToJSON(content.Header.Get("Content-Type"), content.Body)
+		if err != nil {
+			return types.HTTPContent{}, err
+		}
+		content.Header = content.Header.Clone()
+		content.Header.Set("Content-Type", "application/json")
+		content.Body = body
+	}
+
 	firstConv := conv + "_to_aog"
 	secondConv := conv + "_from_aog"
 	EnsureConversionNameValid(firstConv)
@@ -434,15 +515,17 @@ func ConvertBetweenFlavors(from, to APIFlavor, service string, conv string, cont
 }
 
 type ServiceDefaultInfo struct {
-	Endpoints       []string `json:"endpoints"`
-	DefaultModel    string   `json:"default_model"`
-	RequestUrl      string   `json:"url"`
-	RequestExtraUrl string   `json:"request_extra_url"`
-	AuthType        string   `json:"auth_type"`
-	RequestSegments int      `json:"request_segments"`
-	ExtraHeaders    string   `json:"extra_headers"`
-	SupportModels   []string `json:"support_models"`
-	AuthApplyUrl    string   `json:"auth_apply_url"`
+	Endpoints        []string `json:"endpoints"`
+	DefaultModel     string   `json:"default_model"`
+	RequestUrl       string   `json:"url"`
+	RequestStreamUrl string   `json:"stream_url"`
+	RequestExtraUrl  string   `json:"request_extra_url"`
+	AuthType         string   `json:"auth_type"`
+	AuthKeyName      string   `json:"auth_key_name"`
+	RequestSegments  int      `json:"request_segments"`
+	ExtraHeaders     string   `json:"extra_headers"`
+	SupportModels    []string `json:"support_models"`
+	AuthApplyUrl     string   `json:"auth_apply_url"`
 }
 
 var FlavorServiceDefaultInfoMap = make(map[string]map[string]ServiceDefaultInfo)
@@ -455,15 +538,17 @@ func InitProviderDefaultModelTemplate(flavor APIFlavor) {
 	ServiceDefaultInfoMap := make(map[string]ServiceDefaultInfo)
 	for service, serviceDef := range def.Services {
 		ServiceDefaultInfoMap[service] = ServiceDefaultInfo{
-			Endpoints:       serviceDef.Endpoints,
-			DefaultModel:    serviceDef.DefaultModel,
-			RequestUrl:      serviceDef.RequestUrl,
-			RequestExtraUrl: serviceDef.RequestExtraUrl,
-			RequestSegments: serviceDef.RequestSegments,
-			AuthType:        serviceDef.AuthType,
-			ExtraHeaders:    serviceDef.ExtraHeaders,
-			SupportModels:   serviceDef.SupportModels,
-			AuthApplyUrl:    serviceDef.AuthApplyUrl,
+			Endpoints:        serviceDef.Endpoints,
+			DefaultModel:     serviceDef.DefaultModel,
+			RequestUrl:       serviceDef.RequestUrl,
+			RequestStreamUrl: serviceDef.RequestStreamUrl,
+			RequestExtraUrl:  serviceDef.RequestExtraUrl,
+			RequestSegments:  serviceDef.RequestSegments,
+			AuthType:         serviceDef.AuthType,
+			AuthKeyName:      serviceDef.AuthKeyName,
+			ExtraHeaders:     serviceDef.ExtraHeaders,
+			SupportModels:    serviceDef.SupportModels,
+			AuthApplyUrl:     serviceDef.AuthApplyUrl,
 		}
 	}
 	FlavorServiceDefaultInfoMap[flavor.Name()] = ServiceDefaultInfoMap
@@ -474,6 +559,60 @@ func GetProviderServiceDefaultInfo(flavor string, service string) ServiceDefault
 	return serviceDefaultInfo
 }
 
+// ExpandProviderURL Some APIs (e.g. gemini) put the model in the url path, so the url of service
+// provider may contain the placeholder {model}
+func ExpandProviderURL(rawURL, model string) string {
+	return strings.ReplaceAll(rawURL, "{model}", url.PathEscape(model))
+}
+
+// StreamProviderURL The url to invoke the service provider with in stream mode. Some APIs (e.g.
+// gemini) use another url for stream mode, which is derived from the url of the service provider
+// the way stream_url of the flavor differs from its url, so that custom hosts and proxies are kept
+func StreamProviderURL(rawURL string, info ServiceDefaultInfo) string {
+	if info.RequestStreamUrl == "" || info.RequestStreamUrl == info.RequestUrl {
+		return rawURL
+	}
+	defaultPath, _, _ := strings.Cut(info.RequestUrl, "?")
+	streamPath, streamQuery, _ := strings.Cut(info.RequestStreamUrl, "?")
+	path, query, _ := strings.Cut(rawURL, "?")
+	// e.g. :generateContent -> :streamGenerateContent
+	action, streamAction := urlAction(defaultPath), urlAction(streamPath)
+	if action != streamAction && strings.HasSuffix(path, action) {
+		path = strings.TrimSuffix(path, action) + streamAction
+	}
+	if streamQuery == "" {
+		if query == "" {
+			return path
+		}
+		return path + "?" + query
+	}
+	// e.g. alt=sse, params set by the url of the service provider are kept
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		logger.LogicLogger.Warn("[Service] Invalid query in the url of service provider", "url", rawURL, "error", err)
+		return rawURL
+	}
+	streamParams, _ := url.ParseQuery(streamQuery)
+	for k, v := range streamParams {
+		if !params.Has(k) {
+			params[k] = v
+		}
+	}
+	return path + "?" + params.Encode()
+}
+
+// urlAction The last part of the url path, from the last ":" for the APIs putting the action
+// after the model (e.g. :generateContent), or from the last "/"
+func urlAction(path string) string {
+	if i := strings.LastIndex(path, ":"); i > strings.LastIndex(path, "/") {
+		return path[i:]
+	}
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[i:]
+	}
+	return path
+}
+
 type SignParams struct {
 	SecretId      string           `json:"secret_id"`
 	SecretKey     string           `json:"secret_key"`
@@ -580,6 +719,20 @@ type APIKEYAuthenticator struct {
 	Req      http.Request
 }
 
+// APIKEYQueryAuthenticator the api key is sent as the query parameter ParamName of request url
+type APIKEYQueryAuthenticator struct {
+	AuthInfo  string `json:"auth_info"`
+	ParamName string `json:"param_name"`
+	Req       http.Request
+}
+
+// APIKEYHeaderAuthenticator the api key is sent as is in the header HeaderName
+type APIKEYHeaderAuthenticator struct {
+	AuthInfo   string `json:"auth_info"`
+	HeaderName string `json:"header_name"`
+	Req        http.Request
+}
+
 type TencentSignAuthenticator struct {
 	AuthInfo     string                `json:"auth_info"`
 	Req          http.Request          `json:"request"`
@@ -597,6 +750,55 @@ func (a *APIKEYAuthenticator) Authenticate() error {
 	return nil
 }
 
+func (a *APIKEYQueryAuthenticator) Authenticate() error {
+	var authInfoData ApiKeyAuthInfo
+	err := json.Unmarshal([]byte(a.AuthInfo), &authInfoData)
+	if err != nil {
+		return err
+	}
+	// URL is a pointer, so the change is visible to the request being sent
+	q := a.Req.URL.Query()
+	q.Set(a.ParamName, authInfoData.ApiKey)
+	a.Req.URL.RawQuery = q.Encode()
+	return nil
+}
+
+// apiKeyQueryParam The query parameter the api key of the service provider is sent as
+func apiKeyQueryParam(sp *types.ServiceProvider) string {
+	paramName := GetProviderServiceDefaultInfo(sp.Flavor, sp.ServiceName).AuthKeyName
+	if paramName == "" {
+		paramName = "key"
+	}
+	return paramName
+}
+
+// RedactedURL The url of the request to the service provider to log, with the api key sent in
+// the query replaced
+func RedactedURL(u *url.URL, sp *types.ServiceProvider) string {
+	if sp.AuthType != types.AuthTypeApiKeyQuery {
+		return u.String()
+	}
+	paramName := apiKeyQueryParam(sp)
+	q := u.Query()
+	if !q.Has(paramName) {
+		return u.String()
+	}
+	q.Set(paramName, "REDACTED")
+	redacted := *u
+	redacted.RawQuery = q.Encode()
+	return redacted.String()
+}
+
+func (a *APIKEYHeaderAuthenticator) Authenticate() error {
+	var authInfoData ApiKeyAuthInfo
+	err := json.Unmarshal([]byte(a.AuthInfo), &authInfoData)
+	if err != nil {
+		return err
+	}
+	a.Req.Header.Set(a.HeaderName, authInfoData.ApiKey)
+	return nil
+}
+
 func (s *TencentSignAuthenticator) Authenticate() error {
 	var authInfoData SignAuthInfo
 	err := json.Unmarshal([]byte(s.AuthInfo), &authInfoData)
@@ -651,6 +853,22 @@ func ChooseProviderAuthenticator(p *AuthenticatorParams) Authenticator {
 			AuthInfo: p.ProviderInfo.AuthKey,
 			Req:      *p.Request,
 		}
+	} else if p.ProviderInfo.AuthType == types.AuthTypeApiKeyQuery {
+		authenticator = &APIKEYQueryAuthenticator{
+			AuthInfo:  p.ProviderInfo.AuthKey,
+			ParamName: apiKeyQueryParam(p.ProviderInfo),
+			Req:       *p.Request,
+		}
+	} else if p.ProviderInfo.AuthType == types.AuthTypeApiKeyHeader {
+		headerName := GetProviderServiceDefaultInfo(p.ProviderInfo.Flavor, p.ProviderInfo.ServiceName).AuthKeyName
+		if headerName == "" {
+			headerName = "x-api-key"
+		}
+		authenticator = &APIKEYHeaderAuthenticator{
+			AuthInfo:   p.ProviderInfo.AuthKey,
+			HeaderName: headerName,
+			Req:        *p.Request,
+		}
 	}
 	return authenticator
 }
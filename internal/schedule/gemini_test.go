@@ -0,0 +1,285 @@
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ligjn/aog/config"
+	"github.com/ligjn/aog/internal/datastore"
+	"github.com/ligjn/aog/internal/datastore/sqlite"
+	"github.com/ligjn/aog/internal/types"
+	"github.com/ligjn/aog/version"
+)
+
+var setupServiceOnce sync.Once
+
+// setupServiceTest A datastore in the temp root dir and the scheduler, shared by the tests invoking services
+func setupServiceTest(t *testing.T) {
+	t.Helper()
+	setupServiceOnce.Do(func() {
+		ds, err := sqlite.New(filepath.Join(config.GlobalAOGEnvironment.RootDir, "aog.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ds.Init(); err != nil {
+			t.Fatal(err)
+		}
+		datastore.SetDefaultDatastore(ds)
+		StartScheduler("basic")
+	})
+}
+
+// addRemoteProvider Make the provider with the model the remote provider of the service
+func addRemoteProvider(t *testing.T, service, flavor, providerURL, model string) {
+	t.Helper()
+	ctx := context.Background()
+	ds := datastore.GetDefaultDatastore()
+	name := flavor + "_" + service + "_test"
+	sp := &types.ServiceProvider{
+		ProviderName:  name,
+		ServiceName:   service,
+		ServiceSource: types.ServiceSourceRemote,
+		Method:        http.MethodPost,
+		URL:           providerURL,
+		AuthType:      types.AuthTypeNone,
+		Flavor:        flavor,
+		ExtraHeaders:  "{}",
+		ExtraJSONBody: "{}",
+		Properties:    "{}",
+		Status:        1,
+	}
+	if err := ds.Add(ctx, sp); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Add(ctx, &types.Model{ModelName: model, ProviderName: name, Status: "downloaded"}); err != nil {
+		t.Fatal(err)
+	}
+	s := &types.Service{Name: service}
+	if err := ds.Get(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	s.RemoteProvider = name
+	s.HybridPolicy = "always_remote"
+	if err := ds.Put(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// flavorGateway A server with the routes of the flavor, a real one as the handlers need
+// http.CloseNotifier
+func flavorGateway(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	flavor, err := GetAPIFlavor(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	gateway := gin.New()
+	flavor.InstallRoutes(gateway)
+	return httptest.NewServer(gateway)
+}
+
+func postJSON(t *testing.T, url, body string) (int, []byte) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+func TestGeminiEmbedRoutes(t *testing.T) {
+	setupServiceTest(t)
+	// an ollama provider, so that the requests and responses of gemini are converted both ways
+	var gotRequests []map[string]any
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request to provider: %v", err)
+		}
+		gotRequests = append(gotRequests, req)
+		w.Header().Set("Content-Type", "application/json")
+		inputs, _ := req["input"].([]any)
+		embeddings := make([][]float64, len(inputs))
+		for i := range inputs {
+			embeddings[i] = []float64{float64(i), 0.5}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"model": req["model"], "embeddings": embeddings})
+	}))
+	defer provider.Close()
+	addRemoteProvider(t, types.ServiceEmbed, types.FlavorOllama, provider.URL+"/api/embed", "text-embedding-004")
+	gateway := flavorGateway(t, types.FlavorGemini)
+	defer gateway.Close()
+
+	cases := []struct {
+		action string
+		body   string
+		input  []any
+		want   string
+	}{
+		{
+			action: "embedContent",
+			body:   `{"model": "models/text-embedding-004", "content": {"parts": [{"text": "Hello"}]}}`,
+			input:  []any{"Hello"},
+			want:   `{"embedding": {"values": [0, 0.5]}}`,
+		},
+		{
+			action: "batchEmbedContents",
+			body: `{"requests": [{"model": "models/text-embedding-004", "content": {"parts": [{"text": "Hello"}]}},
+				{"model": "models/text-embedding-004", "content": {"parts": [{"text": "world"}]}}]}`,
+			input: []any{"Hello", "world"},
+			want:  `{"embeddings": [{"values": [0, 0.5]}, {"values": [1, 0.5]}]}`,
+		},
+	}
+	for _, c := range cases {
+		gotRequests = nil
+		path := "/aog/" + version.AOGVersion + "/api_flavors/gemini/v1beta/embeddings/text-embedding-004:" + c.action
+		status, body := postJSON(t, gateway.URL+path, c.body)
+		if status != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", c.action, status, body)
+		}
+		assertJSONEqual(t, c.action+" response", body, []byte(c.want))
+		if len(gotRequests) != 1 {
+			t.Fatalf("%s: provider got %d requests", c.action, len(gotRequests))
+		}
+		if gotRequests[0]["model"] != "text-embedding-004" {
+			t.Errorf("%s: model %v is not taken from the path", c.action, gotRequests[0]["model"])
+		}
+		input, _ := json.Marshal(gotRequests[0]["input"])
+		wantInput, _ := json.Marshal(c.input)
+		if !bytes.Equal(input, wantInput) {
+			t.Errorf("%s: provider got input %s, want %s", c.action, input, wantInput)
+		}
+	}
+}
+
+func TestGeminiStreamRoute(t *testing.T) {
+	setupServiceTest(t)
+	var gotPath, gotQuery string
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery = r.URL.Path, r.URL.RawQuery
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "STOP"}]}`+"\n\n")
+	}))
+	defer provider.Close()
+	// a proxy of gemini configured by the user, kept in stream mode
+	addRemoteProvider(t, types.ServiceChat, types.FlavorGemini, provider.URL+"/proxy/v1beta/models/{model}:generateContent?tenant=t1", "gemini-2.0-flash")
+	gateway := flavorGateway(t, types.FlavorGemini)
+	defer gateway.Close()
+
+	path := "/aog/" + version.AOGVersion + "/api_flavors/gemini/v1beta/models/gemini-2.0-flash:streamGenerateContent"
+	status, body := postJSON(t, gateway.URL+path, `{"contents": [{"role": "user", "parts": [{"text": "Hello"}]}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	if gotPath != "/proxy/v1beta/models/gemini-2.0-flash:streamGenerateContent" {
+		t.Errorf("stream url path %q is not derived from the provider url", gotPath)
+	}
+	if gotQuery != "alt=sse&tenant=t1" {
+		t.Errorf("stream url query %q, want alt=sse&tenant=t1", gotQuery)
+	}
+	if !bytes.Contains(body, []byte(`"Hi"`)) {
+		t.Errorf("unexpected response %s", body)
+	}
+}
+
+func TestStreamProviderURL(t *testing.T) {
+	gemini := GetProviderServiceDefaultInfo("gemini", types.ServiceChat)
+	cases := []struct {
+		url  string
+		info ServiceDefaultInfo
+		want string
+	}{
+		{
+			url:  gemini.RequestUrl,
+			info: gemini,
+			want: gemini.RequestStreamUrl,
+		},
+		{
+			url:  "https://proxy.example.com/gemini/v1beta/models/{model}:generateContent",
+			info: gemini,
+			want: "https://proxy.example.com/gemini/v1beta/models/{model}:streamGenerateContent?alt=sse",
+		},
+		{
+			url:  "https://proxy.example.com/v1beta/models/{model}:generateContent?alt=json",
+			info: gemini,
+			want: "https://proxy.example.com/v1beta/models/{model}:streamGenerateContent?alt=json",
+		},
+		{
+			url:  "https://api.openai.com/v1/chat/completions",
+			info: GetProviderServiceDefaultInfo("openai", types.ServiceChat),
+			want: "https://api.openai.com/v1/chat/completions",
+		},
+	}
+	for _, c := range cases {
+		if got := StreamProviderURL(c.url, c.info); got != c.want {
+			t.Errorf("StreamProviderURL(%s) = %s, want %s", c.url, got, c.want)
+		}
+	}
+}
+
+func TestApplyModelAction(t *testing.T) {
+	sr := &types.ServiceRequest{}
+	applyModelAction(sr, "gemini-2.0-flash:streamGenerateContent")
+	if sr.Model != "gemini-2.0-flash" || sr.Action != "streamGenerateContent" || !sr.AskStreamMode {
+		t.Errorf("unexpected request %+v", sr)
+	}
+	sr = &types.ServiceRequest{Model: "models/text-embedding-004"}
+	applyModelAction(sr, "text-embedding-004:embedContent")
+	if sr.Model != "text-embedding-004" || sr.Action != "embedContent" || sr.AskStreamMode {
+		t.Errorf("unexpected request %+v", sr)
+	}
+}
+
+func TestRedactedURL(t *testing.T) {
+	gemini := &types.ServiceProvider{Flavor: types.FlavorGemini, ServiceName: types.ServiceChat, AuthType: types.AuthTypeApiKeyQuery}
+	cases := []struct {
+		url  string
+		sp   *types.ServiceProvider
+		want string
+	}{
+		{
+			url:  "https://generativelanguage.googleapis.com/v1beta/models/m:streamGenerateContent?alt=sse&key=secret",
+			sp:   gemini,
+			want: "https://generativelanguage.googleapis.com/v1beta/models/m:streamGenerateContent?alt=sse&key=REDACTED",
+		},
+		{
+			url:  "https://proxy.example.com/v1beta/models/m:generateContent",
+			sp:   gemini,
+			want: "https://proxy.example.com/v1beta/models/m:generateContent",
+		},
+		{
+			url:  "https://api.openai.com/v1/chat/completions?key=kept",
+			sp:   &types.ServiceProvider{Flavor: types.FlavorOpenAI, ServiceName: types.ServiceChat, AuthType: types.AuthTypeApiKey},
+			want: "https://api.openai.com/v1/chat/completions?key=kept",
+		},
+	}
+	for _, c := range cases {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := RedactedURL(u, c.sp); got != c.want {
+			t.Errorf("RedactedURL(%s) = %s, want %s", c.url, got, c.want)
+		}
+		if u.String() != c.url {
+			t.Errorf("url of the request changed to %s", u)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package schedule
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ligjn/aog/internal/types"
+	"github.com/ligjn/aog/version"
+)
+
+func TestRerankRoute(t *testing.T) {
+	setupServiceTest(t)
+	var gotRequest map[string]any
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&gotRequest); err != nil {
+			t.Errorf("invalid request to provider: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "rr-1", "results": [
+			{"index": 1, "relevance_score": 0.9, "document": {"text": "Paris is the capital of France"}},
+			{"index": 0, "relevance_score": 0.1, "document": {"text": "Berlin is in Germany"}}
+		]}`))
+	}))
+	defer provider.Close()
+	addRemoteProvider(t, types.ServiceRerank, types.FlavorCohere, provider.URL+"/v2/rerank", "rerank-v3.5")
+	gateway := flavorGateway(t, types.FlavorAOG)
+	defer gateway.Close()
+
+	status, body := postJSON(t, gateway.URL+"/aog/"+version.AOGVersion+"/services/rerank", `{
+		"model": "rerank-v3.5",
+		"query": "capital of France",
+		"documents": ["Berlin is in Germany", "Paris is the capital of France"],
+		"top_n": 2,
+		"return_documents": true
+	}`)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	assertJSONEqual(t, "rerank response", body, []byte(`{"id": "rr-1", "results": [
+		{"index": 1, "relevance_score": 0.9, "document": "Paris is the capital of France"},
+		{"index": 0, "relevance_score": 0.1, "document": "Berlin is in Germany"}
+	]}`))
+	if gotRequest["query"] != "capital of France" || gotRequest["model"] != "rerank-v3.5" {
+		t.Errorf("unexpected request to provider %v", gotRequest)
+	}
+}
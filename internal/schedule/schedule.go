@@ -11,9 +11,11 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ligjn/aog/internal/convert"
 	"github.com/ligjn/aog/internal/datastore"
 	"github.com/ligjn/aog/internal/event"
 	"github.com/ligjn/aog/internal/logger"
@@ -310,18 +312,25 @@ func (ss *BasicServiceScheduler) dispatch(task *ServiceTask) (*types.ServiceTarg
 
 	// Stream Mode Selection
 	// ================
+	// assume it supports both modes if not specified supported_response_mode
+	// if the mode asked is not supported, ask the other mode from the service provider,
+	// and bridge the response back to the mode asked if the service can be bridged
 	stream := task.Request.AskStreamMode
-	// assume it supports stream mode if not specified supported_response_mode
-	if stream && len(providerProperties.SupportedResponseMode) > 0 {
-		stream = false
-		for _, mode := range providerProperties.SupportedResponseMode {
-			if mode == "stream" {
-				stream = true
-				break
-			}
-		}
-		if !stream {
+	aggregateStream, synthesizeStream := false, false
+	if len(providerProperties.SupportedResponseMode) > 0 {
+		supportStream := utils.Contains(providerProperties.SupportedResponseMode, types.ResponseModeStream)
+		supportSync := utils.Contains(providerProperties.SupportedResponseMode, types.ResponseModeSync)
+		canBridge := utils.Contains(types.SupportStreamBridgeService, task.Request.Service)
+		if stream && !supportStream {
+			stream = false
+			synthesizeStream = canBridge
 			slog.Warn("[Schedule] Asks for stream mode but it is not supported by the service provider",
+				"id_service_provider", sp.ProviderName, "supported_response_mode", providerProperties.SupportedResponseMode,
+				"synthesize_stream", synthesizeStream)
+		} else if !stream && !supportSync && supportStream && canBridge {
+			stream = true
+			aggregateStream = true
+			slog.Info("[Schedule] Asks for sync mode but service provider only streams, aggregate the stream",
 				"id_service_provider", sp.ProviderName, "supported_response_mode", providerProperties.SupportedResponseMode)
 		}
 	}
@@ -330,12 +339,24 @@ func (ss *BasicServiceScheduler) dispatch(task *ServiceTask) (*types.ServiceTarg
 	// ================
 	// TODO: XPU selection
 
+	// the keep alive set for the model of the local engine
+	keepAlive := ""
+	if location == types.ServiceSourceLocal && model != "" {
+		ka := &types.ModelKeepAlive{ModelName: model, ProviderName: sp.ProviderName}
+		if err := ds.Get(context.Background(), ka); err == nil {
+			keepAlive = ka.KeepAlive
+		}
+	}
+
 	return &types.ServiceTarget{
-		Location:        location,
-		Stream:          stream,
-		Model:           model,
-		ToFavor:         sp.Flavor,
-		ServiceProvider: sp,
+		Location:         location,
+		Stream:           stream,
+		AggregateStream:  aggregateStream,
+		SynthesizeStream: synthesizeStream,
+		Model:            model,
+		ToFavor:          sp.Flavor,
+		ServiceProvider:  sp,
+		KeepAlive:        keepAlive,
 	}, nil
 }
 
@@ -351,6 +372,9 @@ func (ss *BasicServiceScheduler) schedule() {
 			continue
 		}
 		task.Target = target
+		if target.Model != "" {
+			go recordModelUsage(target.ServiceProvider.ProviderName, target.Model)
+		}
 		ss.removeFromList(task)
 		ss.addToList(task, "running")
 		task.Schedule.IsRunning = true
@@ -391,7 +415,9 @@ func GetScheduler() ServiceScheduler {
 	return scheduler
 }
 
-func InvokeService(fromFlavor string, service string, request *http.Request) (uint64, chan *types.ServiceResult, error) {
+// InvokeService Enqueue the request to the service. modelAction is the path param of the APIs
+// putting the model and the action in the same path segment (e.g. gemini), empty for the others
+func InvokeService(fromFlavor string, service string, request *http.Request, modelAction string) (uint64, chan *types.ServiceResult, error) {
 	logger.LogicLogger.Info("[Service] Invoking Service", "fromFlavor", fromFlavor, "service", service)
 
 	body, err := io.ReadAll(request.Body)
@@ -413,10 +439,28 @@ func InvokeService(fromFlavor string, service string, request *http.Request) (ui
 
 		body = queryParamsJSON
 	} // TODO: handle the case that the body is not json and not text
-	if request.Method == http.MethodPost &&
-		!strings.Contains(request.Header.Get("Content-Type"), "application/json") &&
-		!strings.Contains(request.Header.Get("Content-Type"), "text/plain") {
-		panic("TO SUPPORT non JSON or non text request")
+	// fields of ServiceRequest (e.g. model) are read from the JSON representation of multipart
+	// requests, while the original body is kept to be passed through or converted later
+	requestFields := body
+	contentType := request.Header.Get("Content-Type")
+	if request.Method == http.MethodPost && convert.IsMultipart(request.Header) &&
+		utils.Contains(types.SupportMultipartService, service) {
+		requestFields, err = convert.MultipartToJSON(contentType, body)
+		if err == nil {
+			requestFields, err = formFieldsToRequestFields(requestFields)
+		}
+		if err != nil {
+			logger.LogicLogger.Error("[Service] Failed to parse multipart request", "error", err)
+			return 0, nil, &types.HTTPErrorResponse{StatusCode: http.StatusBadRequest, Body: []byte(err.Error())}
+		}
+	} else if request.Method == http.MethodPost &&
+		!strings.Contains(contentType, "application/json") &&
+		!strings.Contains(contentType, "text/plain") {
+		logger.LogicLogger.Error("[Service] Unsupported content type", "service", service, "content_type", contentType)
+		return 0, nil, &types.HTTPErrorResponse{
+			StatusCode: http.StatusUnsupportedMediaType,
+			Body:       []byte(fmt.Sprintf("content type %s is not supported by service %s", contentType, service)),
+		}
 	}
 	hybridPolicy := "default"
 	if service != "" {
@@ -441,13 +485,47 @@ func InvokeService(fromFlavor string, service string, request *http.Request) (ui
 		HybridPolicy:    hybridPolicy,
 	}
 
-	err = json.Unmarshal(body, &serviceRequest)
+	err = json.Unmarshal(requestFields, &serviceRequest)
 	if err != nil {
 		logger.LogicLogger.Error("[Service] Failed to unmarshal POST request", "error", err, "body", string(body))
 		return 0, nil, err
 	}
+	if modelAction != "" {
+		applyModelAction(&serviceRequest, modelAction)
+	}
 
 	taskid, ch := GetScheduler().Enqueue(&serviceRequest)
 
 	return taskid, ch, err
 }
+
+// applyModelAction The model and the action are taken from the path segment, e.g.
+// gemini-2.0-flash:streamGenerateContent, as the path decides them for such APIs rather than the
+// body (e.g. "model": "models/text-embedding-004"). The stream actions ask for stream mode
+func applyModelAction(sr *types.ServiceRequest, modelAction string) {
+	model, action, _ := strings.Cut(modelAction, ":")
+	if model = strings.TrimPrefix(model, "models/"); model != "" {
+		sr.Model = model
+	}
+	sr.Action = action
+	if strings.HasPrefix(action, "stream") {
+		sr.AskStreamMode = true
+	}
+}
+
+// formFieldsToRequestFields values of form fields are all strings, so the stream flag
+// (e.g. "true") is converted to bool to be unmarshalled into ServiceRequest
+func formFieldsToRequestFields(fields []byte) ([]byte, error) {
+	var m map[string]any
+	if err := json.Unmarshal(fields, &m); err != nil {
+		return nil, err
+	}
+	if s, ok := m["stream"].(string); ok {
+		stream, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stream field: %s", s)
+		}
+		m["stream"] = stream
+	}
+	return json.Marshal(m)
+}
@@ -0,0 +1,71 @@
+package schedule
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ligjn/aog/internal/types"
+	"github.com/ligjn/aog/version"
+)
+
+func TestSpeechToTextMultipart(t *testing.T) {
+	setupServiceTest(t)
+	audio := []byte("RIFF\x00\x01fake wav")
+	var gotModel, gotFormat, gotFilename string
+	var gotAudio []byte
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("provider got no multipart form: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		gotModel, gotFormat = r.FormValue("model"), r.FormValue("response_format")
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("provider got no file: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		gotFilename = header.Filename
+		gotAudio, _ = io.ReadAll(file)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"text": "hello world", "language": "en"})
+	}))
+	defer provider.Close()
+	addRemoteProvider(t, types.ServiceSpeechToText, types.FlavorOpenAI, provider.URL+"/v1/audio/transcriptions", "whisper-1")
+	gateway := flavorGateway(t, types.FlavorAOG)
+	defer gateway.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("model", "whisper-1")
+	part, err := form.CreateFormFile("file", "hello.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(audio)
+	form.Close()
+
+	resp, err := http.Post(gateway.URL+"/aog/"+version.AOGVersion+"/services/speech-to-text", form.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, data)
+	}
+	assertJSONEqual(t, "speech-to-text response", data, []byte(`{"text": "hello world", "language": "en"}`))
+	if gotModel != "whisper-1" || gotFormat != "json" {
+		t.Errorf("provider got model %q and response_format %q", gotModel, gotFormat)
+	}
+	if gotFilename != "hello.wav" || !bytes.Equal(gotAudio, audio) {
+		t.Errorf("provider got file %q with %q, want hello.wav with %q", gotFilename, gotAudio, audio)
+	}
+}
@@ -0,0 +1,278 @@
+package schedule
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ligjn/aog/internal/convert"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/types"
+	"github.com/ligjn/aog/internal/utils"
+)
+
+// maxStructuredOutputRetries upper bound of structured_output_retries asked by request
+const maxStructuredOutputRetries = 3
+
+// structuredOutput The JSON output asked by response_format of a chat request
+// - native: the provider enforces the schema (or the JSON object mode) itself
+// - otherwise: the schema is told to the model by a system prompt, and the response_format is
+// downgraded to what the provider supports. AOG then validates the output against the schema
+type structuredOutput struct {
+	// schema is nil for json_object, then the output only needs to be a JSON object
+	schema any
+	native bool
+	// aogRequest the chat request in AOG flavor, used to build the repair requests
+	aogRequest map[string]any
+	requestCtx convert.ConvertContext
+	// rewritten the request in AOG flavor to send instead, if it is not native
+	rewritten *types.HTTPContent
+}
+
+// prepareStructuredOutput Find out the response_format of the chat request and how the target
+// flavor supports it. Returns nil if no JSON output is asked
+func (st *ServiceTask) prepareStructuredOutput(requestFlavor, targetFlavor APIFlavor, ctx convert.ConvertContext) (*structuredOutput, error) {
+	body := st.Request.HTTP.Body
+	if st.Request.Service != types.ServiceChat ||
+		(!bytes.Contains(body, []byte("format")) && !bytes.Contains(body, []byte("responseMimeType"))) {
+		return nil, nil
+	}
+	aogFlavor, err := GetAPIFlavor(types.FlavorAOG)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ConvertBetweenFlavors(requestFlavor, aogFlavor, st.Request.Service, "request", st.Request.HTTP, ctx)
+	if err != nil {
+		return nil, err
+	}
+	var request map[string]any
+	if err := json.Unmarshal(content.Body, &request); err != nil {
+		return nil, err
+	}
+	format, _ := request["response_format"].(map[string]any)
+	so := &structuredOutput{aogRequest: request, requestCtx: ctx}
+	switch format["type"] {
+	case types.StructuredOutputJSONSchema:
+		jsonSchema, _ := format["json_schema"].(map[string]any)
+		so.schema = jsonSchema["schema"]
+		if so.schema == nil {
+			return nil, fmt.Errorf("schema of json_schema is missing in response_format")
+		}
+	case types.StructuredOutputJSONObject:
+	default:
+		return nil, nil
+	}
+
+	support := targetFlavor.GetStructuredOutputSupport(st.Request.Service)
+	so.native = support == types.StructuredOutputJSONSchema || (so.schema == nil && support == types.StructuredOutputJSONObject)
+	if so.native {
+		return so, nil
+	}
+
+	logger.LogicLogger.Info("[Service] JSON schema is not supported by the provider, ask it by prompt", "taskid", st.Schedule.Id,
+		"flavor", targetFlavor.Name(), "support", support)
+	rewritten := make(map[string]any, len(request))
+	for k, v := range request {
+		rewritten[k] = v
+	}
+	if support == types.StructuredOutputJSONObject {
+		rewritten["response_format"] = map[string]any{"type": types.StructuredOutputJSONObject}
+	} else {
+		delete(rewritten, "response_format")
+	}
+	messages, _ := request["messages"].([]any)
+	rewritten["messages"] = append([]any{map[string]any{"role": "system", "content": so.instruction()}}, messages...)
+	newBody, err := json.Marshal(rewritten)
+	if err != nil {
+		return nil, err
+	}
+	so.rewritten = &types.HTTPContent{Body: newBody, Header: content.Header.Clone()}
+	return so, nil
+}
+
+func (so *structuredOutput) instruction() string {
+	if so.schema == nil {
+		return "Respond only with a valid JSON object, without any other text."
+	}
+	schema, _ := json.Marshal(so.schema)
+	return "Respond only with a JSON object conforming to the following JSON schema, without any other text.\n" + string(schema)
+}
+
+// streamStatus Stream responses are sent as they arrive so can't be validated
+func (so *structuredOutput) streamStatus() string {
+	if so.native {
+		return types.StructuredOutputStatusNative
+	}
+	return types.StructuredOutputStatusUnvalidated
+}
+
+// validate Check the message of the chat response in AOG flavor. Returns the output with markdown
+// code fences removed, and the violations found
+func (so *structuredOutput) validate(aogBody []byte) (string, []string) {
+	var response struct {
+		Message struct {
+			Content any `json:"content"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(aogBody, &response); err != nil {
+		return "", []string{"response is not a chat response: " + err.Error()}
+	}
+	var output string
+	switch c := response.Message.Content.(type) {
+	case string:
+		output = c
+	case []any:
+		var texts []string
+		for _, part := range c {
+			if p, ok := part.(map[string]any); ok && p["type"] == "text" {
+				text, _ := p["text"].(string)
+				texts = append(texts, text)
+			}
+		}
+		output = strings.Join(texts, "")
+	}
+	output = trimCodeFence(output)
+
+	var value any
+	if err := json.Unmarshal([]byte(output), &value); err != nil {
+		return output, []string{"output is not valid JSON: " + err.Error()}
+	}
+	if so.schema == nil {
+		if _, ok := value.(map[string]any); !ok {
+			return output, []string{"output is not a JSON object"}
+		}
+		return output, nil
+	}
+	return output, utils.ValidateJSONSchema(so.schema, value)
+}
+
+// repairRequest Ask the model again with its invalid output and the violations
+func (so *structuredOutput) repairRequest(output string, violations []string) ([]byte, error) {
+	request := make(map[string]any, len(so.aogRequest))
+	for k, v := range so.aogRequest {
+		request[k] = v
+	}
+	messages, _ := so.aogRequest["messages"].([]any)
+	prompt := "The response above does not conform to the required format:\n- " + strings.Join(violations, "\n- ") +
+		"\n" + so.instruction()
+	messages = append(append([]any{}, messages...),
+		map[string]any{"role": "assistant", "content": output},
+		map[string]any{"role": "user", "content": prompt})
+	request["messages"] = messages
+	if so.rewritten != nil {
+		// keep the downgraded response_format and the system prompt of the rewritten request
+		var rewritten map[string]any
+		if err := json.Unmarshal(so.rewritten.Body, &rewritten); err != nil {
+			return nil, err
+		}
+		rewritten["messages"] = append([]any{rewritten["messages"].([]any)[0]}, messages...)
+		request = rewritten
+	}
+	return json.Marshal(request)
+}
+
+func trimCodeFence(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "```") || !strings.HasSuffix(s, "```") || len(s) < 6 {
+		return s
+	}
+	s = strings.TrimSuffix(s, "```")
+	if i := strings.Index(s, "\n"); i >= 0 {
+		s = s[i+1:]
+	} else {
+		s = strings.TrimPrefix(s, "```")
+	}
+	return strings.TrimSpace(s)
+}
+
+// enforceStructuredOutput Validate the non-stream chat response of the provider, and ask the model
+// again with a repair prompt while it doesn't match and retries are allowed. Returns the final
+// response in both target flavor and AOG flavor, the latter has code fences removed from the output.
+// Both tell the validation result in the HeaderStructuredOutput header
+func (st *ServiceTask) enforceStructuredOutput(so *structuredOutput, sp *types.ServiceProvider, targetFlavor APIFlavor,
+	content types.HTTPContent, ctx convert.ConvertContext,
+) (types.HTTPContent, types.HTTPContent, error) {
+	aogFlavor, err := GetAPIFlavor(types.FlavorAOG)
+	if err != nil {
+		return content, content, err
+	}
+	retries := min(max(st.Request.StructuredOutputRetries, 0), maxStructuredOutputRetries)
+	var aogContent types.HTTPContent
+	status := types.StructuredOutputStatusInvalid
+	for attempt := 0; ; attempt++ {
+		aogContent, err = ConvertBetweenFlavors(targetFlavor, aogFlavor, st.Request.Service, "response", content, ctx)
+		if err != nil {
+			return content, content, err
+		}
+		output, violations := so.validate(aogContent.Body)
+		if len(violations) == 0 {
+			status = types.StructuredOutputStatusValidated
+			aogContent.Body = setChatOutput(aogContent.Body, output)
+			break
+		}
+		logger.LogicLogger.Warn("[Service] Output doesn't match the JSON schema", "taskid", st.Schedule.Id,
+			"attempt", attempt, "violations", violations)
+		if attempt >= retries {
+			break
+		}
+
+		body, err := so.repairRequest(output, violations)
+		if err != nil {
+			return content, content, err
+		}
+		request, err := ConvertBetweenFlavors(aogFlavor, targetFlavor, st.Request.Service, "request",
+			types.HTTPContent{Body: body, Header: st.Request.HTTP.Header.Clone()}, so.requestCtx)
+		if err != nil {
+			return content, content, err
+		}
+		repaired, ok := st.invokeForRepair(sp, targetFlavor, request)
+		if !ok {
+			break
+		}
+		content = repaired
+	}
+	content.Header.Set(types.HeaderStructuredOutput, status)
+	aogContent.Header.Set(types.HeaderStructuredOutput, status)
+	return content, aogContent, nil
+}
+
+// invokeForRepair Send the repair request, returns false if no valid non-stream response is got
+func (st *ServiceTask) invokeForRepair(sp *types.ServiceProvider, targetFlavor APIFlavor, request types.HTTPContent) (types.HTTPContent, bool) {
+	resp, err := st.invokeServiceProvider(sp, targetFlavor, request)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		logger.LogicLogger.Error("[Service] Failed to invoke service provider for repair", "taskid", st.Schedule.Id, "error", err.Error())
+		return types.HTTPContent{}, false
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || NewStreamMode(resp.Header).IsStream() {
+		logger.LogicLogger.Warn("[Service] Unexpected response for repair", "taskid", st.Schedule.Id, "status", resp.StatusCode)
+		return types.HTTPContent{}, false
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return types.HTTPContent{}, false
+	}
+	return types.HTTPContent{Body: body, Header: resp.Header.Clone()}, true
+}
+
+// setChatOutput Replace message.content of the chat response in AOG flavor
+func setChatOutput(aogBody []byte, output string) []byte {
+	var response map[string]any
+	if err := json.Unmarshal(aogBody, &response); err != nil {
+		return aogBody
+	}
+	message, ok := response["message"].(map[string]any)
+	if !ok {
+		return aogBody
+	}
+	message["content"] = output
+	newBody, err := json.Marshal(response)
+	if err != nil {
+		return aogBody
+	}
+	return newBody
+}
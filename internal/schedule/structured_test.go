@@ -0,0 +1,180 @@
+package schedule
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ligjn/aog/internal/types"
+)
+
+func TestTrimCodeFence(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{in: `{"a": 1}`, want: `{"a": 1}`},
+		{in: "  {\"a\": 1}\n", want: `{"a": 1}`},
+		{in: "```json\n{\"a\": 1}\n```", want: `{"a": 1}`},
+		{in: "```\n{\"a\": 1}\n```\n", want: `{"a": 1}`},
+		{in: "```{\"a\": 1}```", want: `{"a": 1}`},
+		// not closed, kept as is
+		{in: "```json\n{\"a\": 1}", want: "```json\n{\"a\": 1}"},
+		{in: "`````", want: "`````"},
+	}
+	for _, c := range cases {
+		if got := trimCodeFence(c.in); got != c.want {
+			t.Errorf("trimCodeFence(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestStructuredOutputValidate(t *testing.T) {
+	var schema any
+	if err := json.Unmarshal([]byte(`{"type": "object", "required": ["city"], "properties": {"city": {"type": "string"}}}`), &schema); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name       string
+		schema     any
+		body       string
+		wantOutput string
+		// wantViolation a part of the only violation expected, empty if the output is valid
+		wantViolation string
+	}{
+		{
+			name:       "valid",
+			schema:     schema,
+			body:       `{"message": {"role": "assistant", "content": "{\"city\": \"Paris\"}"}}`,
+			wantOutput: `{"city": "Paris"}`,
+		},
+		{
+			name:       "valid in code fence",
+			schema:     schema,
+			body:       `{"message": {"role": "assistant", "content": "` + "```json\\n{\\\"city\\\": \\\"Paris\\\"}\\n```" + `"}}`,
+			wantOutput: `{"city": "Paris"}`,
+		},
+		{
+			name:       "content parts",
+			schema:     schema,
+			body:       `{"message": {"content": [{"type": "text", "text": "{\"city\": "}, {"type": "image_url"}, {"type": "text", "text": "\"Paris\"}"}]}}`,
+			wantOutput: `{"city": "Paris"}`,
+		},
+		{
+			name:          "schema violated",
+			schema:        schema,
+			body:          `{"message": {"content": "{\"town\": \"Paris\"}"}}`,
+			wantOutput:    `{"town": "Paris"}`,
+			wantViolation: `missing required property "city"`,
+		},
+		{
+			name:          "not JSON",
+			schema:        schema,
+			body:          `{"message": {"content": "Paris"}}`,
+			wantOutput:    "Paris",
+			wantViolation: "output is not valid JSON",
+		},
+		{
+			name:          "not a chat response",
+			schema:        schema,
+			body:          `[]`,
+			wantViolation: "response is not a chat response",
+		},
+		{
+			name:       "json object",
+			body:       `{"message": {"content": "{\"any\": 1}"}}`,
+			wantOutput: `{"any": 1}`,
+		},
+		{
+			name:          "json object asked but array given",
+			body:          `{"message": {"content": "[1]"}}`,
+			wantOutput:    "[1]",
+			wantViolation: "output is not a JSON object",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			so := &structuredOutput{schema: c.schema}
+			output, violations := so.validate([]byte(c.body))
+			if output != c.wantOutput {
+				t.Errorf("output %q, want %q", output, c.wantOutput)
+			}
+			if c.wantViolation == "" {
+				if len(violations) > 0 {
+					t.Errorf("unexpected violations %q", violations)
+				}
+				return
+			}
+			if len(violations) != 1 || !strings.Contains(violations[0], c.wantViolation) {
+				t.Errorf("violations %q, want one with %q", violations, c.wantViolation)
+			}
+		})
+	}
+}
+
+func TestStructuredOutputRepairRequest(t *testing.T) {
+	request := map[string]any{
+		"model":           "m1",
+		"messages":        []any{map[string]any{"role": "user", "content": "Where?"}},
+		"response_format": map[string]any{"type": types.StructuredOutputJSONObject},
+	}
+	violations := []string{"output is not valid JSON"}
+
+	t.Run("native", func(t *testing.T) {
+		so := &structuredOutput{aogRequest: request}
+		body, err := so.repairRequest("Paris", violations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]any
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatal(err)
+		}
+		messages := got["messages"].([]any)
+		if len(messages) != 3 {
+			t.Fatalf("got %d messages, want the request, the output and the repair prompt", len(messages))
+		}
+		if !reflect.DeepEqual(messages[1], map[string]any{"role": "assistant", "content": "Paris"}) {
+			t.Errorf("output message %v", messages[1])
+		}
+		prompt, _ := messages[2].(map[string]any)["content"].(string)
+		if !strings.Contains(prompt, "- output is not valid JSON") || !strings.Contains(prompt, "valid JSON object") {
+			t.Errorf("repair prompt %q doesn't tell the violations and the format", prompt)
+		}
+		if !reflect.DeepEqual(got["response_format"], map[string]any{"type": types.StructuredOutputJSONObject}) {
+			t.Errorf("response_format changed to %v", got["response_format"])
+		}
+		if len(request["messages"].([]any)) != 1 {
+			t.Errorf("messages of the original request changed")
+		}
+	})
+
+	t.Run("rewritten", func(t *testing.T) {
+		system := map[string]any{"role": "system", "content": "Respond only with JSON"}
+		rewritten, err := json.Marshal(map[string]any{
+			"model":           "m1",
+			"messages":        []any{system, map[string]any{"role": "user", "content": "Where?"}},
+			"response_format": map[string]any{"type": types.StructuredOutputJSONObject},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		so := &structuredOutput{aogRequest: request, rewritten: &types.HTTPContent{Body: rewritten}}
+		body, err := so.repairRequest("Paris", violations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]any
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatal(err)
+		}
+		messages := got["messages"].([]any)
+		if len(messages) != 4 || !reflect.DeepEqual(messages[0], system) {
+			t.Fatalf("messages %v, want the system prompt kept before the repair messages", messages)
+		}
+		if messages[2].(map[string]any)["content"] != "Paris" {
+			t.Errorf("output message %v", messages[2])
+		}
+	})
+}
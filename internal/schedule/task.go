@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -54,55 +55,167 @@ func NewStreamMode(header http.Header) *types.StreamMode {
 	return &types.StreamMode{Mode: mode, Header: header.Clone()}
 }
 
+// HandleRequest Normalize the images in request body according to the location of service provider.
+// Remote service providers can't read local files, so paths are sent as base64; local engines
+// read images from files, so urls and base64 data are saved into the download dir first.
 func HandleRequest(st *ServiceTask) error {
+	if st.Request.Service == types.ServiceChat {
+		return handleChatImages(st)
+	}
+	fields, ok := types.ImageFieldsOfService[st.Request.Service]
+	if !ok {
+		return nil
+	}
+	var body map[string]interface{}
+	err := json.Unmarshal(st.Request.HTTP.Body, &body)
+	if err != nil {
+		return err
+	}
+	imageType, typeOk := body["image_type"].(string)
+	_, imageOk := body["image"].(string)
+	if !typeOk && !imageOk {
+		return nil
+	} else if !typeOk && imageOk {
+		return errors.New("image request param lost")
+	} else if typeOk && !imageOk {
+		return errors.New("image_type request param lost")
+	}
+	if !utils.Contains(types.SupportImageType, imageType) {
+		return errors.New("unsupported image type")
+	}
+	if imageType == types.ImageTypeBase64 && st.Target.Location == types.ServiceSourceLocal &&
+		st.Request.Service != types.ServiceImageToImage {
+		// only the image-to-image graph of openvino reads the images from files,
+		// base64 images of the other services are passed to the engine as they are
+		return nil
+	}
 
-	if st.Request.Service == types.ServiceTextToImage {
-		reqBody := st.Request.HTTP.Body
-		var body map[string]interface{}
-		err := json.Unmarshal(reqBody, &body)
-		if err != nil {
-			return err
-		}
-		imageType, typeOk := body["image_type"].(string)
-		image, imageOk := body["image"].(string)
-		if !typeOk && !imageOk {
-			return nil
-		} else if !typeOk && imageOk {
-			return errors.New("image request param lost")
-		} else if typeOk && !imageOk {
-			return errors.New("image_type request param lost")
+	newImageType := imageType
+	for _, field := range fields {
+		image, ok := body[field].(string)
+		if !ok || image == "" {
+			continue
 		}
-		if !utils.Contains(types.SupportImageType, imageType) {
-			return errors.New("unsupported image type")
+		body[field], newImageType, err = normalizeImage(image, imageType, st.Target.Location)
+		if err != nil {
+			return fmt.Errorf("failed to handle %s: %s", field, err.Error())
 		}
-		if imageType == types.ImageTypePath && st.Target.Location == types.ServiceSourceRemote {
-			imgData, err := os.ReadFile(image)
-			if err != nil {
-				return err
+	}
+	body["image_type"] = newImageType
+
+	newReqBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	st.Request.HTTP.Body = newReqBody
+	return nil
+}
+
+// handleChatImages Images of chat messages are image_url content parts, whose url can also be a
+// local file path. Paths are sent as data urls, and so are the urls for local engines which
+// can only take base64 images
+func handleChatImages(st *ServiceTask) error {
+	if !bytes.Contains(st.Request.HTTP.Body, []byte("image_url")) {
+		return nil
+	}
+	var body map[string]interface{}
+	err := json.Unmarshal(st.Request.HTTP.Body, &body)
+	if err != nil {
+		return err
+	}
+	messages, _ := body["messages"].([]interface{})
+	for _, m := range messages {
+		message, _ := m.(map[string]interface{})
+		parts, _ := message["content"].([]interface{})
+		for _, p := range parts {
+			part, _ := p.(map[string]interface{})
+			if part == nil || part["type"] != "image_url" {
+				continue
 			}
-			imgDataBase64Str := base64.StdEncoding.EncodeToString(imgData)
-			body["image"] = imgDataBase64Str
-		} else if imageType == types.ImageTypeUrl && st.Target.Location == types.ServiceSourceLocal {
-			downLoadPath, err := utils.GetDownloadDir()
-			if err != nil {
-				return err
+			switch imageURL := part["image_url"].(type) {
+			case string:
+				part["image_url"], err = normalizeChatImage(imageURL, st.Target.Location)
+			case map[string]interface{}:
+				if u, ok := imageURL["url"].(string); ok {
+					imageURL["url"], err = normalizeChatImage(u, st.Target.Location)
+				}
 			}
-			savePath, err := utils.DownloadFile(image, downLoadPath)
 			if err != nil {
-				return err
+				return fmt.Errorf("failed to handle image of message: %s", err.Error())
 			}
-			body["image"] = savePath
-			// todo() Should the original images be deleted after using the local service?
-			//os.Remove(savePath)
 		}
-		newReqBody, err := json.Marshal(body)
+	}
+
+	newReqBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	st.Request.HTTP.Body = newReqBody
+	return nil
+}
+
+func normalizeChatImage(image string, location string) (string, error) {
+	if strings.HasPrefix(image, "data:") {
+		return image, nil
+	}
+	if strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://") {
+		if location == types.ServiceSourceRemote {
+			return image, nil
+		}
+		path, _, err := normalizeImage(image, types.ImageTypeUrl, location)
 		if err != nil {
-			return err
+			return "", err
 		}
-		st.Request.HTTP.Body = newReqBody
-		return nil
+		image = path
 	}
-	return nil
+	imgData, err := os.ReadFile(image)
+	if err != nil {
+		return "", err
+	}
+	return utils.ImageDataURL(imgData), nil
+}
+
+// normalizeImage returns the image and its new image type to send to the service provider
+func normalizeImage(image string, imageType string, location string) (string, string, error) {
+	switch {
+	case imageType == types.ImageTypePath && location == types.ServiceSourceRemote:
+		imgData, err := os.ReadFile(image)
+		if err != nil {
+			return "", "", err
+		}
+		return base64.StdEncoding.EncodeToString(imgData), types.ImageTypeBase64, nil
+	case imageType == types.ImageTypeUrl && location == types.ServiceSourceLocal:
+		downLoadPath, err := utils.GetDownloadDir()
+		if err != nil {
+			return "", "", err
+		}
+		savePath, err := utils.DownloadFile(image, downLoadPath)
+		if err != nil {
+			return "", "", err
+		}
+		// todo() Should the original images be deleted after using the local service?
+		//os.Remove(savePath)
+		return savePath, types.ImageTypePath, nil
+	case imageType == types.ImageTypeBase64 && location == types.ServiceSourceLocal:
+		// data url, e.g. data:image/png;base64,xxx
+		if i := strings.Index(image, ";base64,"); i >= 0 && strings.HasPrefix(image, "data:") {
+			image = image[i+len(";base64,"):]
+		}
+		imgData, err := base64.StdEncoding.DecodeString(image)
+		if err != nil {
+			return "", "", err
+		}
+		downLoadPath, err := utils.GetDownloadDir()
+		if err != nil {
+			return "", "", err
+		}
+		savePath := filepath.Join(downLoadPath, fmt.Sprintf("aog_%d_%04d.png", time.Now().UnixNano(), rand.Intn(10000)))
+		if err = os.WriteFile(savePath, imgData, 0o644); err != nil {
+			return "", "", err
+		}
+		return savePath, types.ImageTypePath, nil
+	}
+	return image, imageType, nil
 }
 
 func (st *ServiceTask) Run() error {
@@ -119,7 +232,7 @@ func (st *ServiceTask) Run() error {
 			"model_to_use", st.Target.Model, "service_provider_id", st.Target.ServiceProvider.ProviderName,
 			"taskid", st.Schedule.Id)
 	}
-	if st.Request.AskStreamMode && !st.Target.Stream {
+	if st.Request.AskStreamMode && !st.Target.Stream && !st.Target.SynthesizeStream {
 		logger.LogicLogger.Warn("[Service] Request asks for stream mode but it is not supported by the service provider",
 			"service_provider_id", st.Target.ServiceProvider.ProviderName, "taskid", st.Schedule.Id)
 	}
@@ -151,35 +264,56 @@ func (st *ServiceTask) Run() error {
 	conversionNeeded := targetFlavor.Name() != requestFlavor.Name()
 	// GRPC Body
 	content := st.Request.HTTP
+	requestCtx := convert.ConvertContext{"stream": st.Target.Stream}
+	if st.Target.Model != "" {
+		requestCtx["model"] = st.Target.Model
+	}
+	if st.Request.Action != "" {
+		requestCtx["action"] = st.Request.Action
+	}
 
-	// todo Here, the converter of grpc needs to be implemented later.
-	logger.LogicLogger.Debug("[Service] ServiceTask conversion......")
-	if conversionNeeded {
-		logger.LogicLogger.Info("[Service] Converting Request", "taskid", st.Schedule.Id, "from flavor", requestFlavor.Name(), "to flavor", targetFlavor.Name())
-		requestCtx := convert.ConvertContext{"stream": st.Target.Stream}
-		if st.Target.Model != "" {
-			requestCtx["model"] = st.Target.Model
+	structured, err := st.prepareStructuredOutput(requestFlavor, targetFlavor, requestCtx)
+	if err != nil {
+		logger.LogicLogger.Error("[Service] Invalid response_format", "taskid", st.Schedule.Id, "error", err)
+		return fmt.Errorf("[Service] Invalid response_format: %s", err.Error())
+	}
+	// the request rewritten to ask for the JSON schema by prompt is in AOG flavor
+	convertRequestFrom := requestFlavor
+	if structured != nil && structured.rewritten != nil {
+		content = *structured.rewritten
+		convertRequestFrom, err = GetAPIFlavor(types.FlavorAOG)
+		if err != nil {
+			return err
 		}
+	}
+	requestConversionNeeded := targetFlavor.Name() != convertRequestFrom.Name()
 
+	// todo Here, the converter of grpc needs to be implemented later.
+	logger.LogicLogger.Debug("[Service] ServiceTask conversion......")
+	if requestConversionNeeded {
+		logger.LogicLogger.Info("[Service] Converting Request", "taskid", st.Schedule.Id, "from flavor", convertRequestFrom.Name(), "to flavor", targetFlavor.Name())
 		var err error
-		content, err = ConvertBetweenFlavors(requestFlavor, targetFlavor, st.Request.Service, "request", content, requestCtx)
+		content, err = ConvertBetweenFlavors(convertRequestFrom, targetFlavor, st.Request.Service, "request", content, requestCtx)
 		if err != nil {
-			logger.LogicLogger.Error("[Service] Failed to convert request", "taskid", st.Schedule.Id, "from flavor", requestFlavor.Name(),
+			logger.LogicLogger.Error("[Service] Failed to convert request", "taskid", st.Schedule.Id, "from flavor", convertRequestFrom.Name(),
 				"to flavor", targetFlavor.Name(), "error", err, "content", content)
 			return fmt.Errorf("[Service] Failed to convert request: %s", err.Error())
 		}
 	}
 
+	if !requestConversionNeeded && (st.Target.AggregateStream || st.Target.SynthesizeStream) {
+		// no conversion to apply $stream, so the stream mode asked from provider is set directly
+		content.Body = setRequestStreamMode(content.Body, st.Target.Stream)
+	}
+	if st.Target.KeepAlive != "" && strings.ToUpper(st.Target.ServiceProvider.Method) != http.MethodGet {
+		content.Body = setRequestKeepAlive(content.Body, st.Target.KeepAlive)
+	}
+
 	// ------------------------------------------------------------------
 	// 2. Invoke the service provider and get response
 	// ------------------------------------------------------------------
 
-	resp := &http.Response{}
-	if targetFlavor.Name() == types.FlavorOpenvino {
-		resp, err = st.invokeGRPCServiceProvider(sp, content)
-	} else {
-		resp, err = st.invokeHTTPServiceProvider(sp, content)
-	}
+	resp, err := st.invokeServiceProvider(sp, targetFlavor, content)
 	if resp != nil {
 		defer resp.Body.Close()
 	}
@@ -197,6 +331,18 @@ func (st *ServiceTask) Run() error {
 
 	// in case response to send out needs a id but not in response returned from service provider
 	respConvertCtx := convert.ConvertContext{"id": fmt.Sprintf("%d%d", rand.Uint64(), st.Schedule.Id)}
+	if st.Request.Action != "" {
+		// e.g. embedContent and batchEmbedContents of gemini answer differently
+		respConvertCtx["action"] = st.Request.Action
+	}
+
+	isSuccess := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if structured != nil && isSuccess && respStreamMode.IsStream() {
+		resp.Header.Set(types.HeaderStructuredOutput, structured.streamStatus())
+	}
+	if st.Target.AggregateStream && respStreamMode.IsStream() && isSuccess {
+		return st.sendAggregatedStream(resp, respStreamMode, targetFlavor, requestFlavor, respConvertCtx, structured)
+	}
 
 	if !respStreamMode.IsStream() {
 		body, err := io.ReadAll(resp.Body)
@@ -210,8 +356,30 @@ func (st *ServiceTask) Run() error {
 
 		content = types.HTTPContent{Body: body, Header: resp.Header.Clone()}
 
+		convertResponseFrom := targetFlavor
+		if structured != nil && isSuccess {
+			var aogContent types.HTTPContent
+			content, aogContent, err = st.enforceStructuredOutput(structured, sp, targetFlavor, content, respConvertCtx)
+			if err != nil {
+				logger.LogicLogger.Error("[Service] Failed to validate structured output", "taskid", st.Schedule.Id, "error", err)
+				return fmt.Errorf("[Service] Failed to validate structured output: %s", err.Error())
+			}
+			if conversionNeeded {
+				// the output in AOG flavor is cleaned up, e.g. code fences around the JSON removed
+				content = aogContent
+				convertResponseFrom, err = GetAPIFlavor(types.FlavorAOG)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if st.Target.SynthesizeStream && isSuccess {
+			return st.sendSynthesizedStream(resp.StatusCode, content, convertResponseFrom, requestFlavor, respConvertCtx)
+		}
+
 		if conversionNeeded {
-			content, err = ConvertBetweenFlavors(targetFlavor, requestFlavor, st.Request.Service, "response", content, respConvertCtx)
+			content, err = ConvertBetweenFlavors(convertResponseFrom, requestFlavor, st.Request.Service, "response", content, respConvertCtx)
 			if err != nil {
 				logger.LogicLogger.Error("[Service] Failed to convert response", "taskid", st.Schedule.Id, "from flavor", targetFlavor.Name(),
 					"to flavor", requestFlavor.Name(), "error", err, "content", content)
@@ -229,7 +397,31 @@ func (st *ServiceTask) Run() error {
 		reader := bufio.NewReader(resp.Body)
 		prolog := requestFlavor.GetStreamResponseProlog(st.Request.Service)
 		epilog := requestFlavor.GetStreamResponseEpilog(st.Request.Service)
-		var sendBackConvertedStreamMode *types.StreamMode // only used if need conversion
+		var merger *toolCallMerger
+		if requestFlavor.IsStreamToolCallsMerged(st.Request.Service) {
+			merger = newToolCallMerger()
+		}
+		// only used if need conversion. Made from the header of the first chunk sent, as the
+		// conversion may change it, or from the response header if all chunks are dropped
+		sendBackConvertedStreamMode := NewStreamMode(resp.Header)
+		prologSent := false
+		sendProlog := func() {
+			prologSent = true
+			if len(prolog) > 0 {
+				logger.LogicLogger.Info("[Service] Stream: Send Prolog", "taskid", st.Schedule.Id, "prolog", prolog)
+			}
+			for i := range prolog {
+				st.Ch <- &types.ServiceResult{
+					Type: types.ServiceResultChunk, TaskId: st.Schedule.Id,
+					Error:      nil,
+					StatusCode: 200,
+					HTTP: types.HTTPContent{
+						Body:   sendBackConvertedStreamMode.WrapChunk([]byte(prolog[i])),
+						Header: sendBackConvertedStreamMode.Header,
+					},
+				}
+			} // end for prolog
+		}
 		for {
 			chunk, readChunkErr := respStreamMode.ReadChunk(reader)
 			if readChunkErr != nil && readChunkErr != io.EOF { // real error
@@ -247,16 +439,23 @@ func (st *ServiceTask) Run() error {
 			content = types.HTTPContent{Body: chunk, Header: resp.Header.Clone()}
 			var convertErr error
 			if conversionNeeded { // need convert response
-				content.Body = respStreamMode.UnwrapChunk(content.Body)
-				// drop empty content
-				if len(bytes.TrimSpace(chunk)) == 0 {
+				ev := respStreamMode.UnwrapEvent(content.Body)
+				content.Body = ev.Data
+				// flavors can branch on the event name of event-stream, e.g. $event = "message_stop"
+				respConvertCtx["event"] = ev.Event
+				// drop empty content, e.g. keep-alive comments of event-stream
+				if !ev.HasData || len(bytes.TrimSpace(ev.Data)) == 0 {
 					convertErr = &types.DropAction{}
 					logger.LogicLogger.Warn("[Service] Stream: Received Empty Content from Service Provider - Drop it", "taskid", st.Schedule.Id, "content", content)
 				} else {
 					if isFirstTrunk {
 						logger.LogicLogger.Info("[Service] Stream: Convert Many Stream Response ...", "taskid", st.Schedule.Id, "from flavor", targetFlavor.Name(), "to flavor", requestFlavor.Name())
 					}
-					content, convertErr = ConvertBetweenFlavors(targetFlavor, requestFlavor, st.Request.Service, "stream_response", content, respConvertCtx)
+					if merger != nil {
+						content, convertErr = convertStreamMergingToolCalls(targetFlavor, requestFlavor, st.Request.Service, content, respConvertCtx, merger)
+					} else {
+						content, convertErr = ConvertBetweenFlavors(targetFlavor, requestFlavor, st.Request.Service, "stream_response", content, respConvertCtx)
+					}
 					if convertErr != nil && !types.IsDropAction(convertErr) {
 						logger.LogicLogger.Error("[Service] Failed to convert response", "taskid", st.Schedule.Id, "from flavor", targetFlavor.Name(),
 							"to flavor", requestFlavor.Name(), "error", err, "content", content)
@@ -264,33 +463,23 @@ func (st *ServiceTask) Run() error {
 					}
 				}
 				if convertErr == nil { // not drop etc.
-					// target stream mode maybe changed from service provider's
-					if sendBackConvertedStreamMode == nil {
+					// the prolog goes before the first chunk sent, the ones dropped before don't count
+					if !prologSent {
+						// target stream mode maybe changed from service provider's
 						sendBackConvertedStreamMode = NewStreamMode(content.Header) // got a most valid header to send back
+						sendProlog()
 					}
 					content.Body = sendBackConvertedStreamMode.WrapChunk(content.Body)
-					if isFirstTrunk { // send Wrapped prolog
-						if len(prolog) > 0 {
-							logger.LogicLogger.Info("[Service] Stream: Send Prolog", "taskid", st.Schedule.Id, "prolog", prolog)
-						}
-						for i := len(prolog) - 1; i >= 0; i-- {
-							st.Ch <- &types.ServiceResult{
-								Type: types.ServiceResultChunk, TaskId: st.Schedule.Id,
-								Error:      nil,
-								StatusCode: 200,
-								HTTP: types.HTTPContent{
-									Body:   sendBackConvertedStreamMode.WrapChunk([]byte(prolog[i])),
-									Header: sendBackConvertedStreamMode.Header,
-								},
-							}
-						} // end for prolog
-					} // end first trunk
 				} // end conversion succeed
 			} // end conversion
 			isFirstTrunk = false
 
 			if readChunkErr == io.EOF {
 				if conversionNeeded {
+					// all chunks dropped, the client still gets a complete stream
+					if !prologSent {
+						sendProlog()
+					}
 					if len(epilog) > 0 {
 						logger.LogicLogger.Info("[Service] Stream: Send Epilog", "taskid", st.Schedule.Id, "epilog", epilog)
 					}
@@ -327,12 +516,19 @@ func (st *ServiceTask) Run() error {
 	return nil
 }
 
+func (st *ServiceTask) invokeServiceProvider(sp *types.ServiceProvider, targetFlavor APIFlavor, content types.HTTPContent) (*http.Response, error) {
+	if targetFlavor.Name() == types.FlavorOpenvino {
+		return st.invokeGRPCServiceProvider(sp, content)
+	}
+	return st.invokeHTTPServiceProvider(sp, content)
+}
+
 func (st *ServiceTask) invokeGRPCServiceProvider(sp *types.ServiceProvider, content types.HTTPContent) (resp *http.Response, err error) {
 	invokeURL := sp.URL
 	resp = &http.Response{}
 
-	if sp.ServiceName != types.ServiceTextToImage {
-		return nil, fmt.Errorf("currently only support text to image service")
+	if sp.ServiceName != types.ServiceTextToImage && sp.ServiceName != types.ServiceImageToImage {
+		return nil, fmt.Errorf("currently only support text to image and image to image service")
 	}
 
 	conn, err := grpc.Dial(invokeURL, grpc.WithInsecure())
@@ -343,124 +539,171 @@ func (st *ServiceTask) invokeGRPCServiceProvider(sp *types.ServiceProvider, cont
 
 	client := grpc_client.NewGRPCInferenceServiceClient(conn)
 
-	switch sp.ServiceName {
-	case types.ServiceTextToImage:
-		var requestMap map[string]interface{}
-		err := json.Unmarshal(content.Body, &requestMap)
+	var requestMap map[string]interface{}
+	err = json.Unmarshal(content.Body, &requestMap)
+	if err != nil {
+		logger.LogicLogger.Error("[Service] Failed to unmarshal request body", "taskid", st.Schedule.Id, "error", err)
+		return nil, err
+	}
+	prompt, ok := requestMap["prompt"].(string)
+	if !ok {
+		logger.LogicLogger.Error("[Service] Failed to get prompt from request body", "taskid", st.Schedule.Id)
+		return nil, fmt.Errorf("failed to get prompt from request body")
+	}
+	batch, ok := requestMap["batch"].(float64)
+	if !ok {
+		batch = float64(1)
+	}
+	height := 1024
+	width := 1024
+	size, ok := requestMap["size"].(string)
+	if ok {
+		sizeStr := strings.Split(size, "x")
+		if len(sizeStr) != 2 {
+			return nil, fmt.Errorf("invalid size %s, it should be like 1024x1024", size)
+		}
+		num, err := strconv.Atoi(sizeStr[0])
 		if err != nil {
-			logger.LogicLogger.Error("[Service] Failed to unmarshal request body", "taskid", st.Schedule.Id, "error", err)
+			logger.LogicLogger.Error("[Service] Failed to parse size from request body", "taskid", st.Schedule.Id, "error", err)
 			return nil, err
 		}
-		prompt, ok := requestMap["prompt"].(string)
-		if !ok {
-			logger.LogicLogger.Error("[Service] Failed to get prompt from request body", "taskid", st.Schedule.Id)
-			return nil, fmt.Errorf("failed to get prompt from request body")
+		height = num
+
+		num, err = strconv.Atoi(sizeStr[1])
+		if err != nil {
+			logger.LogicLogger.Error("[Service] Failed to parse size from request body", "taskid", st.Schedule.Id, "error", err)
+			return nil, err
 		}
-		batch, ok := requestMap["batch"].(float64)
-		if !ok {
-			batch = float64(1)
-		}
-		height := 1024
-		width := 1024
-		size, ok := requestMap["size"].(string)
-		if ok {
-			sizeStr := strings.Split(size, "x")
-			num, err := strconv.Atoi(sizeStr[0])
-			if err != nil {
-				logger.LogicLogger.Error("[Service] Failed to parse size from request body", "taskid", st.Schedule.Id, "error", err)
-				return nil, err
-			}
-			height = num
+		width = num
+	}
 
-			num, err = strconv.Atoi(sizeStr[1])
+	promptBytes := []byte(prompt)
+	rawContents := make([][]byte, 0) // ovms 实际接收值
+	rawContents = append(rawContents, promptBytes)
+	inferTensorInputs := make([]*grpc_client.ModelInferRequest_InferInputTensor, 0)
+	inferTensorInputs = append(inferTensorInputs, &grpc_client.ModelInferRequest_InferInputTensor{
+		Name:     "prompt",
+		Datatype: "BYTES",
+		Shape:    []int64{1},
+	})
+
+	switch sp.ServiceName {
+	case types.ServiceImageToImage:
+		// the images have been saved as local files by HandleRequest
+		imagePath, ok := requestMap["image"].(string)
+		if !ok {
+			logger.LogicLogger.Error("[Service] Failed to get image from request body", "taskid", st.Schedule.Id)
+			return nil, fmt.Errorf("failed to get image from request body")
+		}
+		imageBytes, err := os.ReadFile(imagePath)
+		if err != nil {
+			logger.LogicLogger.Error("[Service] Failed to read image", "taskid", st.Schedule.Id, "error", err)
+			return nil, err
+		}
+		// the mask is optional, an empty one means editing the whole image
+		maskBytes := make([]byte, 0)
+		if maskPath, ok := requestMap["mask"].(string); ok && maskPath != "" {
+			maskBytes, err = os.ReadFile(maskPath)
 			if err != nil {
-				logger.LogicLogger.Error("[Service] Failed to parse size from request body", "taskid", st.Schedule.Id, "error", err)
+				logger.LogicLogger.Error("[Service] Failed to read mask", "taskid", st.Schedule.Id, "error", err)
 				return nil, err
 			}
-			width = num
 		}
-
-		promptBytes := []byte(prompt)
-		rawContents := make([][]byte, 0) // ovms 实际接收值
-		rawContents = append(rawContents, promptBytes)
-		rawContents = append(rawContents, []byte(fmt.Sprintf("%d", int(batch))))
-		rawContents = append(rawContents, []byte(strconv.Itoa(height)))
-		rawContents = append(rawContents, []byte(strconv.Itoa(width)))
-
-		inferTensorInputs := make([]*grpc_client.ModelInferRequest_InferInputTensor, 0)
+		strength, ok := requestMap["strength"].(float64)
+		if !ok {
+			strength = 0.75
+		}
+		rawContents = append(rawContents, imageBytes, maskBytes, []byte(strconv.FormatFloat(strength, 'f', -1, 64)))
+		// the names differ from the output "image" as the streams of ovms graph should be unique
 		inferTensorInputs = append(inferTensorInputs, &grpc_client.ModelInferRequest_InferInputTensor{
-			Name:     "prompt",
+			Name:     "source_image",
 			Datatype: "BYTES",
 			Shape:    []int64{1},
 		}, &grpc_client.ModelInferRequest_InferInputTensor{
-			Name:     "batch",
+			Name:     "mask_image",
 			Datatype: "BYTES",
 			Shape:    []int64{1},
 		}, &grpc_client.ModelInferRequest_InferInputTensor{
-			Name:     "height",
-			Datatype: "BYTES",
-		}, &grpc_client.ModelInferRequest_InferInputTensor{
-			Name:     "width",
+			Name:     "strength",
 			Datatype: "BYTES",
 		})
+	}
 
-		inferOutputs := []*grpc_client.ModelInferRequest_InferRequestedOutputTensor{
-			{
-				Name: "image",
-			},
-		}
-
-		grpcReq := &grpc_client.ModelInferRequest{
-			ModelName:        st.Target.Model,
-			Inputs:           inferTensorInputs,
-			Outputs:          inferOutputs,
-			RawInputContents: rawContents,
-		}
-
-		inferResponse, err := client.ModelInfer(context.Background(), grpcReq)
-		if err != nil {
-			logger.LogicLogger.Error("[Service] Error processing InferRequest", "taskid", st.Schedule.Id, "error", err)
-			return nil, err
-		}
+	rawContents = append(rawContents, []byte(fmt.Sprintf("%d", int(batch))))
+	rawContents = append(rawContents, []byte(strconv.Itoa(height)))
+	rawContents = append(rawContents, []byte(strconv.Itoa(width)))
+	inferTensorInputs = append(inferTensorInputs, &grpc_client.ModelInferRequest_InferInputTensor{
+		Name:     "batch",
+		Datatype: "BYTES",
+		Shape:    []int64{1},
+	}, &grpc_client.ModelInferRequest_InferInputTensor{
+		Name:     "height",
+		Datatype: "BYTES",
+	}, &grpc_client.ModelInferRequest_InferInputTensor{
+		Name:     "width",
+		Datatype: "BYTES",
+	})
+
+	inferOutputs := []*grpc_client.ModelInferRequest_InferRequestedOutputTensor{
+		{
+			Name: "image",
+		},
+	}
 
-		imageList, err := utils.ParseImageData(inferResponse.RawOutputContents[0])
-		if err != nil {
-			logger.LogicLogger.Error("[Service] Failed to parse image data", "taskid", st.Schedule.Id, "error", err)
-			return nil, err
-		}
+	grpcReq := &grpc_client.ModelInferRequest{
+		ModelName:        st.Target.Model,
+		Inputs:           inferTensorInputs,
+		Outputs:          inferOutputs,
+		RawInputContents: rawContents,
+	}
 
-		outputList := make([]string, 0)
-		fmt.Println("InferResponse:", len(inferResponse.RawOutputContents))
-		for i, imageData := range imageList {
-			now := time.Now()
-			randNum := rand.Intn(10000)
-			DownloadPath, _ := utils.GetDownloadDir()
-			imageName := fmt.Sprintf("%d%02d%02d%02d%02d%02d%04d%01d.png", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), randNum, i)
-			imagePath := fmt.Sprintf("%s/%s", DownloadPath, imageName)
-			err = os.WriteFile(imagePath, imageData, 0o644)
-			if err != nil {
-				logger.LogicLogger.Error("[Service] Failed to write image file", "taskid", st.Schedule.Id, "error", err)
-				continue
-			}
+	inferResponse, err := client.ModelInfer(context.Background(), grpcReq)
+	if err != nil {
+		logger.LogicLogger.Error("[Service] Error processing InferRequest", "taskid", st.Schedule.Id, "error", err)
+		return nil, err
+	}
 
-			outputList = append(outputList, imagePath)
-		}
-		respHeader := make(http.Header)
-		respHeader.Set("Content-Type", "application/json")
-		resp.Header = respHeader
+	if len(inferResponse.RawOutputContents) == 0 {
+		logger.LogicLogger.Error("[Service] No output in InferResponse", "taskid", st.Schedule.Id)
+		return nil, errors.New("[Service] No output in InferResponse")
+	}
+	imageList, err := utils.ParseImageData(inferResponse.RawOutputContents[0])
+	if err != nil {
+		logger.LogicLogger.Error("[Service] Failed to parse image data", "taskid", st.Schedule.Id, "error", err)
+		return nil, err
+	}
 
-		respBody := map[string]interface{}{
-			"local_path": outputList,
-		}
-		respBodyBytes, err := json.Marshal(respBody)
+	outputList := make([]string, 0)
+	logger.LogicLogger.Debug("[Service] Got InferResponse", "taskid", st.Schedule.Id, "images", len(imageList))
+	for i, imageData := range imageList {
+		now := time.Now()
+		randNum := rand.Intn(10000)
+		DownloadPath, _ := utils.GetDownloadDir()
+		imageName := fmt.Sprintf("%d%02d%02d%02d%02d%02d%04d%01d.png", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), randNum, i)
+		imagePath := fmt.Sprintf("%s/%s", DownloadPath, imageName)
+		err = os.WriteFile(imagePath, imageData, 0o644)
 		if err != nil {
-			logger.LogicLogger.Error("[Service] Failed to marshal response body", "taskid", st.Schedule.Id, "error", err)
-			return nil, err
+			logger.LogicLogger.Error("[Service] Failed to write image file", "taskid", st.Schedule.Id, "error", err)
+			continue
 		}
 
-		resp.Body = io.NopCloser(bytes.NewReader(respBodyBytes))
+		outputList = append(outputList, imagePath)
+	}
+	respHeader := make(http.Header)
+	respHeader.Set("Content-Type", "application/json")
+	resp.Header = respHeader
+
+	respBody := map[string]interface{}{
+		"local_path": outputList,
+	}
+	respBodyBytes, err := json.Marshal(respBody)
+	if err != nil {
+		logger.LogicLogger.Error("[Service] Failed to marshal response body", "taskid", st.Schedule.Id, "error", err)
+		return nil, err
 	}
 
+	resp.Body = io.NopCloser(bytes.NewReader(respBodyBytes))
+
 	logger.LogicLogger.Debug("[Service] Response Receiving", "taskid", st.Schedule.Id, "header",
 		fmt.Sprintf("%+v", resp.Header), "task", st)
 
@@ -492,6 +735,11 @@ func (st *ServiceTask) invokeHTTPServiceProvider(sp *types.ServiceProvider, cont
 	invokeURL := sp.URL
 	resp := &http.Response{}
 	serviceDefaultInfo := GetProviderServiceDefaultInfo(st.Target.ToFavor, st.Request.Service)
+	if st.Target.Stream {
+		// some APIs (e.g. gemini) use another url for stream mode
+		invokeURL = StreamProviderURL(sp.URL, serviceDefaultInfo)
+	}
+	invokeURL = ExpandProviderURL(invokeURL, st.Target.Model)
 	if strings.ToUpper(sp.Method) == "GET" {
 		// the body could be empty,
 		// or it is GET with parameters, but the parameters should have been
@@ -504,7 +752,7 @@ func (st *ServiceTask) invokeHTTPServiceProvider(sp *types.ServiceProvider, cont
 					st.Schedule.Id, "error", err, "body", string(content.Body))
 				return nil, err
 			}
-			u, err := url.Parse(sp.URL)
+			u, err := url.Parse(invokeURL)
 			if err != nil {
 				logger.LogicLogger.Error("Error parsing Service Provider's URL", "taskid",
 					st.Schedule.Id, "sp.Url", sp.URL, "error", err)
@@ -539,7 +787,7 @@ func (st *ServiceTask) invokeHTTPServiceProvider(sp *types.ServiceProvider, cont
 		var extraHeader map[string]interface{}
 		err := json.Unmarshal([]byte(sp.ExtraHeaders), &extraHeader)
 		if err != nil {
-			logger.LogicLogger.Error("Error parsing JSON:", err)
+			logger.LogicLogger.Error("Error parsing JSON", "error", err)
 			return nil, err
 		}
 		for k, v := range extraHeader {
@@ -572,12 +820,18 @@ func (st *ServiceTask) invokeHTTPServiceProvider(sp *types.ServiceProvider, cont
 		DisableCompression: true,
 	}
 	client := &http.Client{Transport: transport}
-	logger.LogicLogger.Info("[Service] Request Sending to Service Provider ...", "taskid", st.Schedule.Id, "url", req.URL.String())
+	// the api key may be in the query now, which is not to be logged
+	logURL := RedactedURL(req.URL, sp)
+	logger.LogicLogger.Info("[Service] Request Sending to Service Provider ...", "taskid", st.Schedule.Id, "url", logURL)
 	logger.LogicLogger.Debug("[Service] Request Sending to Service Provider ...", "taskid", st.Schedule.Id, "method",
-		req.Method, "url", req.URL.String(), "header", fmt.Sprintf("%+v", req.Header), "body", string(content.Body))
-	event.SysEvents.NotifyHTTPRequest("invoke_service_provider", req.Method, req.URL.String(), content.Header, content.Body)
+		req.Method, "url", logURL, "header", fmt.Sprintf("%+v", req.Header), "body", string(content.Body))
+	event.SysEvents.NotifyHTTPRequest("invoke_service_provider", req.Method, logURL, content.Header, content.Body)
 	resp, err = client.Do(req)
 	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = logURL
+		}
 		return nil, err
 	}
 
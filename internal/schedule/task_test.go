@@ -0,0 +1,21 @@
+package schedule
+
+import (
+	"testing"
+
+	"github.com/ligjn/aog/internal/types"
+)
+
+func TestHandleRequestKeepsBase64ForTextToImage(t *testing.T) {
+	body := `{"model":"m1","prompt":"a cat","image_type":"base64","image":"aGVsbG8="}`
+	st := &ServiceTask{
+		Request: &types.ServiceRequest{Service: types.ServiceTextToImage, HTTP: types.HTTPContent{Body: []byte(body)}},
+		Target:  &types.ServiceTarget{Location: types.ServiceSourceLocal},
+	}
+	if err := HandleRequest(st); err != nil {
+		t.Fatal(err)
+	}
+	if string(st.Request.HTTP.Body) != body {
+		t.Errorf("base64 image of text-to-image is rewritten: %s", st.Request.HTTP.Body)
+	}
+}
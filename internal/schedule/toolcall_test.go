@@ -0,0 +1,248 @@
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ligjn/aog/internal/types"
+)
+
+// the flavors following the chat completions API of openai
+var openaiStyleFlavors = []string{types.FlavorOpenAI, types.FlavorDeepSeek, types.FlavorAliYun}
+
+const openaiToolCallsRequest = `{
+	"model": "m1",
+	"stream": false,
+	"messages": [
+		{"role": "user", "content": "What's the weather in Paris and Rome?"},
+		{"role": "assistant", "content": "", "tool_calls": [
+			{"id": "call_a", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}},
+			{"id": "call_b", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Rome\"}"}}
+		]},
+		{"role": "tool", "tool_call_id": "call_a", "content": "18 degrees"},
+		{"role": "tool", "tool_call_id": "call_b", "content": "25 degrees"}
+	],
+	"tools": [{"type": "function", "function": {
+		"name": "get_weather",
+		"description": "Get the weather of a city",
+		"parameters": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}
+	}}],
+	"tool_choice": "auto",
+	"parallel_tool_calls": true
+}`
+
+const openaiToolCallsResponse = `{
+	"id": "chatcmpl-1",
+	"model": "m1",
+	"object": "chat.completion",
+	"created": 1700000000,
+	"choices": [{
+		"index": 0,
+		"message": {"role": "assistant", "content": null, "tool_calls": [
+			{"id": "call_a", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+		]},
+		"finish_reason": "tool_calls"
+	}]
+}`
+
+const aogToolCallsResponse = `{
+	"id": "chatcmpl-1",
+	"model": "m1",
+	"created_at": 1700000000,
+	"message": {"role": "assistant", "content": null, "tool_calls": [
+		{"id": "call_a", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+	]},
+	"finished": true,
+	"finish_reason": "tool_calls"
+}`
+
+func openaiStreamChunk(delta string, finishReason string) streamEvent {
+	reason := "null"
+	if finishReason != "" {
+		reason = `"` + finishReason + `"`
+	}
+	return streamEvent{Data: json.RawMessage(fmt.Sprintf(`{"id":"chatcmpl-1","object":"chat.completion.chunk",`+
+		`"created":1700000000,"model":"m1","choices":[{"index":0,"delta":%s,"finish_reason":%s}]}`, delta, reason))}
+}
+
+// the arguments of the calls come in fragments, interleaved between the calls
+var openaiToolCallsStream = []streamEvent{
+	openaiStreamChunk(`{"role":"assistant","content":"Checking."}`, ""),
+	openaiStreamChunk(`{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"get_weather","arguments":""}}]}`, ""),
+	openaiStreamChunk(`{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}`, ""),
+	openaiStreamChunk(`{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"get_weather","arguments":"{\"city\""}}]}`, ""),
+	openaiStreamChunk(`{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}`, ""),
+	openaiStreamChunk(`{"tool_calls":[{"index":1,"function":{"arguments":":\"Rome\"}"}}]}`, ""),
+	openaiStreamChunk(`{}`, "tool_calls"),
+	{Data: json.RawMessage(`"[DONE]"`)},
+}
+
+// ollama clients get the calls whole in the last chunk
+var ollamaMergedToolCallsStream = []streamEvent{
+	{Data: json.RawMessage(`{"model":"m1","created_at":1700000000,"message":{"role":"assistant","content":"Checking."},"done":false}`)},
+	{Data: json.RawMessage(`{"model":"m1","created_at":1700000000,"message":{"content":"","tool_calls":[
+		{"function":{"name":"get_weather","arguments":{"city":"Paris"}}},
+		{"function":{"name":"get_weather","arguments":{"city":"Rome"}}}
+	]},"done":true,"done_reason":"stop"}`)},
+}
+
+type toolCallCase struct {
+	name string
+	f    conversionFixture
+}
+
+func TestToolCallConversions(t *testing.T) {
+	var cases []toolCallCase
+	for _, flavor := range openaiStyleFlavors {
+		cases = append(cases, []toolCallCase{
+			{
+				name: flavor + " request",
+				f: conversionFixture{
+					From: flavor, To: types.FlavorAOG, Service: types.ServiceChat, Conversion: "request",
+					Vars: map[string]any{"model": "m1", "stream": false}, RoundTrip: true,
+					Input: json.RawMessage(openaiToolCallsRequest), Want: json.RawMessage(openaiToolCallsRequest),
+				},
+			},
+			{
+				name: flavor + " response",
+				f: conversionFixture{
+					From: flavor, To: types.FlavorAOG, Service: types.ServiceChat, Conversion: "response", RoundTrip: true,
+					Input: json.RawMessage(openaiToolCallsResponse), Want: json.RawMessage(aogToolCallsResponse),
+				},
+			},
+			{
+				name: flavor + " stream to ollama",
+				f: conversionFixture{
+					From: flavor, To: types.FlavorOllama, Service: types.ServiceChat,
+					Chunks: openaiToolCallsStream, WantChunks: ollamaMergedToolCallsStream,
+				},
+			},
+		}...)
+	}
+	cases = append(cases, []toolCallCase{
+		{
+			// ids of the calls are made from the positions, tool messages answer them in order
+			name: "ollama request",
+			f: conversionFixture{
+				From: types.FlavorOllama, To: types.FlavorAOG, Service: types.ServiceChat, Conversion: "request",
+				Vars: map[string]any{"model": "m1", "stream": false},
+				Input: json.RawMessage(`{
+					"model": "m1",
+					"stream": false,
+					"messages": [
+						{"role": "user", "content": "What's the weather in Paris and Rome?"},
+						{"role": "assistant", "content": "", "tool_calls": [
+							{"function": {"name": "get_weather", "arguments": {"city": "Paris"}}},
+							{"function": {"name": "get_weather", "arguments": {"city": "Rome"}}}
+						]},
+						{"role": "tool", "tool_name": "get_weather", "content": "18 degrees"},
+						{"role": "tool", "tool_name": "get_weather", "content": "25 degrees"}
+					],
+					"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}]
+				}`),
+				Want: json.RawMessage(`{
+					"model": "m1",
+					"stream": false,
+					"messages": [
+						{"role": "user", "content": "What's the weather in Paris and Rome?"},
+						{"role": "assistant", "content": "", "tool_calls": [
+							{"id": "call_1_0", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}},
+							{"id": "call_1_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Rome\"}"}}
+						]},
+						{"role": "tool", "tool_call_id": "call_1_0", "name": "get_weather", "content": "18 degrees"},
+						{"role": "tool", "tool_call_id": "call_1_1", "name": "get_weather", "content": "25 degrees"}
+					],
+					"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}]
+				}`),
+			},
+		},
+		{
+			name: "ollama request from openai",
+			f: conversionFixture{
+				From: types.FlavorOpenAI, To: types.FlavorOllama, Service: types.ServiceChat, Conversion: "request",
+				Vars:  map[string]any{"model": "m1", "stream": false},
+				Input: json.RawMessage(openaiToolCallsRequest),
+				Want: json.RawMessage(`{
+					"model": "m1",
+					"stream": false,
+					"messages": [
+						{"role": "user", "content": "What's the weather in Paris and Rome?"},
+						{"role": "assistant", "content": "", "tool_calls": [
+							{"function": {"name": "get_weather", "arguments": {"city": "Paris"}}},
+							{"function": {"name": "get_weather", "arguments": {"city": "Rome"}}}
+						]},
+						{"role": "tool", "tool_name": "get_weather", "content": "18 degrees"},
+						{"role": "tool", "tool_name": "get_weather", "content": "25 degrees"}
+					],
+					"tools": [{"type": "function", "function": {
+						"name": "get_weather",
+						"description": "Get the weather of a city",
+						"parameters": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}
+					}}],
+					"options": {}
+				}`),
+			},
+		},
+		{
+			name: "ollama response to openai",
+			f: conversionFixture{
+				From: types.FlavorOllama, To: types.FlavorOpenAI, Service: types.ServiceChat, Conversion: "response",
+				Input: json.RawMessage(`{
+					"model": "m1",
+					"created_at": "2025-01-01T00:00:00Z",
+					"message": {"role": "assistant", "content": "", "tool_calls": [
+						{"function": {"name": "get_weather", "arguments": {"city": "Paris"}}}
+					]},
+					"done": true,
+					"done_reason": "stop"
+				}`),
+				Want: json.RawMessage(`{
+					"id": "aog-test",
+					"model": "m1",
+					"object": "chat.completion",
+					"created": "2025-01-01T00:00:00Z",
+					"choices": [{
+						"index": 0,
+						"message": {"role": "assistant", "content": "", "tool_calls": [
+							{"id": "call_aog-test_0", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+						]},
+						"finish_reason": "tool_calls"
+					}]
+				}`),
+			},
+		},
+		{
+			name: "ollama stream to openai",
+			f: conversionFixture{
+				From: types.FlavorOllama, To: types.FlavorOpenAI, Service: types.ServiceChat, StreamType: "application/x-ndjson",
+				Chunks: []streamEvent{
+					{Data: json.RawMessage(`{"model":"m1","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"Checking."},"done":false}`)},
+					{Data: json.RawMessage(`{"model":"m1","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":"","tool_calls":[
+						{"function":{"name":"get_weather","arguments":{"city":"Paris"}}},
+						{"function":{"name":"get_weather","arguments":{"city":"Rome"}}}
+					]},"done":false}`)},
+					{Data: json.RawMessage(`{"model":"m1","created_at":"2025-01-01T00:00:00Z","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}`)},
+				},
+				WantChunks: []streamEvent{
+					{Data: json.RawMessage(`{"id":"aog-test","model":"m1","object":"chat.completion.chunk","created":"2025-01-01T00:00:00Z",
+						"choices":[{"index":0,"delta":{"role":"assistant","content":"Checking."}}]}`)},
+					{Data: json.RawMessage(`{"id":"aog-test","model":"m1","object":"chat.completion.chunk","created":"2025-01-01T00:00:00Z",
+						"choices":[{"index":0,"delta":{"role":"assistant","content":"","tool_calls":[
+							{"index":0,"id":"call_aog-test_0","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}},
+							{"index":1,"id":"call_aog-test_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Rome\"}"}}
+						]}}]}`)},
+					{Data: json.RawMessage(`{"id":"aog-test","model":"m1","object":"chat.completion.chunk","created":"2025-01-01T00:00:00Z",
+						"choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":"stop"}]}`)},
+					{Data: json.RawMessage(`"[DONE]"`)},
+				},
+			},
+		},
+	}...)
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			runConversionFixture(t, &c.f)
+		})
+	}
+}
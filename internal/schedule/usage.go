@@ -0,0 +1,50 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ligjn/aog/internal/datastore"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/types"
+)
+
+// modelUsageInterval the use of a model is written to the datastore at most once in it
+const modelUsageInterval = time.Minute
+
+var (
+	modelUsageMu sync.Mutex
+	// modelUsageWritten when the use of the models is last written, keyed by provider and model
+	modelUsageWritten = make(map[[2]string]time.Time)
+)
+
+// recordModelUsage Keep when the model of the service provider is last used
+func recordModelUsage(providerName, model string) {
+	now := time.Now()
+	key := [2]string{providerName, model}
+	modelUsageMu.Lock()
+	if now.Sub(modelUsageWritten[key]) < modelUsageInterval {
+		modelUsageMu.Unlock()
+		return
+	}
+	modelUsageWritten[key] = now
+	modelUsageMu.Unlock()
+
+	ctx := context.Background()
+	ds := datastore.GetDefaultDatastore()
+	usage := &types.ModelUsage{ModelName: model, ProviderName: providerName}
+	err := ds.Get(ctx, usage)
+	if errors.Is(err, datastore.ErrEntityInvalid) {
+		usage.LastUsedAt = now
+		err = ds.Add(ctx, usage)
+	} else if err == nil {
+		usage.LastUsedAt = now
+		err = ds.Put(ctx, usage)
+	}
+	if err != nil {
+		logger.LogicLogger.Warn("[Schedule] Failed to record model usage", "model", model,
+			"service_provider", providerName, "error", err)
+	}
+}
@@ -0,0 +1,380 @@
+package server
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ligjn/aog/internal/api/dto"
+	"github.com/ligjn/aog/internal/datastore"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/provider"
+	"github.com/ligjn/aog/internal/types"
+	"github.com/ligjn/aog/internal/utils"
+	"github.com/ligjn/aog/internal/utils/bcode"
+	"github.com/ligjn/aog/version"
+)
+
+const (
+	// modelBundleMetaFile the metadata of the bundle, the last entry of the tar
+	modelBundleMetaFile = "aog-bundle.json"
+	// modelBundleFilesDir the files of the model are under it in the tar
+	modelBundleFilesDir = "files/"
+	modelBundleVersion  = 1
+)
+
+// modelBundleMeta What a model bundle holds, and the checksums to verify it by
+type modelBundleMeta struct {
+	Version      int               `json:"version"`
+	AOGVersion   string            `json:"aog_version"`
+	Engine       string            `json:"engine"`
+	ModelName    string            `json:"model_name"`
+	ServiceName  string            `json:"service_name"`
+	ProviderName string            `json:"provider_name"`
+	CreatedAt    time.Time         `json:"created_at"`
+	Files        []modelBundleFile `json:"files"`
+}
+
+type modelBundleFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// findBundleModel The downloaded model of a local engine able to make bundles, of the provider if it's given
+func findBundleModel(ctx context.Context, modelName, providerName string) (*types.Model, *types.ServiceProvider, provider.ModelBundler, error) {
+	m, sp, modelEngine, err := findLocalModel(ctx, modelName, providerName, func(e provider.ModelServiceProvider) bool {
+		_, ok := e.(provider.ModelBundler)
+		return ok
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return m, sp, modelEngine.(provider.ModelBundler), nil
+}
+
+// ExportModelBundle Pack the files of a downloaded model in the storage of its engine into a tar,
+// for the machines which can't download it
+func ExportModelBundle(ctx context.Context, request *dto.ExportModelRequest) (*dto.ExportModelResponse, error) {
+	m, sp, bundler, err := findBundleModel(ctx, strings.ToLower(request.ModelName), request.ProviderName)
+	if err != nil {
+		return nil, err
+	}
+	files, err := bundler.ModelFiles(ctx, m.ModelName)
+	if err != nil {
+		logger.LogicLogger.Error("[Model Bundle] Get model files error: " + err.Error())
+		return nil, bcode.ErrModelExport
+	}
+
+	meta := &modelBundleMeta{
+		Version:      modelBundleVersion,
+		AOGVersion:   version.AOGVersion,
+		Engine:       sp.Flavor,
+		ModelName:    m.ModelName,
+		ServiceName:  sp.ServiceName,
+		ProviderName: sp.ProviderName,
+		CreatedAt:    time.Now(),
+	}
+	if err := writeModelBundle(request.Path, files, meta); err != nil {
+		logger.LogicLogger.Error("[Model Bundle] Write bundle error: " + err.Error())
+		os.Remove(request.Path)
+		return nil, bcode.ErrModelExport
+	}
+	logger.LogicLogger.Info("[Model Bundle] Model exported", "model", m.ModelName, "path", request.Path)
+
+	return &dto.ExportModelResponse{
+		Bcode:     *bcode.ModelCode,
+		ModelName: m.ModelName,
+		Engine:    sp.Flavor,
+	}, nil
+}
+
+func writeModelBundle(bundlePath string, files map[string]string, meta *modelBundleMeta) error {
+	f, err := os.Create(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		file, err := addBundleFile(tw, p, files[p])
+		if err != nil {
+			return fmt.Errorf("add %s: %v", p, err)
+		}
+		meta.Files = append(meta.Files, *file)
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    modelBundleMetaFile,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: meta.CreatedAt,
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// addBundleFile Add the file to the tar, its checksum is computed while it's written
+func addBundleFile(tw *tar.Writer, bundlePath, localPath string) (*modelBundleFile, error) {
+	in, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    modelBundleFilesDir + bundlePath,
+		Mode:    0o644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}); err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, h), in); err != nil {
+		return nil, err
+	}
+	return &modelBundleFile{Path: bundlePath, Size: info.Size(), Sha256: fmt.Sprintf("%x", h.Sum(nil))}, nil
+}
+
+// InstallModelBundle Unpack a bundle made by ExportModelBundle, verify the checksums of its files,
+// then install them into the engine and add the model as downloaded
+func InstallModelBundle(ctx context.Context, request *dto.InstallModelBundleRequest) (*dto.InstallModelBundleResponse, error) {
+	downloadDir, err := utils.GetDownloadDir()
+	if err != nil {
+		return nil, bcode.ErrServer
+	}
+	// unpacked next to the engines' storage rather than the system temp dir, models are large
+	dir, err := os.MkdirTemp(downloadDir, "bundle-")
+	if err != nil {
+		return nil, bcode.ErrServer
+	}
+	defer os.RemoveAll(dir)
+
+	meta, err := unpackModelBundle(request.Path, dir)
+	if err != nil {
+		logger.LogicLogger.Error("[Model Bundle] Unpack bundle error: " + err.Error())
+		return nil, bcode.ErrModelBundle
+	}
+	if err := verifyModelBundle(dir, meta); err != nil {
+		logger.LogicLogger.Error("[Model Bundle] Verify bundle error: " + err.Error())
+		return nil, bcode.ErrModelBundleChecksum
+	}
+
+	ds := datastore.GetDefaultDatastore()
+	sp := &types.ServiceProvider{ProviderName: request.ProviderName}
+	if sp.ProviderName == "" {
+		service := &types.Service{Name: meta.ServiceName}
+		if err := ds.Get(ctx, service); err != nil || service.LocalProvider == "" {
+			return nil, bcode.ErrServiceRecordNotFound
+		}
+		sp.ProviderName = service.LocalProvider
+	}
+	err = ds.Get(ctx, sp)
+	if errors.Is(err, datastore.ErrEntityInvalid) {
+		return nil, bcode.ErrServiceRecordNotFound
+	} else if err != nil {
+		return nil, bcode.ErrServer
+	}
+	if sp.ServiceSource != types.ServiceSourceLocal || sp.Flavor != meta.Engine {
+		logger.LogicLogger.Error("[Model Bundle] Bundle of " + meta.Engine + " can't be installed for " + sp.ProviderName)
+		return nil, bcode.ErrModelBundle
+	}
+	modelEngine, err := provider.GetModelEngine(sp.Flavor)
+	if err != nil {
+		return nil, bcode.ErrModelEngineNotFound
+	}
+	bundler, ok := modelEngine.(provider.ModelBundler)
+	if !ok {
+		return nil, bcode.ErrModelBundle
+	}
+	err = bundler.InstallModelFiles(ctx, &types.ImportModelRequest{
+		Model:     meta.ModelName,
+		Path:      filepath.Join(dir, modelBundleFilesDir),
+		ModelType: sp.ServiceName,
+	})
+	if err != nil {
+		logger.LogicLogger.Error("[Model Bundle] Install model files error: " + err.Error())
+		return nil, bcode.ErrModelInstallBundle
+	}
+
+	m := &types.Model{ProviderName: sp.ProviderName, ModelName: meta.ModelName}
+	err = ds.Get(ctx, m)
+	if errors.Is(err, datastore.ErrEntityInvalid) {
+		m.Status = "downloaded"
+		if err := ds.Add(ctx, m); err != nil {
+			return nil, bcode.ErrAddModel
+		}
+	} else if err != nil {
+		return nil, bcode.ErrServer
+	}
+	modelDownloaded(ctx, sp, m)
+	logger.LogicLogger.Info("[Model Bundle] Model installed", "model", m.ModelName, "provider", sp.ProviderName)
+
+	return &dto.InstallModelBundleResponse{
+		Bcode:     *bcode.ModelCode,
+		ModelName: meta.ModelName,
+		Engine:    meta.Engine,
+	}, nil
+}
+
+func unpackModelBundle(bundlePath, dir string) (*modelBundleMeta, error) {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var meta *modelBundleMeta
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if name == modelBundleMetaFile {
+			meta = new(modelBundleMeta)
+			if err := json.NewDecoder(tr).Decode(meta); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", modelBundleMetaFile, err)
+			}
+			continue
+		}
+		// cleaned, a name under files/ can't be out of the dir
+		if !strings.HasPrefix(name, modelBundleFilesDir) {
+			return nil, fmt.Errorf("unexpected entry %s", hdr.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+			return nil, err
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return nil, err
+		}
+		if err := out.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if meta == nil {
+		return nil, fmt.Errorf("%s not found", modelBundleMetaFile)
+	}
+	if meta.Version > modelBundleVersion {
+		return nil, fmt.Errorf("bundle version %d is not supported", meta.Version)
+	}
+	if meta.ModelName == "" || meta.Engine == "" || len(meta.Files) == 0 {
+		return nil, fmt.Errorf("incomplete %s", modelBundleMetaFile)
+	}
+	// the engines make paths of their storage from the model name
+	if !validBundlePath(meta.ModelName) {
+		return nil, fmt.Errorf("invalid model name %s", meta.ModelName)
+	}
+	for _, file := range meta.Files {
+		if !validBundlePath(file.Path) {
+			return nil, fmt.Errorf("invalid file path %s", file.Path)
+		}
+	}
+	return meta, nil
+}
+
+// validBundlePath A relative slash-separated path without . or .. elements, so that it can't
+// lead out of the dir it's joined to
+func validBundlePath(p string) bool {
+	if p == "" || strings.Contains(p, "\\") || path.IsAbs(p) || filepath.IsAbs(p) {
+		return false
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyModelBundle Check every file listed in the metadata is unpacked with its size and sha256,
+// and nothing else is. The files named by their digests, e.g. blobs/sha256-<hex> of Ollama, must
+// have those digests, as the engines share them between the models by the names
+func verifyModelBundle(dir string, meta *modelBundleMeta) error {
+	listed := make(map[string]bool, len(meta.Files))
+	for _, file := range meta.Files {
+		listed[file.Path] = true
+	}
+	filesDir := filepath.Join(dir, filepath.FromSlash(modelBundleFilesDir))
+	err := filepath.WalkDir(filesDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(filesDir, p)
+		if err != nil {
+			return err
+		}
+		if !listed[filepath.ToSlash(rel)] {
+			return fmt.Errorf("%s is not listed in %s", filepath.ToSlash(rel), modelBundleMetaFile)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, file := range meta.Files {
+		localPath := filepath.Join(dir, filepath.FromSlash(modelBundleFilesDir+file.Path))
+		f, err := os.Open(localPath)
+		if err != nil {
+			return fmt.Errorf("%s is missing", file.Path)
+		}
+		h := sha256.New()
+		size, err := io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		digest := fmt.Sprintf("%x", h.Sum(nil))
+		if size != file.Size || digest != file.Sha256 {
+			return fmt.Errorf("checksum of %s mismatch", file.Path)
+		}
+		if named, ok := strings.CutPrefix(path.Base(file.Path), "sha256-"); ok && !strings.EqualFold(named, digest) {
+			return fmt.Errorf("digest of %s mismatch", file.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type bundleEntry struct {
+	name string
+	data string
+}
+
+func bundleFile(path, data string) modelBundleFile {
+	return modelBundleFile{Path: path, Size: int64(len(data)), Sha256: fmt.Sprintf("%x", sha256.Sum256([]byte(data)))}
+}
+
+func writeTestBundle(t *testing.T, entries []bundleEntry, meta *modelBundleMeta) string {
+	t.Helper()
+	bundlePath := filepath.Join(t.TempDir(), "model.tar")
+	f, err := os.Create(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range append(entries, bundleEntry{modelBundleMetaFile, string(metaData)}) {
+		if err := tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bundlePath
+}
+
+func TestModelBundleValidation(t *testing.T) {
+	blob := "GGUF weights"
+	blobName := fmt.Sprintf("blobs/sha256-%x", sha256.Sum256([]byte(blob)))
+	manifest := `{"layers": []}`
+	validFiles := []modelBundleFile{bundleFile(blobName, blob), bundleFile("manifests/registry.ollama.ai/library/qwen2/0.5b", manifest)}
+	validEntries := []bundleEntry{
+		{modelBundleFilesDir + blobName, blob},
+		{modelBundleFilesDir + "manifests/registry.ollama.ai/library/qwen2/0.5b", manifest},
+	}
+
+	cases := []struct {
+		name      string
+		modelName string
+		files     []modelBundleFile
+		entries   []bundleEntry
+		// wantErr the error expected from unpack or verify, empty if the bundle is valid
+		wantErr string
+	}{
+		{name: "valid", modelName: "qwen2:0.5b", files: validFiles, entries: validEntries},
+		{
+			name: "unlisted file", modelName: "qwen2:0.5b", files: validFiles,
+			entries: append(append([]bundleEntry{}, validEntries...), bundleEntry{modelBundleFilesDir + "blobs/extra", "x"}),
+			wantErr: "not listed",
+		},
+		{
+			name: "blob named by another digest", modelName: "qwen2:0.5b",
+			files:   []modelBundleFile{bundleFile("blobs/sha256-"+strings.Repeat("0", 64), blob)},
+			entries: []bundleEntry{{modelBundleFilesDir + "blobs/sha256-" + strings.Repeat("0", 64), blob}},
+			wantErr: "digest of",
+		},
+		{name: "model name out of the dir", modelName: "../../evil", files: validFiles, entries: validEntries, wantErr: "invalid model name"},
+		{name: "absolute model name", modelName: "/etc/evil", files: validFiles, entries: validEntries, wantErr: "invalid model name"},
+		{
+			name: "file path out of the dir", modelName: "qwen2:0.5b",
+			files: append(append([]modelBundleFile{}, validFiles...), bundleFile("../evil", "x")), entries: validEntries,
+			wantErr: "invalid file path",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bundlePath := writeTestBundle(t, c.entries, &modelBundleMeta{
+				Version: modelBundleVersion, Engine: "ollama", ModelName: c.modelName, Files: c.files,
+			})
+			dir := t.TempDir()
+			meta, err := unpackModelBundle(bundlePath, dir)
+			if err == nil {
+				err = verifyModelBundle(dir, meta)
+			}
+			if c.wantErr == "" {
+				if err != nil {
+					t.Fatalf("valid bundle is rejected: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Fatalf("got error %v, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,550 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ligjn/aog/config"
+	"github.com/ligjn/aog/internal/datastore"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/provider"
+	"github.com/ligjn/aog/internal/types"
+	"github.com/ligjn/aog/internal/utils/bcode"
+)
+
+const (
+	// defaultModelDownloadWorkers models downloaded at the same time, AOG_MAX_DOWNLOADS to change
+	defaultModelDownloadWorkers = 2
+	// modelDownloadQueueSize downloads waiting for a worker at most, more are refused
+	modelDownloadQueueSize   = 64
+	modelDownloadMaxAttempts = 3
+	// modelDownloadRetryDelay doubled after each failed attempt
+	modelDownloadRetryDelay = 5 * time.Second
+	// modelProgressSaveInterval how often the progress is saved into the model table
+	modelProgressSaveInterval = 2 * time.Second
+	// modelDownloadEngineTimeout how long a download waits for its engine to be up
+	modelDownloadEngineTimeout = 2 * time.Minute
+)
+
+// downloadEvent A change of a download. Progress is the one reported by the engine, set while
+// the model is downloading
+type downloadEvent struct {
+	State    types.ModelDownload
+	Progress *types.ProgressResponse
+}
+
+// downloadSubscriber The events are kept in pending, the latest one of each download, so that
+// publishing never waits for the subscriber. They're sent to ch in the order of the downloads
+type downloadSubscriber struct {
+	modelName    string
+	providerName string
+	ch           chan downloadEvent
+	done         chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]downloadEvent
+	order   []string
+	// notify has a value when there are pending events
+	notify chan struct{}
+}
+
+// push Keep the event in place of the pending one of the download
+func (sub *downloadSubscriber) push(event downloadEvent) {
+	key := downloadKey(event.State.ProviderName, event.State.ModelName)
+	sub.mu.Lock()
+	if _, ok := sub.pending[key]; !ok {
+		sub.order = append(sub.order, key)
+	}
+	sub.pending[key] = event
+	sub.mu.Unlock()
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *downloadSubscriber) pop() []downloadEvent {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	events := make([]downloadEvent, 0, len(sub.order))
+	for _, key := range sub.order {
+		events = append(events, sub.pending[key])
+	}
+	sub.pending = make(map[string]downloadEvent)
+	sub.order = nil
+	return events
+}
+
+// forward Send the pending events to ch until the subscriber is done
+func (sub *downloadSubscriber) forward() {
+	for {
+		select {
+		case <-sub.done:
+			return
+		case <-sub.notify:
+		}
+		for _, event := range sub.pop() {
+			select {
+			case sub.ch <- event:
+			case <-sub.done:
+				return
+			}
+		}
+	}
+}
+
+func (sub *downloadSubscriber) match(d *types.ModelDownload) bool {
+	return (sub.modelName == "" || sameOllamaModelName(sub.modelName, d.ModelName)) &&
+		(sub.providerName == "" || sub.providerName == d.ProviderName)
+}
+
+type downloadTask struct {
+	sp     *types.ServiceProvider
+	model  *types.Model
+	req    *types.PullModelRequest
+	state  types.ModelDownload
+	ctx    context.Context
+	cancel context.CancelFunc
+	// layers total and completed bytes of each part of the model the engine pulls
+	layers  map[string][2]int64
+	savedAt time.Time
+}
+
+// DownloadManager Pulls the models of the local service providers in a bounded queue, a few at a
+// time. A failed pull is retried, resuming from what is downloaded, and the progress is saved into
+// the model table and sent to the subscribers
+type DownloadManager struct {
+	mu          sync.Mutex
+	tasks       map[string]*downloadTask
+	queue       chan *downloadTask
+	subscribers map[*downloadSubscriber]bool
+	startOnce   sync.Once
+}
+
+var downloadManager = &DownloadManager{
+	tasks:       make(map[string]*downloadTask),
+	queue:       make(chan *downloadTask, modelDownloadQueueSize),
+	subscribers: make(map[*downloadSubscriber]bool),
+}
+
+func downloadKey(providerName, modelName string) string {
+	return providerName + "/" + strings.ToLower(modelName)
+}
+
+func modelDownloadWorkers() int {
+	if n, err := strconv.Atoi(config.Var("AOG_MAX_DOWNLOADS")); err == nil && n > 0 {
+		return n
+	}
+	return defaultModelDownloadWorkers
+}
+
+// Enqueue Add the pull of the model to the queue. It's a no-op if the model is being downloaded
+// for the provider already
+func (dm *DownloadManager) Enqueue(sp *types.ServiceProvider, m *types.Model, req *types.PullModelRequest) error {
+	dm.startOnce.Do(func() {
+		for i := 0; i < modelDownloadWorkers(); i++ {
+			go dm.work()
+		}
+	})
+
+	dm.mu.Lock()
+	key := downloadKey(sp.ProviderName, m.ModelName)
+	if _, ok := dm.tasks[key]; ok {
+		dm.mu.Unlock()
+		return nil
+	}
+	// only Enqueue sends to the queue, under dm.mu, so the space checked here is kept until the send
+	if len(dm.queue) >= cap(dm.queue) {
+		dm.mu.Unlock()
+		return bcode.ErrModelDownloadQueueFull
+	}
+	// the worker owns its copy of the model, the caller's one is not written after Enqueue returns.
+	// The status is saved before the task is queued, so it can't overwrite the one the worker saves
+	model := *m
+	model.Status = "downloading"
+	if err := datastore.GetDefaultDatastore().Put(context.Background(), &model); err != nil {
+		logger.LogicLogger.Warn("[Download] Failed to save model status", "model", m.ModelName, "error", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	task := &downloadTask{
+		sp:     sp,
+		model:  &model,
+		req:    req,
+		ctx:    ctx,
+		cancel: cancel,
+		layers: make(map[string][2]int64),
+		state: types.ModelDownload{
+			ModelName:    m.ModelName,
+			ProviderName: sp.ProviderName,
+			Engine:       sp.Flavor,
+			Status:       types.ModelDownloadQueued,
+			QueuedAt:     time.Now(),
+		},
+	}
+	dm.tasks[key] = task
+	dm.queue <- task
+	state := task.state
+	dm.mu.Unlock()
+
+	logger.LogicLogger.Info("[Download] Model queued", "model", m.ModelName, "provider", sp.ProviderName)
+	dm.publish(downloadEvent{State: state})
+	return nil
+}
+
+func (dm *DownloadManager) work() {
+	for task := range dm.queue {
+		dm.run(task)
+	}
+}
+
+func (dm *DownloadManager) run(task *downloadTask) {
+	defer task.cancel()
+	modelEngine, err := provider.GetModelEngine(task.sp.Flavor)
+	if err != nil {
+		dm.finish(task, types.ModelDownloadFailed, err)
+		return
+	}
+	delay := modelDownloadRetryDelay
+	for attempt := 1; ; attempt++ {
+		if task.ctx.Err() != nil {
+			dm.finish(task, types.ModelDownloadCanceled, task.ctx.Err())
+			return
+		}
+		dm.update(task, func(d *types.ModelDownload) {
+			d.Status = types.ModelDownloadDownloading
+			d.Attempt = attempt
+			d.NextRetryAt = nil
+		})
+		err := dm.pull(task, modelEngine)
+		if err == nil {
+			dm.finish(task, types.ModelDownloadSuccess, nil)
+			return
+		}
+		if task.ctx.Err() != nil {
+			dm.finish(task, types.ModelDownloadCanceled, task.ctx.Err())
+			return
+		}
+		// more space is not made by retrying
+		if attempt >= modelDownloadMaxAttempts || errors.Is(err, bcode.ErrModelDiskSpace) {
+			dm.finish(task, types.ModelDownloadFailed, err)
+			return
+		}
+
+		logger.LogicLogger.Warn("[Download] Pull model failed, retrying", "model", task.model.ModelName,
+			"attempt", attempt, "delay", delay, "error", err)
+		nextRetryAt := time.Now().Add(delay)
+		dm.update(task, func(d *types.ModelDownload) {
+			d.Status = types.ModelDownloadRetrying
+			d.LastError = err.Error()
+			d.NextRetryAt = &nextRetryAt
+		})
+		select {
+		case <-task.ctx.Done():
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (dm *DownloadManager) pull(task *downloadTask, modelEngine provider.ModelServiceProvider) error {
+	if err := waitModelEngine(task.ctx, modelEngine, modelDownloadEngineTimeout); err != nil {
+		return err
+	}
+	if err := checkDiskSpace(task.ctx, modelEngine, task.req.Model); err != nil {
+		return err
+	}
+	_, err := modelEngine.PullModel(task.ctx, task.req, func(p types.ProgressResponse) error {
+		dm.progress(task, p)
+		return nil
+	})
+	return err
+}
+
+// progress Sum up the parts the engine reports, so the progress is of the whole model
+func (dm *DownloadManager) progress(task *downloadTask, p types.ProgressResponse) {
+	dm.mu.Lock()
+	if p.Digest != "" && p.Total > 0 {
+		task.layers[p.Digest] = [2]int64{p.Total, p.Completed}
+	}
+	var total, completed int64
+	for _, layer := range task.layers {
+		total += layer[0]
+		completed += layer[1]
+	}
+	d := &task.state
+	d.Detail = p.Status
+	d.Total, d.Completed = total, completed
+	if total > 0 {
+		d.Progress = math.Floor(float64(completed)*1000/float64(total)) / 10
+	}
+	state := task.state
+	save := time.Since(task.savedAt) >= modelProgressSaveInterval
+	if save {
+		task.savedAt = time.Now()
+	}
+	dm.mu.Unlock()
+
+	if save {
+		dm.saveProgress(task, &state)
+	}
+	dm.publish(downloadEvent{State: state, Progress: &p})
+}
+
+// saveProgress Called by the worker of the task only, which owns task.model
+func (dm *DownloadManager) saveProgress(task *downloadTask, d *types.ModelDownload) {
+	m := task.model
+	m.Progress, m.CompletedBytes, m.TotalBytes = d.Progress, d.Completed, d.Total
+	if err := datastore.GetDefaultDatastore().Put(context.Background(), m); err != nil {
+		logger.LogicLogger.Warn("[Download] Failed to save model progress", "model", m.ModelName, "error", err)
+	}
+}
+
+func (dm *DownloadManager) update(task *downloadTask, fn func(d *types.ModelDownload)) {
+	dm.mu.Lock()
+	fn(&task.state)
+	state := task.state
+	dm.mu.Unlock()
+	dm.publish(downloadEvent{State: state})
+}
+
+func (dm *DownloadManager) finish(task *downloadTask, status string, err error) {
+	ctx := context.Background()
+	dm.mu.Lock()
+	d := &task.state
+	d.Status = status
+	d.NextRetryAt = nil
+	d.LastError = ""
+	if err != nil {
+		d.LastError = err.Error()
+	}
+	if status == types.ModelDownloadSuccess {
+		d.Progress = 100
+		d.Completed = d.Total
+	}
+	state := task.state
+	delete(dm.tasks, downloadKey(task.sp.ProviderName, task.model.ModelName))
+	dm.mu.Unlock()
+
+	m := task.model
+	m.Progress, m.CompletedBytes, m.TotalBytes = state.Progress, state.Completed, state.Total
+	if status == types.ModelDownloadSuccess {
+		logger.LogicLogger.Info("[Download] Pull model completed", "model", m.ModelName, "provider", task.sp.ProviderName)
+		modelDownloaded(ctx, task.sp, m)
+	} else {
+		logger.LogicLogger.Error("[Download] Pull model "+status, "model", m.ModelName, "provider", task.sp.ProviderName, "error", err)
+		m.Status = "failed"
+		if err := datastore.GetDefaultDatastore().Put(ctx, m); err != nil {
+			logger.LogicLogger.Error("[Download] Update model error: " + err.Error())
+		}
+	}
+	dm.publish(downloadEvent{State: state})
+}
+
+// publish Send the event to the subscribers of the model. It never waits for them, a subscriber
+// falling behind gets the latest event of each download only
+func (dm *DownloadManager) publish(event downloadEvent) {
+	dm.mu.Lock()
+	subs := make([]*downloadSubscriber, 0, len(dm.subscribers))
+	for sub := range dm.subscribers {
+		if sub.match(&event.State) {
+			subs = append(subs, sub)
+		}
+	}
+	dm.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.push(event)
+	}
+}
+
+// Subscribe The events of the downloads of the model, or all downloads if modelName is empty.
+// unsubscribe must be called once they are no longer read
+func (dm *DownloadManager) Subscribe(modelName, providerName string) (events <-chan downloadEvent, unsubscribe func()) {
+	sub := &downloadSubscriber{
+		modelName:    modelName,
+		providerName: providerName,
+		ch:           make(chan downloadEvent),
+		done:         make(chan struct{}),
+		pending:      make(map[string]downloadEvent),
+		notify:       make(chan struct{}, 1),
+	}
+	dm.mu.Lock()
+	dm.subscribers[sub] = true
+	dm.mu.Unlock()
+	go sub.forward()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			dm.mu.Lock()
+			delete(dm.subscribers, sub)
+			dm.mu.Unlock()
+			close(sub.done)
+		})
+	}
+}
+
+// List The downloads queued or running of the model, all if modelName is empty, by the time queued
+func (dm *DownloadManager) List(modelName, providerName string) []types.ModelDownload {
+	filter := &downloadSubscriber{modelName: modelName, providerName: providerName}
+	dm.mu.Lock()
+	res := make([]types.ModelDownload, 0, len(dm.tasks))
+	for _, task := range dm.tasks {
+		if filter.match(&task.state) {
+			res = append(res, task.state)
+		}
+	}
+	dm.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].QueuedAt.Before(res[j].QueuedAt)
+	})
+	return res
+}
+
+// Cancel Stop the downloads of the model, of all providers if providerName is empty
+func (dm *DownloadManager) Cancel(modelName, providerName string) int {
+	filter := &downloadSubscriber{modelName: modelName, providerName: providerName}
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	n := 0
+	for _, task := range dm.tasks {
+		if filter.match(&task.state) {
+			task.cancel()
+			n++
+		}
+	}
+	return n
+}
+
+// AsyncPullModel Queue the pull of the model for the service provider, the model is marked failed
+// if the queue is full
+func AsyncPullModel(sp *types.ServiceProvider, m *types.Model, pullReq *types.PullModelRequest) {
+	if err := downloadManager.Enqueue(sp, m, pullReq); err != nil {
+		logger.LogicLogger.Error("[Pull model] Pull model error: " + err.Error())
+		m.Status = "failed"
+		if err := datastore.GetDefaultDatastore().Put(context.Background(), m); err != nil {
+			logger.LogicLogger.Error("[Pull model] Update model error: " + err.Error())
+		}
+	}
+}
+
+// ResumeModelDownloads Queue again the models left downloading when AOG stopped. The engines keep
+// what is downloaded, so the pulls go on from there
+func ResumeModelDownloads(ctx context.Context) {
+	ds := datastore.GetDefaultDatastore()
+	list, err := ds.List(ctx, &types.Model{}, &datastore.ListOptions{
+		FilterOptions: datastore.FilterOptions{
+			Queries: []datastore.FuzzyQueryOption{
+				{Key: "status", Query: "downloading"},
+			},
+		},
+	})
+	if err != nil {
+		logger.LogicLogger.Error("[Download] List downloading models error: " + err.Error())
+		return
+	}
+	for _, v := range list {
+		m := v.(*types.Model)
+		sp := &types.ServiceProvider{ProviderName: m.ProviderName}
+		if err := ds.Get(ctx, sp); err != nil || sp.ServiceSource != types.ServiceSourceLocal {
+			continue
+		}
+		stream := false
+		AsyncPullModel(sp, m, &types.PullModelRequest{
+			Model:     m.ModelName,
+			Stream:    &stream,
+			ModelType: sp.ServiceName,
+		})
+	}
+}
+
+// GetModelDownloads The downloads queued or running, see DownloadManager.List
+func GetModelDownloads(modelName, providerName string) []types.ModelDownload {
+	return downloadManager.List(modelName, providerName)
+}
+
+// SubscribeModelDownloads Follow the downloads of the model, or all downloads if modelName is
+// empty. The states of the downloads are sent to the channel until they are all over, then it's
+// closed. A model not downloading is sent with its state in the model table
+func SubscribeModelDownloads(ctx context.Context, modelName, providerName string) (<-chan types.ModelDownload, error) {
+	events, unsubscribe := downloadManager.Subscribe(modelName, providerName)
+	current := downloadManager.List(modelName, providerName)
+	if len(current) == 0 && modelName != "" {
+		unsubscribe()
+		return savedModelDownloads(ctx, modelName, providerName)
+	}
+
+	ch := make(chan types.ModelDownload, len(current)+1)
+	active := make(map[string]bool)
+	for _, d := range current {
+		active[downloadKey(d.ProviderName, d.ModelName)] = true
+		ch <- d
+	}
+	go func() {
+		defer close(ch)
+		defer unsubscribe()
+		for len(active) > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				d := event.State
+				key := downloadKey(d.ProviderName, d.ModelName)
+				if d.Finished() {
+					delete(active, key)
+				} else {
+					active[key] = true
+				}
+				select {
+				case ch <- d:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// savedModelDownloads The state of the downloads of the model saved in the model table
+func savedModelDownloads(ctx context.Context, modelName, providerName string) (<-chan types.ModelDownload, error) {
+	list, err := datastore.GetDefaultDatastore().List(ctx,
+		&types.Model{ModelName: strings.ToLower(modelName), ProviderName: providerName},
+		&datastore.ListOptions{Page: 0, PageSize: 100})
+	if err != nil {
+		return nil, bcode.ErrServer
+	}
+	if len(list) == 0 {
+		return nil, bcode.ErrModelRecordNotFound
+	}
+	ch := make(chan types.ModelDownload, len(list))
+	for _, v := range list {
+		m := v.(*types.Model)
+		d := types.ModelDownload{
+			ModelName:    m.ModelName,
+			ProviderName: m.ProviderName,
+			Progress:     m.Progress,
+			Completed:    m.CompletedBytes,
+			Total:        m.TotalBytes,
+		}
+		switch m.Status {
+		case "downloaded":
+			d.Status = types.ModelDownloadSuccess
+			d.Progress = 100
+		case "downloading":
+			// queued again once AOG restarts
+			d.Status = types.ModelDownloadQueued
+		default:
+			d.Status = types.ModelDownloadFailed
+		}
+		ch <- d
+	}
+	close(ch)
+	return ch, nil
+}
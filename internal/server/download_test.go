@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ligjn/aog/internal/types"
+)
+
+func downloadState(model, status string, completed int64) types.ModelDownload {
+	return types.ModelDownload{ModelName: model, ProviderName: "local_ollama_chat", Status: status, Completed: completed}
+}
+
+// publishAll Publish the events, failing if it waits for the subscribers
+func publishAll(t *testing.T, dm *DownloadManager, events []downloadEvent) {
+	t.Helper()
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for _, event := range events {
+			dm.publish(event)
+		}
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish waits for the subscriber")
+	}
+}
+
+func TestDownloadPublishSlowSubscriber(t *testing.T) {
+	dm := &DownloadManager{tasks: make(map[string]*downloadTask), subscribers: make(map[*downloadSubscriber]bool)}
+	events, unsubscribe := dm.Subscribe("", "")
+	defer unsubscribe()
+	other, unsubscribeOther := dm.Subscribe("qwen2:7b", "")
+
+	// nobody reads the events meanwhile
+	var all []downloadEvent
+	for i := int64(1); i <= 1000; i++ {
+		p := &types.ProgressResponse{Status: "pulling", Completed: i}
+		all = append(all, downloadEvent{State: downloadState("qwen2:0.5b", types.ModelDownloadDownloading, i), Progress: p})
+		all = append(all, downloadEvent{State: downloadState("qwen2:7b", types.ModelDownloadDownloading, i), Progress: p})
+	}
+	all = append(all, downloadEvent{State: downloadState("qwen2:0.5b", types.ModelDownloadSuccess, 1000)})
+	publishAll(t, dm, all)
+
+	// the latest state of each download, maybe after an event taken before the subscriber fell behind
+	latest := make(map[string]types.ModelDownload)
+	var got []downloadEvent
+	for len(latest) < 2 || latest["qwen2:0.5b"].Status != types.ModelDownloadSuccess || latest["qwen2:7b"].Completed != 1000 {
+		select {
+		case event := <-events:
+			got = append(got, event)
+			latest[event.State.ModelName] = event.State
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d events, the latest %+v, want the last state of each download", len(got), latest)
+		}
+	}
+	if len(got) > 4 {
+		t.Errorf("got %d events, want the pending ones of a download coalesced", len(got))
+	}
+
+	// a subscriber gone doesn't hold the others
+	unsubscribeOther()
+	publishAll(t, dm, []downloadEvent{{State: downloadState("qwen2:7b", types.ModelDownloadFailed, 1000)}})
+	select {
+	case event := <-events:
+		if event.State.Status != types.ModelDownloadFailed {
+			t.Errorf("got %+v, want the failed state", event.State)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event is not sent after a subscriber is gone")
+	}
+	select {
+	case event, ok := <-other:
+		if ok {
+			t.Errorf("subscriber gone got %+v", event.State)
+		}
+	default:
+	}
+}
+
+func TestSubscribeModelDownloads(t *testing.T) {
+	saved := downloadManager
+	defer func() { downloadManager = saved }()
+	downloadManager = &DownloadManager{tasks: make(map[string]*downloadTask), subscribers: make(map[*downloadSubscriber]bool)}
+	state := downloadState("qwen2:0.5b", types.ModelDownloadQueued, 0)
+	downloadManager.tasks[downloadKey(state.ProviderName, state.ModelName)] = &downloadTask{state: state}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := SubscribeModelDownloads(ctx, "qwen2:0.5b", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all []downloadEvent
+	for i := int64(1); i <= 500; i++ {
+		all = append(all, downloadEvent{
+			State:    downloadState("qwen2:0.5b", types.ModelDownloadDownloading, i),
+			Progress: &types.ProgressResponse{Completed: i},
+		})
+	}
+	all = append(all, downloadEvent{State: downloadState("qwen2:0.5b", types.ModelDownloadSuccess, 500)})
+	publishAll(t, downloadManager, all)
+
+	var last types.ModelDownload
+	n := 0
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case d, ok := <-ch:
+			if !ok {
+				if last.Status != types.ModelDownloadSuccess || n < 2 {
+					t.Errorf("got %d states ending with %+v, want the queued one first and the success last", n, last)
+				}
+				return
+			}
+			if n == 0 && d.Status != types.ModelDownloadQueued {
+				t.Errorf("first state %+v, want the current one", d)
+			}
+			last = d
+			n++
+		case <-timeout:
+			t.Fatalf("channel is not closed after the download is over, got %d states", n)
+		}
+	}
+}
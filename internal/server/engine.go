@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ligjn/aog/internal/datastore"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/provider"
+	"github.com/ligjn/aog/internal/provider/engine"
+	"github.com/ligjn/aog/internal/types"
+)
+
+const (
+	engineCheckInterval = 10 * time.Second
+	// engineStartTimeout how long a started engine has to answer the health check
+	engineStartTimeout = 60 * time.Second
+	engineMinBackoff   = 5 * time.Second
+	engineMaxBackoff   = 5 * time.Minute
+	// engineStableTime the restart backoff is reset after the engine is up for so long
+	engineStableTime = 10 * time.Minute
+)
+
+type engineState struct {
+	name       string
+	status     string
+	pids       map[int]bool
+	upSince    time.Time
+	startingAt time.Time
+	restarts   int
+	crashes    int
+	// started the engine was up or started before, so starting it again is a restart
+	started     bool
+	lastError   string
+	lastErrorAt time.Time
+	nextStart   time.Time
+	backoff     time.Duration
+}
+
+func (st *engineState) fail(now time.Time, msg string) {
+	st.lastError, st.lastErrorAt = msg, now
+	logger.EngineLogger.Error("[Engine Supervisor] " + st.name + ": " + msg)
+}
+
+// EngineSupervisor Keeps the local engines used by the service providers running. An engine is
+// restarted when its process exits or it stops answering the health check, with the delay
+// between restarts doubled each time up to engineMaxBackoff
+type EngineSupervisor struct {
+	mu      sync.Mutex
+	engines map[string]*engineState
+	wake    chan struct{}
+}
+
+var engineSupervisor = &EngineSupervisor{
+	engines: make(map[string]*engineState),
+	wake:    make(chan struct{}, 1),
+}
+
+// SuperviseModelEngines Run the engine supervisor until ctx is done
+func SuperviseModelEngines(ctx context.Context) {
+	engine.SetProcessWatcher(engineSupervisor)
+	defer engine.SetProcessWatcher(nil)
+	engineSupervisor.run(ctx)
+}
+
+// GetEngineStatus State of the supervised engines, sorted by name
+func GetEngineStatus() []types.EngineStatus {
+	return engineSupervisor.Status()
+}
+
+func (s *EngineSupervisor) run(ctx context.Context) {
+	for {
+		for _, name := range s.supervisedEngines(ctx) {
+			if err := logger.RotateEngineLog(name); err != nil {
+				logger.EngineLogger.Warn("[Engine Supervisor] Failed to rotate engine log", "engine", name, "error", err)
+			}
+			s.check(name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-time.After(engineCheckInterval):
+		}
+	}
+}
+
+// state must be called with s.mu held
+func (s *EngineSupervisor) state(name string) *engineState {
+	st, ok := s.engines[name]
+	if !ok {
+		st = &engineState{
+			name:    name,
+			status:  types.EngineStatusDown,
+			pids:    make(map[int]bool),
+			backoff: engineMinBackoff,
+		}
+		s.engines[name] = st
+	}
+	return st
+}
+
+// supervisedEngines The local engines of the service providers, and those whose processes are
+// started by AOG. External engines are started outside AOG so not supervised
+func (s *EngineSupervisor) supervisedEngines(ctx context.Context) []string {
+	names := make(map[string]bool)
+	s.mu.Lock()
+	for name := range s.engines {
+		names[name] = true
+	}
+	s.mu.Unlock()
+
+	ds := datastore.GetDefaultDatastore()
+	list, err := ds.List(ctx, &types.ServiceProvider{ServiceSource: types.ServiceSourceLocal},
+		&datastore.ListOptions{Page: 0, PageSize: 100})
+	if err != nil {
+		logger.EngineLogger.Error("[Engine Supervisor] List service provider failed: " + err.Error())
+	}
+	for _, item := range list {
+		names[item.(*types.ServiceProvider).Flavor] = true
+	}
+
+	res := make([]string, 0, len(names))
+	for name := range names {
+		desc, ok := provider.GetModelEngineDescriptor(name)
+		if !ok || desc.External {
+			continue
+		}
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (s *EngineSupervisor) check(name string) {
+	e, err := provider.GetModelEngine(name)
+	if err != nil {
+		logger.EngineLogger.Error("[Engine Supervisor] Get " + name + " engine failed: " + err.Error())
+		return
+	}
+	healthErr := e.HealthCheck()
+	now := time.Now()
+
+	s.mu.Lock()
+	st := s.state(name)
+	switch {
+	case healthErr == nil:
+		if st.status != types.EngineStatusUp {
+			logger.EngineLogger.Info("[Engine Supervisor] " + name + " is up")
+			st.status, st.upSince = types.EngineStatusUp, now
+		}
+		st.started = true
+		if st.backoff > engineMinBackoff && now.Sub(st.upSince) >= engineStableTime {
+			st.backoff = engineMinBackoff
+		}
+		s.mu.Unlock()
+		return
+	case st.status == types.EngineStatusUp:
+		st.crashes++
+		st.fail(now, "health check failed: "+healthErr.Error())
+	case st.status == types.EngineStatusStarting:
+		if now.Sub(st.startingAt) < engineStartTimeout {
+			s.mu.Unlock()
+			return
+		}
+		st.fail(now, fmt.Sprintf("not healthy in %v after started", engineStartTimeout))
+	}
+	st.status = types.EngineStatusDown
+	if now.Before(st.nextStart) {
+		s.mu.Unlock()
+		return
+	}
+	st.status, st.startingAt = types.EngineStatusStarting, now
+	if st.started {
+		st.restarts++
+	}
+	st.started = true
+	st.nextStart = now.Add(st.backoff)
+	st.backoff = min(st.backoff*2, engineMaxBackoff)
+	s.mu.Unlock()
+
+	logger.EngineLogger.Info("[Engine Supervisor] Start " + name + " engine")
+	err = e.InitEnv()
+	if err == nil {
+		err = e.StartEngine(types.EngineStartModeDaemon)
+	}
+	if err != nil {
+		s.mu.Lock()
+		st.status = types.EngineStatusDown
+		st.fail(time.Now(), "start failed: "+err.Error())
+		s.mu.Unlock()
+	}
+}
+
+func (s *EngineSupervisor) ProcessStarted(engineName string, pid int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state(engineName).pids[pid] = true
+}
+
+// ProcessExited An unexpected exit is a crash, the engine is checked at once to be restarted when
+// it has no other processes running
+func (s *EngineSupervisor) ProcessExited(engineName string, pid int, err error, stopped bool) {
+	s.mu.Lock()
+	st := s.state(engineName)
+	delete(st.pids, pid)
+	if stopped {
+		s.mu.Unlock()
+		logger.EngineLogger.Info(fmt.Sprintf("[Engine Supervisor] %s process %d stopped", engineName, pid))
+		return
+	}
+	msg := fmt.Sprintf("process %d exited", pid)
+	if err != nil {
+		msg += ": " + err.Error()
+	}
+	if st.status != types.EngineStatusDown {
+		st.crashes++
+	}
+	st.fail(time.Now(), msg)
+	if len(st.pids) == 0 {
+		st.status = types.EngineStatusDown
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *EngineSupervisor) Status() []types.EngineStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	res := make([]types.EngineStatus, 0, len(s.engines))
+	for _, st := range s.engines {
+		status := types.EngineStatus{
+			Name:      st.name,
+			Status:    st.status,
+			Restarts:  st.restarts,
+			Crashes:   st.crashes,
+			LastError: st.lastError,
+			LogFile:   logger.EngineLogFile(st.name),
+		}
+		for pid := range st.pids {
+			status.Pids = append(status.Pids, pid)
+		}
+		sort.Ints(status.Pids)
+		if st.status == types.EngineStatusUp {
+			upSince := st.upSince
+			status.UpSince = &upSince
+			status.Uptime = int64(now.Sub(upSince).Seconds())
+		}
+		if !st.lastErrorAt.IsZero() {
+			lastErrorAt := st.lastErrorAt
+			status.LastErrorAt = &lastErrorAt
+		}
+		if st.status == types.EngineStatusDown && st.nextStart.After(now) {
+			nextStart := st.nextStart
+			status.NextRestartAt = &nextStart
+		}
+		res = append(res, status)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
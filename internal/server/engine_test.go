@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/provider"
+	"github.com/ligjn/aog/internal/types"
+)
+
+func TestMain(m *testing.M) {
+	logDir, err := os.MkdirTemp("", "aog-server-test")
+	if err != nil {
+		panic(err)
+	}
+	logger.InitLogger(logger.LogConfig{LogLevel: "error", LogPath: logDir})
+	code := m.Run()
+	os.RemoveAll(logDir)
+	os.Exit(code)
+}
+
+// fakeEngine an engine whose health and start results are set by the test
+type fakeEngine struct {
+	healthErr error
+	startErr  error
+	starts    int
+}
+
+func (e *fakeEngine) InstallEngine() error { return nil }
+func (e *fakeEngine) StopEngine() error    { return nil }
+func (e *fakeEngine) HealthCheck() error   { return e.healthErr }
+func (e *fakeEngine) InitEnv() error       { return nil }
+
+func (e *fakeEngine) StartEngine(mode string) error {
+	e.starts++
+	return e.startErr
+}
+
+func (e *fakeEngine) PullModel(ctx context.Context, req *types.PullModelRequest, fn types.PullProgressFunc) (*types.ProgressResponse, error) {
+	return &types.ProgressResponse{Status: "success"}, nil
+}
+
+func (e *fakeEngine) PullModelStream(ctx context.Context, req *types.PullModelRequest) (chan []byte, chan error) {
+	return nil, nil
+}
+
+func (e *fakeEngine) DeleteModel(ctx context.Context, req *types.DeleteRequest) error { return nil }
+
+func (e *fakeEngine) ListModels(ctx context.Context) (*types.ListResponse, error) {
+	return &types.ListResponse{}, nil
+}
+
+func (e *fakeEngine) GetConfig() *types.EngineRecommendConfig { return &types.EngineRecommendConfig{} }
+
+func (e *fakeEngine) GetVersion(ctx context.Context, resp *types.EngineVersionResponse) (*types.EngineVersionResponse, error) {
+	return resp, nil
+}
+
+// elapse Take the supervisor state of the engine back by d, as if the time went by
+func (s *EngineSupervisor) elapse(name string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.state(name)
+	st.upSince = st.upSince.Add(-d)
+	st.startingAt = st.startingAt.Add(-d)
+	st.nextStart = st.nextStart.Add(-d)
+}
+
+func TestEngineSupervisor(t *testing.T) {
+	const name = "fake-supervised"
+	fake := &fakeEngine{healthErr: errors.New("connection refused")}
+	provider.RegisterModelEngine(provider.EngineDescriptor{
+		Name: name,
+		New:  func(config *types.EngineRecommendConfig) provider.ModelServiceProvider { return fake },
+	})
+	s := &EngineSupervisor{engines: make(map[string]*engineState), wake: make(chan struct{}, 1)}
+	status := func() types.EngineStatus {
+		t.Helper()
+		list := s.Status()
+		if len(list) != 1 {
+			t.Fatalf("got %d engines, want 1", len(list))
+		}
+		return list[0]
+	}
+	backoff := func() time.Duration {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.state(name).backoff
+	}
+
+	// the first start is not a restart
+	s.check(name)
+	if st := status(); st.Status != types.EngineStatusStarting || fake.starts != 1 || st.Restarts != 0 {
+		t.Fatalf("status %s, %d starts, %d restarts after the first check", st.Status, fake.starts, st.Restarts)
+	}
+	fake.healthErr = nil
+	s.check(name)
+	if st := status(); st.Status != types.EngineStatusUp || st.Restarts != 0 || st.UpSince == nil {
+		t.Fatalf("status %+v, want up", st)
+	}
+
+	// crashed, restarted once the backoff passes
+	fake.healthErr = errors.New("connection refused")
+	s.check(name)
+	st := status()
+	if st.Status != types.EngineStatusDown || st.Crashes != 1 || fake.starts != 1 || st.NextRestartAt == nil {
+		t.Fatalf("status %+v and %d starts, want down waiting for the restart", st, fake.starts)
+	}
+	s.elapse(name, engineMinBackoff)
+	s.check(name)
+	if st := status(); st.Status != types.EngineStatusStarting || fake.starts != 2 || st.Restarts != 1 {
+		t.Fatalf("status %s, %d starts, %d restarts after the crash", st.Status, fake.starts, st.Restarts)
+	}
+
+	// the backoff doubles with each restart up to the max
+	fake.startErr = errors.New("address in use")
+	want := 8 * engineMinBackoff
+	for restarts := 2; want < 2*engineMaxBackoff; restarts++ {
+		s.elapse(name, max(engineStartTimeout, engineMaxBackoff))
+		s.check(name)
+		st := status()
+		if st.Status != types.EngineStatusDown || st.Restarts != restarts || !strings.Contains(st.LastError, "address in use") {
+			t.Fatalf("status %+v, want down after restart %d failed", st, restarts)
+		}
+		if got := backoff(); got != min(want, engineMaxBackoff) {
+			t.Fatalf("backoff %v after restart %d, want %v", got, restarts, min(want, engineMaxBackoff))
+		}
+		want *= 2
+	}
+
+	// reset once the engine is up long enough
+	fake.healthErr, fake.startErr = nil, nil
+	s.check(name)
+	if got := backoff(); got != engineMaxBackoff {
+		t.Fatalf("backoff %v reset at once", got)
+	}
+	s.elapse(name, engineStableTime)
+	s.check(name)
+	if got := backoff(); got != engineMinBackoff {
+		t.Errorf("backoff %v after the engine is stable, want %v", got, engineMinBackoff)
+	}
+}
+
+func TestEngineSupervisorProcessExited(t *testing.T) {
+	s := &EngineSupervisor{engines: make(map[string]*engineState), wake: make(chan struct{}, 1)}
+	s.ProcessStarted("fake-processes", 100)
+	s.ProcessStarted("fake-processes", 101)
+	s.mu.Lock()
+	s.state("fake-processes").status = types.EngineStatusUp
+	s.mu.Unlock()
+
+	s.ProcessExited("fake-processes", 100, nil, true)
+	if st := s.Status()[0]; st.Crashes != 0 || st.Status != types.EngineStatusUp || len(st.Pids) != 1 {
+		t.Fatalf("status %+v after a process is stopped, want up", st)
+	}
+	s.ProcessExited("fake-processes", 101, errors.New("signal: killed"), false)
+	st := s.Status()[0]
+	if st.Crashes != 1 || st.Status != types.EngineStatusDown || st.LastError != "process 101 exited: signal: killed" {
+		t.Fatalf("status %+v after the last process crashed, want down", st)
+	}
+	select {
+	case <-s.wake:
+	default:
+		t.Errorf("supervisor is not woken to restart the engine")
+	}
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ligjn/aog/internal/api/dto"
+	"github.com/ligjn/aog/internal/datastore"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/provider"
+	"github.com/ligjn/aog/internal/provider/engine"
+	"github.com/ligjn/aog/internal/types"
+	"github.com/ligjn/aog/internal/utils/bcode"
+)
+
+const (
+	// preloadTimeout how long the preload waits for the engine to be up after AOG starts
+	preloadTimeout = 2 * time.Minute
+	// engineReadyCheckInterval how often the engine is checked while waiting for it to be up
+	engineReadyCheckInterval = 2 * time.Second
+)
+
+// waitModelEngine Wait until the engine answers the health check, e.g. while it's started with AOG
+func waitModelEngine(ctx context.Context, modelEngine provider.ModelServiceProvider, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := modelEngine.HealthCheck()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(engineReadyCheckInterval):
+		}
+	}
+}
+
+func isModelLoader(e provider.ModelServiceProvider) bool {
+	_, ok := e.(provider.ModelLoader)
+	return ok
+}
+
+// normalizeKeepAlive The keep alive as a duration string, which every engine takes
+func normalizeKeepAlive(keepAlive string) (string, error) {
+	if keepAlive == "" {
+		return "", nil
+	}
+	d, err := engine.ParseKeepAlive(keepAlive)
+	if err != nil {
+		return "", bcode.ErrModelKeepAlive
+	}
+	return d.String(), nil
+}
+
+// modelKeepAlive The keep alive set for the model of the provider, empty if not set
+func modelKeepAlive(ctx context.Context, modelName, providerName string) string {
+	ka := &types.ModelKeepAlive{ModelName: modelName, ProviderName: providerName}
+	if err := datastore.GetDefaultDatastore().Get(ctx, ka); err != nil {
+		return ""
+	}
+	return ka.KeepAlive
+}
+
+func findLoaderModel(ctx context.Context, modelName, providerName string) (*types.Model, *types.ServiceProvider, provider.ModelLoader, error) {
+	m, sp, modelEngine, err := findLocalModel(ctx, strings.ToLower(modelName), providerName, isModelLoader)
+	if errors.Is(err, bcode.ErrModelRecordNotFound) {
+		// the model may be downloaded, but for an engine that can't load it ahead
+		if _, _, _, e := findLocalModel(ctx, strings.ToLower(modelName), providerName, func(provider.ModelServiceProvider) bool {
+			return true
+		}); e == nil {
+			return nil, nil, nil, bcode.ErrModelLoadEngine
+		}
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return m, sp, modelEngine.(provider.ModelLoader), nil
+}
+
+// LoadModel Load the model into the memory of its local engine ahead of the requests, which is kept
+// for the keep alive asked, or set for the model
+func LoadModel(ctx context.Context, request *dto.LoadModelRequest) (*dto.LoadModelResponse, error) {
+	keepAlive, err := normalizeKeepAlive(request.KeepAlive)
+	if err != nil {
+		return nil, err
+	}
+	m, sp, loader, err := findLoaderModel(ctx, request.ModelName, request.ProviderName)
+	if err != nil {
+		return nil, err
+	}
+	if keepAlive == "" {
+		keepAlive = modelKeepAlive(ctx, m.ModelName, sp.ProviderName)
+	}
+	err = loader.LoadModel(ctx, &types.LoadModelRequest{
+		Model:     m.ModelName,
+		KeepAlive: keepAlive,
+		Embedding: sp.ServiceName == types.ServiceEmbed,
+	})
+	if err != nil {
+		logger.LogicLogger.Error("[Model] Load model error: " + err.Error())
+		return nil, bcode.ErrModelLoad
+	}
+	logger.LogicLogger.Info("[Model] Model loaded", "model", m.ModelName, "engine", sp.Flavor, "keep_alive", keepAlive)
+
+	return &dto.LoadModelResponse{Bcode: *bcode.ModelCode}, nil
+}
+
+// UnloadModel Free the memory of the model in its local engine, it's loaded again when asked
+func UnloadModel(ctx context.Context, request *dto.UnloadModelRequest) (*dto.UnloadModelResponse, error) {
+	m, sp, loader, err := findLoaderModel(ctx, request.ModelName, request.ProviderName)
+	if err != nil {
+		return nil, err
+	}
+	if err := loader.UnloadModel(ctx, m.ModelName); err != nil {
+		logger.LogicLogger.Error("[Model] Unload model error: " + err.Error())
+		return nil, bcode.ErrModelUnload
+	}
+	logger.LogicLogger.Info("[Model] Model unloaded", "model", m.ModelName, "engine", sp.Flavor)
+
+	return &dto.UnloadModelResponse{Bcode: *bcode.ModelCode}, nil
+}
+
+// SetModelKeepAlive Keep the model loaded for so long after each request to it. The requests are sent
+// to the engine with it, and the model is loaded with it at once if it's loaded already
+func SetModelKeepAlive(ctx context.Context, request *dto.SetModelKeepAliveRequest) (*dto.SetModelKeepAliveResponse, error) {
+	keepAlive, err := normalizeKeepAlive(request.KeepAlive)
+	if err != nil {
+		return nil, err
+	}
+	m, sp, loader, err := findLoaderModel(ctx, request.ModelName, request.ProviderName)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := datastore.GetDefaultDatastore()
+	ka := &types.ModelKeepAlive{ModelName: m.ModelName, ProviderName: sp.ProviderName}
+	err = ds.Get(ctx, ka)
+	switch {
+	case errors.Is(err, datastore.ErrEntityInvalid):
+		if keepAlive != "" {
+			ka.KeepAlive = keepAlive
+			err = ds.Add(ctx, ka)
+		} else {
+			err = nil
+		}
+	case err != nil:
+	case keepAlive == "":
+		err = ds.Delete(ctx, ka)
+	default:
+		ka.KeepAlive = keepAlive
+		err = ds.Put(ctx, ka)
+	}
+	if err != nil {
+		logger.LogicLogger.Error("[Model] Save model keep alive error: " + err.Error())
+		return nil, bcode.ErrServer
+	}
+
+	if keepAlive != "" {
+		if running, err := loader.ListRunningModels(ctx); err == nil {
+			for _, r := range running.Models {
+				if sameOllamaModelName(r.Name, m.ModelName) {
+					err := loader.LoadModel(ctx, &types.LoadModelRequest{
+						Model:     m.ModelName,
+						KeepAlive: keepAlive,
+						Embedding: sp.ServiceName == types.ServiceEmbed,
+					})
+					if err != nil {
+						logger.LogicLogger.Warn("[Model] Failed to apply keep alive to the loaded model", "model", m.ModelName, "error", err)
+					}
+					break
+				}
+			}
+		}
+	}
+	logger.LogicLogger.Info("[Model] Model keep alive set", "model", m.ModelName, "provider", sp.ProviderName, "keep_alive", keepAlive)
+
+	return &dto.SetModelKeepAliveResponse{Bcode: *bcode.ModelCode}, nil
+}
+
+// GetLoadedModels The models loaded by the local engines of the service providers. Engines not
+// running are skipped
+func GetLoadedModels(ctx context.Context) (*dto.GetLoadedModelsResponse, error) {
+	ds := datastore.GetDefaultDatastore()
+	list, err := ds.List(ctx, &types.ServiceProvider{ServiceSource: types.ServiceSourceLocal},
+		&datastore.ListOptions{Page: 0, PageSize: 100})
+	if err != nil {
+		return nil, bcode.ErrServer
+	}
+	// the providers of each engine, to find the keep alive set for the models
+	engineProviders := make(map[string][]string)
+	for _, v := range list {
+		sp := v.(*types.ServiceProvider)
+		engineProviders[sp.Flavor] = append(engineProviders[sp.Flavor], sp.ProviderName)
+	}
+	keepAlives, err := ds.List(ctx, &types.ModelKeepAlive{}, &datastore.ListOptions{Page: 0, PageSize: 1000})
+	if err != nil {
+		return nil, bcode.ErrServer
+	}
+
+	res := make([]dto.LoadedModel, 0)
+	for engineName, providers := range engineProviders {
+		modelEngine, err := provider.GetModelEngine(engineName)
+		if err != nil {
+			continue
+		}
+		loader, ok := modelEngine.(provider.ModelLoader)
+		if !ok {
+			continue
+		}
+		running, err := loader.ListRunningModels(ctx)
+		if err != nil {
+			logger.LogicLogger.Warn("[Model] Failed to list loaded models", "engine", engineName, "error", err)
+			continue
+		}
+		for _, r := range running.Models {
+			item := dto.LoadedModel{
+				ModelName: r.Name,
+				Engine:    engineName,
+				Size:      r.Size,
+				SizeVRAM:  r.SizeVRAM,
+			}
+			if !r.ExpiresAt.IsZero() {
+				expiresAt := r.ExpiresAt
+				item.ExpiresAt = &expiresAt
+			}
+			for _, v := range keepAlives {
+				ka := v.(*types.ModelKeepAlive)
+				for _, p := range providers {
+					if ka.ProviderName == p && sameOllamaModelName(ka.ModelName, r.Name) {
+						item.KeepAlive = ka.KeepAlive
+					}
+				}
+			}
+			res = append(res, item)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Engine != res[j].Engine {
+			return res[i].Engine < res[j].Engine
+		}
+		return res[i].ModelName < res[j].ModelName
+	})
+
+	return &dto.GetLoadedModelsResponse{
+		Bcode: *bcode.ModelCode,
+		Data:  res,
+	}, nil
+}
+
+// PreloadChatModel Load the model the chat service uses by default, i.e. the last updated one of its
+// local provider, once the engine is up, so the first chat doesn't wait for the model to load
+func PreloadChatModel(ctx context.Context) {
+	ds := datastore.GetDefaultDatastore()
+	service := &types.Service{Name: types.ServiceChat}
+	if err := ds.Get(ctx, service); err != nil || service.LocalProvider == "" {
+		logger.LogicLogger.Info("[Model] No local provider of chat service to preload the model for")
+		return
+	}
+	sp := &types.ServiceProvider{ProviderName: service.LocalProvider}
+	if err := ds.Get(ctx, sp); err != nil {
+		logger.LogicLogger.Warn("[Model] Service provider of chat service not found: " + service.LocalProvider)
+		return
+	}
+	modelEngine, err := provider.GetModelEngine(sp.Flavor)
+	if err != nil {
+		return
+	}
+	loader, ok := modelEngine.(provider.ModelLoader)
+	if !ok {
+		logger.LogicLogger.Info("[Model] Models of " + sp.Flavor + " can't be preloaded")
+		return
+	}
+	ms, err := ds.List(ctx, &types.Model{ProviderName: sp.ProviderName}, &datastore.ListOptions{
+		FilterOptions: datastore.FilterOptions{
+			Queries: []datastore.FuzzyQueryOption{
+				{Key: "status", Query: "downloaded"},
+			},
+		},
+		SortBy: []datastore.SortOption{
+			{Key: "updated_at", Order: -1},
+		},
+	})
+	if err != nil || len(ms) == 0 {
+		logger.LogicLogger.Info("[Model] No downloaded chat model to preload")
+		return
+	}
+	m := ms[0].(*types.Model)
+
+	if err := waitModelEngine(ctx, modelEngine, preloadTimeout); err != nil {
+		logger.LogicLogger.Warn("[Model] Engine is not up to preload the chat model", "engine", sp.Flavor, "model", m.ModelName, "error", err)
+		return
+	}
+	keepAlive := modelKeepAlive(ctx, m.ModelName, sp.ProviderName)
+	if err := loader.LoadModel(ctx, &types.LoadModelRequest{Model: m.ModelName, KeepAlive: keepAlive}); err != nil {
+		logger.LogicLogger.Error("[Model] Preload chat model error: " + err.Error())
+		return
+	}
+	logger.LogicLogger.Info("[Model] Chat model preloaded", "model", m.ModelName, "engine", sp.Flavor, "keep_alive", keepAlive)
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ligjn/aog/internal/utils/bcode"
+)
+
+func TestNormalizeKeepAlive(t *testing.T) {
+	cases := []struct {
+		keepAlive string
+		want      string
+		wantErr   error
+	}{
+		{keepAlive: "", want: ""},
+		{keepAlive: "10m", want: "10m0s"},
+		{keepAlive: "300", want: "5m0s"},
+		{keepAlive: "-1", want: "-1s"},
+		{keepAlive: "0", want: "0s"},
+		{keepAlive: "soon", wantErr: bcode.ErrModelKeepAlive},
+	}
+	for _, c := range cases {
+		got, err := normalizeKeepAlive(c.keepAlive)
+		if !errors.Is(err, c.wantErr) || got != c.want {
+			t.Errorf("normalizeKeepAlive(%q) = %q, %v, want %q, %v", c.keepAlive, got, err, c.want, c.wantErr)
+		}
+	}
+}
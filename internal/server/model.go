@@ -5,8 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"log/slog"
+	"os"
 	"runtime"
 	"strings"
 
@@ -24,8 +24,10 @@ import (
 
 type Model interface {
 	CreateModel(ctx context.Context, request *dto.CreateModelRequest) (*dto.CreateModelResponse, error)
+	ImportModel(ctx context.Context, request *dto.ImportModelRequest) (*dto.ImportModelResponse, error)
 	DeleteModel(ctx context.Context, request *dto.DeleteModelRequest) (*dto.DeleteModelResponse, error)
 	GetModels(ctx context.Context, request *dto.GetModelsRequest) (*dto.GetModelsResponse, error)
+	GetModelDetail(ctx context.Context, request *dto.GetModelDetailRequest) (*dto.GetModelDetailResponse, error)
 }
 
 type ModelImpl struct {
@@ -45,7 +47,9 @@ func (s *ModelImpl) CreateModel(ctx context.Context, request *dto.CreateModelReq
 	} else {
 		// get default service provider
 		if request.ServiceName != types.ServiceChat && request.ServiceName != types.ServiceGenerate && request.ServiceName != types.ServiceEmbed &&
-			request.ServiceName != types.ServiceTextToImage {
+			request.ServiceName != types.ServiceTextToImage && request.ServiceName != types.ServiceImageToImage &&
+			request.ServiceName != types.ServiceTextToSpeech &&
+			request.ServiceName != types.ServiceSpeechToText && request.ServiceName != types.ServiceRerank {
 			return nil, bcode.ErrServer
 		}
 
@@ -99,13 +103,95 @@ func (s *ModelImpl) CreateModel(ctx context.Context, request *dto.CreateModelReq
 		Stream:    &stream,
 		ModelType: sp.ServiceName,
 	}
-	go AsyncPullModel(sp, m, pullReq)
+	AsyncPullModel(sp, m, pullReq)
 
 	return &dto.CreateModelResponse{
 		Bcode: *bcode.ModelCode,
 	}, nil
 }
 
+// ImportModel Register the model files on the disk into the local engine, for the sites that can't
+// download the models. The model is added as downloaded
+func (s *ModelImpl) ImportModel(ctx context.Context, request *dto.ImportModelRequest) (*dto.ImportModelResponse, error) {
+	modelType, ok := importModelTypes[request.Engine]
+	if !ok {
+		return nil, bcode.ErrModelImportEngine
+	}
+	if request.ServiceName == "" {
+		request.ServiceName = modelType
+	}
+	info, err := os.Stat(request.Path)
+	if err != nil {
+		logger.LogicLogger.Error("[Import model] Model path not found", "path", request.Path, "error", err)
+		return nil, bcode.ErrModelImportPath
+	}
+	if (request.Engine == types.FlavorOpenvino) != info.IsDir() {
+		return nil, bcode.ErrModelImportPath
+	}
+
+	sp := new(types.ServiceProvider)
+	if request.ProviderName != "" {
+		sp.ProviderName = request.ProviderName
+	} else {
+		service := &types.Service{Name: request.ServiceName}
+		err := s.Ds.Get(ctx, service)
+		if err != nil || service.LocalProvider == "" {
+			return nil, bcode.ErrServiceRecordNotFound
+		}
+		sp.ProviderName = service.LocalProvider
+	}
+	err = s.Ds.Get(ctx, sp)
+	if errors.Is(err, datastore.ErrEntityInvalid) {
+		return nil, bcode.ErrServiceRecordNotFound
+	} else if err != nil {
+		return nil, bcode.ErrServer
+	}
+	if sp.ServiceSource != types.ServiceSourceLocal || sp.Flavor != request.Engine {
+		return nil, bcode.ErrModelImportEngine
+	}
+
+	modelEngine, err := provider.GetModelEngine(sp.Flavor)
+	if err != nil {
+		return nil, bcode.ErrModelEngineNotFound
+	}
+	importer, ok := modelEngine.(provider.ModelImporter)
+	if !ok {
+		return nil, bcode.ErrModelImportEngine
+	}
+	modelName := strings.ToLower(request.ModelName)
+	err = importer.ImportModel(ctx, &types.ImportModelRequest{
+		Model:     modelName,
+		Path:      request.Path,
+		ModelType: sp.ServiceName,
+	})
+	if err != nil {
+		logger.LogicLogger.Error("[Import model] Import model error: " + err.Error())
+		return nil, bcode.ErrModelImport
+	}
+
+	m := &types.Model{ProviderName: sp.ProviderName, ModelName: modelName}
+	err = s.Ds.Get(ctx, m)
+	if errors.Is(err, datastore.ErrEntityInvalid) {
+		m.Status = "downloaded"
+		if err := s.Ds.Add(ctx, m); err != nil {
+			return nil, bcode.ErrAddModel
+		}
+	} else if err != nil {
+		return nil, bcode.ErrServer
+	}
+	modelDownloaded(ctx, sp, m)
+
+	return &dto.ImportModelResponse{
+		Bcode: *bcode.ModelCode,
+	}, nil
+}
+
+// importModelTypes The engines models can be imported into, and the service of their models by default
+var importModelTypes = map[string]string{
+	types.FlavorOllama:   types.ServiceChat,
+	types.FlavorOpenvino: types.ServiceTextToImage,
+}
+
 func (s *ModelImpl) DeleteModel(ctx context.Context, request *dto.DeleteModelRequest) (*dto.DeleteModelResponse, error) {
 	sp := new(types.ServiceProvider)
 	sp.ProviderName = request.ProviderName
@@ -132,7 +218,10 @@ func (s *ModelImpl) DeleteModel(ctx context.Context, request *dto.DeleteModelReq
 
 	//Call engin to delete model.
 	if m.Status == "downloaded" {
-		modelEngine := provider.GetModelEngine(sp.Flavor)
+		modelEngine, err := provider.GetModelEngine(sp.Flavor)
+		if err != nil {
+			return nil, bcode.ErrModelEngineNotFound
+		}
 		deleteReq := &types.DeleteRequest{
 			Model: request.ModelName,
 		}
@@ -195,6 +284,9 @@ func (s *ModelImpl) GetModels(ctx context.Context, request *dto.GetModelsRequest
 		tmp.Status = dsModel.Status
 		tmp.CreatedAt = dsModel.CreatedAt
 		tmp.UpdatedAt = dsModel.UpdatedAt
+		tmp.Progress = dsModel.Progress
+		tmp.CompletedBytes = dsModel.CompletedBytes
+		tmp.TotalBytes = dsModel.TotalBytes
 
 		respData = append(respData, *tmp)
 	}
@@ -205,6 +297,122 @@ func (s *ModelImpl) GetModels(ctx context.Context, request *dto.GetModelsRequest
 	}, nil
 }
 
+// GetModelDetail The model of the service providers, the services using them and when it's last used,
+// with the size, digest, quantization etc. asked to the local engine it's downloaded to
+func (s *ModelImpl) GetModelDetail(ctx context.Context, request *dto.GetModelDetailRequest) (*dto.GetModelDetailResponse, error) {
+	list, err := s.Ds.List(ctx, &types.Model{ModelName: strings.ToLower(request.ModelName), ProviderName: request.ProviderName},
+		&datastore.ListOptions{Page: 0, PageSize: 100})
+	if err != nil {
+		return nil, bcode.ErrServer
+	}
+	if len(list) == 0 {
+		return nil, bcode.ErrModelRecordNotFound
+	}
+
+	detail := dto.ModelDetail{
+		ModelName: list[0].(*types.Model).ModelName,
+		Services:  make([]string, 0),
+		Providers: make([]dto.ModelProvider, 0, len(list)),
+	}
+	providers := make(map[string]bool)
+	for _, v := range list {
+		m := v.(*types.Model)
+		providers[m.ProviderName] = true
+		item := dto.ModelProvider{
+			ProviderName: m.ProviderName,
+			Status:       m.Status,
+			CreatedAt:    m.CreatedAt,
+			UpdatedAt:    m.UpdatedAt,
+		}
+		sp := &types.ServiceProvider{ProviderName: m.ProviderName}
+		if err := s.Ds.Get(ctx, sp); err == nil {
+			item.ServiceName, item.ServiceSource, item.Flavor = sp.ServiceName, sp.ServiceSource, sp.Flavor
+			if detail.Engine == "" && sp.ServiceSource == types.ServiceSourceLocal && m.Status == "downloaded" {
+				if info := inspectModel(ctx, sp.Flavor, m.ModelName); info != nil {
+					detail.Engine = sp.Flavor
+					detail.Size, detail.Digest, detail.ContextLength = info.Size, info.Digest, info.ContextLength
+					detail.Format, detail.Family = info.Details.Format, info.Details.Family
+					detail.ParameterSize, detail.QuantizationLevel = info.Details.ParameterSize, info.Details.QuantizationLevel
+					if !info.ModifiedAt.IsZero() {
+						detail.ModifiedAt = &info.ModifiedAt
+					}
+				}
+			}
+		}
+		usage := &types.ModelUsage{ModelName: m.ModelName, ProviderName: m.ProviderName}
+		if err := s.Ds.Get(ctx, usage); err == nil {
+			item.LastUsedAt = &usage.LastUsedAt
+			if detail.LastUsedAt == nil || usage.LastUsedAt.After(*detail.LastUsedAt) {
+				detail.LastUsedAt = item.LastUsedAt
+			}
+		}
+		detail.Providers = append(detail.Providers, item)
+	}
+
+	services, err := s.Ds.List(ctx, &types.Service{}, &datastore.ListOptions{Page: 0, PageSize: 100})
+	if err != nil {
+		return nil, bcode.ErrServer
+	}
+	for _, v := range services {
+		service := v.(*types.Service)
+		if providers[service.LocalProvider] || providers[service.RemoteProvider] {
+			detail.Services = append(detail.Services, service.Name)
+		}
+	}
+
+	return &dto.GetModelDetailResponse{
+		Bcode: *bcode.ModelCode,
+		Data:  detail,
+	}, nil
+}
+
+// inspectModel Metadata of the model from the local engine, nil if the engine can't tell
+func inspectModel(ctx context.Context, engineName, model string) *types.EngineModelInfo {
+	modelEngine, err := provider.GetModelEngine(engineName)
+	if err != nil {
+		return nil
+	}
+	inspector, ok := modelEngine.(provider.ModelInspector)
+	if !ok {
+		return nil
+	}
+	info, err := inspector.ModelInfo(ctx, model)
+	if err != nil {
+		logger.LogicLogger.Warn("[Model] Failed to get model info from engine", "model", model, "engine", engineName, "error", err)
+		return nil
+	}
+	return info
+}
+
+// findLocalModel The downloaded model of a local engine accepted by accept, of the provider if it's given
+func findLocalModel(ctx context.Context, modelName, providerName string,
+	accept func(provider.ModelServiceProvider) bool,
+) (*types.Model, *types.ServiceProvider, provider.ModelServiceProvider, error) {
+	ds := datastore.GetDefaultDatastore()
+	list, err := ds.List(ctx, &types.Model{ModelName: modelName, ProviderName: providerName}, &datastore.ListOptions{Page: 0, PageSize: 100})
+	if err != nil {
+		return nil, nil, nil, bcode.ErrServer
+	}
+	for _, v := range list {
+		m := v.(*types.Model)
+		if m.Status != "downloaded" {
+			continue
+		}
+		sp := &types.ServiceProvider{ProviderName: m.ProviderName}
+		if err := ds.Get(ctx, sp); err != nil || sp.ServiceSource != types.ServiceSourceLocal {
+			continue
+		}
+		modelEngine, err := provider.GetModelEngine(sp.Flavor)
+		if err != nil {
+			continue
+		}
+		if accept(modelEngine) {
+			return m, sp, modelEngine, nil
+		}
+	}
+	return nil, nil, nil, bcode.ErrModelRecordNotFound
+}
+
 func CreateModelStream(ctx context.Context, request dto.CreateModelRequest) (chan []byte, chan error) {
 	newDataChan := make(chan []byte, 100)
 	newErrChan := make(chan error, 1)
@@ -252,150 +460,90 @@ func CreateModelStream(ctx context.Context, request dto.CreateModelRequest) (cha
 	err = ds.Get(ctx, m)
 	if err != nil && !errors.Is(err, datastore.ErrEntityInvalid) {
 		newErrChan <- err
+		return newDataChan, newErrChan
 	} else if errors.Is(err, datastore.ErrEntityInvalid) {
 		m.Status = "downloading"
 		err = ds.Add(ctx, m)
 		if err != nil {
 			newErrChan <- err
+			return newDataChan, newErrChan
 		}
 	}
-	modelName := request.ModelName
-	providerEngine := provider.GetModelEngine(sp.Flavor)
-	steam := true
-	req := types.PullModelRequest{
-		Model:  modelName,
-		Stream: &steam,
+
+	// the pull is run by the download manager, it goes on if the request is gone and other
+	// clients can follow it by SubscribeModelDownloads
+	events, unsubscribe := downloadManager.Subscribe(m.ModelName, sp.ProviderName)
+	stream := false
+	err = downloadManager.Enqueue(sp, m, &types.PullModelRequest{
+		Model:     request.ModelName,
+		Stream:    &stream,
+		ModelType: sp.ServiceName,
+	})
+	if err != nil {
+		unsubscribe()
+		newErrChan <- err
+		return newDataChan, newErrChan
 	}
-	dataChan, errChan := providerEngine.PullModelStream(ctx, &req)
 
 	newDataCh := make(chan []byte, 100)
 	newErrorCh := make(chan error, 1)
 	go func() {
-
-		defer close(newDataCh)
+		defer unsubscribe()
 		defer close(newErrorCh)
 		for {
 			select {
-			case data, ok := <-dataChan:
-				if !ok {
-					if data == nil {
-						client.ModelClientMap[strings.ToLower(request.ModelName)] = nil
-						return
-					}
-				}
-
-				var errResp map[string]interface{}
-				if err := json.Unmarshal(data, &errResp); err != nil {
-					continue
-				}
-				if _, ok := errResp["error"]; ok {
-					m.Status = "failed"
-					err = ds.Put(ctx, m)
+			case event := <-events:
+				if event.Progress != nil {
+					data, err := json.Marshal(event.Progress)
 					if err != nil {
-						newErrorCh <- err
+						continue
+					}
+					select {
+					case newDataCh <- data:
+					case <-ctx.Done():
+						return
 					}
-					newErrorCh <- errors.New(string(data))
-					return
-				}
-				var resp types.ProgressResponse
-				if err := json.Unmarshal(data, &resp); err != nil {
-					log.Printf("Error unmarshaling response: %v", err)
-
 					continue
 				}
-
-				if resp.Completed > 0 || resp.Status == "success" {
-					if resp.Status == "success" {
-						m.Status = "downloaded"
-						err = ds.Put(ctx, m)
-						if err != nil {
-							newErrorCh <- err
-							return
-						}
-						if request.ServiceName == "chat" {
-							generateM := new(types.Model)
-							generateM.ModelName = m.ModelName
-							generateM.ProviderName = strings.Replace(m.ProviderName, "chat", "generate", -1)
-							generateM.Status = m.Status
-							err = ds.Get(ctx, generateM)
-							if err != nil && !errors.Is(err, datastore.ErrEntityInvalid) {
-								newErrorCh <- err
-								return
-							} else if errors.Is(err, datastore.ErrEntityInvalid) {
-								err = ds.Add(ctx, generateM)
-								if err != nil {
-									newErrorCh <- err
-								}
-								return
-							}
-							err = ds.Put(ctx, generateM)
-							if err != nil {
-								newErrorCh <- err
-								return
-							}
-						}
-					}
+				switch event.State.Status {
+				case types.ModelDownloadSuccess:
+					data, _ := json.Marshal(types.ProgressResponse{Status: "success"})
 					newDataCh <- data
-				}
-
-			case err, ok := <-errChan:
-				if !ok {
+					close(newDataCh)
+					return
+				case types.ModelDownloadCanceled:
+					newErrorCh <- fmt.Errorf("pull model %s: %w", m.ModelName, context.Canceled)
+					return
+				case types.ModelDownloadFailed:
+					// errors of the engine are sent like {"error": "..."}
+					data, _ := json.Marshal(map[string]string{"error": event.State.LastError})
+					newErrorCh <- errors.New(string(data))
 					return
-				}
-				log.Printf("Error: %v", err)
-				client.ModelClientMap[strings.ToLower(request.ModelName)] = nil
-				if err != nil && strings.Contains(err.Error(), "context cancel") {
-					if strings.Contains(err.Error(), "context cancel") {
-						newErrorCh <- err
-						return
-					} else {
-						m.Status = "failed"
-						err = ds.Put(ctx, m)
-						if err != nil {
-							newErrorCh <- err
-						}
-						return
-					}
 				}
 			case <-ctx.Done():
 				newErrorCh <- ctx.Err()
+				return
 			}
-
 		}
 	}()
 	return newDataCh, newErrorCh
 }
 
+// ModelStreamCancel Cancel the pulls of the model, queued or running, of all service providers
 func ModelStreamCancel(ctx context.Context, req *dto.ModelStreamCancelRequest) (*dto.ModelStreamCancelResponse, error) {
-	modelClientCancelArray := client.ModelClientMap[req.ModelName]
-	if modelClientCancelArray != nil {
-		for _, cancel := range modelClientCancelArray {
-			cancel()
-		}
-	}
+	downloadManager.Cancel(req.ModelName, "")
+	client.ModelClientMap.Cancel(req.ModelName)
 	return &dto.ModelStreamCancelResponse{
 		Bcode: *bcode.ModelCode,
 	}, nil
 }
 
-func AsyncPullModel(sp *types.ServiceProvider, m *types.Model, pullReq *types.PullModelRequest) {
-	ctx := context.Background()
+// modelDownloaded Mark the model downloaded, and the service provider available once it has a model.
+// A chat model serves the generate service as well
+func modelDownloaded(ctx context.Context, sp *types.ServiceProvider, m *types.Model) {
 	ds := datastore.GetDefaultDatastore()
-	modelEngine := provider.GetModelEngine(sp.Flavor)
-	_, err := modelEngine.PullModel(ctx, pullReq, nil)
-	if err != nil {
-		logger.LogicLogger.Error("[Pull model] Pull model error: ", err.Error())
-		m.Status = "failed"
-		err = ds.Put(ctx, m)
-		if err != nil {
-			return
-		}
-		return
-	}
-	logger.LogicLogger.Info("Pull model %s completed ..." + m.ModelName)
-
 	m.Status = "downloaded"
-	err = ds.Put(ctx, m)
+	err := ds.Put(ctx, m)
 	if err != nil {
 		logger.LogicLogger.Error("[Pull model] Update model error:", err.Error())
 		return
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/ligjn/aog/internal/datastore"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/provider"
+	"github.com/ligjn/aog/internal/provider/engine"
+	"github.com/ligjn/aog/internal/types"
+	"github.com/ligjn/aog/version"
+)
+
+// ErrOllamaModelNotFound the model asked by the Ollama API is not in the AOG model table
+var ErrOllamaModelNotFound = errors.New("model not found")
+
+// ErrOllamaPullServiceNotSupported the service asked to pull a model by the Ollama API can't pull models
+var ErrOllamaPullServiceNotSupported = errors.New("service does not support pulling models")
+
+func ollamaEngine() (*engine.OllamaProvider, error) {
+	e, err := provider.GetModelEngine(types.FlavorOllama)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := e.(*engine.OllamaProvider)
+	if !ok {
+		return nil, errors.New("ollama engine is not available")
+	}
+	return o, nil
+}
+
+// ollamaModel a downloaded model in the AOG model table with its service provider
+type ollamaModel struct {
+	model *types.Model
+	sp    *types.ServiceProvider
+}
+
+func (m ollamaModel) isLocalOllama() bool {
+	return m.sp.ServiceSource == types.ServiceSourceLocal && m.sp.Flavor == types.FlavorOllama
+}
+
+// sameOllamaModelName Ollama takes "qwen2" as "qwen2:latest"
+func sameOllamaModelName(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if !strings.Contains(a, ":") {
+		a += ":latest"
+	}
+	if !strings.Contains(b, ":") {
+		b += ":latest"
+	}
+	return a == b
+}
+
+// listOllamaModels Downloaded models in the AOG model table, those named modelName only if it is not empty
+func listOllamaModels(ctx context.Context, modelName string) ([]ollamaModel, error) {
+	ds := datastore.GetDefaultDatastore()
+	list, err := listAllPages(ctx, ds, &types.Model{}, 100)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := make(map[string]*types.ServiceProvider)
+	res := make([]ollamaModel, 0, len(list))
+	for _, v := range list {
+		m := v.(*types.Model)
+		if m.Status != "downloaded" || (modelName != "" && !sameOllamaModelName(m.ModelName, modelName)) {
+			continue
+		}
+		sp, ok := providers[m.ProviderName]
+		if !ok {
+			sp = &types.ServiceProvider{ProviderName: m.ProviderName}
+			if err := ds.Get(ctx, sp); err != nil {
+				logger.LogicLogger.Warn("[Ollama API] Service provider of model not found", "model", m.ModelName,
+					"provider", m.ProviderName, "error", err)
+				sp = nil
+			}
+			providers[m.ProviderName] = sp
+		}
+		if sp == nil {
+			continue
+		}
+		res = append(res, ollamaModel{model: m, sp: sp})
+	}
+	return res, nil
+}
+
+// OllamaPullServiceName The service to pull the model by the Ollama API for. It's serviceName given in
+// the request if any, otherwise the service of a model of the same name in the model table, chat by default
+func OllamaPullServiceName(ctx context.Context, modelName, serviceName string) (string, error) {
+	if serviceName != "" {
+		switch serviceName {
+		case types.ServiceChat, types.ServiceGenerate, types.ServiceEmbed:
+			return serviceName, nil
+		}
+		return "", ErrOllamaPullServiceNotSupported
+	}
+	ds := datastore.GetDefaultDatastore()
+	list, err := listAllPages(ctx, ds, &types.Model{}, 100)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range list {
+		m := v.(*types.Model)
+		if !sameOllamaModelName(m.ModelName, modelName) {
+			continue
+		}
+		sp := &types.ServiceProvider{ProviderName: m.ProviderName}
+		if err := ds.Get(ctx, sp); err != nil {
+			continue
+		}
+		return sp.ServiceName, nil
+	}
+	return types.ServiceChat, nil
+}
+
+// ListOllamaModels The models of the AOG model table in the format of Ollama /api/tags. Details of
+// the models served by the local Ollama engine are taken from it
+func ListOllamaModels(ctx context.Context) (*types.ListResponse, error) {
+	models, err := listOllamaModels(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var engineModels []types.ListModelResponse
+	for _, m := range models {
+		if m.isLocalOllama() {
+			if o, err := ollamaEngine(); err == nil {
+				if lr, err := o.ListModels(ctx); err == nil {
+					engineModels = lr.Models
+				}
+			}
+			break
+		}
+	}
+
+	seen := make(map[string]bool)
+	res := &types.ListResponse{Models: make([]types.ListModelResponse, 0, len(models))}
+	for _, m := range models {
+		name := m.model.ModelName
+		if seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		item := types.ListModelResponse{
+			Name:       name,
+			Model:      name,
+			ModifiedAt: m.model.UpdatedAt,
+			Digest:     name,
+		}
+		if m.isLocalOllama() {
+			for _, em := range engineModels {
+				if sameOllamaModelName(em.Name, name) {
+					item.Size, item.Digest, item.Details = em.Size, em.Digest, em.Details
+					break
+				}
+			}
+		}
+		res.Models = append(res.Models, item)
+	}
+	sort.Slice(res.Models, func(i, j int) bool {
+		return res.Models[i].ModifiedAt.After(res.Models[j].ModifiedAt)
+	})
+	return res, nil
+}
+
+// ShowOllamaModel Details of a model in the AOG model table in the format of Ollama /api/show. It's
+// asked to the local Ollama engine if it serves the model, otherwise made from the model table
+func ShowOllamaModel(ctx context.Context, modelName string) (*types.ShowResponse, error) {
+	models, err := listOllamaModels(ctx, modelName)
+	if err != nil {
+		return nil, err
+	}
+	if len(models) == 0 {
+		return nil, ErrOllamaModelNotFound
+	}
+
+	for _, m := range models {
+		if m.isLocalOllama() {
+			o, err := ollamaEngine()
+			if err == nil {
+				var resp *types.ShowResponse
+				if resp, err = o.ShowModel(ctx, &types.ShowRequest{Model: m.model.ModelName}); err == nil {
+					return resp, nil
+				}
+			}
+			logger.LogicLogger.Warn("[Ollama API] Failed to show model by ollama engine", "model", m.model.ModelName, "error", err)
+			break
+		}
+	}
+
+	resp := &types.ShowResponse{
+		ModelInfo:  map[string]any{},
+		ModifiedAt: models[0].model.UpdatedAt,
+	}
+	capabilities := make(map[string]bool)
+	var providers []string
+	for _, m := range models {
+		switch m.sp.ServiceName {
+		case types.ServiceChat, types.ServiceGenerate:
+			capabilities["completion"] = true
+		case types.ServiceEmbed:
+			capabilities["embedding"] = true
+		}
+		providers = append(providers, m.sp.ProviderName)
+		if resp.Details.Format == "" {
+			resp.Details.Format = m.sp.Flavor
+		}
+	}
+	for _, c := range []string{"completion", "embedding"} {
+		if capabilities[c] {
+			resp.Capabilities = append(resp.Capabilities, c)
+		}
+	}
+	resp.ModelInfo["aog.service_providers"] = providers
+	return resp, nil
+}
+
+// ListOllamaRunningModels Models loaded by the local Ollama engine in the format of Ollama /api/ps.
+// Empty if the engine is not running
+func ListOllamaRunningModels(ctx context.Context) *types.ProcessResponse {
+	o, err := ollamaEngine()
+	if err == nil {
+		var resp *types.ProcessResponse
+		if resp, err = o.ListRunningModels(ctx); err == nil && resp.Models != nil {
+			return resp
+		}
+	}
+	return &types.ProcessResponse{Models: []types.ProcessModelResponse{}}
+}
+
+// GetOllamaVersion Version of the local Ollama engine, or of AOG if the engine is not running
+func GetOllamaVersion(ctx context.Context) *types.EngineVersionResponse {
+	o, err := ollamaEngine()
+	if err == nil {
+		var resp *types.EngineVersionResponse
+		if resp, err = o.GetVersion(ctx, &types.EngineVersionResponse{}); err == nil {
+			return resp
+		}
+	}
+	return &types.EngineVersionResponse{Version: version.AOGVersion}
+}
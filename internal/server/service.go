@@ -58,6 +58,12 @@ func (s *AIGCServiceImpl) CreateAIGCService(ctx context.Context, request *dto.Cr
 	if request.ApiFlavor == types.FlavorOllama && request.ServiceName == types.ServiceTextToImage {
 		return nil, fmt.Errorf("Ollama not support  text-to-image service")
 	}
+	if request.ApiFlavor == types.FlavorOllama && request.ServiceName == types.ServiceImageToImage {
+		return nil, fmt.Errorf("Ollama not support image-to-image service")
+	}
+	if request.ServiceSource == types.ServiceSourceLocal && request.ServiceName == types.ServiceTextToSpeech {
+		return nil, fmt.Errorf("no local model engine supports text-to-speech service yet")
+	}
 
 	m.ProviderName = request.ProviderName
 	providerServiceInfo := schedule.GetProviderServiceDefaultInfo(request.ApiFlavor, request.ServiceName)
@@ -83,56 +89,65 @@ func (s *AIGCServiceImpl) CreateAIGCService(ctx context.Context, request *dto.Cr
 		recommendConfig := getRecommendConfig(request.ServiceName)
 		// Check if ollama is installed locally and if it is available.
 		// If it is available, proceed to the next step. Otherwise, prompt that ollama is not installed.
-		engineProvider := provider.GetModelEngine(recommendConfig.ModelEngine)
+		engineProvider, err := provider.GetModelEngine(recommendConfig.ModelEngine)
+		if err != nil {
+			return nil, bcode.ErrModelEngineNotFound
+		}
 		engineConfig := engineProvider.GetConfig()
 		if request.ModelName != "" {
 			recommendConfig.ModelName = request.ModelName
 		}
 		if request.ApiFlavor != recommendConfig.ModelEngine {
 			request.ApiFlavor = recommendConfig.ModelEngine
+			sp.Flavor = request.ApiFlavor
+			providerServiceInfo = schedule.GetProviderServiceDefaultInfo(request.ApiFlavor, request.ServiceName)
 		}
 
-		cmd := exec.Command(engineConfig.ExecFile, "-h")
-		err := cmd.Run()
-		if err != nil {
-			logger.LogicLogger.Info("Check model engine " + recommendConfig.ModelEngine + "  not exist...")
-			reCheckCmd := exec.Command(engineConfig.ExecPath+"/"+engineConfig.ExecFile, "-h")
-			err = reCheckCmd.Run()
-			_, isExistErr := os.Stat(engineConfig.ExecPath + "/" + engineConfig.ExecFile)
-			if err != nil && isExistErr != nil {
-				logger.LogicLogger.Info("Model engine " + recommendConfig.ModelEngine + " not exist, start download...")
-				err := engineProvider.InstallEngine()
-				if err != nil {
-					logger.LogicLogger.Error("Install model "+recommendConfig.ModelEngine+" engine failed :", err.Error())
-					return nil, bcode.ErrAIGCServiceInstallEngine
+		// external engines (e.g. vllm) are installed and started outside aog
+		isExternalEngine := provider.IsExternalModelEngine(recommendConfig.ModelEngine)
+		if !isExternalEngine {
+			cmd := exec.Command(engineConfig.ExecFile, "-h")
+			err = cmd.Run()
+			if err != nil {
+				logger.LogicLogger.Info("Check model engine " + recommendConfig.ModelEngine + "  not exist...")
+				reCheckCmd := exec.Command(engineConfig.ExecPath+"/"+engineConfig.ExecFile, "-h")
+				err = reCheckCmd.Run()
+				_, isExistErr := os.Stat(engineConfig.ExecPath + "/" + engineConfig.ExecFile)
+				if err != nil && isExistErr != nil {
+					logger.LogicLogger.Info("Model engine " + recommendConfig.ModelEngine + " not exist, start download...")
+					err := engineProvider.InstallEngine()
+					if err != nil {
+						logger.LogicLogger.Error("Install model "+recommendConfig.ModelEngine+" engine failed :", err.Error())
+						return nil, bcode.ErrAIGCServiceInstallEngine
+					}
+					logger.LogicLogger.Info("Model engine " + recommendConfig.ModelEngine + " download completed...")
 				}
-				logger.LogicLogger.Info("Model engine " + recommendConfig.ModelEngine + " download completed...")
 			}
-		}
 
-		logger.LogicLogger.Info("Setting env...")
-		err = engineProvider.InitEnv()
-		if err != nil {
-			logger.LogicLogger.Error("Setting env error: ", err.Error())
-			return nil, bcode.ErrAIGCServiceInitEnv
-		}
-
-		err = engineProvider.HealthCheck()
-		if err != nil {
-			err = engineProvider.StartEngine(types.EngineStartModeDaemon)
+			logger.LogicLogger.Info("Setting env...")
+			err = engineProvider.InitEnv()
 			if err != nil {
-				logger.LogicLogger.Error("Start engine "+recommendConfig.ModelEngine+" error: ", err.Error())
-				return nil, bcode.ErrAIGCServiceStartEngine
+				logger.LogicLogger.Error("Setting env error: ", err.Error())
+				return nil, bcode.ErrAIGCServiceInitEnv
 			}
 
-			logger.LogicLogger.Info("Waiting " + recommendConfig.ModelEngine + " start 60s...")
-			for i := 60; i > 0; i-- {
-				time.Sleep(time.Second * 1)
-				err = engineProvider.HealthCheck()
-				if err == nil {
-					break
+			err = engineProvider.HealthCheck()
+			if err != nil {
+				err = engineProvider.StartEngine(types.EngineStartModeDaemon)
+				if err != nil {
+					logger.LogicLogger.Error("Start engine "+recommendConfig.ModelEngine+" error: ", err.Error())
+					return nil, bcode.ErrAIGCServiceStartEngine
+				}
+
+				logger.LogicLogger.Info("Waiting " + recommendConfig.ModelEngine + " start 60s...")
+				for i := 60; i > 0; i-- {
+					time.Sleep(time.Second * 1)
+					err = engineProvider.HealthCheck()
+					if err == nil {
+						break
+					}
+					logger.LogicLogger.Info("Waiting "+recommendConfig.ModelEngine+" start ...", strconv.Itoa(i), "s")
 				}
-				logger.LogicLogger.Info("Waiting "+recommendConfig.ModelEngine+" start ...", strconv.Itoa(i), "s")
 			}
 		}
 
@@ -182,6 +197,10 @@ func (s *AIGCServiceImpl) CreateAIGCService(ctx context.Context, request *dto.Cr
 				}
 			}
 
+			if !isPulled && isExternalEngine {
+				// models of external engines are loaded when the server starts, they can't be pulled
+				return nil, bcode.ErrProviderModelNotServed
+			}
 			if !isPulled {
 				if m.Status == "failed" {
 					m.Status = "downloading"
@@ -192,7 +211,7 @@ func (s *AIGCServiceImpl) CreateAIGCService(ctx context.Context, request *dto.Cr
 					Stream:    &stream,
 					ModelType: sp.ServiceName,
 				}
-				go AsyncPullModel(sp, m, pullReq)
+				AsyncPullModel(sp, m, pullReq)
 			} else {
 				m.Status = "downloaded"
 				err = s.Ds.Put(ctx, m)
@@ -462,7 +481,7 @@ func (s *AIGCServiceImpl) ImportService(ctx context.Context, request *dto.Import
 				if modelObj.Status == "failed" {
 					modelObj.Status = "downloading"
 				}
-				go AsyncPullModel(tmpSp, modelObj, pullReq)
+				AsyncPullModel(tmpSp, modelObj, pullReq)
 				//_, err := engineProvider.PullModel(ctx, pullReq, nil)
 				//if err != nil {
 				//	slog.Error(fmt.Sprintf("Pull model error: %s", err.Error()))
@@ -606,9 +625,8 @@ func (s *AIGCServiceImpl) GetAIGCServices(ctx context.Context, request *dto.GetA
 			}
 			err = s.Ds.Get(ctx, localSp)
 			if err == nil {
-				providerEngine := provider.GetModelEngine(localSp.Flavor)
-				err = providerEngine.HealthCheck()
-				if err == nil {
+				providerEngine, err := provider.GetModelEngine(localSp.Flavor)
+				if err == nil && providerEngine.HealthCheck() == nil {
 					serviceStatus = 1
 				}
 			}
@@ -632,7 +650,7 @@ func (s *AIGCServiceImpl) GetAIGCServices(ctx context.Context, request *dto.GetA
 				serviceStatus = 0
 				continue
 			}
-			if dsService.Name == types.ServiceTextToImage {
+			if dsService.Name == types.ServiceTextToImage || dsService.Name == types.ServiceImageToImage {
 				continue
 			}
 			checkServerObj := ChooseCheckServer(*remoteSp, remoteModel.ModelName)
@@ -778,6 +796,17 @@ func getRecommendConfig(service string) types.RecommendConfig {
 			ModelName:         "OpenVINO/stable-diffusion-v1-5-fp16-ov",
 			EngineDownloadUrl: "https://smartvision-aipc-open.oss-cn-hangzhou.aliyuncs.com/byze/windows/ovms_windows.zip",
 		}
+	case types.ServiceImageToImage:
+		return types.RecommendConfig{
+			ModelEngine:       types.FlavorOpenvino,
+			ModelName:         "OpenVINO/stable-diffusion-v1-5-fp16-ov",
+			EngineDownloadUrl: "https://smartvision-aipc-open.oss-cn-hangzhou.aliyuncs.com/byze/windows/ovms_windows.zip",
+		}
+	case types.ServiceSpeechToText:
+		return types.RecommendConfig{
+			ModelEngine: types.FlavorVLLM,
+			ModelName:   "openai/whisper-large-v3-turbo",
+		}
 	default:
 		return types.RecommendConfig{}
 	}
@@ -3,9 +3,14 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"image"
+	"image/png"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"strings"
@@ -88,17 +93,26 @@ func (s *ServiceProviderImpl) CreateServiceProvider(ctx context.Context, request
 	modelIsExist := make(map[string]bool)
 
 	if request.ServiceSource == types.ServiceSourceLocal {
-		engineProvider := provider.GetModelEngine(request.ApiFlavor)
-		engineConfig := engineProvider.GetConfig()
-		if strings.Contains(request.Url, engineConfig.Host) {
+		engineProvider, err := provider.GetModelEngine(request.ApiFlavor)
+		if err != nil {
+			return nil, bcode.ErrModelEngineNotFound
+		}
+		engineConfig := *engineProvider.GetConfig()
+		isExternalEngine := provider.IsExternalModelEngine(request.ApiFlavor)
+		if strings.Contains(request.Url, engineConfig.Host) || (isExternalEngine && request.Url != "") {
 			parseUrl, err := url.Parse(request.Url)
 			if err != nil {
 				return nil, bcode.ErrProviderServiceUrlNotFormat
 			}
 			host := parseUrl.Host
 			engineConfig.Host = host
+			// reach the engine at the host of the url, the shared engine keeps its own host
+			engineProvider, err = provider.NewModelEngine(request.ApiFlavor, &engineConfig)
+			if err != nil {
+				return nil, bcode.ErrModelEngineNotFound
+			}
 		}
-		err := engineProvider.HealthCheck()
+		err = engineProvider.HealthCheck()
 		if err != nil {
 			return nil, err
 		}
@@ -119,6 +133,10 @@ func (s *ServiceProviderImpl) CreateServiceProvider(ctx context.Context, request
 		}
 
 		for _, mName := range request.Models {
+			if !modelIsExist[mName] && isExternalEngine {
+				// models of external engines are loaded when the server starts, they can't be pulled
+				return nil, bcode.ErrProviderModelNotServed
+			}
 			if !modelIsExist[mName] {
 				slog.Info("The model " + mName + " does not exist, ready to start pulling the model.")
 				stream := false
@@ -145,7 +163,7 @@ func (s *ServiceProviderImpl) CreateServiceProvider(ctx context.Context, request
 				}
 				if err != nil {
 				}
-				go AsyncPullModel(sp, m, pullReq)
+				AsyncPullModel(sp, m, pullReq)
 			}
 		}
 	} else if request.ServiceSource == types.ServiceSourceRemote {
@@ -253,7 +271,10 @@ func (s *ServiceProviderImpl) DeleteServiceProvider(ctx context.Context, request
 		// Delete the locally downloaded model.
 		// It is necessary to check whether the local model is jointly referenced by other service providers.
 		// If so, do not delete the local model but only delete the record.
-		engine := provider.GetModelEngine(sp.Flavor)
+		engine, err := provider.GetModelEngine(sp.Flavor)
+		if err != nil {
+			return nil, bcode.ErrModelEngineNotFound
+		}
 		for _, m := range list {
 			dsModel := m.(*types.Model)
 			tmpModel := &types.Model{
@@ -462,9 +483,8 @@ func (s *ServiceProviderImpl) GetServiceProviders(ctx context.Context, request *
 				serviceProviderStatus = 1
 			}
 		} else {
-			providerEngine := provider.GetModelEngine(dsProvider.Flavor)
-			err = providerEngine.HealthCheck()
-			if err == nil {
+			providerEngine, err := provider.GetModelEngine(dsProvider.Flavor)
+			if err == nil && providerEngine.HealthCheck() == nil {
 				serviceProviderStatus = 1
 			}
 		}
@@ -522,6 +542,26 @@ type CheckTextToImageServer struct {
 	ModelName       string
 }
 
+type CheckImageToImageServer struct {
+	ServiceProvider types.ServiceProvider
+	ModelName       string
+}
+
+type CheckTextToSpeechServer struct {
+	ServiceProvider types.ServiceProvider
+	ModelName       string
+}
+
+type CheckSpeechToTextServer struct {
+	ServiceProvider types.ServiceProvider
+	ModelName       string
+}
+
+type CheckRerankServer struct {
+	ServiceProvider types.ServiceProvider
+	ModelName       string
+}
+
 func (m *CheckModelsServer) CheckServer() bool {
 	req, err := http.NewRequest(m.ServiceProvider.Method, m.ServiceProvider.URL, nil)
 	if err != nil {
@@ -536,28 +576,83 @@ func (c *CheckChatServer) CheckServer() bool {
 		Role    string `json:"role"`
 		Content string `json:"content"`
 	}
-	type RequestBody struct {
-		Model    string    `json:"model"`
-		Messages []Message `json:"messages"`
-		Stream   bool      `json:"stream"`
-	}
-
-	requestBody := RequestBody{
-		Model:  c.ModelName,
-		Stream: false,
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: "你好！",
+	prompt := "你好！"
+	var jsonData []byte
+	var err error
+	switch c.ServiceProvider.Flavor {
+	case types.FlavorGemini:
+		type Part struct {
+			Text string `json:"text"`
+		}
+		type Content struct {
+			Role  string `json:"role"`
+			Parts []Part `json:"parts"`
+		}
+		type RequestBody struct {
+			Contents []Content `json:"contents"`
+		}
+		requestBody := RequestBody{
+			Contents: []Content{
+				{
+					Role:  "user",
+					Parts: []Part{{Text: prompt}},
+				},
 			},
-		},
+		}
+		jsonData, err = json.Marshal(requestBody)
+	case types.FlavorAnthropic:
+		type RequestBody struct {
+			Model     string    `json:"model"`
+			MaxTokens int       `json:"max_tokens"`
+			Messages  []Message `json:"messages"`
+		}
+		requestBody := RequestBody{
+			Model:     c.ModelName,
+			MaxTokens: 16,
+			Messages: []Message{
+				{
+					Role:    "user",
+					Content: prompt,
+				},
+			},
+		}
+		jsonData, err = json.Marshal(requestBody)
+	case types.FlavorOpenAIResponses:
+		type RequestBody struct {
+			Model           string `json:"model"`
+			Input           string `json:"input"`
+			MaxOutputTokens int    `json:"max_output_tokens"`
+			Store           bool   `json:"store"`
+		}
+		requestBody := RequestBody{
+			Model:           c.ModelName,
+			Input:           prompt,
+			MaxOutputTokens: 16,
+		}
+		jsonData, err = json.Marshal(requestBody)
+	default:
+		type RequestBody struct {
+			Model    string    `json:"model"`
+			Messages []Message `json:"messages"`
+			Stream   bool      `json:"stream"`
+		}
+		requestBody := RequestBody{
+			Model:  c.ModelName,
+			Stream: false,
+			Messages: []Message{
+				{
+					Role:    "user",
+					Content: prompt,
+				},
+			},
+		}
+		jsonData, err = json.Marshal(requestBody)
 	}
-	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		logger.LogicLogger.Error("[Schedule] Failed to marshal request body", "error", err)
 		return false
 	}
-	req, err := http.NewRequest(c.ServiceProvider.Method, c.ServiceProvider.URL, bytes.NewReader(jsonData))
+	req, err := http.NewRequest(c.ServiceProvider.Method, schedule.ExpandProviderURL(c.ServiceProvider.URL, c.ModelName), bytes.NewReader(jsonData))
 	if err != nil {
 		logger.LogicLogger.Error("[Schedule] Failed to prepare request", "error", err)
 		return false
@@ -572,24 +667,52 @@ func (g *CheckGenerateServer) CheckServer() bool {
 }
 
 func (e *CheckEmbeddingServer) CheckServer() bool {
-	type RequestBody struct {
-		Model          string   `json:"model"`
-		Input          []string `json:"input"`
-		Dimensions     int      `json:"dimensions"`
-		EncodingFormat string   `json:"encoding_format"`
-	}
-	requestBody := RequestBody{
-		Model:          e.ModelName,
-		Input:          []string{"test text"},
-		Dimensions:     1024,
-		EncodingFormat: "float",
-	}
-	jsonData, err := json.Marshal(requestBody)
+	var jsonData []byte
+	var err error
+	switch e.ServiceProvider.Flavor {
+	case types.FlavorGemini:
+		type Part struct {
+			Text string `json:"text"`
+		}
+		type Content struct {
+			Parts []Part `json:"parts"`
+		}
+		type EmbedRequest struct {
+			Model   string  `json:"model"`
+			Content Content `json:"content"`
+		}
+		type RequestBody struct {
+			Requests []EmbedRequest `json:"requests"`
+		}
+		requestBody := RequestBody{
+			Requests: []EmbedRequest{
+				{
+					Model:   "models/" + e.ModelName,
+					Content: Content{Parts: []Part{{Text: "test text"}}},
+				},
+			},
+		}
+		jsonData, err = json.Marshal(requestBody)
+	default:
+		type RequestBody struct {
+			Model          string   `json:"model"`
+			Input          []string `json:"input"`
+			Dimensions     int      `json:"dimensions"`
+			EncodingFormat string   `json:"encoding_format"`
+		}
+		requestBody := RequestBody{
+			Model:          e.ModelName,
+			Input:          []string{"test text"},
+			Dimensions:     1024,
+			EncodingFormat: "float",
+		}
+		jsonData, err = json.Marshal(requestBody)
+	}
 	if err != nil {
 		logger.LogicLogger.Error("[Schedule] Failed to marshal request body", "error", err)
 		return false
 	}
-	req, err := http.NewRequest(e.ServiceProvider.Method, e.ServiceProvider.URL, bytes.NewReader(jsonData))
+	req, err := http.NewRequest(e.ServiceProvider.Method, schedule.ExpandProviderURL(e.ServiceProvider.URL, e.ModelName), bytes.NewReader(jsonData))
 	if err != nil {
 		logger.LogicLogger.Error("[Schedule] Failed to prepare request", "error", err)
 		return false
@@ -667,6 +790,230 @@ func (e *CheckTextToImageServer) CheckServer() bool {
 	return status
 }
 
+func (t *CheckTextToSpeechServer) CheckServer() bool {
+	text := "你好"
+	var jsonData []byte
+	var err error
+	switch t.ServiceProvider.Flavor {
+	case types.FlavorAliYun:
+		type InputData struct {
+			Text  string `json:"text"`
+			Voice string `json:"voice"`
+		}
+		type RequestBody struct {
+			Model string    `json:"model"`
+			Input InputData `json:"input"`
+		}
+		requestBody := RequestBody{
+			Model: t.ModelName,
+			Input: InputData{
+				Text:  text,
+				Voice: "Cherry",
+			},
+		}
+		jsonData, err = json.Marshal(requestBody)
+	default:
+		type RequestBody struct {
+			Model string `json:"model"`
+			Input string `json:"input"`
+			Voice string `json:"voice"`
+		}
+		requestBody := RequestBody{
+			Model: t.ModelName,
+			Input: text,
+			Voice: "alloy",
+		}
+		jsonData, err = json.Marshal(requestBody)
+	}
+	if err != nil {
+		logger.LogicLogger.Error("[Schedule] Failed to marshal request body", "error", err)
+		return false
+	}
+	req, err := http.NewRequest(t.ServiceProvider.Method, t.ServiceProvider.URL, bytes.NewReader(jsonData))
+	if err != nil {
+		logger.LogicLogger.Error("[Schedule] Failed to prepare request", "error", err)
+		return false
+	}
+
+	status := CheckServerRequest(req, t.ServiceProvider, string(jsonData))
+	return status
+}
+
+// blankPng a white 64x64 png, used to check image-to-image services
+func blankPng() []byte {
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func (i *CheckImageToImageServer) CheckServer() bool {
+	prompt := "把背景换成蓝色"
+	imageData := base64.StdEncoding.EncodeToString(blankPng())
+	var jsonData []byte
+	var err error
+	switch i.ServiceProvider.Flavor {
+	case types.FlavorTencent:
+		type RequestBody struct {
+			Prompt     string `json:"Prompt"`
+			InputImage string `json:"InputImage"`
+			RspImgType string `json:"RspImgType"`
+		}
+		requestBody := RequestBody{
+			Prompt:     prompt,
+			InputImage: imageData,
+			RspImgType: "url",
+		}
+		jsonData, err = json.Marshal(requestBody)
+	case types.FlavorAliYun:
+		type InputData struct {
+			Function     string `json:"function"`
+			Prompt       string `json:"prompt"`
+			BaseImageUrl string `json:"base_image_url"`
+		}
+		type RequestBody struct {
+			Model string    `json:"model"`
+			Input InputData `json:"input"`
+		}
+		requestBody := RequestBody{
+			Model: i.ModelName,
+			Input: InputData{
+				Function:     "description_edit",
+				Prompt:       prompt,
+				BaseImageUrl: "data:image/png;base64," + imageData,
+			},
+		}
+		jsonData, err = json.Marshal(requestBody)
+	default:
+		type RequestBody struct {
+			Model     string `json:"model"`
+			Prompt    string `json:"prompt"`
+			Image     string `json:"image"`
+			ImageType string `json:"image_type"`
+		}
+		requestBody := RequestBody{
+			Model:     i.ModelName,
+			Prompt:    prompt,
+			Image:     imageData,
+			ImageType: types.ImageTypeBase64,
+		}
+		jsonData, err = json.Marshal(requestBody)
+	}
+	if err != nil {
+		logger.LogicLogger.Error("[Schedule] Failed to marshal request body", "error", err)
+		return false
+	}
+	req, err := http.NewRequest(i.ServiceProvider.Method, i.ServiceProvider.URL, bytes.NewReader(jsonData))
+	if err != nil {
+		logger.LogicLogger.Error("[Schedule] Failed to prepare request", "error", err)
+		return false
+	}
+
+	status := CheckServerRequest(req, i.ServiceProvider, string(jsonData))
+	return status
+}
+
+// silentWav half a second of silence in 16kHz 16bit mono wav, used to check speech-to-text services
+func silentWav() []byte {
+	const sampleRate, dataSize = 16000, 16000
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(36+dataSize))
+	buf.WriteString("WAVEfmt ")
+	// fmt chunk: size, PCM, channels, sample rate, byte rate, block align, bits per sample
+	for _, v := range []any{uint32(16), uint16(1), uint16(1), uint32(sampleRate), uint32(sampleRate * 2), uint16(2), uint16(16)} {
+		_ = binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(dataSize))
+	buf.Write(make([]byte, dataSize))
+	return buf.Bytes()
+}
+
+func (s *CheckSpeechToTextServer) CheckServer() bool {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	err := writer.WriteField("model", s.ModelName)
+	if err != nil {
+		logger.LogicLogger.Error("[Schedule] Failed to prepare request body", "error", err)
+		return false
+	}
+	fileWriter, err := writer.CreateFormFile("file", "check.wav")
+	if err != nil {
+		logger.LogicLogger.Error("[Schedule] Failed to prepare request body", "error", err)
+		return false
+	}
+	_, _ = fileWriter.Write(silentWav())
+	if err = writer.Close(); err != nil {
+		logger.LogicLogger.Error("[Schedule] Failed to prepare request body", "error", err)
+		return false
+	}
+	req, err := http.NewRequest(s.ServiceProvider.Method, s.ServiceProvider.URL, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		logger.LogicLogger.Error("[Schedule] Failed to prepare request", "error", err)
+		return false
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	status := CheckServerRequest(req, s.ServiceProvider, "")
+	return status
+}
+
+func (r *CheckRerankServer) CheckServer() bool {
+	query := "什么是文本排序模型"
+	documents := []string{"文本排序模型广泛用于搜索引擎和推荐系统中", "量子计算是计算科学的一个前沿领域"}
+	var jsonData []byte
+	var err error
+	switch r.ServiceProvider.Flavor {
+	case types.FlavorAliYun:
+		type InputData struct {
+			Query     string   `json:"query"`
+			Documents []string `json:"documents"`
+		}
+		type RequestBody struct {
+			Model string    `json:"model"`
+			Input InputData `json:"input"`
+		}
+		requestBody := RequestBody{
+			Model: r.ModelName,
+			Input: InputData{
+				Query:     query,
+				Documents: documents,
+			},
+		}
+		jsonData, err = json.Marshal(requestBody)
+	default:
+		type RequestBody struct {
+			Model     string   `json:"model"`
+			Query     string   `json:"query"`
+			Documents []string `json:"documents"`
+			TopN      int      `json:"top_n"`
+		}
+		requestBody := RequestBody{
+			Model:     r.ModelName,
+			Query:     query,
+			Documents: documents,
+			TopN:      1,
+		}
+		jsonData, err = json.Marshal(requestBody)
+	}
+	if err != nil {
+		logger.LogicLogger.Error("[Schedule] Failed to marshal request body", "error", err)
+		return false
+	}
+	req, err := http.NewRequest(r.ServiceProvider.Method, r.ServiceProvider.URL, bytes.NewReader(jsonData))
+	if err != nil {
+		logger.LogicLogger.Error("[Schedule] Failed to prepare request", "error", err)
+		return false
+	}
+
+	status := CheckServerRequest(req, r.ServiceProvider, string(jsonData))
+	return status
+}
+
 func ChooseCheckServer(sp types.ServiceProvider, modelName string) ModelServiceManager {
 	var server ModelServiceManager
 	switch sp.ServiceName {
@@ -680,6 +1027,14 @@ func ChooseCheckServer(sp types.ServiceProvider, modelName string) ModelServiceM
 		server = &CheckEmbeddingServer{ServiceProvider: sp, ModelName: modelName}
 	case types.ServiceTextToImage:
 		server = &CheckTextToImageServer{ServiceProvider: sp, ModelName: modelName}
+	case types.ServiceImageToImage:
+		server = &CheckImageToImageServer{ServiceProvider: sp, ModelName: modelName}
+	case types.ServiceTextToSpeech:
+		server = &CheckTextToSpeechServer{ServiceProvider: sp, ModelName: modelName}
+	case types.ServiceSpeechToText:
+		server = &CheckSpeechToTextServer{ServiceProvider: sp, ModelName: modelName}
+	case types.ServiceRerank:
+		server = &CheckRerankServer{ServiceProvider: sp, ModelName: modelName}
 	default:
 		logger.LogicLogger.Error("[Schedule] Unknown service name", "error", sp.ServiceName)
 		return nil
@@ -706,7 +1061,9 @@ func CheckServerRequest(req *http.Request, serviceProvider types.ServiceProvider
 
 	}
 	client := &http.Client{Transport: transport}
-	req.Header.Set("Content-Type", "application/json")
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	if serviceProvider.AuthType != "none" {
 		authParams := &schedule.AuthenticatorParams{
 			Request:      req,
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ligjn/aog/config"
+	"github.com/ligjn/aog/internal/api/dto"
+	"github.com/ligjn/aog/internal/datastore"
+	"github.com/ligjn/aog/internal/logger"
+	"github.com/ligjn/aog/internal/provider"
+	"github.com/ligjn/aog/internal/provider/template"
+	"github.com/ligjn/aog/internal/types"
+	"github.com/ligjn/aog/internal/utils/bcode"
+	"github.com/ligjn/aog/internal/utils/progress"
+	"github.com/shirou/gopsutil/disk"
+)
+
+// modelDiskSpaceReserve kept free besides the model, for the engine to unpack and convert it
+const modelDiskSpaceReserve = 512 * progress.MegaByte
+
+// parseModelSize The bytes of the sizes in the model templates, e.g. 4.7G, 1.1GB, 352MB
+func parseModelSize(s string) (int64, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(s, "B")
+	unit := int64(progress.Byte)
+	for suffix, u := range map[string]int64{
+		"K": progress.KiloByte,
+		"M": progress.MegaByte,
+		"G": progress.GigaByte,
+		"T": progress.TeraByte,
+	} {
+		if strings.HasSuffix(s, suffix) {
+			s, unit = strings.TrimSuffix(s, suffix), u
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return int64(n * float64(unit)), true
+}
+
+// templateModelSize The size of the model in recommend_models.json or local_model.json
+func templateModelSize(model string) (int64, bool) {
+	var names, sizes []string
+	if data, err := template.FlavorTemplateFs.ReadFile("recommend_models.json"); err == nil {
+		var info RecommendServicesInfo
+		if json.Unmarshal(data, &info) == nil {
+			for _, mm := range info.MemoryModelsMapList {
+				for _, m := range mm.Models {
+					names, sizes = append(names, m.Name), append(sizes, m.Size)
+				}
+			}
+		}
+	}
+	if data, err := template.FlavorTemplateFs.ReadFile("local_model.json"); err == nil {
+		var services map[string][]dto.LocalSupportModelData
+		if json.Unmarshal(data, &services) == nil {
+			for _, models := range services {
+				for _, m := range models {
+					names, sizes = append(names, m.Name), append(sizes, m.Size)
+				}
+			}
+		}
+	}
+	for i, name := range names {
+		if sameOllamaModelName(name, model) {
+			if size, ok := parseModelSize(sizes[i]); ok {
+				return size, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// freeDiskSpace The bytes free on the disk of the path, which may not be created yet
+func freeDiskSpace(path string) (uint64, error) {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	usage, err := disk.Usage(path)
+	if err != nil {
+		return 0, err
+	}
+	return usage.Free, nil
+}
+
+// checkDiskSpace Make sure the disk the engine stores the models on has room for the model before
+// it's pulled. The size is asked to the registry of the engine, or taken from the model templates.
+// The check is skipped if the size or the free space can't be told
+func checkDiskSpace(ctx context.Context, modelEngine provider.ModelServiceProvider, model string) error {
+	path := config.GlobalAOGEnvironment.RootDir
+	size, known := int64(0), false
+	if storage, ok := modelEngine.(provider.ModelStorage); ok {
+		if p := storage.ModelStoragePath(); p != "" {
+			path = p
+		}
+		n, err := storage.PullSize(ctx, model)
+		if err != nil {
+			logger.LogicLogger.Debug("[Model] Failed to get pull size of model from the registry", "model", model, "error", err)
+		} else {
+			size, known = n, true
+		}
+	}
+	if !known {
+		size, known = templateModelSize(model)
+	}
+	if !known || size == 0 {
+		return nil
+	}
+
+	free, err := freeDiskSpace(path)
+	if err != nil {
+		logger.LogicLogger.Warn("[Model] Failed to get free disk space", "path", path, "error", err)
+		return nil
+	}
+	if free < uint64(size+modelDiskSpaceReserve) {
+		return fmt.Errorf("%w: %s needed, %s free in %s", bcode.ErrModelDiskSpace,
+			progress.HumanBytes(size+modelDiskSpaceReserve), progress.HumanBytes(int64(free)), path)
+	}
+	return nil
+}
+
+// listAllPages List the entities matching entity page by page, until a page is not full. The pages
+// are sorted by id, so that an entity is in one of them only
+func listAllPages(ctx context.Context, ds datastore.Datastore, entity datastore.Entity, pageSize int) ([]datastore.Entity, error) {
+	var all []datastore.Entity
+	sortBy := []datastore.SortOption{{Key: "id", Order: datastore.SortOrderAscending}}
+	for page := 1; ; page++ {
+		list, err := ds.List(ctx, entity, &datastore.ListOptions{Page: page, PageSize: pageSize, SortBy: sortBy})
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, list...)
+		if len(list) < pageSize {
+			return all, nil
+		}
+	}
+}
+
+// PruneModels List the models of the local engines no service provider refers to, i.e. not in
+// the model table, nor downloading, and delete them if request.Delete is set. The engines not
+// running are skipped. Models pulled with the engine itself, e.g. ollama pull, are in the list
+// too, so they are only deleted on request
+func PruneModels(ctx context.Context, request *dto.PruneModelsRequest) (*dto.PruneModelsResponse, error) {
+	ds := datastore.GetDefaultDatastore()
+	// all pages, a model of a provider left out would be deleted
+	list, err := listAllPages(ctx, ds, &types.ServiceProvider{ServiceSource: types.ServiceSourceLocal}, 100)
+	if err != nil {
+		return nil, bcode.ErrServer
+	}
+	engineProviders := make(map[string]map[string]bool)
+	for _, v := range list {
+		sp := v.(*types.ServiceProvider)
+		if request.Engine != "" && sp.Flavor != request.Engine {
+			continue
+		}
+		if engineProviders[sp.Flavor] == nil {
+			engineProviders[sp.Flavor] = make(map[string]bool)
+		}
+		engineProviders[sp.Flavor][sp.ProviderName] = true
+	}
+	if request.Engine != "" && len(engineProviders) == 0 {
+		if _, ok := provider.GetModelEngineDescriptor(request.Engine); !ok {
+			return nil, bcode.ErrModelEngineNotFound
+		}
+		// an engine no provider uses, all its models are pruned
+		engineProviders[request.Engine] = make(map[string]bool)
+	}
+	models, err := listAllPages(ctx, ds, &types.Model{}, 1000)
+	if err != nil {
+		return nil, bcode.ErrServer
+	}
+	downloads := downloadManager.List("", "")
+
+	res := &dto.PruneModelsData{Models: make([]dto.PrunedModel, 0)}
+	for engineName, providers := range engineProviders {
+		if provider.IsExternalModelEngine(engineName) {
+			continue
+		}
+		modelEngine, err := provider.GetModelEngine(engineName)
+		if err != nil {
+			continue
+		}
+		if err := modelEngine.HealthCheck(); err != nil {
+			logger.LogicLogger.Warn("[Model] Engine is not running, its models are not pruned", "engine", engineName)
+			continue
+		}
+		engineModels, err := modelEngine.ListModels(ctx)
+		if err != nil {
+			logger.LogicLogger.Warn("[Model] Failed to list models of the engine", "engine", engineName, "error", err)
+			continue
+		}
+
+		for _, em := range pruneSelect(engineName, providers, models, downloads, engineModels.Models) {
+			item := dto.PrunedModel{ModelName: em.Name, Engine: engineName, Size: em.Size}
+			if item.Size == 0 {
+				if inspector, ok := modelEngine.(provider.ModelInspector); ok {
+					if info, err := inspector.ModelInfo(ctx, em.Name); err == nil {
+						item.Size = info.Size
+					}
+				}
+			}
+			if request.Delete {
+				if err := modelEngine.DeleteModel(ctx, &types.DeleteRequest{Model: em.Name}); err != nil {
+					logger.LogicLogger.Error("[Model] Prune model error", "model", em.Name, "engine", engineName, "error", err)
+					item.Error = err.Error()
+					res.Models = append(res.Models, item)
+					continue
+				}
+				logger.LogicLogger.Info("[Model] Model pruned", "model", em.Name, "engine", engineName, "size", item.Size)
+			}
+			res.FreedBytes += item.Size
+			res.Models = append(res.Models, item)
+		}
+	}
+	sort.Slice(res.Models, func(i, j int) bool {
+		if res.Models[i].Engine != res.Models[j].Engine {
+			return res.Models[i].Engine < res.Models[j].Engine
+		}
+		return res.Models[i].ModelName < res.Models[j].ModelName
+	})
+
+	return &dto.PruneModelsResponse{
+		Bcode: *bcode.ModelCode,
+		Data:  *res,
+	}, nil
+}
+
+// pruneSelect The models of the engine neither a model of its providers nor a download of the
+// engine refers to
+func pruneSelect(engineName string, providers map[string]bool, models []datastore.Entity, downloads []types.ModelDownload, engineModels []types.ListModelResponse) []types.ListModelResponse {
+	referenced := func(name string) bool {
+		for _, v := range models {
+			m := v.(*types.Model)
+			if providers[m.ProviderName] && sameOllamaModelName(m.ModelName, name) {
+				return true
+			}
+		}
+		for _, d := range downloads {
+			if d.Engine == engineName && sameOllamaModelName(d.ModelName, name) {
+				return true
+			}
+		}
+		return false
+	}
+	selected := make([]types.ListModelResponse, 0)
+	for _, em := range engineModels {
+		if !referenced(em.Name) {
+			selected = append(selected, em)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ligjn/aog/internal/datastore"
+	"github.com/ligjn/aog/internal/types"
+)
+
+func TestPruneSelect(t *testing.T) {
+	models := []datastore.Entity{
+		&types.Model{ModelName: "qwen2:0.5b", ProviderName: "local_ollama_chat"},
+		&types.Model{ModelName: "bge-m3", ProviderName: "local_ollama_embed"},
+		&types.Model{ModelName: "deepseek-r1:7b", ProviderName: "remote_deepseek_chat"},
+	}
+	downloads := []types.ModelDownload{
+		{ModelName: "llama3.2:1b", Engine: "ollama"},
+		{ModelName: "phi3:mini", Engine: "llamacpp"},
+	}
+	engineModels := []types.ListModelResponse{
+		{Name: "qwen2:0.5b"},
+		{Name: "Qwen2:0.5B"},
+		{Name: "bge-m3:latest"},
+		{Name: "llama3.2:1b"},
+		// not of a provider of the engine, nor downloaded by the engine
+		{Name: "deepseek-r1:7b"},
+		{Name: "phi3:mini"},
+		// pulled with the engine itself
+		{Name: "mistral:latest"},
+	}
+	names := func(list []types.ListModelResponse) []string {
+		res := make([]string, 0, len(list))
+		for _, m := range list {
+			res = append(res, m.Name)
+		}
+		return res
+	}
+
+	cases := []struct {
+		name      string
+		providers map[string]bool
+		want      []string
+	}{
+		{
+			name:      "providers of the engine",
+			providers: map[string]bool{"local_ollama_chat": true, "local_ollama_embed": true},
+			want:      []string{"deepseek-r1:7b", "phi3:mini", "mistral:latest"},
+		},
+		{
+			name:      "provider removed",
+			providers: map[string]bool{"local_ollama_chat": true},
+			want:      []string{"bge-m3:latest", "deepseek-r1:7b", "phi3:mini", "mistral:latest"},
+		},
+		{
+			name: "no provider",
+			want: []string{"qwen2:0.5b", "Qwen2:0.5B", "bge-m3:latest", "deepseek-r1:7b", "phi3:mini", "mistral:latest"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := names(pruneSelect("ollama", c.providers, models, downloads, engineModels))
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	if got := pruneSelect("ollama", nil, models, downloads, nil); len(got) != 0 {
+		t.Errorf("got %v from an engine without models", got)
+	}
+}
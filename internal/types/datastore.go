@@ -104,6 +104,10 @@ type Model struct {
 	Status       string    `gorm:"column:status;not null" json:"status"`
 	CreatedAt    time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt    time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP" json:"updated_at"`
+	// Progress percent of the download, of TotalBytes with CompletedBytes downloaded
+	Progress       float64 `gorm:"column:progress;default:0" json:"progress"`
+	CompletedBytes int64   `gorm:"column:completed_bytes;default:0" json:"completed_bytes"`
+	TotalBytes     int64   `gorm:"column:total_bytes;default:0" json:"total_bytes"`
 }
 
 func (t *Model) SetCreateTime(time time.Time) {
@@ -135,6 +139,85 @@ func (t *Model) Index() map[string]interface{} {
 	return index
 }
 
+// ModelUsage table structure, when a model of a service provider is last used. Kept apart from
+// Model so recording the use doesn't change its updated_at
+type ModelUsage struct {
+	ID           int       `gorm:"primaryKey;column:id;autoIncrement" json:"id"`
+	ModelName    string    `gorm:"column:model_name;not null" json:"model_name"`
+	ProviderName string    `gorm:"column:provider_name" json:"provider_name"`
+	LastUsedAt   time.Time `gorm:"column:last_used_at" json:"last_used_at"`
+	CreatedAt    time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+func (t *ModelUsage) SetCreateTime(time time.Time) {
+	t.CreatedAt = time
+}
+
+func (t *ModelUsage) SetUpdateTime(time time.Time) {
+	t.UpdatedAt = time
+}
+
+func (t *ModelUsage) PrimaryKey() string {
+	return "id"
+}
+
+func (t *ModelUsage) TableName() string {
+	return "aog_model_usage"
+}
+
+func (t *ModelUsage) Index() map[string]interface{} {
+	index := make(map[string]interface{})
+	if t.ModelName != "" {
+		index["model_name"] = t.ModelName
+	}
+
+	if t.ProviderName != "" {
+		index["provider_name"] = t.ProviderName
+	}
+
+	return index
+}
+
+// ModelKeepAlive table structure, how long a model of a local service provider stays loaded after used
+type ModelKeepAlive struct {
+	ID           int       `gorm:"primaryKey;column:id;autoIncrement" json:"id"`
+	ModelName    string    `gorm:"column:model_name;not null" json:"model_name"`
+	ProviderName string    `gorm:"column:provider_name" json:"provider_name"`
+	KeepAlive    string    `gorm:"column:keep_alive;not null" json:"keep_alive"`
+	CreatedAt    time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+func (t *ModelKeepAlive) SetCreateTime(time time.Time) {
+	t.CreatedAt = time
+}
+
+func (t *ModelKeepAlive) SetUpdateTime(time time.Time) {
+	t.UpdatedAt = time
+}
+
+func (t *ModelKeepAlive) PrimaryKey() string {
+	return "id"
+}
+
+func (t *ModelKeepAlive) TableName() string {
+	return "aog_model_keep_alive"
+}
+
+func (t *ModelKeepAlive) Index() map[string]interface{} {
+	index := make(map[string]interface{})
+	if t.ModelName != "" {
+		index["model_name"] = t.ModelName
+	}
+
+	if t.ProviderName != "" {
+		index["provider_name"] = t.ProviderName
+	}
+
+	return index
+}
+
 // VersionUpdateRecord  table structure
 type VersionUpdateRecord struct {
 	ID           int       `gorm:"primaryKey;column:id;autoIncrement" json:"id"`
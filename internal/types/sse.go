@@ -0,0 +1,115 @@
+package types
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// SSEEvent One event of a text/event-stream, see
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+type SSEEvent struct {
+	Event string
+	ID    string
+	Retry int // reconnection time in milliseconds, 0 if not set
+	Data  []byte
+	// HasData whether the event contains at least one data field. Per spec an event
+	// without data field is not dispatched, e.g. a chunk of only comments (keep-alive)
+	HasData bool
+}
+
+var sseFieldPrefixes = [][]byte{[]byte("data:"), []byte("event:"), []byte("id:"), []byte("retry:"), []byte(":")}
+
+// IsSSEFormatted Whether the chunk already starts with a field of event-stream, i.e. it has been
+// formatted by the flavor and needs no "data: " wrapping
+func IsSSEFormatted(chunk []byte) bool {
+	for _, prefix := range sseFieldPrefixes {
+		if bytes.HasPrefix(chunk, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSSELines lines of event-stream can be terminated by "\r\n", "\n" or "\r"
+func splitSSELines(chunk []byte) [][]byte {
+	var lines [][]byte
+	for len(chunk) > 0 {
+		i := bytes.IndexAny(chunk, "\r\n")
+		if i < 0 {
+			lines = append(lines, chunk)
+			break
+		}
+		lines = append(lines, chunk[:i])
+		if chunk[i] == '\r' && i+1 < len(chunk) && chunk[i+1] == '\n' {
+			i++
+		}
+		chunk = chunk[i+1:]
+	}
+	return lines
+}
+
+// ParseSSEEvent Parse one event (the lines up to a blank line) of event-stream.
+// Comment lines are ignored, multiple data fields are joined by "\n", and unknown
+// fields are ignored as required by the spec
+func ParseSSEEvent(chunk []byte) *SSEEvent {
+	ev := &SSEEvent{}
+	var data bytes.Buffer
+	for _, line := range splitSSELines(chunk) {
+		if len(line) == 0 {
+			break // end of the event
+		}
+		if line[0] == ':' {
+			continue // comment
+		}
+		field, value := line, []byte{}
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			value = bytes.TrimPrefix(value, []byte(" "))
+		}
+		switch string(field) {
+		case "data":
+			if ev.HasData {
+				data.WriteByte('\n')
+			}
+			data.Write(value)
+			ev.HasData = true
+		case "event":
+			ev.Event = string(value)
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				ev.ID = string(value)
+			}
+		case "retry":
+			if retry, err := strconv.Atoi(string(value)); err == nil && retry >= 0 {
+				ev.Retry = retry
+			}
+		}
+	}
+	ev.Data = data.Bytes()
+	return ev
+}
+
+// Bytes Serialize the event into event-stream format, terminated by a blank line.
+// Data containing line breaks is written as multiple data fields
+func (ev *SSEEvent) Bytes() []byte {
+	var buf bytes.Buffer
+	if ev.Event != "" {
+		buf.WriteString("event: " + ev.Event + "\n")
+	}
+	if ev.ID != "" {
+		buf.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Retry > 0 {
+		buf.WriteString("retry: " + strconv.Itoa(ev.Retry) + "\n")
+	}
+	for _, line := range splitSSELines(ev.Data) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if len(ev.Data) == 0 {
+		buf.WriteString("data: \n")
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/ligjn/aog/internal/utils"
 )
@@ -43,10 +44,10 @@ func (sr *ServiceResult) WriteBack(w http.ResponseWriter) {
 	if sr.Type == ServiceResultFailed {
 		if httpError, ok := sr.Error.(*HTTPErrorResponse); ok {
 			clear(w.Header())
-			w.WriteHeader(httpError.StatusCode)
 			for k, v := range httpError.Header {
 				w.Header().Set(k, v[0])
 			}
+			w.WriteHeader(httpError.StatusCode)
 			_, _ = w.Write(httpError.Body)
 			// event.SysEvents.NotifyHTTPResponse("send_back_response", httpError.StatusCode, w.Header(), httpError.Body)
 			return
@@ -60,16 +61,36 @@ func (sr *ServiceResult) WriteBack(w http.ResponseWriter) {
 		}
 		// event.SysEvents.NotifyHTTPResponse("send_back_response", http.StatusInternalServerError, w.Header(), errBytes)
 	} else {
+		// the body is written as is, so binary content (e.g. audio of text-to-speech) is passed
+		// through. Headers must be set before WriteHeader to take effect on any ResponseWriter
 		clear(w.Header())
-		w.WriteHeader(sr.StatusCode)
 		for k, v := range sr.HTTP.Header {
+			if hopByHopHeaders[http.CanonicalHeaderKey(k)] {
+				continue
+			}
 			w.Header().Set(k, v[0])
 		}
+		if sr.Type == ServiceResultDone {
+			// the body may have been converted, so the length of provider's response is not reliable
+			w.Header().Set("Content-Length", strconv.Itoa(len(sr.HTTP.Body)))
+		} else {
+			w.Header().Del("Content-Length")
+		}
+		w.WriteHeader(sr.StatusCode)
 		// event.SysEvents.NotifyHTTPResponse("send_back_response", sr.StatusCode, w.Header(), sr.HTTP.Body)
 		_, _ = w.Write(sr.HTTP.Body)
 	}
 }
 
+// hopByHopHeaders headers of the connection to service provider, which must not be sent back
+var hopByHopHeaders = map[string]bool{
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+	"Trailer":           true,
+	"Upgrade":           true,
+}
+
 func (sr *ServiceResult) String() string {
 	var stype string
 	switch sr.Type {
@@ -97,6 +118,12 @@ type ServiceRequest struct {
 	RequestExtraUrl       string        `json:"extra_url"`
 	HTTP                  HTTPContent   `json:"-"`
 	OriginalRequest       *http.Request `json:"-"`
+	// Action the action in the url path of APIs putting the model and the action in the same
+	// path segment, e.g. embedContent of gemini in /v1beta/models/text-embedding-004:embedContent
+	Action string `json:"-"`
+	// StructuredOutputRetries times to ask the model again with a repair prompt if its output
+	// doesn't match the json schema of response_format, only when the schema is validated by aog
+	StructuredOutputRetries int `json:"structured_output_retries"`
 }
 
 func (sr *ServiceRequest) String() string {
@@ -105,12 +132,19 @@ func (sr *ServiceRequest) String() string {
 }
 
 type ServiceTarget struct {
-	Location        string
-	Stream          bool
-	Model           string
-	ToFavor         string
-	XPU             string
-	ServiceProvider *ServiceProvider
+	Location string
+	// Stream the response mode asked from the service provider, which may differ from the request
+	Stream bool
+	// AggregateStream the provider only streams but sync mode is asked, chunks need to be merged into one response
+	AggregateStream bool
+	// SynthesizeStream the provider doesn't stream but stream mode is asked, the response need to be sent as chunks
+	SynthesizeStream bool
+	Model            string
+	ToFavor          string
+	XPU              string
+	ServiceProvider  *ServiceProvider
+	// KeepAlive set for the model of the local engine, how long it stays loaded after the request
+	KeepAlive string
 }
 
 func (sr *ServiceTarget) String() string {
@@ -140,15 +174,51 @@ func (st StreamModeType) String() string {
 type StreamMode struct {
 	Mode   StreamModeType
 	Header http.Header
+	// crPending the last line read ended with "\r", whose "\n" may not be received yet
+	crPending bool
 }
 
 func (sm *StreamMode) IsStream() bool {
 	return sm.Mode != StreamModeNonStream
 }
 
-// ReadChunk the chunks of the stream is delimited by "\n\n" for event-stream, and "\n"
-// or "\r\n" for x=ndjson so only need to read to '\n'. However, reader can only
-// stop at one character, so we need to handle it by ourselves for "\n\n" case
+// readSSELine Read a line of event-stream, which may end with "\n", "\r\n" or "\r". The line is
+// returned with the ending it had, the same as ReadBytes. The "\n" after a "\r" is only looked for
+// in what is received already, so a line ending with "\r" is not held until more data arrives
+func (sm *StreamMode) readSSELine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return line, err
+		}
+		if sm.crPending {
+			// the "\n" of a "\r\n" split by the reads, the line is returned already
+			sm.crPending = false
+			if b == '\n' && len(line) == 0 {
+				continue
+			}
+		}
+		line = append(line, b)
+		switch b {
+		case '\n':
+			return line, nil
+		case '\r':
+			if reader.Buffered() == 0 {
+				sm.crPending = true
+			} else if next, _ := reader.Peek(1); next[0] == '\n' {
+				_, _ = reader.ReadByte()
+				line = append(line, '\n')
+			}
+			return line, nil
+		}
+	}
+}
+
+// ReadChunk the chunks of the stream is delimited by a blank line for event-stream, and "\n"
+// or "\r\n" for x=ndjson so only need to read to '\n'. Lines of event-stream may end with
+// "\n", "\r\n" or "\r", so the blank line is detected line by line. Blank lines before an event
+// are skipped so that a chunk always contains exactly one event.
 func (sm *StreamMode) ReadChunk(reader *bufio.Reader) ([]byte, error) {
 	if sm.Mode == StreamModeNonStream {
 		return io.ReadAll(reader)
@@ -162,65 +232,59 @@ func (sm *StreamMode) ReadChunk(reader *bufio.Reader) ([]byte, error) {
 	var line []byte
 	var err error
 	for {
-		line, err = reader.ReadBytes('\n')
+		line, err = sm.readSSELine(reader)
 		if err != nil && err != io.EOF { // real error
 			break
 		}
-		buffer.Write(line)
+		isBlank := len(bytes.TrimRight(line, "\r\n")) == 0
+		if !isBlank || buffer.Len() > 0 {
+			buffer.Write(line)
+		}
 		if err == io.EOF { // the end
 			break
 		}
-		// Check the character before '\n'
-		if buffer.Len() >= 2 && buffer.Bytes()[buffer.Len()-2] == '\n' && buffer.Bytes()[buffer.Len()-1] == '\n' {
+		if isBlank && buffer.Len() > 0 { // blank line ends the event
 			break
 		}
 	}
 	return buffer.Bytes(), err
 }
 
-// UnwrapChunk Get real data
-// event-stream is started with "data: " which need to be removed
-// TODO: event-stream may contain multiple fields, and we only need to pick
-// the data: fields. Need to handle it in the future. Currently we assume there
-// is only a data: field.
-func (sm *StreamMode) UnwrapChunk(chunk []byte) []byte {
-	// remove "data: " at the beginning of the chunk
+// UnwrapEvent Parse the chunk of event-stream into an event. For other modes the whole
+// chunk is the data of the event
+func (sm *StreamMode) UnwrapEvent(chunk []byte) *SSEEvent {
 	if sm.Mode == StreamModeEventStream {
-		if len(chunk) >= 6 && bytes.HasPrefix(chunk, []byte("data: ")) {
-			return chunk[6:]
-		}
+		return ParseSSEEvent(chunk)
 	}
-	return chunk
+	return &SSEEvent{Data: chunk, HasData: len(chunk) > 0}
 }
 
-func (sm *StreamMode) WrapChunk(chunk []byte) []byte {
-	if sm.Mode == StreamModeNonStream {
-		return chunk
-	}
-	n := 0
-	for i := len(chunk) - 1; i >= 0 && chunk[i] == '\n'; i-- {
-		n++
-	}
-
-	nNeed := 0
-	if sm.Mode == StreamModeNDJson {
-		nNeed = 1
-	} else if sm.Mode == StreamModeEventStream {
-		nNeed = 2
-	}
+// UnwrapChunk Get real data
+// event-stream may contain event, id, retry and comment lines besides the data fields,
+// only the data fields are returned, joined by "\n" if there are multiple
+func (sm *StreamMode) UnwrapChunk(chunk []byte) []byte {
+	return sm.UnwrapEvent(chunk).Data
+}
 
-	if n == nNeed {
-		return chunk
-	} else if n > nNeed {
-		return chunk[:len(chunk)-n+nNeed]
-	} else {
-		for range nNeed - n {
-			chunk = append(chunk, '\n')
+// WrapChunk Make the chunk ready to be sent as one message of the stream. Chunks already
+// formatted as event-stream by the flavor (e.g. having an event: field) are kept as is,
+// otherwise the chunk is sent as data fields of an unnamed event
+func (sm *StreamMode) WrapChunk(chunk []byte) []byte {
+	switch sm.Mode {
+	case StreamModeNDJson:
+		chunk = bytes.TrimRight(chunk, "\r\n")
+		wrapped := make([]byte, 0, len(chunk)+1)
+		wrapped = append(wrapped, chunk...)
+		return append(wrapped, '\n')
+	case StreamModeEventStream:
+		chunk = bytes.TrimRight(chunk, "\r\n")
+		if IsSSEFormatted(chunk) {
+			wrapped := make([]byte, 0, len(chunk)+2)
+			wrapped = append(wrapped, chunk...)
+			return append(wrapped, '\n', '\n')
 		}
-	}
-
-	if sm.Mode == StreamModeEventStream && !bytes.HasPrefix(chunk, []byte("data: ")) {
-		chunk = append([]byte("data: "), chunk...)
+		ev := SSEEvent{Data: chunk, HasData: true}
+		return ev.Bytes()
 	}
 	return chunk
 }
@@ -0,0 +1,54 @@
+package types
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadChunkLineEndings(t *testing.T) {
+	cases := []struct {
+		name   string
+		stream string
+	}{
+		{"lf", "event: a\ndata: 1\n\nevent: b\ndata: 2\n\n"},
+		{"crlf", "event: a\r\ndata: 1\r\n\r\nevent: b\r\ndata: 2\r\n\r\n"},
+		{"cr", "event: a\rdata: 1\r\revent: b\rdata: 2\r\r"},
+		{"mixed", "\r\nevent: a\rdata: 1\r\n\nevent: b\ndata: 2\r\r"},
+	}
+	for _, c := range cases {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(c.stream)
+			if oneByte {
+				// "\r\n" split across reads
+				r = iotest.OneByteReader(r)
+			}
+			reader := bufio.NewReader(r)
+			sm := &StreamMode{Mode: StreamModeEventStream}
+			var events []*SSEEvent
+			for {
+				chunk, err := sm.ReadChunk(reader)
+				if len(chunk) > 0 {
+					events = append(events, sm.UnwrapEvent(chunk))
+				}
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("%s: %v", c.name, err)
+				}
+			}
+			if len(events) != 2 {
+				t.Fatalf("%s (one byte reads: %v): got %d events, want 2", c.name, oneByte, len(events))
+			}
+			for i, want := range [][2]string{{"a", "1"}, {"b", "2"}} {
+				if events[i].Event != want[0] || string(events[i].Data) != want[1] {
+					t.Errorf("%s (one byte reads: %v): event %d is %q %q, want %q %q", c.name, oneByte, i,
+						events[i].Event, events[i].Data, want[0], want[1])
+				}
+			}
+		}
+	}
+}
@@ -13,24 +13,41 @@ const (
 	ServiceSourceLocal  = "local"
 	ServiceSourceRemote = "remote"
 
-	FlavorAOG      = "aog"
-	FlavorTencent  = "tencent"
-	FlavorDeepSeek = "deepseek"
-	FlavorOpenAI   = "openai"
-	FlavorOllama   = "ollama"
-	FlavorBaidu    = "baidu"
-	FlavorAliYun   = "aliyun"
-	FlavorOpenvino = "openvino"
+	FlavorAOG       = "aog"
+	FlavorTencent   = "tencent"
+	FlavorDeepSeek  = "deepseek"
+	FlavorOpenAI    = "openai"
+	FlavorOllama    = "ollama"
+	FlavorBaidu     = "baidu"
+	FlavorAliYun    = "aliyun"
+	FlavorOpenvino  = "openvino"
+	FlavorAnthropic = "anthropic"
+	FlavorGemini    = "gemini"
+	FlavorLlamaCpp  = "llamacpp"
+	FlavorVLLM      = "vllm"
+	FlavorCohere    = "cohere"
+	// FlavorOpenAIResponses the Responses API of openai, only for the chat service
+	FlavorOpenAIResponses = "openai-responses"
 
 	AuthTypeNone   = "none"
 	AuthTypeApiKey = "apikey"
 	AuthTypeToken  = "token"
+	// AuthTypeApiKeyQuery api key sent as a query parameter, e.g. ?key= of gemini
+	AuthTypeApiKeyQuery = "apikey_query"
+	// AuthTypeApiKeyHeader api key sent as is in a header other than Authorization, e.g. x-api-key of anthropic
+	AuthTypeApiKeyHeader = "apikey_header"
 
 	ServiceChat        = "chat"
 	ServiceModels      = "models"
 	ServiceGenerate    = "generate"
 	ServiceEmbed       = "embed"
 	ServiceTextToImage = "text-to-image"
+	// ServiceImageToImage edit a source image by prompt, and inpaint the masked area if a mask is given
+	ServiceImageToImage = "image-to-image"
+	// ServiceTextToSpeech the response of it is the binary audio, not JSON
+	ServiceTextToSpeech = "text-to-speech"
+	ServiceSpeechToText = "speech-to-text"
+	ServiceRerank       = "rerank"
 
 	ImageTypeUrl    = "url"
 	ImageTypePath   = "path"
@@ -44,6 +61,23 @@ const (
 	ProtocolHTTPS = "HTTPS"
 	ProtocolGRPC  = "GRPC"
 
+	ResponseModeStream = "stream"
+	ResponseModeSync   = "sync"
+
+	// StructuredOutputJSONSchema the provider enforces the json schema given in response_format
+	StructuredOutputJSONSchema = "json_schema"
+	// StructuredOutputJSONObject the provider only ensures the output to be a valid JSON object
+	StructuredOutputJSONObject = "json_object"
+
+	// HeaderStructuredOutput tells the client whether the structured output is validated, see StructuredOutputStatus*
+	HeaderStructuredOutput          = "X-AOG-Structured-Output"
+	StructuredOutputStatusValidated = "validated"
+	StructuredOutputStatusInvalid   = "invalid"
+	// StructuredOutputStatusNative the output is streamed as is, the schema is only enforced by the provider
+	StructuredOutputStatusNative = "native"
+	// StructuredOutputStatusUnvalidated the output is streamed as is and the provider can't enforce the schema
+	StructuredOutputStatusUnvalidated = "unvalidated"
+
 	LogLevelDebug = "debug"
 	LogLevelInfo  = "info"
 	LogLevelWarn  = "warn"
@@ -52,17 +86,36 @@ const (
 	EngineStartModeDaemon   = "daemon"
 	EngineStartModeStandard = "standard"
 
+	EngineStatusUp       = "UP"
+	EngineStatusDown     = "DOWN"
+	EngineStatusStarting = "STARTING"
+
+	ModelDownloadQueued      = "queued"
+	ModelDownloadDownloading = "downloading"
+	ModelDownloadRetrying    = "retrying"
+	ModelDownloadSuccess     = "success"
+	ModelDownloadFailed      = "failed"
+	ModelDownloadCanceled    = "canceled"
+
 	VersionRecordStatusInstalled = 1
 	VersionRecordStatusUpdated   = 2
 )
 
 var (
-	SupportService      = []string{ServiceEmbed, ServiceModels, ServiceChat, ServiceGenerate, ServiceTextToImage}
+	SupportService      = []string{ServiceEmbed, ServiceModels, ServiceChat, ServiceGenerate, ServiceTextToImage, ServiceImageToImage, ServiceTextToSpeech, ServiceSpeechToText, ServiceRerank}
 	SupportHybridPolicy = []string{HybridPolicyDefault, HybridPolicyLocal, HybridPolicyRemote}
-	SupportAuthType     = []string{AuthTypeNone, AuthTypeApiKey, AuthTypeToken}
-	SupportFlavor       = []string{FlavorDeepSeek, FlavorOpenAI, FlavorTencent, FlavorOllama, FlavorBaidu, FlavorAliYun, FlavorOpenvino}
-	SupportModelEngine  = []string{FlavorOpenvino, FlavorOllama}
+	SupportAuthType     = []string{AuthTypeNone, AuthTypeApiKey, AuthTypeToken, AuthTypeApiKeyQuery, AuthTypeApiKeyHeader}
+	SupportFlavor       = []string{FlavorDeepSeek, FlavorOpenAI, FlavorTencent, FlavorOllama, FlavorBaidu, FlavorAliYun, FlavorOpenvino, FlavorAnthropic, FlavorGemini, FlavorLlamaCpp, FlavorVLLM, FlavorCohere, FlavorOpenAIResponses}
 	SupportImageType    = []string{ImageTypeUrl, ImageTypeBase64, ImageTypePath}
+	// SupportStreamBridgeService services whose responses can be converted between stream and sync mode by aog
+	SupportStreamBridgeService = []string{ServiceChat, ServiceGenerate}
+	// SupportMultipartService services accepting multipart/form-data requests, e.g. audio uploads
+	SupportMultipartService = []string{ServiceSpeechToText}
+	// ImageFieldsOfService request fields holding images which are normalized by image_type before invoking
+	ImageFieldsOfService = map[string][]string{
+		ServiceTextToImage:  {"image"},
+		ServiceImageToImage: {"image", "mask"},
+	}
 )
 
 type HTTPContent struct {
@@ -140,6 +193,70 @@ type EngineVersionResponse struct {
 	Version string `json:"version"`
 }
 
+// EngineStatus State of a local model engine kept by the engine supervisor
+type EngineStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Pids the processes of the engine started by AOG and still running
+	Pids    []int      `json:"pids,omitempty"`
+	UpSince *time.Time `json:"up_since,omitempty"`
+	// Uptime seconds since the engine is up
+	Uptime int64 `json:"uptime"`
+	// Restarts times the engine is started again by the supervisor, the first start not counted
+	Restarts int `json:"restarts"`
+	// Crashes times the engine exits or stops answering health checks unexpectedly
+	Crashes       int        `json:"crashes"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+	NextRestartAt *time.Time `json:"next_restart_at,omitempty"`
+	LogFile       string     `json:"log_file"`
+}
+
+// ModelDownload State of a model pull kept by the download manager
+type ModelDownload struct {
+	ModelName    string `json:"model_name"`
+	ProviderName string `json:"provider_name"`
+	Engine       string `json:"engine"`
+	Status       string `json:"status"`
+	// Detail what the engine is doing, e.g. pulling manifest
+	Detail string `json:"detail,omitempty"`
+	// Progress percent of the Total bytes with Completed downloaded, 0 if the engine doesn't tell
+	Progress  float64 `json:"progress"`
+	Completed int64   `json:"completed"`
+	Total     int64   `json:"total"`
+	// Attempt the pulls tried, a failed pull is retried until the max attempts
+	Attempt int `json:"attempt"`
+	// LastError why the last attempt failed
+	LastError   string     `json:"last_error,omitempty"`
+	NextRetryAt *time.Time `json:"next_retry_at,omitempty"`
+	QueuedAt    time.Time  `json:"queued_at"`
+}
+
+// Finished whether the download is over, i.e. succeeded, failed or canceled
+func (d *ModelDownload) Finished() bool {
+	return d.Status == ModelDownloadSuccess || d.Status == ModelDownloadFailed || d.Status == ModelDownloadCanceled
+}
+
+// LoadModelRequest Load a model into the memory of a local engine
+type LoadModelRequest struct {
+	Model string `json:"model"`
+	// KeepAlive how long the model stays loaded after used, e.g. "10m", forever if negative, the
+	// default of the engine if empty
+	KeepAlive string `json:"keep_alive,omitempty"`
+	// Embedding the model is loaded to serve embeddings
+	Embedding bool `json:"embedding,omitempty"`
+}
+
+// EngineModelInfo Metadata of a model in the storage of a local engine
+type EngineModelInfo struct {
+	// Size bytes of the model files on disk
+	Size          int64        `json:"size"`
+	Digest        string       `json:"digest,omitempty"`
+	ContextLength int64        `json:"context_length,omitempty"`
+	Details       ModelDetails `json:"details"`
+	ModifiedAt    time.Time    `json:"modified_at"`
+}
+
 // ModelDetails provides details about a model.
 type ModelDetails struct {
 	ParentModel       string   `json:"parent_model"`
@@ -168,6 +285,60 @@ type DeleteRequest struct {
 	Model string `json:"model"`
 }
 
+// CreateRequest is the request of Ollama /api/create. Older Ollama takes the Modelfile, newer
+// takes the Files, both referring to blobs pushed by /api/blobs
+type CreateRequest struct {
+	Model     string            `json:"model"`
+	Modelfile string            `json:"modelfile,omitempty"`
+	Files     map[string]string `json:"files,omitempty"`
+	Stream    *bool             `json:"stream,omitempty"`
+}
+
+// ImportModelRequest Register the model files already on the disk into the engine
+type ImportModelRequest struct {
+	Model string `json:"model"`
+	// Path a GGUF file for ollama, or an OpenVINO IR directory for openvino
+	Path      string `json:"path"`
+	ModelType string `json:"model_type,omitempty"`
+}
+
+// ShowRequest is the request passed to [Client.Show].
+type ShowRequest struct {
+	Model string `json:"model"`
+
+	// Deprecated: set the model name with Model instead
+	Name string `json:"name"`
+}
+
+// ShowResponse is the response returned from [Client.Show].
+type ShowResponse struct {
+	License      string         `json:"license,omitempty"`
+	Modelfile    string         `json:"modelfile,omitempty"`
+	Parameters   string         `json:"parameters,omitempty"`
+	Template     string         `json:"template,omitempty"`
+	System       string         `json:"system,omitempty"`
+	Details      ModelDetails   `json:"details,omitempty"`
+	ModelInfo    map[string]any `json:"model_info,omitempty"`
+	Capabilities []string       `json:"capabilities,omitempty"`
+	ModifiedAt   time.Time      `json:"modified_at,omitempty"`
+}
+
+// ProcessResponse is the response from [Client.ListRunning].
+type ProcessResponse struct {
+	Models []ProcessModelResponse `json:"models"`
+}
+
+// ProcessModelResponse is a single model description in [ProcessResponse].
+type ProcessModelResponse struct {
+	Name      string       `json:"name"`
+	Model     string       `json:"model"`
+	Size      int64        `json:"size"`
+	Digest    string       `json:"digest"`
+	Details   ModelDetails `json:"details,omitempty"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	SizeVRAM  int64        `json:"size_vram"`
+}
+
 // [PullProgressFunc] and [PushProgressFunc].
 type ProgressResponse struct {
 	Status    string `json:"status"`
@@ -36,4 +36,6 @@ var (
 	ErrUnSupportFlavor = NewBcode(http.StatusBadRequest, 10017, "unsupport api flavor")
 
 	ErrUnSupportAuthType = NewBcode(http.StatusBadRequest, 10018, "unsupport auth type")
+
+	ErrModelEngineNotFound = NewBcode(http.StatusBadRequest, 10019, "model engine not registered")
 )
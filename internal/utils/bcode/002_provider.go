@@ -18,4 +18,6 @@ var (
 	ErrProviderAuthInfoLost = NewBcode(http.StatusBadRequest, 20006, "provider api auth info lost")
 
 	ErrProviderServiceUrlNotFormat = NewBcode(http.StatusBadRequest, 20007, "provider service url is irregular")
+
+	ErrProviderModelNotServed = NewBcode(http.StatusBadRequest, 20008, "model is not served by the provider")
 )
@@ -18,4 +18,30 @@ var (
 	ErrEngineDeleteModel = NewBcode(http.StatusBadRequest, 30006, "engine delete model failed")
 
 	ErrNoRecommendModel = NewBcode(http.StatusBadRequest, 30007, "No Recommend Model")
+
+	ErrModelImportEngine = NewBcode(http.StatusBadRequest, 30008, "models can only be imported into local ollama or openvino")
+
+	ErrModelImportPath = NewBcode(http.StatusBadRequest, 30009, "model path must be a GGUF file for ollama or an OpenVINO IR directory for openvino")
+
+	ErrModelImport = NewBcode(http.StatusBadRequest, 30010, "engine import model failed")
+
+	ErrModelExport = NewBcode(http.StatusBadRequest, 30011, "export model bundle failed")
+
+	ErrModelBundle = NewBcode(http.StatusBadRequest, 30012, "invalid model bundle")
+
+	ErrModelBundleChecksum = NewBcode(http.StatusBadRequest, 30013, "model bundle checksum mismatch")
+
+	ErrModelInstallBundle = NewBcode(http.StatusBadRequest, 30014, "engine install model bundle failed")
+
+	ErrModelLoadEngine = NewBcode(http.StatusBadRequest, 30015, "models can only be loaded by local ollama or llamacpp")
+
+	ErrModelLoad = NewBcode(http.StatusBadRequest, 30016, "engine load model failed")
+
+	ErrModelUnload = NewBcode(http.StatusBadRequest, 30017, "engine unload model failed")
+
+	ErrModelKeepAlive = NewBcode(http.StatusBadRequest, 30018, "keep_alive must be a duration like 10m, or seconds, negative to keep forever")
+
+	ErrModelDownloadQueueFull = NewBcode(http.StatusBadRequest, 30019, "too many models are waiting to download, try again later")
+
+	ErrModelDiskSpace = NewBcode(http.StatusBadRequest, 30020, "not enough disk space to download the model")
 )
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
@@ -468,3 +469,21 @@ func ParseImageData(data []byte) ([][]byte, error) {
 
 	return images, nil
 }
+
+// ImageDataURL Encode the image as a data url, e.g. data:image/png;base64,xxx
+func ImageDataURL(data []byte) string {
+	return fmt.Sprintf("data:%s;base64,%s", http.DetectContentType(data), base64.StdEncoding.EncodeToString(data))
+}
+
+// ParseImageDataURL Split a data url into its media type and base64 data. ok is false if it is
+// not a data url
+func ParseImageDataURL(s string) (mediaType string, data string, ok bool) {
+	if !strings.HasPrefix(s, "data:") {
+		return "", "", false
+	}
+	header, data, found := strings.Cut(s, ",")
+	if !found || !strings.HasSuffix(header, ";base64") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(header, "data:"), ";base64"), data, true
+}
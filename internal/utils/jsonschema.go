@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ValidateJSONSchema Check the JSON value against the schema, returns the violations found.
+// Only the commonly used subset of JSON Schema is supported: type, enum, const, properties,
+// required, additionalProperties, items, min/maxItems, min/maxLength, pattern, minimum,
+// maximum, exclusiveMinimum, exclusiveMaximum, anyOf, oneOf, allOf and local $ref
+// (#/$defs/... or #/definitions/...). Unknown keywords are ignored.
+func ValidateJSONSchema(schema interface{}, value interface{}) []string {
+	v := &schemaValidator{root: schema, refs: make(map[string]bool)}
+	v.validate(schema, value, "$")
+	return v.errors
+}
+
+type schemaValidator struct {
+	root   interface{}
+	errors []string
+	// refs the $refs being followed, by the path of the value they are followed at. A $ref
+	// followed again at the same path is a cycle, which would never end
+	refs map[string]bool
+}
+
+func (v *schemaValidator) fail(path string, format string, args ...interface{}) {
+	v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *schemaValidator) resolve(ref string) (interface{}, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+	var node interface{} = v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func (v *schemaValidator) validate(schema interface{}, value interface{}, path string) {
+	if b, ok := schema.(bool); ok {
+		if !b {
+			v.fail(path, "no value is allowed")
+		}
+		return
+	}
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		target, found := v.resolve(ref)
+		if !found {
+			v.fail(path, "unresolvable $ref %s", ref)
+			return
+		}
+		key := path + "\x00" + ref
+		if v.refs[key] {
+			v.fail(path, "$ref %s refers to itself", ref)
+			return
+		}
+		v.refs[key] = true
+		v.validate(target, value, path)
+		delete(v.refs, key)
+	}
+
+	if t, ok := s["type"]; ok && !matchSchemaType(t, value) {
+		v.fail(path, "expected type %v but got %s", t, jsonTypeOf(value))
+		return
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "value is not one of %v", enum)
+		}
+	}
+	if c, ok := s["const"]; ok && !jsonEqual(c, value) {
+		v.fail(path, "value must be %v", c)
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(s, val, path)
+	case []interface{}:
+		v.validateArray(s, val, path)
+	case string:
+		n := float64(len([]rune(val)))
+		if m, ok := s["minLength"].(float64); ok && n < m {
+			v.fail(path, "string is shorter than %v", m)
+		}
+		if m, ok := s["maxLength"].(float64); ok && n > m {
+			v.fail(path, "string is longer than %v", m)
+		}
+		if p, ok := s["pattern"].(string); ok {
+			if re, err := regexp.Compile(p); err == nil && !re.MatchString(val) {
+				v.fail(path, "string does not match pattern %s", p)
+			}
+		}
+	case float64:
+		if m, ok := s["minimum"].(float64); ok && val < m {
+			v.fail(path, "value is less than %v", m)
+		}
+		if m, ok := s["maximum"].(float64); ok && val > m {
+			v.fail(path, "value is greater than %v", m)
+		}
+		if m, ok := s["exclusiveMinimum"].(float64); ok && val <= m {
+			v.fail(path, "value must be greater than %v", m)
+		}
+		if m, ok := s["exclusiveMaximum"].(float64); ok && val >= m {
+			v.fail(path, "value must be less than %v", m)
+		}
+	}
+
+	if all, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			v.validate(sub, value, path)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok && v.countMatches(anyOf, value, path) == 0 {
+		v.fail(path, "value does not match any schema of anyOf")
+	}
+	if oneOf, ok := s["oneOf"].([]interface{}); ok {
+		if n := v.countMatches(oneOf, value, path); n != 1 {
+			v.fail(path, "value matches %d schemas of oneOf, exactly one is expected", n)
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(s map[string]interface{}, obj map[string]interface{}, path string) {
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, exists := obj[name]; !exists {
+					v.fail(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+	props, _ := s["properties"].(map[string]interface{})
+	// sorted so the errors are reported in a stable order
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if sub, ok := props[k]; ok {
+			v.validate(sub, obj[k], path+"."+k)
+			continue
+		}
+		switch additional := s["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.fail(path, "property %q is not allowed", k)
+			}
+		case map[string]interface{}:
+			v.validate(additional, obj[k], path+"."+k)
+		}
+	}
+}
+
+func (v *schemaValidator) validateArray(s map[string]interface{}, arr []interface{}, path string) {
+	n := float64(len(arr))
+	if m, ok := s["minItems"].(float64); ok && n < m {
+		v.fail(path, "array has fewer than %v items", m)
+	}
+	if m, ok := s["maxItems"].(float64); ok && n > m {
+		v.fail(path, "array has more than %v items", m)
+	}
+	if items, ok := s["items"]; ok {
+		for i, item := range arr {
+			v.validate(items, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+func (v *schemaValidator) countMatches(schemas []interface{}, value interface{}, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		sv := &schemaValidator{root: v.root, refs: v.refs}
+		sv.validate(sub, value, path)
+		if len(sv.errors) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func matchSchemaType(t interface{}, value interface{}) bool {
+	switch tt := t.(type) {
+	case string:
+		return matchOneSchemaType(tt, value)
+	case []interface{}:
+		for _, one := range tt {
+			if s, ok := one.(string); ok && matchOneSchemaType(s, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchOneSchemaType(t string, value interface{}) bool {
+	switch t {
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return jsonTypeOf(value) == t
+	}
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64, json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
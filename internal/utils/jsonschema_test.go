@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		value  string
+		want   []string
+	}{
+		{
+			name:   "type",
+			schema: `{"type": "object", "properties": {"n": {"type": "integer"}, "s": {"type": ["string", "null"]}}}`,
+			value:  `{"n": 1.5, "s": null}`,
+			want:   []string{"$.n: expected type integer but got number"},
+		},
+		{
+			name:   "type of the root",
+			schema: `{"type": "array"}`,
+			value:  `{"a": 1}`,
+			want:   []string{"$: expected type array but got object"},
+		},
+		{
+			name:   "required",
+			schema: `{"type": "object", "required": ["name", "age"], "properties": {"name": {"type": "string"}}}`,
+			value:  `{"name": "Ann"}`,
+			want:   []string{`$: missing required property "age"`},
+		},
+		{
+			name:   "additionalProperties false",
+			schema: `{"type": "object", "properties": {"a": {}}, "additionalProperties": false}`,
+			value:  `{"a": 1, "c": 2, "b": 3}`,
+			want:   []string{`$: property "b" is not allowed`, `$: property "c" is not allowed`},
+		},
+		{
+			name:   "additionalProperties schema",
+			schema: `{"type": "object", "additionalProperties": {"type": "number"}}`,
+			value:  `{"a": 1, "b": "x"}`,
+			want:   []string{"$.b: expected type number but got string"},
+		},
+		{
+			name:   "items and bounds",
+			schema: `{"type": "array", "maxItems": 2, "items": {"type": "string", "minLength": 2, "enum": ["ab", "cd"]}}`,
+			value:  `["ab", "c", "cd"]`,
+			want: []string{
+				"$: array has more than 2 items",
+				"$[1]: value is not one of [ab cd]",
+				"$[1]: string is shorter than 2",
+			},
+		},
+		{
+			name:   "numbers",
+			schema: `{"type": "object", "properties": {"a": {"minimum": 1}, "b": {"exclusiveMaximum": 10}, "c": {"const": 3}}}`,
+			value:  `{"a": 0, "b": 10, "c": 4}`,
+			want:   []string{"$.a: value is less than 1", "$.b: value must be less than 10", "$.c: value must be 3"},
+		},
+		{
+			name:   "pattern",
+			schema: `{"type": "string", "pattern": "^[a-z]+$"}`,
+			value:  `"Abc"`,
+			want:   []string{"$: string does not match pattern ^[a-z]+$"},
+		},
+		{
+			name:   "anyOf matched",
+			schema: `{"anyOf": [{"type": "string"}, {"type": "number"}]}`,
+			value:  `1`,
+		},
+		{
+			name:   "anyOf not matched",
+			schema: `{"anyOf": [{"type": "string"}, {"type": "number"}]}`,
+			value:  `true`,
+			want:   []string{"$: value does not match any schema of anyOf"},
+		},
+		{
+			name:   "oneOf matched twice",
+			schema: `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`,
+			value:  `2`,
+			want:   []string{"$: value matches 2 schemas of oneOf, exactly one is expected"},
+		},
+		{
+			name:   "oneOf matched once",
+			schema: `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`,
+			value:  `2.5`,
+		},
+		{
+			name:   "allOf",
+			schema: `{"allOf": [{"required": ["a"]}, {"required": ["b"]}]}`,
+			value:  `{"a": 1}`,
+			want:   []string{`$: missing required property "b"`},
+		},
+		{
+			name: "$ref",
+			schema: `{"$defs": {"city": {"type": "object", "required": ["name"]}},
+				"type": "object", "properties": {"from": {"$ref": "#/$defs/city"}, "to": {"$ref": "#/definitions/city"}},
+				"definitions": {"city": {"$ref": "#/$defs/city"}}}`,
+			value: `{"from": {"name": "Paris"}, "to": {}}`,
+			want:  []string{`$.to: missing required property "name"`},
+		},
+		{
+			name:   "unresolvable $ref",
+			schema: `{"$ref": "#/$defs/missing"}`,
+			value:  `1`,
+			want:   []string{"$: unresolvable $ref #/$defs/missing"},
+		},
+		{
+			// a tree follows the same $ref again, at the values nested deeper
+			name: "recursive $ref",
+			schema: `{"$defs": {"node": {"type": "object", "required": ["name"],
+				"properties": {"children": {"type": "array", "items": {"$ref": "#/$defs/node"}}}}},
+				"$ref": "#/$defs/node"}`,
+			value: `{"name": "a", "children": [{"name": "b", "children": [{"children": []}]}]}`,
+			want:  []string{`$.children[0].children[0]: missing required property "name"`},
+		},
+		{
+			name:   "$ref cycle",
+			schema: `{"$defs": {"a": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`,
+			value:  `{}`,
+			want:   []string{"$: $ref #/$defs/a refers to itself"},
+		},
+		{
+			name:   "$ref cycle through the root",
+			schema: `{"type": "object", "properties": {"a": {"$ref": "#/$defs/b"}}, "$defs": {"b": {"$ref": "#/$defs/c"}, "c": {"$ref": "#/$defs/b"}}}`,
+			value:  `{"a": 1}`,
+			want:   []string{"$.a: $ref #/$defs/b refers to itself"},
+		},
+		{
+			name:   "$ref cycle in anyOf",
+			schema: `{"anyOf": [{"$ref": "#"}]}`,
+			value:  `1`,
+			want:   []string{"$: value does not match any schema of anyOf"},
+		},
+		{
+			name:   "false schema",
+			schema: `{"type": "object", "properties": {"a": false}}`,
+			value:  `{"a": 1}`,
+			want:   []string{"$.a: no value is allowed"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var schema, value interface{}
+			if err := json.Unmarshal([]byte(c.schema), &schema); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(c.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			got := ValidateJSONSchema(schema, value)
+			if len(got) == 0 && len(c.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got violations %q, want %q", got, c.want)
+			}
+		})
+	}
+}